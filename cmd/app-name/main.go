@@ -9,15 +9,23 @@ import (
 	"time"
 
 	"github.com/dannypaul/go-skeleton/internal/config"
+	"github.com/dannypaul/go-skeleton/internal/driver/cache"
+	"github.com/dannypaul/go-skeleton/internal/driver/encryption"
+	"github.com/dannypaul/go-skeleton/internal/driver/instrument"
 	"github.com/dannypaul/go-skeleton/internal/driver/platform/mongo"
+	"github.com/dannypaul/go-skeleton/internal/driver/platform/sqlite"
 	"github.com/dannypaul/go-skeleton/internal/iam"
 	"github.com/dannypaul/go-skeleton/internal/middleware"
 	"github.com/dannypaul/go-skeleton/internal/notification"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+	"github.com/dannypaul/go-skeleton/internal/throttle"
 
 	"github.com/go-chi/chi"
 
 	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
 	"github.com/golang-migrate/migrate/v4/database/mongodb"
+	"github.com/golang-migrate/migrate/v4/database/sqlite3"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 
 	"github.com/rs/zerolog"
@@ -38,16 +46,31 @@ func main() {
 	zerolog.SetGlobalLevel(logLevel)
 
 	ctx := context.Background()
-	mongoDbClient := mongo.Connect(ctx)
 
-	log.Info().Msg("Starting database migration")
+	var mongoDbClient *mongo.Client
+	var sqliteClient *sqlite.Client
+	var migrationDriver database.Driver
+	var databaseName string
 
-	migrationDriver, err := mongodb.WithInstance(mongoDbClient.Client, &mongodb.Config{DatabaseName: conf.MongoDatabasebName})
+	switch conf.DatabaseDriver {
+	case "mongo":
+		mongoDbClient = mongo.Connect(ctx)
+		migrationDriver, err = mongodb.WithInstance(mongoDbClient.Client, &mongodb.Config{DatabaseName: conf.MongoDatabasebName})
+		databaseName = conf.MongoDatabasebName
+	case "sqlite":
+		sqliteClient = sqlite.Open(ctx)
+		migrationDriver, err = sqlite3.WithInstance(sqliteClient.DB, &sqlite3.Config{})
+		databaseName = conf.SqlitePath
+	default:
+		log.Fatal().Msg("Unsupported database driver " + conf.DatabaseDriver)
+	}
 	if err != nil {
-		log.Fatal().Err(err).Msg("error initialising MongoDB migration driver")
+		log.Fatal().Err(err).Msg("error initialising the migration driver")
 	}
 
-	migration, err := migrate.NewWithDatabaseInstance(conf.MigrationSourcePath, conf.MongoDatabasebName, migrationDriver)
+	log.Info().Msg("Starting database migration")
+
+	migration, err := migrate.NewWithDatabaseInstance(conf.MigrationSourcePath, databaseName, migrationDriver)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Error initialising migration")
 	}
@@ -61,17 +84,115 @@ func main() {
 
 	notificationService := notification.NewService()
 
-	userRepo, _ := iam.NewMongoUserRepo(mongoDbClient)
-	challengeRepo, _ := iam.NewMongoChallengeRepo(mongoDbClient)
-	iamService := iam.NewService(userRepo, challengeRepo, notificationService)
+	var (
+		userRepo      iam.UserRepo
+		challengeRepo iam.ChallengeRepo
+		attributeRepo iam.AttributeRepo
+		groupRepo     iam.GroupRepo
+		policyRepo    iam.PolicyRepo
+		consentRepo   iam.ConsentRepo
+		erasureRepo   iam.ErasureRepo
+		auditRepo     iam.AuditRepo
+		transactor    repository.Transactor
+	)
+	if sqliteClient != nil {
+		userRepo, _ = iam.NewSqliteUserRepo(sqliteClient)
+		challengeRepo, _ = iam.NewSqliteChallengeRepo(sqliteClient)
+		attributeRepo, _ = iam.NewSqliteAttributeRepo(sqliteClient)
+		groupRepo, _ = iam.NewSqliteGroupRepo(sqliteClient)
+		policyRepo, _ = iam.NewSqlitePolicyRepo(sqliteClient)
+		consentRepo, _ = iam.NewSqliteConsentRepo(sqliteClient)
+		erasureRepo, _ = iam.NewSqliteErasureRepo(sqliteClient)
+		auditRepo, _ = iam.NewSqliteAuditRepo(sqliteClient)
+		transactor = sqliteClient
+	} else {
+		var cipher *encryption.Cipher
+		if conf.EncryptionKeyFile != "" {
+			cipher, err = mongo.NewCipher(ctx, mongoDbClient, conf.EncryptionKeyFile)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Error initialising the field-level encryption")
+			}
+		}
+
+		userRepo, _ = iam.NewMongoUserRepo(mongoDbClient, cipher)
+		challengeRepo, _ = iam.NewMongoChallengeRepo(mongoDbClient, cipher)
+		attributeRepo, _ = iam.NewMongoAttributeRepo(mongoDbClient)
+		groupRepo, _ = iam.NewMongoGroupRepo(mongoDbClient)
+		policyRepo, _ = iam.NewMongoPolicyRepo(mongoDbClient)
+		consentRepo, _ = iam.NewMongoConsentRepo(mongoDbClient)
+		erasureRepo, _ = iam.NewMongoErasureRepo(mongoDbClient)
+		auditRepo, _ = iam.NewMongoAuditRepo(mongoDbClient)
+		transactor = mongoDbClient
+	}
+	// Every call of the iam repositories is recorded, and the slow ones are logged
+	metrics := instrument.NewHistograms()
+	instrumentation := instrument.Options{SlowThreshold: conf.SlowQueryThreshold, Metrics: metrics}
+	instrumented := func(repo repository.Collection, name string) instrument.Repository {
+		inner, ok := repo.(instrument.Repository)
+		if !ok {
+			log.Fatal().Str("collection", name).Msg("The repository cannot be instrumented")
+		}
+		return instrument.NewCollection(inner, name, instrumentation)
+	}
+	userRepo = instrumented(userRepo, iam.UserCollectionName)
+	challengeRepo = instrumented(challengeRepo, iam.ChallengeCollectionName)
+	attributeRepo = instrumented(attributeRepo, iam.AttributeCollectionName)
+	groupRepo = instrumented(groupRepo, iam.GroupCollectionName)
+	policyRepo = instrumented(policyRepo, iam.PolicyCollectionName)
+	consentRepo = instrumented(consentRepo, iam.ConsentCollectionName)
+	erasureRepo = instrumented(erasureRepo, iam.ErasureCollectionName)
+	auditRepo = instrumented(auditRepo, iam.AuditCollectionName)
+
+	// The users found by ID are cached, as every authenticated request finds one
+	var userCache *cache.Collection
+	if conf.UserCacheTTL > 0 {
+		cached := cache.NewCollection(userRepo, cache.Options{Size: conf.UserCacheSize, TTL: conf.UserCacheTTL})
+		userRepo, userCache = cached, &cached
+		transactor = cache.NewTransactor(transactor)
+	}
+
+	iamService := iam.NewService(userRepo, challengeRepo, attributeRepo, groupRepo, policyRepo, consentRepo, erasureRepo, auditRepo, transactor, notificationService)
 
 	_ = iamService.VerifySeedUser(ctx)
 
+	go func() {
+		ticker := time.NewTicker(conf.ErasureCheckInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			erased, err := iamService.EraseDueUsers(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("Error erasing the users pending erasure")
+				continue
+			}
+			if erased > 0 {
+				log.Info().Int("erased", erased).Msg("Erased the users whose grace period has ended")
+			}
+		}
+	}()
+
+	throttleStore := throttle.NewMemoryStore()
+	if conf.ThrottleStore == "mongo" {
+		if mongoDbClient == nil {
+			log.Fatal().Msg("The mongo throttle store requires the mongo database driver")
+		}
+		throttleStore, err = throttle.NewMongoStore(mongoDbClient)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error initialising the throttle store")
+		}
+	}
+	limiter := throttle.NewLimiter(throttleStore)
+
 	router := chi.NewRouter()
 
-	router.Use(middleware.CorrelationId, middleware.Auth)
+	router.Use(middleware.CorrelationId)
+
+	router.Group(func(router chi.Router) {
+		router.Use(middleware.Auth(iamService))
+		router.Mount("/identity", iam.Router(iamService, limiter))
+	})
 
-	router.Mount("/identity", iam.Router(iamService))
+	// Provisioning clients authenticate with SCIM_TOKENS instead of sessions
+	router.Mount("/scim/v2", iam.ScimRouter(iam.NewScimService(iamService)))
 
 	// TODO: document the timeouts
 	// https://blog.cloudflare.com/the-complete-guide-to-golang-net-http-timeouts/
@@ -84,6 +205,33 @@ func main() {
 		Handler:           router,
 	}
 
+	// The metrics are served on a port of their own, which is not exposed
+	// with the API
+	var metricsServer *http.Server
+	if conf.MetricsPort != "" {
+		metricsRouter := chi.NewRouter()
+		metricsRouter.Handle("/metrics", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			metrics.ServeHTTP(w, r)
+			if userCache != nil {
+				userCache.WriteMetrics(w, iam.UserCollectionName)
+			}
+		}))
+
+		metricsServer = &http.Server{
+			ReadTimeout:       20 * time.Second,
+			WriteTimeout:      20 * time.Second,
+			ReadHeaderTimeout: 10 * time.Second,
+			Addr:              ":" + conf.MetricsPort,
+			Handler:           metricsRouter,
+		}
+		go func() {
+			err := metricsServer.ListenAndServe()
+			if err != http.ErrServerClosed {
+				log.Fatal().Err(err).Msg("Metrics server stopped because of an error")
+			}
+		}()
+	}
+
 	var osSignal = make(chan os.Signal, 1)
 	signal.Notify(osSignal, syscall.SIGINT, syscall.SIGKILL, syscall.SIGTERM)
 
@@ -102,7 +250,12 @@ func main() {
 		log.Info().Msg("Server shutdown successful")
 
 		// Release all shared resources
-		mongo.Disconnect(mongoDbClient)
+		if mongoDbClient != nil {
+			mongo.Disconnect(mongoDbClient)
+		}
+		if sqliteClient != nil {
+			sqlite.Close(sqliteClient)
+		}
 
 		log.Info().Msg("Released all shared resources")
 
@@ -116,4 +269,10 @@ func main() {
 	if err != nil {
 		log.Info().Msgf("Server shutdown failed %+v", err)
 	}
+	if metricsServer != nil {
+		err = metricsServer.Shutdown(ctx)
+		if err != nil {
+			log.Info().Msgf("Metrics server shutdown failed %+v", err)
+		}
+	}
 }
@@ -0,0 +1,76 @@
+// Command canonicalise recomputes the canonical email IDs of the users with
+// the canonicaliser of the application and the current EMAIL_PROVIDER_RULES.
+// It writes nothing and exits with an error when two users would share a
+// canonical email ID. With -check it only looks for such conflicts.
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/dannypaul/go-skeleton/internal/config"
+	"github.com/dannypaul/go-skeleton/internal/driver/encryption"
+	"github.com/dannypaul/go-skeleton/internal/driver/platform/mongo"
+	"github.com/dannypaul/go-skeleton/internal/driver/platform/sqlite"
+	"github.com/dannypaul/go-skeleton/internal/iam"
+
+	"github.com/rs/zerolog/log"
+)
+
+func main() {
+	check := flag.Bool("check", false, "only report the users which would share a canonical email ID")
+	flag.Parse()
+
+	conf, err := config.Get()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error reading environment variables")
+	}
+
+	ctx := context.Background()
+
+	var userRepo iam.UserRepo
+	switch conf.DatabaseDriver {
+	case "mongo":
+		client := mongo.Connect(ctx)
+		defer mongo.Disconnect(client)
+
+		var cipher *encryption.Cipher
+		if conf.EncryptionKeyFile != "" {
+			cipher, err = mongo.NewCipher(ctx, client, conf.EncryptionKeyFile)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Error initialising the field-level encryption")
+			}
+		}
+		userRepo, _ = iam.NewMongoUserRepo(client, cipher)
+		// The unique index covers the deleted users as well
+		ctx = mongo.WithDeleted(ctx)
+	case "sqlite":
+		client := sqlite.Open(ctx)
+		defer client.Close()
+		userRepo, _ = iam.NewSqliteUserRepo(client)
+	default:
+		log.Fatal().Msg("Unsupported database driver " + conf.DatabaseDriver)
+	}
+
+	updated, conflicts, err := iam.CanonicaliseEmailIds(ctx, userRepo, *check)
+	if err != nil {
+		log.Fatal().Err(err).Int("updated", updated).Msg("Error canonicalising the email IDs")
+	}
+
+	for canonicalEmailId, userIds := range conflicts {
+		userIdStrings := make([]string, len(userIds))
+		for i, userId := range userIds {
+			userIdStrings[i] = userId.String()
+		}
+		log.Error().Str("canonicalEmailId", canonicalEmailId).Strs("userIds", userIdStrings).Msg("Users share a canonical email ID")
+	}
+	if len(conflicts) > 0 {
+		log.Fatal().Int("conflicts", len(conflicts)).Msg("Resolve the conflicting users before canonicalising the email IDs")
+	}
+
+	if *check {
+		log.Info().Int("users", updated).Msg("No conflicts found, the canonical email IDs of the users would change")
+		return
+	}
+	log.Info().Int("updated", updated).Msg("Canonicalised the email IDs of the users")
+}
@@ -0,0 +1,73 @@
+// Command reencrypt encrypts the email IDs and phone numbers of the users and
+// challenges with the active data key, including the ones still in plaintext.
+// With -rotate-data-key it creates a new data key instead, which the
+// application uses once it is restarted.
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/dannypaul/go-skeleton/internal/config"
+	"github.com/dannypaul/go-skeleton/internal/driver/platform/mongo"
+	"github.com/dannypaul/go-skeleton/internal/iam"
+
+	"github.com/rs/zerolog/log"
+)
+
+type reencrypter interface {
+	Reencrypt(ctx context.Context) (int, error)
+}
+
+func main() {
+	rotateDataKey := flag.Bool("rotate-data-key", false, "create a new data key which encrypts the fields from then on")
+	flag.Parse()
+
+	conf, err := config.Get()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error reading environment variables")
+	}
+	if conf.DatabaseDriver != "mongo" || conf.EncryptionKeyFile == "" {
+		log.Fatal().Msg("Field-level encryption requires the mongo database driver and ENCRYPTION_KEY_FILE")
+	}
+
+	ctx := context.Background()
+	client := mongo.Connect(ctx)
+	defer mongo.Disconnect(client)
+
+	cipher, err := mongo.NewCipher(ctx, client, conf.EncryptionKeyFile)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error initialising the field-level encryption")
+	}
+
+	// The data keys wrapped by previous master keys are wrapped by the active
+	// one, so that the previous ones can be removed from the key file
+	rewrapped, err := cipher.Rewrap(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error rewrapping the data keys")
+	}
+	log.Info().Int("rewrapped", rewrapped).Msg("Rewrapped the data keys with the active master key")
+
+	if *rotateDataKey {
+		id, err := cipher.RotateDataKey(ctx)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error creating the data key")
+		}
+		log.Info().Str("dataKeyId", id).Msg("Created the data key. Restart the application before re-encrypting")
+		return
+	}
+
+	userRepo, _ := iam.NewMongoUserRepo(client, cipher)
+	challengeRepo, _ := iam.NewMongoChallengeRepo(client, cipher)
+	collections := map[string]interface{}{
+		iam.UserCollectionName:      userRepo,
+		iam.ChallengeCollectionName: challengeRepo,
+	}
+	for name, repo := range collections {
+		reencrypted, err := repo.(reencrypter).Reencrypt(ctx)
+		if err != nil {
+			log.Fatal().Err(err).Str("collection", name).Msg("Error re-encrypting the collection")
+		}
+		log.Info().Int("reencrypted", reencrypted).Str("collection", name).Msg("Re-encrypted the collection")
+	}
+}
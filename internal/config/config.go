@@ -8,6 +8,10 @@ import (
 	"time"
 )
 
+// defaultDisposableEmailDomains is used when DISPOSABLE_EMAIL_DOMAINS is not set
+const defaultDisposableEmailDomains = "mailinator.com,guerrillamail.com,sharklasers.com,10minutemail.com," +
+	"yopmail.com,trashmail.com,tempmail.com,temp-mail.org,getnada.com,dispostable.com,maildrop.cc"
+
 type env map[string]string
 
 var e = make(env)
@@ -19,6 +23,25 @@ func (e *env) lookup(key string) string {
 	return (*e)[key]
 }
 
+// lookupOptional reads an environment variable which is not required to be
+// set. The fallback is returned when the variable is empty.
+func lookupOptional(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 func (e env) emptyKeys() []string {
 	var keys []string
 	for key, value := range e {
@@ -30,16 +53,47 @@ func (e env) emptyKeys() []string {
 }
 
 type Config struct {
-	Port                string
-	MigrationSourcePath string
-	SeedEmailId         string
-	SeedPhoneNumber     string
-	MongoURI            string
-	MongoDatabasebName  string
-	JwtSecret           string
-	JwtTTL              time.Duration
-	ChallengeTTL        time.Duration
-	LogLevel            string
+	Port                   string
+	MigrationSourcePath    string
+	SeedEmailId            string
+	SeedPhoneNumber        string
+	DatabaseDriver         string
+	SqlitePath             string
+	MongoURI               string
+	MongoDatabasebName     string
+	JwtSecret              string
+	JwtTTL                 time.Duration
+	ChallengeTTL           time.Duration
+	LogLevel               string
+	PublicUrl              string
+	ReportTokenTTL         time.Duration
+	StepUpMaxAge           time.Duration
+	SignupMode             string
+	SignupAllowedDomains   []string
+	DefaultPhoneRegion     string
+	EmailProviderRules     bool
+	DisposableEmailDomains []string
+	TrustForwardedFor      bool
+	ThrottleStore          string
+	ThrottleWindow         time.Duration
+	ThrottleIpLimit        int
+	ThrottleIdentityLimit  int
+	ThrottleUserLimit      int
+	ScimTokens             []string
+	ErasureGracePeriod     time.Duration
+	ErasureCheckInterval   time.Duration
+	EncryptionKeyFile      string
+	SlowQueryThreshold     time.Duration
+	MetricsPort            string
+	UserCacheSize          int
+	UserCacheTTL           time.Duration
+
+	// The sensitive actions can each require a more recent authentication
+	// than StepUpMaxAge
+	PasswordStepUpMaxAge      time.Duration
+	RecoveryCodesStepUpMaxAge time.Duration
+	PrivacyStepUpMaxAge       time.Duration
+	IdentityStepUpMaxAge      time.Duration
 }
 
 func Get() (Config, error) {
@@ -52,8 +106,13 @@ func Get() (Config, error) {
 	conf.SeedEmailId = e.lookup("SEED_EMAIL_ID")
 	conf.SeedPhoneNumber = e.lookup("SEED_PHONE_NUMBER")
 
-	conf.MongoURI = e.lookup("MONGO_URI")
-	conf.MongoDatabasebName = e.lookup("MONGO_DATABASE_NAME")
+	// MongoDB is only required when it is the database driver
+	conf.DatabaseDriver = lookupOptional("DATABASE_DRIVER", "mongo")
+	if conf.DatabaseDriver == "mongo" {
+		conf.MongoURI = e.lookup("MONGO_URI")
+		conf.MongoDatabasebName = e.lookup("MONGO_DATABASE_NAME")
+	}
+	conf.SqlitePath = lookupOptional("SQLITE_PATH", "app.db")
 
 	conf.LogLevel = e.lookup("LOG_LEVEL")
 
@@ -70,6 +129,103 @@ func Get() (Config, error) {
 	}
 	conf.ChallengeTTL = challengeTTL
 
+	conf.PublicUrl = lookupOptional("PUBLIC_URL", "http://localhost:"+conf.Port)
+	conf.ReportTokenTTL, err = time.ParseDuration(lookupOptional("REPORT_TOKEN_TTL", "168h"))
+	if err != nil {
+		return Config{}, err
+	}
+
+	conf.StepUpMaxAge, err = time.ParseDuration(lookupOptional("STEP_UP_MAX_AGE", "5m"))
+	if err != nil {
+		return Config{}, err
+	}
+
+	conf.PasswordStepUpMaxAge, err = time.ParseDuration(lookupOptional("STEP_UP_MAX_AGE_PASSWORD", conf.StepUpMaxAge.String()))
+	if err != nil {
+		return Config{}, err
+	}
+
+	conf.RecoveryCodesStepUpMaxAge, err = time.ParseDuration(lookupOptional("STEP_UP_MAX_AGE_RECOVERY_CODES", conf.StepUpMaxAge.String()))
+	if err != nil {
+		return Config{}, err
+	}
+
+	conf.PrivacyStepUpMaxAge, err = time.ParseDuration(lookupOptional("STEP_UP_MAX_AGE_PRIVACY", conf.StepUpMaxAge.String()))
+	if err != nil {
+		return Config{}, err
+	}
+
+	conf.IdentityStepUpMaxAge, err = time.ParseDuration(lookupOptional("STEP_UP_MAX_AGE_IDENTITY", conf.StepUpMaxAge.String()))
+	if err != nil {
+		return Config{}, err
+	}
+
+	conf.SignupMode = lookupOptional("SIGNUP_MODE", "DISABLED")
+	conf.SignupAllowedDomains = splitList(lookupOptional("SIGNUP_ALLOWED_DOMAINS", ""))
+
+	conf.ScimTokens = splitList(lookupOptional("SCIM_TOKENS", ""))
+
+	conf.ErasureGracePeriod, err = time.ParseDuration(lookupOptional("ERASURE_GRACE_PERIOD", "720h"))
+	if err != nil {
+		return Config{}, err
+	}
+	conf.ErasureCheckInterval, err = time.ParseDuration(lookupOptional("ERASURE_CHECK_INTERVAL", "1h"))
+	if err != nil {
+		return Config{}, err
+	}
+	if conf.ErasureCheckInterval <= 0 {
+		return Config{}, errors.New("ERASURE_CHECK_INTERVAL has to be greater than 0s")
+	}
+
+	conf.EncryptionKeyFile = lookupOptional("ENCRYPTION_KEY_FILE", "")
+
+	conf.SlowQueryThreshold, err = time.ParseDuration(lookupOptional("SLOW_QUERY_THRESHOLD", "100ms"))
+	if err != nil {
+		return Config{}, err
+	}
+
+	conf.MetricsPort = lookupOptional("METRICS_PORT", "")
+
+	conf.UserCacheSize, err = strconv.Atoi(lookupOptional("USER_CACHE_SIZE", "10000"))
+	if err != nil {
+		return Config{}, err
+	}
+	conf.UserCacheTTL, err = time.ParseDuration(lookupOptional("USER_CACHE_TTL", "0s"))
+	if err != nil {
+		return Config{}, err
+	}
+
+	conf.DefaultPhoneRegion = lookupOptional("DEFAULT_PHONE_REGION", "IN")
+
+	conf.EmailProviderRules, err = strconv.ParseBool(lookupOptional("EMAIL_PROVIDER_RULES", "false"))
+	if err != nil {
+		return Config{}, err
+	}
+	conf.DisposableEmailDomains = splitList(lookupOptional("DISPOSABLE_EMAIL_DOMAINS", defaultDisposableEmailDomains))
+
+	conf.TrustForwardedFor, err = strconv.ParseBool(lookupOptional("TRUST_FORWARDED_FOR", "false"))
+	if err != nil {
+		return Config{}, err
+	}
+
+	conf.ThrottleStore = lookupOptional("THROTTLE_STORE", "memory")
+	conf.ThrottleWindow, err = time.ParseDuration(lookupOptional("THROTTLE_WINDOW", "15m"))
+	if err != nil {
+		return Config{}, err
+	}
+	conf.ThrottleIpLimit, err = strconv.Atoi(lookupOptional("THROTTLE_IP_LIMIT", "100"))
+	if err != nil {
+		return Config{}, err
+	}
+	conf.ThrottleIdentityLimit, err = strconv.Atoi(lookupOptional("THROTTLE_IDENTITY_LIMIT", "10"))
+	if err != nil {
+		return Config{}, err
+	}
+	conf.ThrottleUserLimit, err = strconv.Atoi(lookupOptional("THROTTLE_USER_LIMIT", "20"))
+	if err != nil {
+		return Config{}, err
+	}
+
 	emptyKeys := e.emptyKeys()
 	if len(emptyKeys) > 0 {
 		missingEnvVars := strings.Join(emptyKeys[:], ",")
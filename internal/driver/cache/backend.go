@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var _ Backend = &LRU{}
+
+// Backend stores the cached documents of a collection, so that several
+// replicas can share them. Get reports whether the key was found, and Clear
+// removes every key of the collection. Set does not replace a newer version
+// of the document, so that a replica which read it before a versioned write
+// of another replica does not replace the version cached since.
+type Backend interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, version int, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+	Clear(ctx context.Context) error
+}
+
+type entry struct {
+	key     string
+	value   []byte
+	version int
+	expires time.Time
+}
+
+// LRU is an in-process Backend holding up to size entries, which evicts the
+// least recently used entry when it is full, and the expired entries when
+// they are read.
+type LRU struct {
+	size      int
+	mu        *sync.Mutex
+	entries   map[string]*list.Element
+	order     *list.List
+	evictions *uint64
+}
+
+func NewLRU(size int) *LRU {
+	return &LRU{size: size, mu: &sync.Mutex{}, entries: map[string]*list.Element{}, order: list.New(), evictions: new(uint64)}
+}
+
+func (l *LRU) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	element, ok := l.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	e := element.Value.(*entry)
+	if time.Now().After(e.expires) {
+		l.remove(element)
+		atomic.AddUint64(l.evictions, 1)
+		return nil, false, nil
+	}
+	l.order.MoveToFront(element)
+	return e.value, true, nil
+}
+
+func (l *LRU) Set(ctx context.Context, key string, value []byte, version int, ttl time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	expires := time.Now().Add(ttl)
+	if element, ok := l.entries[key]; ok {
+		if element.Value.(*entry).version > version {
+			return nil
+		}
+		element.Value = &entry{key: key, value: value, version: version, expires: expires}
+		l.order.MoveToFront(element)
+		return nil
+	}
+
+	l.entries[key] = l.order.PushFront(&entry{key: key, value: value, version: version, expires: expires})
+	for l.order.Len() > l.size {
+		l.remove(l.order.Back())
+		atomic.AddUint64(l.evictions, 1)
+	}
+	return nil
+}
+
+func (l *LRU) Delete(ctx context.Context, key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if element, ok := l.entries[key]; ok {
+		l.remove(element)
+	}
+	return nil
+}
+
+func (l *LRU) Clear(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = map[string]*list.Element{}
+	l.order.Init()
+	return nil
+}
+
+// Evictions returns the number of entries evicted because the LRU was full or
+// they expired.
+func (l *LRU) Evictions() uint64 {
+	return atomic.LoadUint64(l.evictions)
+}
+
+func (l *LRU) remove(element *list.Element) {
+	l.order.Remove(element)
+	delete(l.entries, element.Value.(*entry).key)
+}
@@ -0,0 +1,282 @@
+// Package cache caches the documents a repository finds by ID.
+package cache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dannypaul/go-skeleton/internal/primitive"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var (
+	_ Repository            = Collection{}
+	_ repository.Transactor = Transactor{}
+)
+
+// Repository is the repository a Collection caches. Its writes are overridden
+// to invalidate the cached documents they change.
+type Repository interface {
+	repository.Collection
+	repository.Incrementer
+	repository.Patcher
+	repository.Setter
+	repository.VersionedWriter
+}
+
+type Options struct {
+	// Size is the number of documents the in-process LRU holds.
+	Size int
+
+	// TTL is the time for which a document is cached, which bounds how stale
+	// it can be when it is written other than through the Collection.
+	TTL time.Duration
+
+	// Backend replaces the in-process LRU, such as with a cache shared by the
+	// replicas of the application.
+	Backend Backend
+}
+
+// Stats are the counters of a Collection. Evictions are only counted by the
+// in-process LRU.
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+// Collection is a read-through cache of the documents found by FindById. The
+// writes through it invalidate the documents they change, and the writes by
+// filters the whole cache, as the documents they change are not known.
+//
+// A document read while a write is in flight is not cached, so that it is not
+// cached as it was before the write. Within transactions the cache is not
+// used, and the documents written are invalidated again once the transaction
+// ends, as they may have been cached before it committed.
+type Collection struct {
+	Repository
+	backend Backend
+	ttl     time.Duration
+	hits    *uint64
+	misses  *uint64
+
+	// writes counts the invalidations of this replica, so that a document
+	// read while one happened is not cached. mu orders the caching of a
+	// document and the invalidations. The writes of other replicas are
+	// guarded by the version of the document instead, see Backend.
+	mu     *sync.Mutex
+	writes *uint64
+}
+
+func NewCollection(inner Repository, options Options) Collection {
+	backend := options.Backend
+	if backend == nil {
+		backend = NewLRU(options.Size)
+	}
+	return Collection{Repository: inner, backend: backend, ttl: options.TTL, hits: new(uint64), misses: new(uint64), mu: &sync.Mutex{}, writes: new(uint64)}
+}
+
+func (c Collection) FindById(ctx context.Context, id primitive.Id) (repository.Copier, error) {
+	if ctx.Value(transactionKey{}) != nil {
+		return c.Repository.FindById(ctx, id)
+	}
+
+	doc, ok, err := c.backend.Get(ctx, string(id))
+	if err != nil {
+		log.Warn().Err(err).Str("id", id.String()).Msg("Could not read the cached document")
+	}
+	if ok {
+		atomic.AddUint64(c.hits, 1)
+		return copier{doc}, nil
+	}
+	atomic.AddUint64(c.misses, 1)
+
+	writes := atomic.LoadUint64(c.writes)
+	found, err := c.Repository.FindById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw bson.Raw
+	err = found.Copy(&raw)
+	if err != nil {
+		return nil, err
+	}
+	// The documents without a version are cached as version 0
+	version, _ := raw.Lookup(repository.VersionKey).AsInt64OK()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if atomic.LoadUint64(c.writes) == writes {
+		err = c.backend.Set(ctx, string(id), raw, int(version), c.ttl)
+		if err != nil {
+			log.Warn().Err(err).Str("id", id.String()).Msg("Could not cache the document")
+		}
+	}
+	return copier{raw}, nil
+}
+
+// Stats returns the number of hits, misses and evictions so far.
+func (c Collection) Stats() Stats {
+	stats := Stats{Hits: atomic.LoadUint64(c.hits), Misses: atomic.LoadUint64(c.misses)}
+	if lru, ok := c.backend.(*LRU); ok {
+		stats.Evictions = lru.Evictions()
+	}
+	return stats
+}
+
+// WriteMetrics writes the counters in the Prometheus text format, labelled
+// with the name of the collection.
+func (c Collection) WriteMetrics(w io.Writer, name string) {
+	stats := c.Stats()
+	for _, counter := range []struct {
+		name  string
+		help  string
+		value uint64
+	}{
+		{"repository_cache_hits_total", "Documents found in the cache.", stats.Hits},
+		{"repository_cache_misses_total", "Documents not found in the cache.", stats.Misses},
+		{"repository_cache_evictions_total", "Documents evicted from the cache.", stats.Evictions},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s{collection=%q} %d\n", counter.name, counter.help, counter.name, counter.name, name, counter.value)
+	}
+}
+
+// invalidate removes the document with the ID from the cache, and again once
+// the transaction of the context ends.
+func (c Collection) invalidate(ctx context.Context, id primitive.Id) {
+	c.evict(ctx, func(ctx context.Context) error {
+		return c.backend.Delete(ctx, string(id))
+	})
+}
+
+// invalidateAll removes every document from the cache, and again once the
+// transaction of the context ends.
+func (c Collection) invalidateAll(ctx context.Context) {
+	c.evict(ctx, c.backend.Clear)
+}
+
+func (c Collection) evict(ctx context.Context, remove func(ctx context.Context) error) {
+	c.removeNow(ctx, remove)
+	if t, ok := ctx.Value(transactionKey{}).(*transaction); ok {
+		t.afterEnd = append(t.afterEnd, func() {
+			c.removeNow(context.Background(), remove)
+		})
+	}
+}
+
+func (c Collection) removeNow(ctx context.Context, remove func(ctx context.Context) error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	atomic.AddUint64(c.writes, 1)
+	err := remove(ctx)
+	if err != nil {
+		log.Warn().Err(err).Msg("Could not invalidate the cached documents")
+	}
+}
+
+func (c Collection) Delete(ctx context.Context, id primitive.Id) (int64, error) {
+	defer c.invalidate(ctx, id)
+	return c.Repository.Delete(ctx, id)
+}
+
+func (c Collection) Replace(ctx context.Context, id primitive.Id, value interface{}) (repository.Copier, error) {
+	defer c.invalidate(ctx, id)
+	return c.Repository.Replace(ctx, id, value)
+}
+
+func (c Collection) IncrementById(ctx context.Context, id primitive.Id, key string, incrementBy int) error {
+	defer c.invalidate(ctx, id)
+	return c.Repository.IncrementById(ctx, id, key, incrementBy)
+}
+
+func (c Collection) Patch(ctx context.Context, id primitive.Id, patches []repository.Patch) error {
+	defer c.invalidate(ctx, id)
+	return c.Repository.Patch(ctx, id, patches)
+}
+
+func (c Collection) Set(ctx context.Context, filters []repository.Filter, key string, value interface{}) error {
+	defer c.invalidateAll(ctx)
+	return c.Repository.Set(ctx, filters, key, value)
+}
+
+func (c Collection) SetById(ctx context.Context, id primitive.Id, key string, value interface{}) error {
+	defer c.invalidate(ctx, id)
+	return c.Repository.SetById(ctx, id, key, value)
+}
+
+func (c Collection) SetAll(ctx context.Context, filters []repository.Filter, keyValues []repository.KeyValue) error {
+	defer c.invalidateAll(ctx)
+	return c.Repository.SetAll(ctx, filters, keyValues)
+}
+
+func (c Collection) SetAllById(ctx context.Context, id primitive.Id, keyValues []repository.KeyValue) error {
+	defer c.invalidate(ctx, id)
+	return c.Repository.SetAllById(ctx, id, keyValues)
+}
+
+func (c Collection) UnSet(ctx context.Context, filters []repository.Filter, key string) error {
+	defer c.invalidateAll(ctx)
+	return c.Repository.UnSet(ctx, filters, key)
+}
+
+func (c Collection) PatchIfVersion(ctx context.Context, id primitive.Id, version int, patches []repository.Patch) error {
+	defer c.invalidate(ctx, id)
+	return c.Repository.PatchIfVersion(ctx, id, version, patches)
+}
+
+func (c Collection) SetAllByIdIfVersion(ctx context.Context, id primitive.Id, version int, keyValues []repository.KeyValue) error {
+	defer c.invalidate(ctx, id)
+	return c.Repository.SetAllByIdIfVersion(ctx, id, version, keyValues)
+}
+
+func (c Collection) ReplaceIfVersion(ctx context.Context, id primitive.Id, version int, value interface{}) (repository.Copier, error) {
+	defer c.invalidate(ctx, id)
+	return c.Repository.ReplaceIfVersion(ctx, id, version, value)
+}
+
+type copier struct {
+	doc bson.Raw
+}
+
+func (c copier) Copy(destination interface{}) error {
+	return bson.Unmarshal(c.doc, destination)
+}
+
+type transactionKey struct{}
+
+type transaction struct {
+	afterEnd []func()
+}
+
+// Transactor marks the contexts of its transactions, so that the Collections
+// bypass the cache within them and invalidate the documents written once they
+// end.
+type Transactor struct {
+	repository.Transactor
+}
+
+func NewTransactor(transactor repository.Transactor) Transactor {
+	return Transactor{transactor}
+}
+
+func (t Transactor) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(transactionKey{}) != nil {
+		return t.Transactor.WithTransaction(ctx, fn)
+	}
+
+	tx := &transaction{}
+	err := t.Transactor.WithTransaction(context.WithValue(ctx, transactionKey{}, tx), fn)
+	for _, invalidate := range tx.afterEnd {
+		invalidate()
+	}
+	return err
+}
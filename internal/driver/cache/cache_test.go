@@ -0,0 +1,170 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dannypaul/go-skeleton/internal/driver/platform/memory"
+	"github.com/dannypaul/go-skeleton/internal/primitive"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+)
+
+type user struct {
+	Id   primitive.Id `bson:"_id,omitempty"`
+	Name string       `bson:"name"`
+}
+
+func find(t *testing.T, c Collection, id primitive.Id) user {
+	t.Helper()
+
+	copier, err := c.FindById(context.Background(), id)
+	if err != nil {
+		t.Fatalf("Could not find the user, got: %v", err)
+	}
+	var u user
+	if err = copier.Copy(&u); err != nil {
+		t.Fatalf("Could not copy the user, got: %v", err)
+	}
+	return u
+}
+
+func TestCollection(t *testing.T) {
+	ctx := context.Background()
+	inner := memory.NewCollection()
+	users := NewCollection(inner, Options{Size: 10, TTL: time.Minute})
+
+	ada, err := repository.Copy[user](users.Create(ctx, user{Name: "Ada"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	find(t, users, ada.Id)
+	find(t, users, ada.Id)
+	if stats := users.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("Stats were incorrect, got: %+v", stats)
+	}
+
+	// Writes through the cache invalidate it, others are not seen
+	if err = inner.SetById(ctx, ada.Id, "name", "Bypassed"); err != nil {
+		t.Fatal(err)
+	}
+	if got := find(t, users, ada.Id); got.Name != "Ada" {
+		t.Errorf("Cached user was incorrect, got: %+v", got)
+	}
+	if err = users.Patch(ctx, ada.Id, []repository.Patch{{Action: "$set", Key: "name", Value: "Ada Lovelace"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := find(t, users, ada.Id); got.Name != "Ada Lovelace" {
+		t.Errorf("User was not invalidated by the patch, got: %+v", got)
+	}
+	if err = users.Set(ctx, []repository.Filter{{Key: "name", Value: "Ada Lovelace"}}, "name", "Countess"); err != nil {
+		t.Fatal(err)
+	}
+	if got := find(t, users, ada.Id); got.Name != "Countess" {
+		t.Errorf("User was not invalidated by the write by filters, got: %+v", got)
+	}
+}
+
+func TestTransactor(t *testing.T) {
+	ctx := context.Background()
+	inner := memory.NewCollection()
+	users := NewCollection(inner, Options{Size: 10, TTL: time.Minute})
+	transactor := NewTransactor(memory.NewTransactor(inner))
+
+	ada, err := repository.Copy[user](users.Create(ctx, user{Name: "Ada"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		err := users.SetById(ctx, ada.Id, "name", "Uncommitted")
+		if err != nil {
+			return err
+		}
+
+		// Another request caches the user before the transaction ends
+		find(t, users, ada.Id)
+		if _, err = users.FindById(ctx, ada.Id); err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats := users.Stats(); stats.Misses != 1 || stats.Hits != 0 {
+		t.Errorf("Cache was used within the transaction, got: %+v", stats)
+	}
+	if err = inner.SetById(ctx, ada.Id, "name", "Committed"); err != nil {
+		t.Fatal(err)
+	}
+	if got := find(t, users, ada.Id); got.Name != "Committed" {
+		t.Errorf("User was not invalidated once the transaction ended, got: %+v", got)
+	}
+}
+
+func TestSharedBackend(t *testing.T) {
+	ctx := context.Background()
+	inner := memory.NewCollection()
+	shared := NewLRU(10)
+	replica := NewCollection(inner, Options{TTL: time.Minute, Backend: shared})
+	other := NewCollection(inner, Options{TTL: time.Minute, Backend: shared})
+
+	ada, err := repository.Copy[user](replica.Create(ctx, user{Name: "Ada"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	find(t, replica, ada.Id)
+	find(t, other, ada.Id)
+	if stats := other.Stats(); stats.Hits != 1 {
+		t.Errorf("Cache was not shared, got: %+v", stats)
+	}
+
+	if err = replica.SetById(ctx, ada.Id, "name", "Ada Lovelace"); err != nil {
+		t.Fatal(err)
+	}
+	if got := find(t, other, ada.Id); got.Name != "Ada Lovelace" {
+		t.Errorf("Shared cache was not invalidated, got: %+v", got)
+	}
+}
+
+func TestLRU(t *testing.T) {
+	ctx := context.Background()
+	lru := NewLRU(2)
+
+	for _, key := range []string{"a", "b", "c"} {
+		if key == "c" {
+			// a is used more recently than b
+			lru.Get(ctx, "a")
+		}
+		_ = lru.Set(ctx, key, []byte(key), 0, time.Minute)
+	}
+	if _, ok, _ := lru.Get(ctx, "b"); ok {
+		t.Errorf("Least recently used entry was not evicted")
+	}
+	if _, ok, _ := lru.Get(ctx, "a"); !ok {
+		t.Errorf("Recently used entry was evicted")
+	}
+
+	_ = lru.Set(ctx, "d", []byte("d"), 0, -time.Second)
+	if _, ok, _ := lru.Get(ctx, "d"); ok {
+		t.Errorf("Expired entry was returned")
+	}
+	if lru.Evictions() != 3 {
+		t.Errorf("Evictions were incorrect, got: %d", lru.Evictions())
+	}
+}
+
+func TestLRUVersion(t *testing.T) {
+	ctx := context.Background()
+	lru := NewLRU(2)
+
+	// A replica which read the document before it was written caches it last
+	_ = lru.Set(ctx, "a", []byte("new"), 2, time.Minute)
+	_ = lru.Set(ctx, "a", []byte("old"), 1, time.Minute)
+	if got, _, _ := lru.Get(ctx, "a"); string(got) != "new" {
+		t.Errorf("Newer version was replaced, got: %s", got)
+	}
+}
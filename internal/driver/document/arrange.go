@@ -0,0 +1,171 @@
+package document
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/dannypaul/go-skeleton/internal/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Arrange sorts, pages and projects the documents matching a query.
+func Arrange(docs []Document, query repository.Query) []Document {
+	sorts := query.Sorts()
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, s := range sorts {
+			order := sortOrder(docs[i], docs[j], Split(s.Key))
+			if s.Descending {
+				order = -order
+			}
+			if order != 0 {
+				return order < 0
+			}
+		}
+		return false
+	})
+
+	if query.Skip >= int64(len(docs)) {
+		return []Document{}
+	}
+	docs = docs[query.Skip:]
+	if query.Limit > 0 && query.Limit < int64(len(docs)) {
+		docs = docs[:query.Limit]
+	}
+	return projectAll(docs, query.Projection)
+}
+
+// Page arranges the documents matching a query, which is the query for the
+// page after a cursor, and returns the cursor of the next page.
+func Page(docs []Document, query repository.Query) ([]Document, string, error) {
+	if query.Limit <= 0 {
+		return Arrange(docs, query), "", nil
+	}
+
+	// One more document tells whether there is a next page, and the sort keys
+	// of the last document are needed even when they are not projected
+	extended := query
+	extended.Limit = query.Limit + 1
+	extended.Projection = nil
+	docs = Arrange(docs, extended)
+	if int64(len(docs)) <= query.Limit {
+		return projectAll(docs, query.Projection), "", nil
+	}
+
+	docs = docs[:query.Limit]
+	var values []json.RawMessage
+	for _, s := range query.Sorts() {
+		value, _ := Get(docs[len(docs)-1], Split(s.Key))
+		encoded, err := CursorValue(value)
+		if err != nil {
+			return nil, "", err
+		}
+		values = append(values, encoded)
+	}
+	next, err := repository.NewCursor(values)
+	if err != nil {
+		return nil, "", err
+	}
+	return projectAll(docs, query.Projection), next, nil
+}
+
+func projectAll(docs []Document, keys []string) []Document {
+	if len(keys) == 0 {
+		return docs
+	}
+	projected := make([]Document, len(docs))
+	for i, doc := range docs {
+		projected[i] = Project(doc, keys)
+	}
+	return projected
+}
+
+// typeOrder is the position of the type of a value in the order in which
+// MongoDB sorts values of different types.
+func typeOrder(value interface{}) int {
+	if _, ok := Number(value); ok {
+		return 2
+	}
+
+	switch value.(type) {
+	case nil:
+		return 1
+	case string:
+		return 3
+	case Document:
+		return 4
+	case []interface{}:
+		return 5
+	case primitive.ObjectID:
+		return 7
+	case bool:
+		return 8
+	case primitive.DateTime:
+		return 9
+	}
+	return 10
+}
+
+// sortOrder compares the values of two documents at the path. Missing values
+// sort as nil, and values of different types sort by their type.
+func sortOrder(a Document, b Document, path []string) int {
+	x, _ := Get(a, path)
+	y, _ := Get(b, path)
+
+	if typeOrder(x) != typeOrder(y) {
+		return typeOrder(x) - typeOrder(y)
+	}
+	order, _ := Compare(x, y)
+	return order
+}
+
+// Project returns a document with only the dotted keys and _id. Like in
+// MongoDB, a key within an array of documents is projected from each of
+// them.
+func Project(doc Document, keys []string) Document {
+	projected := Document{}
+	if id, ok := doc["_id"]; ok {
+		projected["_id"] = id
+	}
+	for _, key := range keys {
+		projected = project(doc, projected, Split(key)).(Document)
+	}
+	return projected
+}
+
+// project merges the part of the value at the path into projected.
+func project(value interface{}, projected interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return Clone(value)
+	}
+
+	switch v := value.(type) {
+	case Document:
+		target, ok := projected.(Document)
+		if !ok {
+			target = Document{}
+		}
+		if child, ok := v[path[0]]; ok {
+			if merged := project(child, target[path[0]], path[1:]); merged != nil {
+				target[path[0]] = merged
+			}
+		}
+		return target
+	case []interface{}:
+		// Elements which are not documents have no keys to project
+		target, _ := projected.([]interface{})
+		array := make([]interface{}, 0, len(v))
+		for _, e := range v {
+			if _, ok := e.(Document); !ok {
+				continue
+			}
+			var existing interface{}
+			if len(array) < len(target) {
+				existing = target[len(array)]
+			}
+			array = append(array, project(e, existing, path))
+		}
+		return array
+	}
+	return projected
+}
@@ -0,0 +1,60 @@
+package document
+
+import (
+	"fmt"
+
+	"github.com/dannypaul/go-skeleton/internal/exception"
+	"github.com/dannypaul/go-skeleton/internal/primitive"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+
+	bsonprimitive "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BulkWrite makes the writes one at a time with write, which returns the ID of
+// the document it created. An ordered bulk write skips the writes after the
+// first one which fails.
+func BulkWrite(writes []repository.Write, ordered bool, write func(w repository.Write) (primitive.Id, error)) ([]repository.WriteResult, error) {
+	for _, w := range writes {
+		switch w.Op {
+		case repository.InsertOp, repository.UpdateOp, repository.UpsertOp, repository.DeleteOp:
+		default:
+			return nil, fmt.Errorf("unsupported write operation '%s'", w.Op)
+		}
+	}
+
+	results := make([]repository.WriteResult, len(writes))
+	failed := false
+	for i, w := range writes {
+		if failed && ordered {
+			results[i].Err = exception.ErrWriteSkipped
+			continue
+		}
+		results[i].Id, results[i].Err = write(w)
+		failed = failed || results[i].Err != nil
+	}
+	return results, nil
+}
+
+// Id returns the ID of the document when it is an ObjectID.
+func Id(doc Document) primitive.Id {
+	if objectId, ok := doc["_id"].(bsonprimitive.ObjectID); ok {
+		return primitive.Id(objectId.Hex())
+	}
+	return ""
+}
+
+// Upserted returns the document an upsert creates from its replacement. Like
+// MongoDB, it takes the ID from an equality filter on _id.
+func Upserted(filters []repository.Filter, replacement Document) (Document, error) {
+	for _, f := range filters {
+		if f.Key != "_id" || (f.Operator != "" && f.Operator != repository.Eq) {
+			continue
+		}
+		id, err := Value(f.Value)
+		if err != nil {
+			return nil, err
+		}
+		replacement["_id"] = id
+	}
+	return replacement, nil
+}
@@ -0,0 +1,317 @@
+// Package document implements the MongoDB document semantics shared by the
+// drivers which store documents without MongoDB.
+package document
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Document is a document the way MongoDB stores it, BSON marshalled with the
+// bson struct tags of the models, so that filters and patches see the same
+// field names and value types as they do in MongoDB.
+type Document = map[string]interface{}
+
+// FromModel marshals a model to a Document.
+func FromModel(model interface{}) (Document, error) {
+	raw, err := bson.Marshal(model)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc bson.D
+	err = bson.Unmarshal(raw, &doc)
+	if err != nil {
+		return nil, err
+	}
+	return normalise(doc).(Document), nil
+}
+
+// Value converts a Go value to the value it is stored as.
+func Value(value interface{}) (interface{}, error) {
+	doc, err := FromModel(bson.M{"v": value})
+	if err != nil {
+		return nil, err
+	}
+	return doc["v"], nil
+}
+
+// ToJSON encodes a Document as canonical MongoDB Extended JSON, which keeps
+// the BSON types of the values.
+func ToJSON(doc Document) ([]byte, error) {
+	return bson.MarshalExtJSON(doc, true, false)
+}
+
+// FromJSON decodes a Document encoded by ToJSON.
+func FromJSON(data []byte) (Document, error) {
+	var doc bson.D
+	err := bson.UnmarshalExtJSON(data, true, &doc)
+	if err != nil {
+		return nil, err
+	}
+	return normalise(doc).(Document), nil
+}
+
+// CursorValue encodes a value of a Document for repository.NewCursor as
+// canonical Extended JSON, which keeps its BSON type.
+func CursorValue(value interface{}) (json.RawMessage, error) {
+	return ToJSON(Document{"v": value})
+}
+
+// FromCursorValue decodes a value encoded by CursorValue.
+func FromCursorValue(data json.RawMessage) (interface{}, error) {
+	doc, err := FromJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	return doc["v"], nil
+}
+
+// normalise converts the documents and arrays decoded by the bson package to
+// maps and slices.
+func normalise(value interface{}) interface{} {
+	switch v := value.(type) {
+	case primitive.D:
+		doc := make(Document, len(v))
+		for _, e := range v {
+			doc[e.Key] = normalise(e.Value)
+		}
+		return doc
+	case primitive.M:
+		doc := make(Document, len(v))
+		for key, e := range v {
+			doc[key] = normalise(e)
+		}
+		return doc
+	case primitive.A:
+		array := make([]interface{}, len(v))
+		for i, e := range v {
+			array[i] = normalise(e)
+		}
+		return array
+	}
+	return value
+}
+
+// Clone deep copies a document so that stored documents are never shared with
+// callers.
+func Clone(value interface{}) interface{} {
+	switch v := value.(type) {
+	case Document:
+		doc := make(Document, len(v))
+		for key, e := range v {
+			doc[key] = Clone(e)
+		}
+		return doc
+	case []interface{}:
+		array := make([]interface{}, len(v))
+		for i, e := range v {
+			array[i] = Clone(e)
+		}
+		return array
+	}
+	return value
+}
+
+func Number(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// Equal compares stored values. Numbers of different types are equal when
+// their values are, as in MongoDB.
+func Equal(a interface{}, b interface{}) bool {
+	if x, ok := Number(a); ok {
+		y, ok := Number(b)
+		return ok && x == y
+	}
+
+	switch x := a.(type) {
+	case Document:
+		y, ok := b.(Document)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for key, value := range x {
+			if other, ok := y[key]; !ok || !Equal(value, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !Equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// Lookup returns the values a query on the dotted path matches against. Arrays
+// on the path are traversed, and an array at the end of the path matches both
+// as a whole and by its elements.
+func Lookup(value interface{}, path []string) []interface{} {
+	if len(path) == 0 {
+		if array, ok := value.([]interface{}); ok {
+			return append([]interface{}{value}, array...)
+		}
+		return []interface{}{value}
+	}
+
+	switch v := value.(type) {
+	case Document:
+		child, ok := v[path[0]]
+		if !ok {
+			return nil
+		}
+		return Lookup(child, path[1:])
+	case []interface{}:
+		if i, err := strconv.Atoi(path[0]); err == nil {
+			if i < 0 || i >= len(v) {
+				return nil
+			}
+			return Lookup(v[i], path[1:])
+		}
+		var values []interface{}
+		for _, e := range v {
+			if _, ok := e.(Document); ok {
+				values = append(values, Lookup(e, path)...)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// IndexValues returns the values a multikey index has for the dotted path.
+func IndexValues(value interface{}, path []string) []interface{} {
+	if array, ok := value.([]interface{}); ok {
+		var values []interface{}
+		for _, e := range array {
+			values = append(values, IndexValues(e, path)...)
+		}
+		return values
+	}
+
+	if len(path) == 0 {
+		return []interface{}{value}
+	}
+
+	if doc, ok := value.(Document); ok {
+		if child, ok := doc[path[0]]; ok {
+			return IndexValues(child, path[1:])
+		}
+	}
+	return nil
+}
+
+func Split(path string) []string {
+	return strings.Split(path, ".")
+}
+
+// parent returns the document or array holding the last segment of the path,
+// creating the missing documents on the way when create is set.
+func parent(doc Document, path []string, create bool) (interface{}, bool) {
+	var current interface{} = doc
+	for _, segment := range path[:len(path)-1] {
+		switch v := current.(type) {
+		case Document:
+			child, ok := v[segment]
+			if !ok {
+				if !create {
+					return nil, false
+				}
+				child = Document{}
+				v[segment] = child
+			}
+			current = child
+		case []interface{}:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			current = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func Get(doc Document, path []string) (interface{}, bool) {
+	container, ok := parent(doc, path, false)
+	if !ok {
+		return nil, false
+	}
+
+	last := path[len(path)-1]
+	switch v := container.(type) {
+	case Document:
+		value, ok := v[last]
+		return value, ok
+	case []interface{}:
+		i, err := strconv.Atoi(last)
+		if err != nil || i < 0 || i >= len(v) {
+			return nil, false
+		}
+		return v[i], true
+	}
+	return nil, false
+}
+
+func Set(doc Document, path []string, value interface{}) bool {
+	container, ok := parent(doc, path, true)
+	if !ok {
+		return false
+	}
+
+	last := path[len(path)-1]
+	switch v := container.(type) {
+	case Document:
+		v[last] = value
+		return true
+	case []interface{}:
+		i, err := strconv.Atoi(last)
+		if err != nil || i < 0 || i >= len(v) {
+			return false
+		}
+		v[i] = value
+		return true
+	}
+	return false
+}
+
+func Unset(doc Document, path []string) {
+	container, ok := parent(doc, path, false)
+	if !ok {
+		return
+	}
+
+	last := path[len(path)-1]
+	switch v := container.(type) {
+	case Document:
+		delete(v, last)
+	case []interface{}:
+		if i, err := strconv.Atoi(last); err == nil && i >= 0 && i < len(v) {
+			v[i] = nil
+		}
+	}
+}
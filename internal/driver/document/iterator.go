@@ -0,0 +1,90 @@
+package document
+
+import (
+	"context"
+	"errors"
+
+	"github.com/dannypaul/go-skeleton/internal/repository"
+)
+
+// Fetch returns the page of the documents matching a query which follows the
+// cursor, and the cursor of the next page.
+type Fetch func(ctx context.Context, query repository.Query, cursor string) ([]Document, string, error)
+
+// Iterator implements repository.Iterator by fetching the documents of a query
+// a batch at a time, as they are consumed.
+type Iterator struct {
+	query     repository.Query
+	fetch     Fetch
+	remaining int64
+	batch     []Document
+	cursor    string
+	current   Document
+	done      bool
+	err       error
+}
+
+var _ repository.Iterator = &Iterator{}
+
+// NewIterator returns an Iterator over the documents matching the query in
+// batches of batchSize. The Limit of the query limits the total number of
+// documents.
+func NewIterator(query repository.Query, batchSize int64, fetch Fetch) *Iterator {
+	iterator := &Iterator{query: query, fetch: fetch, remaining: query.Limit}
+	iterator.query.Limit = batchSize
+	return iterator
+}
+
+func (it *Iterator) Next(ctx context.Context) bool {
+	if it.err != nil {
+		return false
+	}
+	if err := ctx.Err(); err != nil {
+		it.err = err
+		return false
+	}
+
+	for len(it.batch) == 0 {
+		if it.done {
+			return false
+		}
+
+		query := it.query
+		if it.remaining > 0 && it.remaining < query.Limit {
+			query.Limit = it.remaining
+		}
+		batch, next, err := it.fetch(ctx, query, it.cursor)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.batch, it.cursor, it.done = batch, next, next == ""
+	}
+
+	it.current, it.batch = it.batch[0], it.batch[1:]
+	if it.remaining > 0 {
+		it.remaining--
+		if it.remaining == 0 {
+			it.batch = nil
+			it.done = true
+		}
+	}
+	return true
+}
+
+func (it *Iterator) Decode(destination interface{}) error {
+	if it.current == nil {
+		return errors.New("there is no current document to decode")
+	}
+	return Decode(it.current, destination)
+}
+
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+func (it *Iterator) Close(ctx context.Context) error {
+	it.batch = nil
+	it.done = true
+	return nil
+}
@@ -0,0 +1,198 @@
+package document
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/dannypaul/go-skeleton/internal/repository"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Matches reports whether the document matches all of the filters.
+func Matches(doc Document, filters []repository.Filter) (bool, error) {
+	for _, f := range filters {
+		ok, err := matches(doc, f)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matches(doc Document, f repository.Filter) (bool, error) {
+	if len(f.Or) > 0 {
+		for _, alternative := range f.Or {
+			ok, err := matches(doc, alternative)
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+
+	if len(f.And) > 0 {
+		return Matches(doc, f.And)
+	}
+
+	value, err := Value(f.Value)
+	if err != nil {
+		return false, err
+	}
+
+	candidates := Lookup(doc, Split(f.Key))
+
+	switch f.Operator {
+	case "", repository.Eq:
+		return contains(candidates, value), nil
+	case repository.Ne:
+		return !contains(candidates, value), nil
+	case repository.Gt, repository.Gte, repository.Lt, repository.Lte:
+		for _, candidate := range candidates {
+			order, ok := Compare(candidate, value)
+			if !ok {
+				continue
+			}
+			switch {
+			case f.Operator == repository.Gt && order > 0,
+				f.Operator == repository.Gte && order >= 0,
+				f.Operator == repository.Lt && order < 0,
+				f.Operator == repository.Lte && order <= 0:
+				return true, nil
+			}
+		}
+		return false, nil
+	case repository.In, repository.Nin:
+		values, ok := value.([]interface{})
+		if !ok && value != nil {
+			return false, fmt.Errorf("the value of the %s filter on %s is not a slice", f.Operator, f.Key)
+		}
+		in := false
+		for _, v := range values {
+			if contains(candidates, v) {
+				in = true
+				break
+			}
+		}
+		return in == (f.Operator == repository.In), nil
+	case repository.Exists:
+		exists, ok := value.(bool)
+		if !ok {
+			return false, fmt.Errorf("the value of the %s filter on %s is not a bool", f.Operator, f.Key)
+		}
+		return (len(candidates) > 0) == exists, nil
+	case repository.Prefix:
+		prefix, ok := value.(string)
+		if !ok {
+			return false, fmt.Errorf("the value of the %s filter on %s is not a string", f.Operator, f.Key)
+		}
+		for _, candidate := range candidates {
+			if s, ok := candidate.(string); ok && strings.HasPrefix(s, prefix) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, fmt.Errorf("filter operator %s is not supported", f.Operator)
+}
+
+// contains reports whether one of the candidates equals the value. A nil value
+// also matches documents without the field, as in MongoDB.
+func contains(candidates []interface{}, value interface{}) bool {
+	if value == nil && len(candidates) == 0 {
+		return true
+	}
+	for _, candidate := range candidates {
+		if Equal(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Compare orders two values of the same BSON type, numbers of any type being
+// the same type. It reports false for values which cannot be compared, which
+// range filters do not match, as in MongoDB.
+func Compare(a interface{}, b interface{}) (int, bool) {
+	if x, ok := Number(a); ok {
+		y, ok := Number(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+
+	switch x := a.(type) {
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	case primitive.DateTime:
+		if y, ok := b.(primitive.DateTime); ok {
+			return Compare(int64(x), int64(y))
+		}
+	case primitive.ObjectID:
+		if y, ok := b.(primitive.ObjectID); ok {
+			return bytes.Compare(x[:], y[:]), true
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			switch {
+			case x == y:
+				return 0, true
+			case y:
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, false
+}
+
+// IndexEntries returns the entries of the document in an index on the dotted
+// paths in keys, one for every combination of the values of the keys. Like the
+// sparse indexes of the migrations, documents without any of the keys have no
+// entries.
+func IndexEntries(doc Document, keys []string) [][]interface{} {
+	entries := [][]interface{}{{}}
+	indexed := false
+	for _, key := range keys {
+		values := IndexValues(doc, Split(key))
+		if len(values) == 0 {
+			values = []interface{}{nil}
+		} else {
+			indexed = true
+		}
+
+		var combined [][]interface{}
+		for _, entry := range entries {
+			for _, value := range values {
+				combined = append(combined, append(append([]interface{}{}, entry...), value))
+			}
+		}
+		entries = combined
+	}
+
+	if !indexed {
+		return nil
+	}
+	return entries
+}
+
+// Decode unmarshals the document into destination the way the mongo driver
+// decodes a result.
+func Decode(doc Document, destination interface{}) error {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(raw, destination)
+}
@@ -0,0 +1,107 @@
+package document
+
+import (
+	"fmt"
+
+	"github.com/dannypaul/go-skeleton/internal/repository"
+)
+
+// Apply applies the update operators used by the repositories.
+func Apply(doc Document, p repository.Patch) error {
+	path := Split(p.Key)
+	value, err := Value(p.Value)
+	if err != nil {
+		return err
+	}
+
+	switch p.Action {
+	case "$set":
+		if !Set(doc, path, value) {
+			return fmt.Errorf("cannot set the field %s", p.Key)
+		}
+	case "$unset":
+		Unset(doc, path)
+	case "$inc":
+		current, _ := Get(doc, path)
+		if current == nil {
+			current = int32(0)
+		}
+		sum, err := add(current, value)
+		if err != nil {
+			return fmt.Errorf("cannot increment the field %s %w", p.Key, err)
+		}
+		Set(doc, path, sum)
+	case "$push", "$addToSet", "$pull":
+		current, _ := Get(doc, path)
+		array, ok := current.([]interface{})
+		if current != nil && !ok {
+			return fmt.Errorf("the field %s is not an array", p.Key)
+		}
+
+		switch p.Action {
+		case "$push":
+			array = append(array, value)
+		case "$addToSet":
+			exists := false
+			for _, e := range array {
+				exists = exists || Equal(e, value)
+			}
+			if !exists {
+				array = append(array, value)
+			}
+		case "$pull":
+			pulled := make([]interface{}, 0, len(array))
+			for _, e := range array {
+				if !Equal(e, value) {
+					pulled = append(pulled, e)
+				}
+			}
+			array = pulled
+		}
+
+		if array == nil {
+			array = []interface{}{}
+		}
+		Set(doc, path, array)
+	default:
+		return fmt.Errorf("update operator %s is not supported", p.Action)
+	}
+	return nil
+}
+
+// add adds numbers keeping integers integral, as $inc does.
+func add(a interface{}, b interface{}) (interface{}, error) {
+	x, ok := Number(a)
+	y, ok2 := Number(b)
+	if !ok || !ok2 {
+		return nil, fmt.Errorf("cannot add non numeric values")
+	}
+
+	_, aFloat := a.(float64)
+	_, bFloat := b.(float64)
+	if aFloat || bFloat {
+		return x + y, nil
+	}
+
+	sum := int64(x) + int64(y)
+	_, aInt32 := a.(int32)
+	_, bInt32 := b.(int32)
+	if aInt32 && bInt32 && sum >= -1<<31 && sum < 1<<31 {
+		return int32(sum), nil
+	}
+	return sum, nil
+}
+
+// SetAll sets the dotted keys to the values.
+func SetAll(doc Document, keyValues []repository.KeyValue) error {
+	for _, kv := range keyValues {
+		value, err := Value(kv.Value)
+		if err != nil {
+			return err
+		}
+		if !Set(doc, Split(kv.Key), value) {
+			return fmt.Errorf("cannot set the field %s", kv.Key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package encryption
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DataKey is a key encrypting the values of the fields, wrapped by the master
+// key with the ID MasterKeyId.
+type DataKey struct {
+	Id          string    `bson:"_id"`
+	MasterKeyId string    `bson:"masterKeyId"`
+	Wrapped     []byte    `bson:"wrapped"`
+	CreatedAt   time.Time `bson:"createdAt"`
+}
+
+// KeyStore keeps the wrapped data keys. DataKeys returns them in the order
+// they were created.
+type KeyStore interface {
+	DataKeys(ctx context.Context) ([]DataKey, error)
+	SaveDataKey(ctx context.Context, key DataKey) error
+}
+
+// dataKeySize is the size of a data key, an AES-256 key followed by the
+// HMAC-SHA256 key deriving the nonces of deterministic encryption.
+const dataKeySize = 64
+
+// binarySubtype is the user defined BSON binary subtype of encrypted values.
+const binarySubtype = 0x80
+
+// formatVersion is the version of the layout of encrypted values.
+const formatVersion = 1
+
+// refreshTimeout bounds the reload of the data keys when a value is
+// encrypted with a data key which is not loaded.
+const refreshTimeout = 10 * time.Second
+
+// Cipher encrypts values with the newest data key, and decrypts them with any
+// of the data keys of the key store.
+type Cipher struct {
+	keyring Keyring
+	store   KeyStore
+
+	mu     *sync.RWMutex
+	keys   map[string][]byte
+	order  []string
+	active string
+}
+
+// NewCipher loads the data keys of the store, creating the first one when
+// there is none.
+func NewCipher(ctx context.Context, keyring Keyring, store KeyStore) (*Cipher, error) {
+	c := &Cipher{keyring: keyring, store: store, mu: &sync.RWMutex{}}
+	err := c.Refresh(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if c.active == "" {
+		_, err = c.RotateDataKey(ctx)
+	}
+	return c, err
+}
+
+// Refresh reloads the data keys of the store, so that the data keys created
+// by other processes are used.
+func (c *Cipher) Refresh(ctx context.Context) error {
+	dataKeys, err := c.store.DataKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("could not load the data keys %w", err)
+	}
+
+	keys := map[string][]byte{}
+	var order []string
+	for _, dataKey := range dataKeys {
+		key, err := c.keyring.unwrap(dataKey)
+		if err != nil {
+			return err
+		}
+		keys[dataKey.Id] = key
+		order = append(order, dataKey.Id)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keys, c.order = keys, order
+	c.active = ""
+	if len(order) > 0 {
+		c.active = order[len(order)-1]
+	}
+	return nil
+}
+
+// RotateDataKey creates a data key, which encrypts the values from then on,
+// and returns its ID. The values encrypted with the previous data keys are
+// re-encrypted by re-encrypting the collections.
+func (c *Cipher) RotateDataKey(ctx context.Context) (string, error) {
+	key := make([]byte, dataKeySize)
+	id := make([]byte, 8)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	dataKey, err := c.keyring.wrap(hex.EncodeToString(id), key)
+	if err != nil {
+		return "", err
+	}
+	dataKey.CreatedAt = time.Now()
+	err = c.store.SaveDataKey(ctx, dataKey)
+	if err != nil {
+		return "", fmt.Errorf("could not save the data key %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keys[dataKey.Id] = key
+	c.order = append(c.order, dataKey.Id)
+	c.active = dataKey.Id
+	return dataKey.Id, nil
+}
+
+// Rewrap wraps the data keys which are not wrapped by the active master key
+// with it, so that the previous master keys can be removed from the keyring.
+// It returns the number of data keys rewrapped.
+func (c *Cipher) Rewrap(ctx context.Context) (int, error) {
+	dataKeys, err := c.store.DataKeys(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("could not load the data keys %w", err)
+	}
+
+	rewrapped := 0
+	for _, dataKey := range dataKeys {
+		if dataKey.MasterKeyId == c.keyring.active {
+			continue
+		}
+
+		key, err := c.keyring.unwrap(dataKey)
+		if err != nil {
+			return rewrapped, err
+		}
+		wrapped, err := c.keyring.wrap(dataKey.Id, key)
+		if err != nil {
+			return rewrapped, err
+		}
+		wrapped.CreatedAt = dataKey.CreatedAt
+		err = c.store.SaveDataKey(ctx, wrapped)
+		if err != nil {
+			return rewrapped, fmt.Errorf("could not save the data key %w", err)
+		}
+		rewrapped++
+	}
+	return rewrapped, nil
+}
+
+// activeKey returns the ID and the key of the newest data key.
+func (c *Cipher) activeKey() (string, []byte) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.active, c.keys[c.active]
+}
+
+// allKeys returns the IDs and the keys of every data key.
+func (c *Cipher) allKeys() ([]string, [][]byte) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	keys := make([][]byte, len(c.order))
+	for i, id := range c.order {
+		keys[i] = c.keys[id]
+	}
+	return append([]string{}, c.order...), keys
+}
+
+// key returns the data key with the ID, reloading the data keys when it is
+// not loaded.
+func (c *Cipher) key(id string) ([]byte, error) {
+	c.mu.RLock()
+	key, ok := c.keys[id]
+	c.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+	defer cancel()
+	err := c.Refresh(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	key, ok = c.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("data key '%s' does not exist", id)
+	}
+	return key, nil
+}
+
+// encrypt encrypts the value of the field at the path with the data key. The
+// path is authenticated, so that a value cannot be moved to another field.
+// Deterministic encryption derives the nonce from the path and the value, so
+// that equal values have equal ciphertexts.
+func encrypt(keyId string, key []byte, path string, mode Mode, value interface{}) (primitive.Binary, error) {
+	t, data, err := bson.MarshalValue(value)
+	if err != nil {
+		return primitive.Binary{}, err
+	}
+	plaintext := append([]byte{byte(t)}, data...)
+
+	aead, err := newAEAD(key[:32])
+	if err != nil {
+		return primitive.Binary{}, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if mode == Deterministic {
+		mac := hmac.New(sha256.New, key[32:])
+		mac.Write([]byte(path))
+		mac.Write([]byte{0})
+		mac.Write(plaintext)
+		copy(nonce, mac.Sum(nil))
+	} else if _, err = rand.Read(nonce); err != nil {
+		return primitive.Binary{}, err
+	}
+
+	header := append([]byte{formatVersion, byte(mode), byte(len(keyId))}, keyId...)
+	sealed := aead.Seal(append(header, nonce...), nonce, plaintext, []byte(path))
+	return primitive.Binary{Subtype: binarySubtype, Data: sealed}, nil
+}
+
+// encrypted is the header of an encrypted value.
+type encrypted struct {
+	mode  Mode
+	keyId string
+	rest  []byte
+}
+
+// parse reads the header of the value when it is an encrypted value.
+func parse(value interface{}) (encrypted, bool) {
+	binary, ok := value.(primitive.Binary)
+	if !ok || binary.Subtype != binarySubtype || len(binary.Data) < 3 || binary.Data[0] != formatVersion {
+		return encrypted{}, false
+	}
+
+	keyIdLength := int(binary.Data[2])
+	if len(binary.Data) < 3+keyIdLength {
+		return encrypted{}, false
+	}
+	return encrypted{
+		mode:  Mode(binary.Data[1]),
+		keyId: string(binary.Data[3 : 3+keyIdLength]),
+		rest:  binary.Data[3+keyIdLength:],
+	}, true
+}
+
+// decrypt decrypts the value of the field at the path.
+func (c *Cipher) decrypt(path string, e encrypted) (bson.RawValue, error) {
+	key, err := c.key(e.keyId)
+	if err != nil {
+		return bson.RawValue{}, err
+	}
+
+	aead, err := newAEAD(key[:32])
+	if err != nil {
+		return bson.RawValue{}, err
+	}
+	if len(e.rest) < aead.NonceSize() {
+		return bson.RawValue{}, errors.New("encrypted value is too short")
+	}
+
+	nonce, sealed := e.rest[:aead.NonceSize()], e.rest[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, sealed, []byte(path))
+	if err != nil || len(plaintext) == 0 {
+		return bson.RawValue{}, fmt.Errorf("could not decrypt the value of '%s' %w", path, err)
+	}
+	return bson.RawValue{Type: bsontype.Type(plaintext[0]), Value: plaintext[1:]}, nil
+}
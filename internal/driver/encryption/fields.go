@@ -0,0 +1,398 @@
+package encryption
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/dannypaul/go-skeleton/internal/repository"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Mode byte
+
+const (
+	// Randomized encrypts equal values to different ciphertexts, so the field
+	// cannot be queried
+	Randomized Mode = 1
+	// Deterministic encrypts equal values of a field to the same ciphertext
+	// under a data key, so the field can be matched by equality and stay in
+	// unique indexes
+	Deterministic Mode = 2
+)
+
+// Field is the dotted path of an encrypted field. The arrays along the path
+// are traversed, so "identities.emailId" encrypts the emailId of every
+// identity.
+type Field struct {
+	Path string
+	Mode Mode
+}
+
+// Fields encrypts the fields of the documents of a collection. A nil *Fields
+// encrypts nothing.
+type Fields struct {
+	cipher *Cipher
+	fields []Field
+}
+
+func NewFields(cipher *Cipher, fields ...Field) *Fields {
+	return &Fields{cipher: cipher, fields: fields}
+}
+
+// EncryptDocument returns the document with the values of the fields
+// encrypted with the active data key. The document is not modified.
+func (f *Fields) EncryptDocument(doc bson.D) (bson.D, error) {
+	if f == nil {
+		return doc, nil
+	}
+
+	keyId, key := f.cipher.activeKey()
+	var value interface{} = doc
+	for _, field := range f.fields {
+		var err error
+		value, err = transform(value, strings.Split(field.Path, "."), func(leaf interface{}) (interface{}, error) {
+			if _, ok := parse(leaf); ok {
+				return leaf, nil
+			}
+			return encrypt(keyId, key, field.Path, field.Mode, leaf)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return value.(bson.D), nil
+}
+
+// DecryptDocument returns the document with the values of the fields
+// decrypted. The values which are not encrypted are kept as they are.
+func (f *Fields) DecryptDocument(raw bson.Raw) (bson.Raw, error) {
+	if f == nil {
+		return raw, nil
+	}
+
+	var doc bson.D
+	err := bson.Unmarshal(raw, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{} = doc
+	decrypted := false
+	for _, field := range f.fields {
+		value, err = transform(value, strings.Split(field.Path, "."), func(leaf interface{}) (interface{}, error) {
+			e, ok := parse(leaf)
+			if !ok {
+				return leaf, nil
+			}
+			decrypted = true
+			return f.cipher.decrypt(field.Path, e)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !decrypted {
+		return raw, nil
+	}
+	return bson.Marshal(value)
+}
+
+// Reencrypt encrypts the values of the fields which are in plaintext or
+// encrypted with another data key than the active one with the active data
+// key. It returns the top level elements of the document which changed.
+func (f *Fields) Reencrypt(doc bson.D) (bson.D, error) {
+	if f == nil {
+		return bson.D{}, nil
+	}
+
+	keyId, key := f.cipher.activeKey()
+	changed := map[string]bool{}
+	var value interface{} = doc
+	for _, field := range f.fields {
+		segments := strings.Split(field.Path, ".")
+		var err error
+		value, err = transform(value, segments, func(leaf interface{}) (interface{}, error) {
+			e, ok := parse(leaf)
+			if ok && e.keyId == keyId && e.mode == field.Mode {
+				return leaf, nil
+			}
+
+			plaintext := leaf
+			if ok {
+				decrypted, err := f.cipher.decrypt(field.Path, e)
+				if err != nil {
+					return nil, err
+				}
+				plaintext = decrypted
+			}
+			changed[segments[0]] = true
+			return encrypt(keyId, key, field.Path, field.Mode, plaintext)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	elements := bson.D{}
+	for _, e := range value.(bson.D) {
+		if changed[e.Key] {
+			elements = append(elements, e)
+		}
+	}
+	return elements, nil
+}
+
+// Update returns the update document with the values set on the fields, or on
+// the documents and arrays containing them, encrypted with the active data
+// key. The update document is not modified.
+func (f *Fields) Update(update bson.D) (bson.D, error) {
+	if f == nil {
+		return update, nil
+	}
+
+	keyId, key := f.cipher.activeKey()
+	encrypted := make(bson.D, len(update))
+	for i, operation := range update {
+		encrypted[i] = operation
+		setters, ok := operation.Value.(bson.D)
+		if !ok || !updatesValues(operation.Key) {
+			continue
+		}
+
+		encryptedSetters := make(bson.D, len(setters))
+		for j, setter := range setters {
+			value, err := f.encryptSetter(keyId, key, segmentsOf(setter.Key), setter.Value)
+			if err != nil {
+				return nil, err
+			}
+			encryptedSetters[j] = bson.E{Key: setter.Key, Value: value}
+		}
+		encrypted[i].Value = encryptedSetters
+	}
+	return encrypted, nil
+}
+
+// updatesValues tells whether the values of the update operator are stored
+// in the document.
+func updatesValues(operator string) bool {
+	switch operator {
+	case "$set", "$setOnInsert", "$push", "$addToSet":
+		return true
+	}
+	return false
+}
+
+// encryptSetter encrypts the fields within the value set at the key.
+func (f *Fields) encryptSetter(keyId string, key []byte, keySegments []string, value interface{}) (interface{}, error) {
+	converted := false
+	for _, field := range f.fields {
+		fieldSegments := strings.Split(field.Path, ".")
+		if !hasPrefix(fieldSegments, keySegments) {
+			continue
+		}
+
+		if !converted {
+			var err error
+			value, err = toValue(value)
+			if err != nil {
+				return nil, err
+			}
+			converted = true
+		}
+
+		var err error
+		value, err = transform(value, fieldSegments[len(keySegments):], func(leaf interface{}) (interface{}, error) {
+			if _, ok := parse(leaf); ok {
+				return leaf, nil
+			}
+			return encrypt(keyId, key, field.Path, field.Mode, leaf)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+// Filters returns the filters with the equality matches on the deterministic
+// fields matching the ciphertexts of the values under every data key. The
+// other filters on encrypted fields cannot match, and are kept as they are.
+func (f *Fields) Filters(filters []repository.Filter) []repository.Filter {
+	if f == nil || len(filters) == 0 {
+		return filters
+	}
+
+	encrypted := make([]repository.Filter, len(filters))
+	for i, filter := range filters {
+		encrypted[i] = f.filter(filter)
+	}
+	return encrypted
+}
+
+func (f *Fields) filter(filter repository.Filter) repository.Filter {
+	if len(filter.Or) > 0 || len(filter.And) > 0 {
+		filter.Or = f.Filters(filter.Or)
+		filter.And = f.Filters(filter.And)
+		return filter
+	}
+
+	path := strings.Join(segmentsOf(filter.Key), ".")
+	for _, field := range f.fields {
+		if field.Path != path || field.Mode != Deterministic || filter.Value == nil {
+			continue
+		}
+
+		var values []interface{}
+		var operator repository.Operator
+		switch filter.Operator {
+		case "", repository.Eq, repository.Ne:
+			values = []interface{}{filter.Value}
+			operator = repository.In
+			if filter.Operator == repository.Ne {
+				operator = repository.Nin
+			}
+		case repository.In, repository.Nin:
+			var ok bool
+			values, ok = filter.Value.([]interface{})
+			if !ok {
+				values = toSlice(filter.Value)
+			}
+			operator = filter.Operator
+		default:
+			return filter
+		}
+
+		ciphertexts, err := f.ciphertexts(field, values)
+		if err != nil {
+			return filter
+		}
+		return repository.Filter{Key: filter.Key, Operator: operator, Value: ciphertexts}
+	}
+	return filter
+}
+
+// ciphertexts returns the values and their ciphertexts under every data key,
+// as the documents which have not been re-encrypted yet hold the others.
+func (f *Fields) ciphertexts(field Field, values []interface{}) (bson.A, error) {
+	keyIds, keys := f.cipher.allKeys()
+	ciphertexts := bson.A{}
+	for _, value := range values {
+		ciphertexts = append(ciphertexts, value)
+		for i, keyId := range keyIds {
+			ciphertext, err := encrypt(keyId, keys[i], field.Path, field.Mode, value)
+			if err != nil {
+				return nil, err
+			}
+			ciphertexts = append(ciphertexts, ciphertext)
+		}
+	}
+	return ciphertexts, nil
+}
+
+// transform replaces the values at the path within the value with the result
+// of the leaf function, traversing the arrays along the path. The documents
+// and arrays along the path are copied.
+func transform(value interface{}, segments []string, leaf func(interface{}) (interface{}, error)) (interface{}, error) {
+	switch v := value.(type) {
+	case primitive.A:
+		copied := make(primitive.A, len(v))
+		for i, element := range v {
+			transformed, err := transform(element, segments, leaf)
+			if err != nil {
+				return nil, err
+			}
+			copied[i] = transformed
+		}
+		return copied, nil
+	case primitive.D:
+		if len(v) > 0 && v[0].Key == "$each" {
+			each, err := transform(v[0].Value, segments, leaf)
+			if err != nil {
+				return nil, err
+			}
+			return append(primitive.D{{Key: "$each", Value: each}}, v[1:]...), nil
+		}
+		if len(segments) == 0 {
+			return v, nil
+		}
+		copied := make(primitive.D, len(v))
+		copy(copied, v)
+		for i, e := range copied {
+			if e.Key != segments[0] {
+				continue
+			}
+			transformed, err := transform(e.Value, segments[1:], leaf)
+			if err != nil {
+				return nil, err
+			}
+			copied[i].Value = transformed
+		}
+		return copied, nil
+	case nil:
+		return nil, nil
+	}
+
+	if len(segments) > 0 {
+		return value, nil
+	}
+	return leaf(value)
+}
+
+// segmentsOf returns the segments of a dotted key without the array indexes
+// and positional operators, which a path of a field does not have.
+func segmentsOf(key string) []string {
+	var segments []string
+	for _, segment := range strings.Split(key, ".") {
+		if _, err := strconv.Atoi(segment); err == nil || strings.HasPrefix(segment, "$") {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+	return segments
+}
+
+func hasPrefix(segments []string, prefix []string) bool {
+	if len(prefix) > len(segments) {
+		return false
+	}
+	for i := range prefix {
+		if segments[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// toValue converts the value to the documents, arrays and values it is
+// stored as, keeping the $each modifier of $push and $addToSet.
+func toValue(value interface{}) (interface{}, error) {
+	data, err := bson.Marshal(bson.D{{Key: "v", Value: value}})
+	if err != nil {
+		return nil, err
+	}
+
+	var doc bson.D
+	err = bson.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	if modifier, ok := doc[0].Value.(primitive.D); ok && len(modifier) > 0 && modifier[0].Key == "$each" {
+		return modifier, nil
+	}
+	return doc[0].Value, nil
+}
+
+func toSlice(value interface{}) []interface{} {
+	converted, err := toValue(value)
+	if err != nil {
+		return []interface{}{value}
+	}
+	if a, ok := converted.(primitive.A); ok {
+		return a
+	}
+	return []interface{}{converted}
+}
@@ -0,0 +1,264 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+
+	"github.com/dannypaul/go-skeleton/internal/repository"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type keyStore struct {
+	keys []DataKey
+}
+
+func (s *keyStore) DataKeys(ctx context.Context) ([]DataKey, error) {
+	return append([]DataKey{}, s.keys...), nil
+}
+
+func (s *keyStore) SaveDataKey(ctx context.Context, key DataKey) error {
+	for i, k := range s.keys {
+		if k.Id == key.Id {
+			s.keys[i] = key
+			return nil
+		}
+	}
+	s.keys = append(s.keys, key)
+	return nil
+}
+
+func newKeyring(t *testing.T, active string, ids ...string) Keyring {
+	encoded := map[string]string{}
+	for _, id := range ids {
+		key := make([]byte, masterKeySize)
+		if _, err := rand.Read(key); err != nil {
+			t.Fatal(err)
+		}
+		encoded[id] = base64.StdEncoding.EncodeToString(key)
+	}
+
+	keyring, err := NewKeyring(active, encoded)
+	if err != nil {
+		t.Fatalf("Could not create the keyring, got: %v", err)
+	}
+	return keyring
+}
+
+func newFields(t *testing.T) (*Fields, *Cipher, *keyStore) {
+	store := &keyStore{}
+	cipher, err := NewCipher(context.Background(), newKeyring(t, "a", "a"), store)
+	if err != nil {
+		t.Fatalf("Could not create the cipher, got: %v", err)
+	}
+
+	fields := NewFields(cipher,
+		Field{Path: "name", Mode: Randomized},
+		Field{Path: "identities.emailId", Mode: Deterministic},
+		Field{Path: "identities.phone.number", Mode: Deterministic},
+	)
+	return fields, cipher, store
+}
+
+type identity struct {
+	EmailId string `bson:"emailId,omitempty"`
+	Phone   *struct {
+		Number string `bson:"number"`
+	} `bson:"phone,omitempty"`
+}
+
+type user struct {
+	Name       string     `bson:"name"`
+	Identities []identity `bson:"identities"`
+}
+
+func TestEncryptDocument(t *testing.T) {
+	fields, _, _ := newFields(t)
+
+	const phoneNumber = "+919999999999"
+	doc := bson.D{
+		{Key: "name", Value: "Jane"},
+		{Key: "identities", Value: bson.A{
+			bson.D{{Key: "emailId", Value: "jane@example.com"}},
+			bson.D{{Key: "phone", Value: bson.D{{Key: "number", Value: phoneNumber}}}},
+		}},
+	}
+
+	encrypted, err := fields.EncryptDocument(doc)
+	if err != nil {
+		t.Fatalf("Could not encrypt the document, got: %v", err)
+	}
+	if doc[0].Value != "Jane" {
+		t.Errorf("Document was modified, got: %v", doc)
+	}
+
+	raw, err := bson.Marshal(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("jane@example.com")) || bytes.Contains(raw, []byte(phoneNumber)) || bytes.Contains(raw, []byte("Jane")) {
+		t.Errorf("Encrypted document contains plaintext")
+	}
+
+	decrypted, err := fields.DecryptDocument(raw)
+	if err != nil {
+		t.Fatalf("Could not decrypt the document, got: %v", err)
+	}
+	var got user
+	if err = bson.Unmarshal(decrypted, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Jane" || got.Identities[0].EmailId != "jane@example.com" || got.Identities[1].Phone.Number != phoneNumber {
+		t.Errorf("Decrypted document was incorrect, got: %+v", got)
+	}
+
+	again, err := fields.EncryptDocument(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ciphertextOf(encrypted, "identities") == nil || !equal(ciphertextOf(encrypted, "identities"), ciphertextOf(again, "identities")) {
+		t.Errorf("Deterministic encryption of equal values was not equal")
+	}
+	if equal(encrypted[0].Value, again[0].Value) {
+		t.Errorf("Randomized encryption of equal values was equal")
+	}
+}
+
+func TestFilters(t *testing.T) {
+	fields, _, _ := newFields(t)
+
+	doc, err := fields.EncryptDocument(bson.D{{Key: "identities", Value: bson.A{bson.D{{Key: "emailId", Value: "jane@example.com"}}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := ciphertextOf(doc, "identities")
+
+	filters := fields.Filters([]repository.Filter{
+		{Key: "identities.emailId", Value: "jane@example.com"},
+		repository.Or(repository.Filter{Key: "identities.0.emailId", Operator: repository.Ne, Value: "jane@example.com"}),
+		{Key: "identities.emailId", Operator: repository.Prefix, Value: "jane"},
+		{Key: "type", Value: "EMAIL"},
+	})
+
+	if filters[0].Operator != repository.In || !contains(filters[0].Value.(bson.A), ciphertext) || !contains(filters[0].Value.(bson.A), "jane@example.com") {
+		t.Errorf("Equality filter was incorrect, got: %+v", filters[0])
+	}
+	if filters[1].Or[0].Operator != repository.Nin || !contains(filters[1].Or[0].Value.(bson.A), ciphertext) {
+		t.Errorf("Inequality filter was incorrect, got: %+v", filters[1].Or[0])
+	}
+	if filters[2].Operator != repository.Prefix || filters[3].Value != "EMAIL" {
+		t.Errorf("Filters were changed, got: %+v", filters[2:])
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	fields, _, _ := newFields(t)
+
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "identities.0", Value: identity{EmailId: "jane@example.com"}}, {Key: "status", Value: "ACTIVE"}}},
+		{Key: "$push", Value: bson.D{{Key: "identities", Value: bson.D{{Key: "$each", Value: []identity{{EmailId: "john@example.com"}}}}}}},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+	}
+	encrypted, err := fields.Update(update)
+	if err != nil {
+		t.Fatalf("Could not encrypt the update, got: %v", err)
+	}
+
+	set := encrypted[0].Value.(bson.D)
+	if _, ok := parse(set[0].Value.(primitive.D)[0].Value); !ok || set[1].Value != "ACTIVE" {
+		t.Errorf("$set was incorrect, got: %v", set)
+	}
+	each := encrypted[1].Value.(bson.D)[0].Value.(primitive.D)[0].Value.(primitive.A)
+	if _, ok := parse(each[0].(primitive.D)[0].Value); !ok {
+		t.Errorf("$push was incorrect, got: %v", each)
+	}
+	if !equal(encrypted[2], update[2]) {
+		t.Errorf("$inc was changed, got: %v", encrypted[2])
+	}
+}
+
+func TestRotation(t *testing.T) {
+	fields, cipher, store := newFields(t)
+	ctx := context.Background()
+
+	doc, err := fields.EncryptDocument(bson.D{{Key: "identities", Value: bson.A{bson.D{{Key: "emailId", Value: "jane@example.com"}}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := ciphertextOf(doc, "identities")
+
+	if _, err = cipher.RotateDataKey(ctx); err != nil {
+		t.Fatalf("Could not rotate the data key, got: %v", err)
+	}
+
+	changed, err := fields.Reencrypt(append(doc, bson.E{Key: "name", Value: "Jane"}))
+	if err != nil {
+		t.Fatalf("Could not re-encrypt the document, got: %v", err)
+	}
+	if len(changed) != 2 || changed[0].Key != "identities" || changed[1].Key != "name" {
+		t.Fatalf("Changed elements were incorrect, got: %v", changed)
+	}
+	current := ciphertextOf(changed, "identities")
+	if equal(previous, current) {
+		t.Errorf("Document was not re-encrypted with the new data key")
+	}
+
+	filters := fields.Filters([]repository.Filter{{Key: "identities.emailId", Value: "jane@example.com"}})
+	if !contains(filters[0].Value.(bson.A), previous) || !contains(filters[0].Value.(bson.A), current) {
+		t.Errorf("Filter did not match the ciphertexts of every data key, got: %+v", filters[0])
+	}
+
+	// Another process loads the data keys rewrapped with a new master key
+	keyring := newKeyring(t, "b", "b")
+	keyring.keys["a"] = cipher.keyring.keys["a"]
+	rewrapping, err := NewCipher(ctx, keyring, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rewrapped, err := rewrapping.Rewrap(ctx); err != nil || rewrapped != 2 {
+		t.Fatalf("Rewrap was incorrect, got: %d, %v", rewrapped, err)
+	}
+
+	delete(keyring.keys, "a")
+	reloaded, err := NewCipher(ctx, keyring, store)
+	if err != nil {
+		t.Fatalf("Could not load the rewrapped data keys, got: %v", err)
+	}
+	raw, _ := bson.Marshal(doc)
+	decrypted, err := NewFields(reloaded, Field{Path: "identities.emailId", Mode: Deterministic}).DecryptDocument(raw)
+	if err != nil {
+		t.Fatalf("Could not decrypt with the rewrapped data key, got: %v", err)
+	}
+	var got user
+	if err = bson.Unmarshal(decrypted, &got); err != nil || got.Identities[0].EmailId != "jane@example.com" {
+		t.Errorf("Decrypted document was incorrect, got: %+v, %v", got, err)
+	}
+}
+
+func ciphertextOf(doc bson.D, key string) interface{} {
+	for _, e := range doc {
+		if e.Key == key {
+			return e.Value.(primitive.A)[0].(primitive.D)[0].Value
+		}
+	}
+	return nil
+}
+
+func equal(a interface{}, b interface{}) bool {
+	x, _ := bson.Marshal(bson.D{{Key: "v", Value: a}})
+	y, _ := bson.Marshal(bson.D{{Key: "v", Value: b}})
+	return bytes.Equal(x, y)
+}
+
+func contains(values bson.A, value interface{}) bool {
+	for _, v := range values {
+		if equal(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// masterKeySize is the size of the AES-256 master keys.
+const masterKeySize = 32
+
+// localKeyId is the ID of the master key of a key file holding a single key.
+const localKeyId = "local"
+
+// Keyring holds the master keys, which wrap the data keys. New data keys are
+// wrapped with the active master key, and the others are kept to unwrap the
+// data keys wrapped before it became active.
+type Keyring struct {
+	active string
+	keys   map[string][]byte
+}
+
+type keyringFile struct {
+	ActiveKey string            `json:"activeKey"`
+	Keys      map[string]string `json:"keys"`
+}
+
+// LoadKeyring reads the master keys from a key file, which either holds a
+// single base64 encoded key, or a keyring as JSON:
+//
+//	{"activeKey": "2024", "keys": {"2023": "<base64 key>", "2024": "<base64 key>"}}
+func LoadKeyring(path string) (Keyring, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Keyring{}, fmt.Errorf("could not read the key file %w", err)
+	}
+
+	var file keyringFile
+	if json.Unmarshal(data, &file) != nil {
+		file = keyringFile{ActiveKey: localKeyId, Keys: map[string]string{localKeyId: strings.TrimSpace(string(data))}}
+	}
+	return NewKeyring(file.ActiveKey, file.Keys)
+}
+
+// NewKeyring decodes the base64 encoded master keys by their IDs.
+func NewKeyring(active string, encoded map[string]string) (Keyring, error) {
+	keyring := Keyring{active: active, keys: map[string][]byte{}}
+	for id, value := range encoded {
+		key, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(key) != masterKeySize {
+			return Keyring{}, fmt.Errorf("master key '%s' is not a base64 encoded %d byte key", id, masterKeySize)
+		}
+		keyring.keys[id] = key
+	}
+
+	if _, ok := keyring.keys[active]; !ok {
+		return Keyring{}, fmt.Errorf("active master key '%s' is not in the keyring", active)
+	}
+	return keyring, nil
+}
+
+// wrap encrypts the data key with the active master key.
+func (k Keyring) wrap(dataKeyId string, key []byte) (DataKey, error) {
+	aead, err := newAEAD(k.keys[k.active])
+	if err != nil {
+		return DataKey{}, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return DataKey{}, err
+	}
+	wrapped := aead.Seal(nonce, nonce, key, []byte(dataKeyId))
+	return DataKey{Id: dataKeyId, MasterKeyId: k.active, Wrapped: wrapped}, nil
+}
+
+// unwrap decrypts the data key with the master key which wrapped it.
+func (k Keyring) unwrap(dataKey DataKey) ([]byte, error) {
+	masterKey, ok := k.keys[dataKey.MasterKeyId]
+	if !ok {
+		return nil, fmt.Errorf("master key '%s' of data key '%s' is not in the keyring", dataKey.MasterKeyId, dataKey.Id)
+	}
+
+	aead, err := newAEAD(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(dataKey.Wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped data key is too short")
+	}
+
+	nonce, wrapped := dataKey.Wrapped[:aead.NonceSize()], dataKey.Wrapped[aead.NonceSize():]
+	key, err := aead.Open(nil, nonce, wrapped, []byte(dataKey.Id))
+	if err != nil {
+		return nil, fmt.Errorf("could not unwrap data key '%s' %w", dataKey.Id, err)
+	}
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
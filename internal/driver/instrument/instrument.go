@@ -0,0 +1,445 @@
+// Package instrument decorates repositories to log their slow calls and
+// record the latency, result count and error class of every call.
+package instrument
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/dannypaul/go-skeleton/internal/exception"
+	"github.com/dannypaul/go-skeleton/internal/primitive"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	_ Repository         = Collection{}
+	_ Repository         = WatchedCollection{}
+	_ repository.Watcher = WatchedCollection{}
+)
+
+// Repository is the repository a Collection instruments, which is made of
+// the interfaces every driver implements.
+type Repository interface {
+	repository.Collection
+	repository.Setter
+	repository.Patcher
+	repository.Incrementer
+	repository.Adder
+	repository.VersionedWriter
+	repository.Streamer
+	repository.BulkWriter
+	repository.ManyUpdater
+	repository.Upserter
+}
+
+// Call is a call of a repository method. Count is the number of documents it
+// returned or wrote, and ErrorClass is empty when it succeeded.
+type Call struct {
+	Operation  string
+	Collection string
+	Latency    time.Duration
+	Count      int64
+	ErrorClass string
+}
+
+// Metrics records the calls of the repositories.
+type Metrics interface {
+	Observe(call Call)
+}
+
+type Options struct {
+	// SlowThreshold is the latency from which calls are logged as slow. Zero
+	// logs no calls.
+	SlowThreshold time.Duration
+
+	// Metrics records every call when it is set.
+	Metrics Metrics
+}
+
+// Collection records the calls of the repository it wraps.
+//
+// The calls returning documents to be copied, or iterated over, are recorded
+// once they are copied or the iterator is closed, as the documents may be
+// fetched only then.
+type Collection struct {
+	inner   Repository
+	name    string
+	options Options
+}
+
+// WatchedCollection is the Collection of a repository which is also a
+// Watcher.
+type WatchedCollection struct {
+	Collection
+	watcher repository.Watcher
+}
+
+// NewCollection returns the Collection of inner, which is a WatchedCollection
+// when inner is a Watcher, so that it implements the interfaces inner does.
+func NewCollection(inner Repository, name string, options Options) Repository {
+	collection := Collection{inner: inner, name: name, options: options}
+	if watcher, ok := inner.(repository.Watcher); ok {
+		return WatchedCollection{Collection: collection, watcher: watcher}
+	}
+	return collection
+}
+
+// observe records the call which started at start, and logs it when it is
+// slow.
+func (c Collection) observe(ctx context.Context, operation string, filters []repository.Filter, start time.Time, count int64, err error) {
+	call := Call{
+		Operation:  operation,
+		Collection: c.name,
+		Latency:    time.Since(start),
+		Count:      count,
+		ErrorClass: ErrorClass(err),
+	}
+
+	if c.options.Metrics != nil {
+		c.options.Metrics.Observe(call)
+	}
+
+	if c.options.SlowThreshold > 0 && call.Latency >= c.options.SlowThreshold {
+		correlationId, _ := ctx.Value("correlationId").(string)
+		log.Warn().
+			Str("operation", call.Operation).
+			Str("collection", call.Collection).
+			Dur("latency", call.Latency).
+			Int64("count", call.Count).
+			Str("errorClass", call.ErrorClass).
+			Interface("filter", Redact(filters)).
+			Str("correlationId", correlationId).
+			Msg("Slow repository call")
+	}
+}
+
+// classified are the errors of the repositories which are their own class.
+var classified = []error{
+	exception.ErrNotFound,
+	exception.ErrConflict,
+	exception.ErrIdInvalid,
+	exception.ErrCursorInvalid,
+	exception.ErrVersionConflict,
+	exception.ErrWriteSkipped,
+}
+
+// ErrorClass returns the class of the error of a call, which is empty when
+// the error is nil. The errors of the exception package are classed by their
+// code.
+func ErrorClass(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	for _, e := range classified {
+		if errors.Is(err, e) {
+			return e.Error()
+		}
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "deadlineExceeded"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	}
+	return "unknown"
+}
+
+// Redact returns the keys and operators of the filters with their values
+// left out, so that they can be logged.
+func Redact(filters []repository.Filter) []map[string]interface{} {
+	redacted := make([]map[string]interface{}, len(filters))
+	for i, f := range filters {
+		switch {
+		case len(f.Or) > 0:
+			redacted[i] = map[string]interface{}{"or": Redact(f.Or)}
+		case len(f.And) > 0:
+			redacted[i] = map[string]interface{}{"and": Redact(f.And)}
+		default:
+			operator := f.Operator
+			if operator == "" {
+				operator = repository.Eq
+			}
+			redacted[i] = map[string]interface{}{f.Key: map[string]string{string(operator): "?"}}
+		}
+	}
+	return redacted
+}
+
+func byId(id primitive.Id) []repository.Filter {
+	return []repository.Filter{{Key: "_id", Value: id}}
+}
+
+// found returns the count of a call returning a single document.
+func found(err error) int64 {
+	if err != nil {
+		return 0
+	}
+	return 1
+}
+
+func (c Collection) Count(ctx context.Context, filters []repository.Filter) (int64, error) {
+	start := time.Now()
+	count, err := c.inner.Count(ctx, filters)
+	c.observe(ctx, "Count", filters, start, count, err)
+	return count, err
+}
+
+func (c Collection) Create(ctx context.Context, model interface{}) (repository.Copier, error) {
+	start := time.Now()
+	copier, err := c.inner.Create(ctx, model)
+	c.observe(ctx, "Create", nil, start, found(err), err)
+	return copier, err
+}
+
+func (c Collection) Delete(ctx context.Context, id primitive.Id) (int64, error) {
+	start := time.Now()
+	count, err := c.inner.Delete(ctx, id)
+	c.observe(ctx, "Delete", byId(id), start, count, err)
+	return count, err
+}
+
+func (c Collection) FindById(ctx context.Context, id primitive.Id) (repository.Copier, error) {
+	start := time.Now()
+	copier, err := c.inner.FindById(ctx, id)
+	c.observe(ctx, "FindById", byId(id), start, found(err), err)
+	return copier, err
+}
+
+func (c Collection) FindSingle(ctx context.Context, filters []repository.Filter) (repository.Copier, error) {
+	start := time.Now()
+	copier, err := c.inner.FindSingle(ctx, filters)
+	c.observe(ctx, "FindSingle", filters, start, found(err), err)
+	return copier, err
+}
+
+func (c Collection) FindAll(ctx context.Context, filters []repository.Filter) (repository.ListCopier, error) {
+	start := time.Now()
+	copier, err := c.inner.FindAll(ctx, filters)
+	return c.listCopier(ctx, "FindAll", filters, start, copier, err)
+}
+
+func (c Collection) Query(ctx context.Context, query repository.Query) (repository.ListCopier, error) {
+	start := time.Now()
+	copier, err := c.inner.Query(ctx, query)
+	return c.listCopier(ctx, "Query", query.Filters, start, copier, err)
+}
+
+func (c Collection) Paginate(ctx context.Context, query repository.Query, cursor string) (repository.ListCopier, string, error) {
+	start := time.Now()
+	copier, next, err := c.inner.Paginate(ctx, query, cursor)
+	copier, err = c.listCopier(ctx, "Paginate", query.Filters, start, copier, err)
+	return copier, next, err
+}
+
+// listCopier records the call once its documents are copied, or right away
+// when it failed.
+func (c Collection) listCopier(ctx context.Context, operation string, filters []repository.Filter, start time.Time, copier repository.ListCopier, err error) (repository.ListCopier, error) {
+	if err != nil {
+		c.observe(ctx, operation, filters, start, 0, err)
+		return nil, err
+	}
+	return ListCopier{copier: copier, collection: c, operation: operation, filters: filters, start: start}, nil
+}
+
+func (c Collection) Stream(ctx context.Context, query repository.Query) (repository.Iterator, error) {
+	start := time.Now()
+	iterator, err := c.inner.Stream(ctx, query)
+	if err != nil {
+		c.observe(ctx, "Stream", query.Filters, start, 0, err)
+		return nil, err
+	}
+	return &Iterator{iterator: iterator, collection: c, filters: query.Filters, start: start}, nil
+}
+
+func (c Collection) Replace(ctx context.Context, id primitive.Id, value interface{}) (repository.Copier, error) {
+	start := time.Now()
+	copier, err := c.inner.Replace(ctx, id, value)
+	c.observe(ctx, "Replace", byId(id), start, found(err), err)
+	return copier, err
+}
+
+func (c Collection) Set(ctx context.Context, filters []repository.Filter, key string, value interface{}) error {
+	start := time.Now()
+	err := c.inner.Set(ctx, filters, key, value)
+	c.observe(ctx, "Set", filters, start, found(err), err)
+	return err
+}
+
+func (c Collection) SetById(ctx context.Context, id primitive.Id, key string, value interface{}) error {
+	start := time.Now()
+	err := c.inner.SetById(ctx, id, key, value)
+	c.observe(ctx, "SetById", byId(id), start, found(err), err)
+	return err
+}
+
+func (c Collection) SetAll(ctx context.Context, filters []repository.Filter, keyValues []repository.KeyValue) error {
+	start := time.Now()
+	err := c.inner.SetAll(ctx, filters, keyValues)
+	c.observe(ctx, "SetAll", filters, start, found(err), err)
+	return err
+}
+
+func (c Collection) SetAllById(ctx context.Context, id primitive.Id, keyValues []repository.KeyValue) error {
+	start := time.Now()
+	err := c.inner.SetAllById(ctx, id, keyValues)
+	c.observe(ctx, "SetAllById", byId(id), start, found(err), err)
+	return err
+}
+
+func (c Collection) UnSet(ctx context.Context, filters []repository.Filter, key string) error {
+	start := time.Now()
+	err := c.inner.UnSet(ctx, filters, key)
+	c.observe(ctx, "UnSet", filters, start, found(err), err)
+	return err
+}
+
+func (c Collection) Patch(ctx context.Context, id primitive.Id, patches []repository.Patch) error {
+	start := time.Now()
+	err := c.inner.Patch(ctx, id, patches)
+	c.observe(ctx, "Patch", byId(id), start, found(err), err)
+	return err
+}
+
+func (c Collection) IncrementById(ctx context.Context, id primitive.Id, key string, incrementBy int) error {
+	start := time.Now()
+	err := c.inner.IncrementById(ctx, id, key, incrementBy)
+	c.observe(ctx, "IncrementById", byId(id), start, found(err), err)
+	return err
+}
+
+func (c Collection) Add(ctx context.Context, filters []repository.Filter, key string) (float64, error) {
+	start := time.Now()
+	total, err := c.inner.Add(ctx, filters, key)
+	c.observe(ctx, "Add", filters, start, found(err), err)
+	return total, err
+}
+
+func (c Collection) PatchIfVersion(ctx context.Context, id primitive.Id, version int, patches []repository.Patch) error {
+	start := time.Now()
+	err := c.inner.PatchIfVersion(ctx, id, version, patches)
+	c.observe(ctx, "PatchIfVersion", byId(id), start, found(err), err)
+	return err
+}
+
+func (c Collection) SetAllByIdIfVersion(ctx context.Context, id primitive.Id, version int, keyValues []repository.KeyValue) error {
+	start := time.Now()
+	err := c.inner.SetAllByIdIfVersion(ctx, id, version, keyValues)
+	c.observe(ctx, "SetAllByIdIfVersion", byId(id), start, found(err), err)
+	return err
+}
+
+func (c Collection) ReplaceIfVersion(ctx context.Context, id primitive.Id, version int, value interface{}) (repository.Copier, error) {
+	start := time.Now()
+	copier, err := c.inner.ReplaceIfVersion(ctx, id, version, value)
+	c.observe(ctx, "ReplaceIfVersion", byId(id), start, found(err), err)
+	return copier, err
+}
+
+// succeeded returns the number of writes of a bulk write which succeeded.
+func succeeded(results []repository.WriteResult) int64 {
+	var count int64
+	for _, result := range results {
+		if result.Err == nil {
+			count++
+		}
+	}
+	return count
+}
+
+func (c Collection) CreateMany(ctx context.Context, models []interface{}, ordered bool) ([]repository.WriteResult, error) {
+	start := time.Now()
+	results, err := c.inner.CreateMany(ctx, models, ordered)
+	c.observe(ctx, "CreateMany", nil, start, succeeded(results), err)
+	return results, err
+}
+
+func (c Collection) BulkWrite(ctx context.Context, writes []repository.Write, ordered bool) ([]repository.WriteResult, error) {
+	start := time.Now()
+	results, err := c.inner.BulkWrite(ctx, writes, ordered)
+	c.observe(ctx, "BulkWrite", nil, start, succeeded(results), err)
+	return results, err
+}
+
+func (c Collection) UpdateMany(ctx context.Context, filters []repository.Filter, patches []repository.Patch) (int64, error) {
+	start := time.Now()
+	count, err := c.inner.UpdateMany(ctx, filters, patches)
+	c.observe(ctx, "UpdateMany", filters, start, count, err)
+	return count, err
+}
+
+func (c Collection) Upsert(ctx context.Context, filters []repository.Filter, model interface{}) (repository.Copier, error) {
+	start := time.Now()
+	copier, err := c.inner.Upsert(ctx, filters, model)
+	c.observe(ctx, "Upsert", filters, start, found(err), err)
+	return copier, err
+}
+
+func (c WatchedCollection) Watch(ctx context.Context, resumeToken string) (repository.EventStream, error) {
+	start := time.Now()
+	stream, err := c.watcher.Watch(ctx, resumeToken)
+	c.observe(ctx, "Watch", nil, start, 0, err)
+	return stream, err
+}
+
+// ListCopier records the call which returned the copier once the documents
+// are copied.
+type ListCopier struct {
+	copier     repository.ListCopier
+	collection Collection
+	operation  string
+	filters    []repository.Filter
+	start      time.Time
+}
+
+func (l ListCopier) CopyAll(ctx context.Context, destination interface{}) error {
+	err := l.copier.CopyAll(ctx, destination)
+
+	var count int64
+	if slice := reflect.ValueOf(destination); err == nil && slice.Kind() == reflect.Ptr && slice.Elem().Kind() == reflect.Slice {
+		count = int64(slice.Elem().Len())
+	}
+	l.collection.observe(ctx, l.operation, l.filters, l.start, count, err)
+	return err
+}
+
+// Iterator records the call which returned the iterator once it is closed,
+// with the number of documents iterated over.
+type Iterator struct {
+	iterator   repository.Iterator
+	collection Collection
+	filters    []repository.Filter
+	start      time.Time
+	count      int64
+}
+
+func (i *Iterator) Next(ctx context.Context) bool {
+	next := i.iterator.Next(ctx)
+	if next {
+		i.count++
+	}
+	return next
+}
+
+func (i *Iterator) Decode(destination interface{}) error {
+	return i.iterator.Decode(destination)
+}
+
+func (i *Iterator) Err() error {
+	return i.iterator.Err()
+}
+
+func (i *Iterator) Close(ctx context.Context) error {
+	err := i.iterator.Close(ctx)
+	iterationErr := i.iterator.Err()
+	if iterationErr == nil {
+		iterationErr = err
+	}
+	i.collection.observe(ctx, "Stream", i.filters, i.start, i.count, iterationErr)
+	return err
+}
@@ -0,0 +1,124 @@
+package instrument
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dannypaul/go-skeleton/internal/driver/platform/memory"
+	"github.com/dannypaul/go-skeleton/internal/exception"
+	"github.com/dannypaul/go-skeleton/internal/primitive"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+)
+
+type recorder struct {
+	calls []Call
+}
+
+func (r *recorder) Observe(call Call) {
+	r.calls = append(r.calls, call)
+}
+
+type user struct {
+	Id   primitive.Id `bson:"_id,omitempty"`
+	Name string       `bson:"name"`
+}
+
+func TestCollection(t *testing.T) {
+	ctx := context.Background()
+	metrics := &recorder{}
+	users := NewCollection(memory.NewCollection(), "users", Options{Metrics: metrics})
+
+	for _, name := range []string{"Ada", "Bob"} {
+		if _, err := users.Create(ctx, user{Name: name}); err != nil {
+			t.Fatalf("Could not create the user, got: %v", err)
+		}
+	}
+
+	copier, err := users.FindAll(ctx, []repository.Filter{{Key: "name", Operator: repository.Ne, Value: ""}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics.calls) != 2 {
+		t.Errorf("List call was recorded before its documents were copied, got: %v", metrics.calls)
+	}
+	var all []user
+	if err = copier.CopyAll(ctx, &all); err != nil || len(all) != 2 {
+		t.Fatalf("Could not copy the users, got: %v, %v", all, err)
+	}
+
+	if _, err = users.FindById(ctx, primitive.NewObjectId()); err != exception.ErrNotFound {
+		t.Errorf("Error was not returned as is, got: %v", err)
+	}
+	if _, ok := users.(repository.Watcher); ok {
+		t.Errorf("Collection of a repository which cannot be watched was a watcher")
+	}
+
+	want := []Call{
+		{Operation: "Create", Collection: "users", Count: 1},
+		{Operation: "Create", Collection: "users", Count: 1},
+		{Operation: "FindAll", Collection: "users", Count: 2},
+		{Operation: "FindById", Collection: "users", ErrorClass: exception.NotFound},
+	}
+	for i, call := range metrics.calls {
+		call.Latency = 0
+		if call != want[i] {
+			t.Errorf("Call %d was incorrect, got: %+v, want: %+v", i, call, want[i])
+		}
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{fmt.Errorf("could not find the user %w", exception.ErrNotFound), exception.NotFound},
+		{context.DeadlineExceeded, "deadlineExceeded"},
+		{fmt.Errorf("connection reset"), "unknown"},
+	}
+	for _, test := range tests {
+		if got := ErrorClass(test.err); got != test.want {
+			t.Errorf("Error class of %v was incorrect, got: %s, want: %s", test.err, got, test.want)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	redacted := fmt.Sprint(Redact([]repository.Filter{
+		{Key: "identities.emailId", Value: "jane@example.com"},
+		repository.Or(repository.Filter{Key: "name", Operator: repository.Prefix, Value: "Jane"}),
+	}))
+
+	if strings.Contains(redacted, "jane@example.com") || strings.Contains(redacted, "Jane") {
+		t.Errorf("Filter values were not redacted, got: %s", redacted)
+	}
+	if !strings.Contains(redacted, "identities.emailId:map[eq:?]") || !strings.Contains(redacted, "or:[map[name:map[prefix:?]]]") {
+		t.Errorf("Filter keys and operators were not kept, got: %s", redacted)
+	}
+}
+
+func TestHistograms(t *testing.T) {
+	histograms := NewHistograms()
+	histograms.Observe(Call{Operation: "FindAll", Collection: "users", Latency: 20e6, Count: 3})
+
+	w := httptest.NewRecorder()
+	histograms.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := w.Body.String()
+	labels := `collection="users",operation="FindAll",error=""`
+	for _, line := range []string{
+		`repository_call_duration_seconds_bucket{` + labels + `,le="0.01"} 0`,
+		`repository_call_duration_seconds_bucket{` + labels + `,le="0.025"} 1`,
+		`repository_call_duration_seconds_count{` + labels + `} 1`,
+		`repository_call_results_bucket{` + labels + `,le="10"} 1`,
+		`repository_call_results_sum{` + labels + `} 3`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Metrics did not contain %s, got: %s", line, body)
+		}
+	}
+}
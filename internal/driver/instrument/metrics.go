@@ -0,0 +1,129 @@
+package instrument
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var _ Metrics = &Histograms{}
+
+// defaultBuckets are the upper bounds of the latency buckets of Histograms
+// made without buckets.
+var defaultBuckets = []time.Duration{
+	time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond,
+	50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second,
+}
+
+// defaultCountBuckets are the upper bounds of the result count buckets.
+var defaultCountBuckets = []int64{0, 1, 10, 100, 1000, 10000}
+
+type series struct {
+	collection string
+	operation  string
+	errorClass string
+}
+
+type histogram struct {
+	latencies []uint64
+	counts    []uint64
+	calls     uint64
+	latency   time.Duration
+	results   int64
+}
+
+// Histograms keeps the histograms of the latency and of the result count of
+// the calls by collection, operation and error class in memory, and serves
+// them in the Prometheus text format.
+type Histograms struct {
+	buckets []time.Duration
+	mu      *sync.Mutex
+	series  map[series]*histogram
+}
+
+func NewHistograms(buckets ...time.Duration) *Histograms {
+	if len(buckets) == 0 {
+		buckets = defaultBuckets
+	}
+	return &Histograms{buckets: buckets, mu: &sync.Mutex{}, series: map[series]*histogram{}}
+}
+
+func (h *Histograms) Observe(call Call) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := series{collection: call.Collection, operation: call.Operation, errorClass: call.ErrorClass}
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{latencies: make([]uint64, len(h.buckets)), counts: make([]uint64, len(defaultCountBuckets))}
+		h.series[key] = s
+	}
+
+	for i, bucket := range h.buckets {
+		if call.Latency <= bucket {
+			s.latencies[i]++
+		}
+	}
+	for i, bucket := range defaultCountBuckets {
+		if call.Count <= bucket {
+			s.counts[i]++
+		}
+	}
+	s.calls++
+	s.latency += call.Latency
+	s.results += call.Count
+}
+
+func (h *Histograms) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	keys := make([]series, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.collection != b.collection {
+			return a.collection < b.collection
+		}
+		if a.operation != b.operation {
+			return a.operation < b.operation
+		}
+		return a.errorClass < b.errorClass
+	})
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP repository_call_duration_seconds Latency of the repository calls.")
+	fmt.Fprintln(w, "# TYPE repository_call_duration_seconds histogram")
+	for _, key := range keys {
+		s := h.series[key]
+		for i, bucket := range h.buckets {
+			fmt.Fprintf(w, "repository_call_duration_seconds_bucket{%s,le=\"%s\"} %d\n", key.labels(), strconv.FormatFloat(bucket.Seconds(), 'g', -1, 64), s.latencies[i])
+		}
+		fmt.Fprintf(w, "repository_call_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", key.labels(), s.calls)
+		fmt.Fprintf(w, "repository_call_duration_seconds_sum{%s} %s\n", key.labels(), strconv.FormatFloat(s.latency.Seconds(), 'g', -1, 64))
+		fmt.Fprintf(w, "repository_call_duration_seconds_count{%s} %d\n", key.labels(), s.calls)
+	}
+
+	fmt.Fprintln(w, "# HELP repository_call_results Number of documents returned or written by the repository calls.")
+	fmt.Fprintln(w, "# TYPE repository_call_results histogram")
+	for _, key := range keys {
+		s := h.series[key]
+		for i, bucket := range defaultCountBuckets {
+			fmt.Fprintf(w, "repository_call_results_bucket{%s,le=\"%d\"} %d\n", key.labels(), bucket, s.counts[i])
+		}
+		fmt.Fprintf(w, "repository_call_results_bucket{%s,le=\"+Inf\"} %d\n", key.labels(), s.calls)
+		fmt.Fprintf(w, "repository_call_results_sum{%s} %d\n", key.labels(), s.results)
+		fmt.Fprintf(w, "repository_call_results_count{%s} %d\n", key.labels(), s.calls)
+	}
+}
+
+func (s series) labels() string {
+	return fmt.Sprintf("collection=%q,operation=%q,error=%q", s.collection, s.operation, s.errorClass)
+}
@@ -0,0 +1,117 @@
+package memory
+
+import (
+	"context"
+	"errors"
+
+	"github.com/dannypaul/go-skeleton/internal/driver/document"
+	"github.com/dannypaul/go-skeleton/internal/exception"
+	"github.com/dannypaul/go-skeleton/internal/primitive"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+)
+
+var (
+	_ repository.BulkWriter  = Collection{}
+	_ repository.ManyUpdater = Collection{}
+	_ repository.Upserter    = Collection{}
+)
+
+func (c Collection) CreateMany(ctx context.Context, models []interface{}, ordered bool) ([]repository.WriteResult, error) {
+	return c.BulkWrite(ctx, repository.Inserts(models), ordered)
+}
+
+func (c Collection) BulkWrite(ctx context.Context, writes []repository.Write, ordered bool) ([]repository.WriteResult, error) {
+	return document.BulkWrite(writes, ordered, func(w repository.Write) (primitive.Id, error) {
+		return c.writeOne(ctx, w)
+	})
+}
+
+// writeOne makes a write of a bulk write, and returns the ID of the document
+// it created.
+func (c Collection) writeOne(ctx context.Context, w repository.Write) (primitive.Id, error) {
+	switch w.Op {
+	case repository.InsertOp:
+		copier, err := c.Create(ctx, w.Document)
+		if err != nil {
+			return "", err
+		}
+		return document.Id(copier.(Copier).doc), nil
+	case repository.UpdateOp:
+		err := c.update(w.Filters, applyAll(w.Patches))
+		if errors.Is(err, exception.ErrNotFound) {
+			return "", nil
+		}
+		return "", err
+	case repository.UpsertOp:
+		doc, created, err := c.upsert(ctx, w.Filters, w.Document)
+		if err != nil || !created {
+			return "", err
+		}
+		return document.Id(doc), nil
+	default:
+		_, err := c.remove(w.Filters)
+		return "", err
+	}
+}
+
+func (c Collection) UpdateMany(ctx context.Context, filters []repository.Filter, patches []repository.Patch) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var matched int64
+	for i, doc := range *c.docs {
+		ok, err := document.Matches(doc, filters)
+		if err != nil {
+			return matched, err
+		}
+		if !ok {
+			continue
+		}
+		matched++
+
+		updated := document.Clone(doc).(document.Document)
+		err = applyAll(patches)(updated)
+		if err != nil {
+			return matched, err
+		}
+		err = c.checkUnique(updated, i)
+		if err != nil {
+			return matched, err
+		}
+		(*c.docs)[i] = updated
+	}
+	return matched, nil
+}
+
+func (c Collection) Upsert(ctx context.Context, filters []repository.Filter, model interface{}) (repository.Copier, error) {
+	doc, _, err := c.upsert(ctx, filters, model)
+	if err != nil {
+		return nil, err
+	}
+	return Copier{doc}, nil
+}
+
+// upsert replaces the first document matching the filters with the model, or
+// creates it, and returns the document written and whether it was created.
+func (c Collection) upsert(ctx context.Context, filters []repository.Filter, model interface{}) (document.Document, bool, error) {
+	doc, err := document.FromModel(model)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var replaced document.Document
+	err = c.update(filters, replaceWith(document.Clone(doc).(document.Document), &replaced))
+	if !errors.Is(err, exception.ErrNotFound) {
+		return replaced, false, err
+	}
+
+	doc, err = document.Upserted(filters, doc)
+	if err != nil {
+		return nil, false, err
+	}
+	copier, err := c.Create(ctx, doc)
+	if err != nil {
+		return nil, false, err
+	}
+	return copier.(Copier).doc, true, nil
+}
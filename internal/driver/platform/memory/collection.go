@@ -0,0 +1,454 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/dannypaul/go-skeleton/internal/driver/document"
+	"github.com/dannypaul/go-skeleton/internal/exception"
+	"github.com/dannypaul/go-skeleton/internal/primitive"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+
+	bsonprimitive "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UniqueIndex emulates a unique MongoDB index on the dotted paths in Keys.
+// Like the sparse indexes of the migrations, documents without any of the keys
+// are not indexed. When Partial is set, only the documents matching its
+// filters are indexed.
+type UniqueIndex struct {
+	Keys    []string
+	Partial []repository.Filter
+}
+
+// Collection is an in-memory implementation of the repository interfaces with
+// the semantics of the mongo driver. It is meant for tests.
+type Collection struct {
+	mu      *sync.RWMutex
+	docs    *[]document.Document
+	indexes []UniqueIndex
+}
+
+var (
+	_ repository.Adder           = Collection{}
+	_ repository.Counter         = Collection{}
+	_ repository.Creator         = Collection{}
+	_ repository.Deleter         = Collection{}
+	_ repository.Finder          = Collection{}
+	_ repository.Incrementer     = Collection{}
+	_ repository.Lister          = Collection{}
+	_ repository.Paginator       = Collection{}
+	_ repository.Patcher         = Collection{}
+	_ repository.Querier         = Collection{}
+	_ repository.Streamer        = Collection{}
+	_ repository.Replacer        = Collection{}
+	_ repository.Setter          = Collection{}
+	_ repository.VersionedWriter = Collection{}
+)
+
+// streamBatchSize is the number of documents a stream copies at a time.
+const streamBatchSize = 100
+
+func NewCollection(indexes ...UniqueIndex) Collection {
+	return Collection{mu: &sync.RWMutex{}, docs: &[]document.Document{}, indexes: indexes}
+}
+
+// find returns the index of the first document matching the filters, or -1.
+func (c Collection) find(filters []repository.Filter) (int, error) {
+	for i, doc := range *c.docs {
+		ok, err := document.Matches(doc, filters)
+		if err != nil {
+			return -1, err
+		}
+		if ok {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
+
+func byId(id primitive.Id) []repository.Filter {
+	return []repository.Filter{{Key: "_id", Value: id}}
+}
+
+// checkUnique returns exception.ErrConflict when the document at position
+// (-1 for a new document) would violate one of the unique indexes.
+func (c Collection) checkUnique(doc document.Document, position int) error {
+	for i, other := range *c.docs {
+		if i != position && document.Equal(other["_id"], doc["_id"]) {
+			return exception.ErrConflict
+		}
+	}
+
+	for _, index := range c.indexes {
+		if ok, err := document.Matches(doc, index.Partial); err != nil || !ok {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		entries := document.IndexEntries(doc, index.Keys)
+		for i, other := range *c.docs {
+			if i == position {
+				continue
+			}
+			if ok, err := document.Matches(other, index.Partial); err != nil || !ok {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			for _, entry := range entries {
+				for _, otherEntry := range document.IndexEntries(other, index.Keys) {
+					if document.Equal(entry, otherEntry) {
+						return exception.ErrConflict
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// update applies the update to a copy of the first document matching the
+// filters and stores it when it does not violate the unique indexes.
+func (c Collection) update(filters []repository.Filter, update func(doc document.Document) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	i, err := c.find(filters)
+	if err != nil {
+		return err
+	}
+	if i < 0 {
+		return exception.ErrNotFound
+	}
+
+	doc := document.Clone((*c.docs)[i]).(document.Document)
+	err = update(doc)
+	if err != nil {
+		return err
+	}
+
+	err = c.checkUnique(doc, i)
+	if err != nil {
+		return err
+	}
+
+	(*c.docs)[i] = doc
+	return nil
+}
+
+func setAll(keyValues []repository.KeyValue) func(doc document.Document) error {
+	return func(doc document.Document) error {
+		return document.SetAll(doc, keyValues)
+	}
+}
+
+func (c Collection) Set(ctx context.Context, filters []repository.Filter, Key string, value interface{}) error {
+	return c.update(filters, setAll([]repository.KeyValue{{Key: Key, Value: value}}))
+}
+
+func (c Collection) SetAll(ctx context.Context, filters []repository.Filter, keyValues []repository.KeyValue) error {
+	return c.update(filters, setAll(keyValues))
+}
+
+func (c Collection) SetById(ctx context.Context, id primitive.Id, Key string, Value interface{}) error {
+	if id.IsValid() == false {
+		return exception.ErrIdInvalid
+	}
+	return c.update(byId(id), setAll([]repository.KeyValue{{Key: Key, Value: Value}}))
+}
+
+func (c Collection) SetAllById(ctx context.Context, id primitive.Id, keyValues []repository.KeyValue) error {
+	if id.IsValid() == false {
+		return exception.ErrIdInvalid
+	}
+	return c.update(byId(id), setAll(keyValues))
+}
+
+func (c Collection) UnSet(ctx context.Context, filters []repository.Filter, Key string) error {
+	return c.update(filters, func(doc document.Document) error {
+		document.Unset(doc, document.Split(Key))
+		return nil
+	})
+}
+
+func (c Collection) Patch(ctx context.Context, id primitive.Id, patches []repository.Patch) error {
+	if id.IsValid() == false {
+		return exception.ErrIdInvalid
+	}
+
+	return c.update(byId(id), applyAll(patches))
+}
+
+func applyAll(patches []repository.Patch) func(doc document.Document) error {
+	return func(doc document.Document) error {
+		for _, p := range patches {
+			err := document.Apply(doc, p)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func (c Collection) IncrementById(ctx context.Context, id primitive.Id, Key string, incrementBy int) error {
+	return c.Patch(ctx, id, []repository.Patch{{Action: "$inc", Key: Key, Value: incrementBy}})
+}
+
+func (c Collection) Count(ctx context.Context, filters []repository.Filter) (int64, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var count int64
+	for _, doc := range *c.docs {
+		ok, err := document.Matches(doc, filters)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (c Collection) Create(ctx context.Context, model interface{}) (repository.Copier, error) {
+	doc, err := document.FromModel(model)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = bsonprimitive.NewObjectID()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err = c.checkUnique(doc, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	*c.docs = append(*c.docs, doc)
+	return Copier{document.Clone(doc).(document.Document)}, nil
+}
+
+func (c Collection) FindById(ctx context.Context, id primitive.Id) (repository.Copier, error) {
+	if id.IsValid() == false {
+		return nil, exception.ErrIdInvalid
+	}
+	return c.FindSingle(ctx, byId(id))
+}
+
+func (c Collection) Delete(ctx context.Context, id primitive.Id) (int64, error) {
+	if id.IsValid() == false {
+		return 0, exception.ErrIdInvalid
+	}
+	return c.remove(byId(id))
+}
+
+// remove deletes the first document matching the filters, and returns the
+// number of documents deleted.
+func (c Collection) remove(filters []repository.Filter) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	i, err := c.find(filters)
+	if err != nil || i < 0 {
+		return 0, err
+	}
+
+	*c.docs = append((*c.docs)[:i], (*c.docs)[i+1:]...)
+	return 1, nil
+}
+
+func (c Collection) Add(ctx context.Context, filters []repository.Filter, key string) (float64, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var total float64
+	for _, doc := range *c.docs {
+		ok, err := document.Matches(doc, filters)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			continue
+		}
+
+		// $sum ignores the values which are not numbers
+		if value, found := document.Get(doc, document.Split(key)); found {
+			if n, isNumber := document.Number(value); isNumber {
+				total += n
+			}
+		}
+	}
+	return total, nil
+}
+
+func (c Collection) FindSingle(ctx context.Context, filters []repository.Filter) (repository.Copier, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	i, err := c.find(filters)
+	if err != nil {
+		return nil, err
+	}
+	if i < 0 {
+		return nil, exception.ErrNotFound
+	}
+	return Copier{document.Clone((*c.docs)[i]).(document.Document)}, nil
+}
+
+func (c Collection) FindAll(ctx context.Context, filters []repository.Filter) (repository.ListCopier, error) {
+	docs, err := c.matching(filters)
+	if err != nil {
+		return nil, err
+	}
+	return ListCopier{docs}, nil
+}
+
+// matching returns copies of the documents matching the filters.
+func (c Collection) matching(filters []repository.Filter) ([]document.Document, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var docs []document.Document
+	for _, doc := range *c.docs {
+		ok, err := document.Matches(doc, filters)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			docs = append(docs, document.Clone(doc).(document.Document))
+		}
+	}
+	return docs, nil
+}
+
+func (c Collection) Query(ctx context.Context, query repository.Query) (repository.ListCopier, error) {
+	docs, err := c.matching(query.Filters)
+	if err != nil {
+		return nil, err
+	}
+	return ListCopier{document.Arrange(docs, query)}, nil
+}
+
+func (c Collection) page(ctx context.Context, query repository.Query, cursor string) ([]document.Document, string, error) {
+	query, err := query.After(cursor, document.FromCursorValue)
+	if err != nil {
+		return nil, "", err
+	}
+
+	docs, err := c.matching(query.Filters)
+	if err != nil {
+		return nil, "", err
+	}
+	return document.Page(docs, query)
+}
+
+func (c Collection) Paginate(ctx context.Context, query repository.Query, cursor string) (repository.ListCopier, string, error) {
+	docs, next, err := c.page(ctx, query, cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	return ListCopier{docs}, next, nil
+}
+
+func (c Collection) Stream(ctx context.Context, query repository.Query) (repository.Iterator, error) {
+	return document.NewIterator(query, streamBatchSize, c.page), nil
+}
+
+func (c Collection) Replace(ctx context.Context, id primitive.Id, value interface{}) (repository.Copier, error) {
+	if id.IsValid() == false {
+		return nil, exception.ErrIdInvalid
+	}
+
+	doc, err := document.FromModel(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var replaced document.Document
+	err = c.update(byId(id), replaceWith(doc, &replaced))
+	if err != nil {
+		return nil, err
+	}
+	return Copier{replaced}, nil
+}
+
+// replaceWith replaces the contents of a document with doc, keeping its ID,
+// and copies the result to replaced.
+func replaceWith(doc document.Document, replaced *document.Document) func(current document.Document) error {
+	return func(current document.Document) error {
+		doc["_id"] = current["_id"]
+		for key := range current {
+			delete(current, key)
+		}
+		for key, v := range doc {
+			current[key] = v
+		}
+		*replaced = document.Clone(current).(document.Document)
+		return nil
+	}
+}
+
+func byVersion(id primitive.Id, version int) []repository.Filter {
+	return []repository.Filter{{Key: "_id", Value: id}, {Key: repository.VersionKey, Value: version}}
+}
+
+// updateIfVersion applies the update to the document with the ID when it is
+// at the version, and increments the version.
+func (c Collection) updateIfVersion(ctx context.Context, id primitive.Id, version int, update func(doc document.Document) error) error {
+	if id.IsValid() == false {
+		return exception.ErrIdInvalid
+	}
+
+	err := c.update(byVersion(id, version), func(doc document.Document) error {
+		err := update(doc)
+		if err != nil {
+			return err
+		}
+		return document.SetAll(doc, []repository.KeyValue{{Key: repository.VersionKey, Value: version + 1}})
+	})
+	if errors.Is(err, exception.ErrNotFound) {
+		if _, findErr := c.FindById(ctx, id); findErr == nil {
+			return exception.ErrVersionConflict
+		}
+	}
+	return err
+}
+
+func (c Collection) PatchIfVersion(ctx context.Context, id primitive.Id, version int, patches []repository.Patch) error {
+	return c.updateIfVersion(ctx, id, version, applyAll(patches))
+}
+
+func (c Collection) SetAllByIdIfVersion(ctx context.Context, id primitive.Id, version int, keyValues []repository.KeyValue) error {
+	return c.updateIfVersion(ctx, id, version, setAll(keyValues))
+}
+
+func (c Collection) ReplaceIfVersion(ctx context.Context, id primitive.Id, version int, value interface{}) (repository.Copier, error) {
+	doc, err := document.FromModel(value)
+	if err != nil {
+		return nil, err
+	}
+
+	// The version is set before the replacement, which copies the document
+	err = document.SetAll(doc, []repository.KeyValue{{Key: repository.VersionKey, Value: version + 1}})
+	if err != nil {
+		return nil, err
+	}
+
+	var replaced document.Document
+	err = c.updateIfVersion(ctx, id, version, replaceWith(doc, &replaced))
+	if err != nil {
+		return nil, err
+	}
+	return Copier{replaced}, nil
+}
@@ -0,0 +1,279 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dannypaul/go-skeleton/internal/exception"
+	"github.com/dannypaul/go-skeleton/internal/primitive"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+)
+
+type identity struct {
+	EmailId string `bson:"emailId,omitempty"`
+}
+
+type user struct {
+	Id         primitive.Id `bson:"_id,omitempty"`
+	Name       string       `bson:"name"`
+	Version    int          `bson:"version"`
+	Identities []identity   `bson:"identities"`
+	Tags       []string     `bson:"tags,omitempty"`
+}
+
+func TestCollection(t *testing.T) {
+	ctx := context.Background()
+	users := NewCollection(UniqueIndex{Keys: []string{"identities.emailId"}})
+
+	copier, err := users.Create(ctx, user{Name: "Ada", Identities: []identity{{EmailId: "ada@example.com"}}})
+	if err != nil {
+		t.Fatalf("Could not create the user, got: %v", err)
+	}
+	var ada user
+	if err = copier.Copy(&ada); err != nil || !ada.Id.IsValid() {
+		t.Fatalf("Created user was not copied with an ID, got: %+v, %v", ada, err)
+	}
+
+	_, err = users.Create(ctx, user{Name: "Eve", Identities: []identity{{EmailId: "ada@example.com"}}})
+	if !errors.Is(err, exception.ErrConflict) {
+		t.Errorf("Duplicate email ID was not a conflict, got: %v", err)
+	}
+
+	// Documents without the indexed field are not indexed
+	for _, name := range []string{"Bob", "Eve"} {
+		if _, err = users.Create(ctx, user{Name: name}); err != nil {
+			t.Errorf("Could not create a user without identities, got: %v", err)
+		}
+	}
+
+	_, err = users.FindSingle(ctx, []repository.Filter{{Key: "identities.emailId", Value: "ada@example.com"}})
+	if err != nil {
+		t.Errorf("Could not find the user by a dotted path, got: %v", err)
+	}
+
+	_, err = users.FindSingle(ctx, []repository.Filter{{Key: "name", Value: "Mallory"}})
+	if !errors.Is(err, exception.ErrNotFound) {
+		t.Errorf("Missing user was found, got: %v", err)
+	}
+
+	err = users.Patch(ctx, ada.Id, []repository.Patch{
+		{Action: "$set", Key: "identities.0.emailId", Value: "lovelace@example.com"},
+		{Action: "$inc", Key: "version", Value: 1},
+		{Action: "$addToSet", Key: "tags", Value: "admin"},
+		{Action: "$addToSet", Key: "tags", Value: "admin"},
+	})
+	if err != nil {
+		t.Fatalf("Could not patch the user, got: %v", err)
+	}
+
+	copier, err = users.FindById(ctx, ada.Id)
+	if err != nil {
+		t.Fatalf("Could not find the user by ID, got: %v", err)
+	}
+	var patched user
+	_ = copier.Copy(&patched)
+	if patched.Identities[0].EmailId != "lovelace@example.com" || patched.Version != 1 || len(patched.Tags) != 1 {
+		t.Errorf("User was not patched, got: %+v", patched)
+	}
+
+	count, _ := users.Count(ctx, []repository.Filter{{Key: "tags", Value: "admin"}})
+	if count != 1 {
+		t.Errorf("Array element filter count was incorrect, got: %d, want: 1.", count)
+	}
+
+	total, _ := users.Add(ctx, nil, "version")
+	if total != 1 {
+		t.Errorf("Sum of the versions was incorrect, got: %v, want: 1.", total)
+	}
+
+	err = users.UnSet(ctx, []repository.Filter{{Key: "_id", Value: ada.Id}}, "tags")
+	if err != nil {
+		t.Errorf("Could not unset the tags, got: %v", err)
+	}
+	count, _ = users.Count(ctx, []repository.Filter{{Key: "tags", Value: nil}})
+	if count != 3 {
+		t.Errorf("Users without tags count was incorrect, got: %d, want: 3.", count)
+	}
+
+	listCopier, _ := users.FindAll(ctx, nil)
+	var all []user
+	if err = listCopier.CopyAll(ctx, &all); err != nil || len(all) != 3 {
+		t.Errorf("Could not list the users, got: %d, %v", len(all), err)
+	}
+
+	err = users.SetById(ctx, primitive.NewObjectId(), "name", "Nobody")
+	if !errors.Is(err, exception.ErrNotFound) {
+		t.Errorf("Setting a missing user was not a not found error, got: %v", err)
+	}
+
+	deleted, _ := users.Delete(ctx, ada.Id)
+	if deleted != 1 {
+		t.Errorf("Deleted count was incorrect, got: %d, want: 1.", deleted)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	ctx := context.Background()
+	users := NewCollection()
+
+	for i, name := range []string{"Ada", "Bob", "Eve", "Mallory"} {
+		if _, err := users.Create(ctx, user{Name: name, Version: i}); err != nil {
+			t.Fatalf("Could not create the user, got: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query repository.Query
+		want  []string
+	}{
+		{
+			name:  "range",
+			query: repository.Query{Filters: []repository.Filter{{Key: "version", Operator: repository.Gte, Value: 1}, {Key: "version", Operator: repository.Lt, Value: 3}}},
+			want:  []string{"Bob", "Eve"},
+		},
+		{
+			name:  "in",
+			query: repository.Query{Filters: []repository.Filter{{Key: "name", Operator: repository.In, Value: []string{"Eve", "Ada"}}}},
+			want:  []string{"Ada", "Eve"},
+		},
+		{
+			name: "or",
+			query: repository.Query{Filters: []repository.Filter{repository.Or(
+				repository.Filter{Key: "name", Operator: repository.Prefix, Value: "Ma"},
+				repository.And(repository.Filter{Key: "version", Value: 0}, repository.Filter{Key: "tags", Operator: repository.Exists, Value: false}),
+			)}},
+			want: []string{"Ada", "Mallory"},
+		},
+		{
+			name:  "sort and page",
+			query: repository.Query{Sort: []repository.Sort{{Key: "version", Descending: true}}, Skip: 1, Limit: 2},
+			want:  []string{"Eve", "Bob"},
+		},
+	}
+
+	for _, test := range tests {
+		listCopier, err := users.Query(ctx, test.query)
+		if err != nil {
+			t.Errorf("Could not query the users in %s, got: %v", test.name, err)
+			continue
+		}
+
+		var found []user
+		_ = listCopier.CopyAll(ctx, &found)
+		var names []string
+		for _, u := range found {
+			names = append(names, u.Name)
+		}
+		if strings.Join(names, ",") != strings.Join(test.want, ",") {
+			t.Errorf("Query %s found incorrect users, got: %v, want: %v.", test.name, names, test.want)
+		}
+	}
+
+	listCopier, _ := users.Query(ctx, repository.Query{
+		Sort:       []repository.Sort{{Key: "version", Descending: true}},
+		Projection: []string{"name"},
+		Limit:      1,
+	})
+	var projected []user
+	_ = listCopier.CopyAll(ctx, &projected)
+	if len(projected) != 1 || projected[0].Name != "Mallory" || !projected[0].Id.IsValid() || projected[0].Version != 0 {
+		t.Errorf("User was not projected, got: %+v", projected)
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	ctx := context.Background()
+	users := NewCollection()
+
+	for i, name := range []string{"Ada", "Bob", "Eve", "Mallory", "Trent"} {
+		if _, err := users.Create(ctx, user{Name: name, Version: i % 2}); err != nil {
+			t.Fatalf("Could not create the user, got: %v", err)
+		}
+	}
+
+	query := repository.Query{Sort: []repository.Sort{{Key: "version", Descending: true}}, Limit: 2}
+	var names []string
+	cursor := ""
+	for pages := 0; pages == 0 || cursor != ""; pages++ {
+		if pages > 3 {
+			t.Fatalf("Pagination did not end")
+		}
+
+		listCopier, next, err := users.Paginate(ctx, query, cursor)
+		if err != nil {
+			t.Fatalf("Could not paginate the users, got: %v", err)
+		}
+		var page []user
+		_ = listCopier.CopyAll(ctx, &page)
+		for _, u := range page {
+			names = append(names, u.Name)
+		}
+		cursor = next
+	}
+	if strings.Join(names, ",") != "Bob,Mallory,Ada,Eve,Trent" {
+		t.Errorf("Users were not paginated in order, got: %v", names)
+	}
+
+	_, _, err := users.Paginate(ctx, repository.Query{Limit: 2}, "invalid")
+	if !errors.Is(err, exception.ErrCursorInvalid) {
+		t.Errorf("Invalid cursor was not rejected, got: %v", err)
+	}
+
+	iterator, _ := users.Stream(ctx, repository.Query{Skip: 1, Limit: 3})
+	names = nil
+	for iterator.Next(ctx) {
+		var u user
+		_ = iterator.Decode(&u)
+		names = append(names, u.Name)
+	}
+	if iterator.Err() != nil || strings.Join(names, ",") != "Bob,Eve,Mallory" {
+		t.Errorf("Users were not streamed, got: %v, %v", names, iterator.Err())
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	iterator, _ = users.Stream(ctx, repository.Query{})
+	if iterator.Next(cancelled) || !errors.Is(iterator.Err(), context.Canceled) {
+		t.Errorf("Stream was not cancelled, got: %v", iterator.Err())
+	}
+}
+
+func TestVersionedWriter(t *testing.T) {
+	ctx := context.Background()
+	users := NewCollection()
+
+	copier, err := users.Create(ctx, user{Name: "Ada", Version: 1})
+	if err != nil {
+		t.Fatalf("Could not create the user, got: %v", err)
+	}
+	var ada user
+	if err = copier.Copy(&ada); err != nil {
+		t.Fatalf("Could not copy the user, got: %v", err)
+	}
+
+	err = users.PatchIfVersion(ctx, ada.Id, 1, []repository.Patch{{Action: "$set", Key: "name", Value: "Ada Lovelace"}})
+	if err != nil {
+		t.Fatalf("Could not patch the user at its version, got: %v", err)
+	}
+
+	err = users.SetAllByIdIfVersion(ctx, ada.Id, 1, []repository.KeyValue{{Key: "name", Value: "Eve"}})
+	if !errors.Is(err, exception.ErrVersionConflict) {
+		t.Errorf("Stale write was not a version conflict, got: %v", err)
+	}
+
+	copier, err = users.ReplaceIfVersion(ctx, ada.Id, 2, user{Name: "Ada King"})
+	if err != nil {
+		t.Fatalf("Could not replace the user at its version, got: %v", err)
+	}
+	if err = copier.Copy(&ada); err != nil || ada.Name != "Ada King" || ada.Version != 3 {
+		t.Errorf("Replaced user was incorrect, got: %+v, %v", ada, err)
+	}
+
+	_, err = users.ReplaceIfVersion(ctx, primitive.NewObjectId(), 3, user{Name: "Mallory"})
+	if !errors.Is(err, exception.ErrNotFound) {
+		t.Errorf("Missing user was not reported as not found, got: %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/dannypaul/go-skeleton/internal/driver/document"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+)
+
+// Transactor runs transactions on its collections one at a time. When a
+// transaction fails, the collections are restored to their state before it,
+// which also discards the writes made outside of the transaction meanwhile.
+type Transactor struct {
+	mu          *sync.Mutex
+	collections []Collection
+}
+
+var _ repository.Transactor = Transactor{}
+
+// txKey is the context key marking the calls made within a transaction.
+type txKey struct{}
+
+func NewTransactor(collections ...Collection) Transactor {
+	return Transactor{mu: &sync.Mutex{}, collections: collections}
+}
+
+func (t Transactor) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(txKey{}) != nil {
+		return fn(ctx)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	snapshots := make([][]document.Document, len(t.collections))
+	for i, c := range t.collections {
+		snapshots[i] = c.snapshot()
+	}
+
+	err := fn(context.WithValue(ctx, txKey{}, true))
+	if err != nil {
+		for i, c := range t.collections {
+			c.restore(snapshots[i])
+		}
+	}
+	return err
+}
+
+func (c Collection) snapshot() []document.Document {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return append([]document.Document(nil), *c.docs...)
+}
+
+func (c Collection) restore(docs []document.Document) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	*c.docs = docs
+}
@@ -0,0 +1,154 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dannypaul/go-skeleton/internal/exception"
+	"github.com/dannypaul/go-skeleton/internal/primitive"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+
+	"go.mongodb.org/mongo-driver/bson"
+	bsonprimitive "go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	_ repository.BulkWriter  = Collection{}
+	_ repository.ManyUpdater = Collection{}
+	_ repository.Upserter    = Collection{}
+)
+
+func (c Collection) CreateMany(ctx context.Context, models []interface{}, ordered bool) ([]repository.WriteResult, error) {
+	return c.BulkWrite(ctx, repository.Inserts(models), ordered)
+}
+
+func (c Collection) BulkWrite(ctx context.Context, writes []repository.Write, ordered bool) ([]repository.WriteResult, error) {
+	results := make([]repository.WriteResult, len(writes))
+	if len(writes) == 0 {
+		return results, nil
+	}
+
+	models := make([]mongo.WriteModel, len(writes))
+	for i, w := range writes {
+		model, id, err := c.writeModel(ctx, w)
+		if err != nil {
+			return nil, err
+		}
+		models[i], results[i].Id = model, id
+	}
+
+	res, err := c.Collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(ordered))
+	var bulkWriteException mongo.BulkWriteException
+	if errors.As(err, &bulkWriteException) && bulkWriteException.WriteConcernError == nil {
+		failed := len(writes)
+		for _, we := range bulkWriteException.WriteErrors {
+			results[we.Index].Id = ""
+			results[we.Index].Err = we.WriteError
+			if we.Code == 11000 {
+				results[we.Index].Err = exception.ErrConflict
+			}
+			if we.Index < failed {
+				failed = we.Index
+			}
+		}
+		if ordered {
+			for i := failed + 1; i < len(results); i++ {
+				results[i] = repository.WriteResult{Err: exception.ErrWriteSkipped}
+			}
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	if res != nil {
+		for i, id := range res.UpsertedIDs {
+			if objectId, ok := id.(bsonprimitive.ObjectID); ok {
+				results[i].Id = primitive.Id(objectId.Hex())
+			}
+		}
+	}
+	return results, nil
+}
+
+// writeModel translates a write to a write model, and returns the ID of the
+// document an insert creates.
+func (c Collection) writeModel(ctx context.Context, w repository.Write) (mongo.WriteModel, primitive.Id, error) {
+	switch w.Op {
+	case repository.InsertOp:
+		doc, err := c.document(w.Document)
+		if err != nil {
+			return nil, "", err
+		}
+		if c.Timestamps {
+			now := time.Now()
+			doc = setKey(setKey(doc, createdAtKey, now), updatedAtKey, now)
+		}
+		id := documentId(doc)
+		if id == "" {
+			objectId := bsonprimitive.NewObjectID()
+			doc, id = setKey(doc, "_id", objectId), primitive.Id(objectId.Hex())
+		}
+		return mongo.NewInsertOneModel().SetDocument(doc), id, nil
+	case repository.UpdateOp:
+		update, err := c.updateDocument(patchDocument(w.Patches))
+		if err != nil {
+			return nil, "", err
+		}
+		return mongo.NewUpdateOneModel().SetFilter(c.match(ctx, w.Filters)).SetUpdate(update), "", nil
+	case repository.UpsertOp:
+		doc, err := c.replacementOf(ctx, w.Filters, w.Document)
+		if err != nil {
+			return nil, "", err
+		}
+		model := mongo.NewReplaceOneModel().SetFilter(c.match(ctx, w.Filters)).SetReplacement(doc).SetUpsert(true)
+		return model, "", nil
+	case repository.DeleteOp:
+		if c.SoftDelete {
+			deleted := bson.D{{Key: "$set", Value: bson.D{{Key: deletedAtKey, Value: time.Now()}}}}
+			return mongo.NewUpdateOneModel().SetFilter(c.match(ctx, w.Filters)).SetUpdate(c.stamp(deleted)), "", nil
+		}
+		return mongo.NewDeleteOneModel().SetFilter(c.match(ctx, w.Filters)), "", nil
+	}
+	return nil, "", fmt.Errorf("unsupported write operation '%s'", w.Op)
+}
+
+// documentId returns the ID of the document when it is an ObjectID.
+func documentId(doc bson.D) primitive.Id {
+	for _, e := range doc {
+		if objectId, ok := e.Value.(bsonprimitive.ObjectID); ok && e.Key == "_id" {
+			return primitive.Id(objectId.Hex())
+		}
+	}
+	return ""
+}
+
+func (c Collection) UpdateMany(ctx context.Context, filters []repository.Filter, patches []repository.Patch) (int64, error) {
+	update, err := c.updateDocument(patchDocument(patches))
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := c.Collection.UpdateMany(ctx, c.match(ctx, filters), update)
+	if err != nil {
+		return 0, conflict(err)
+	}
+	return res.MatchedCount, nil
+}
+
+func (c Collection) Upsert(ctx context.Context, filters []repository.Filter, model interface{}) (repository.Copier, error) {
+	doc, err := c.replacementOf(ctx, filters, model)
+	if err != nil {
+		return nil, err
+	}
+
+	replaceOptions := options.FindOneAndReplace().SetUpsert(true).SetReturnDocument(options.After)
+	singleResult := c.FindOneAndReplace(ctx, c.match(ctx, filters), doc, replaceOptions)
+	if singleResult.Err() != nil {
+		return nil, conflict(singleResult.Err())
+	}
+	return Copier{singleResult, c.Encryption}, nil
+}
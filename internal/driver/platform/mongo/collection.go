@@ -2,8 +2,13 @@ package mongo
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"regexp"
+	"strings"
+	"time"
 
+	"github.com/dannypaul/go-skeleton/internal/driver/encryption"
 	"github.com/dannypaul/go-skeleton/internal/exception"
 	"github.com/dannypaul/go-skeleton/internal/primitive"
 	"github.com/dannypaul/go-skeleton/internal/repository"
@@ -15,16 +20,215 @@ import (
 
 type Collection struct {
 	*mongo.Collection
+
+	// Timestamps sets createdAt when a document is created, and updatedAt
+	// whenever it is written unless the write sets it.
+	Timestamps bool
+
+	// SoftDelete makes Delete set deletedAt instead of removing the document,
+	// and excludes the deleted documents from every read and write unless the
+	// context is made by WithDeleted. The deleted documents remain in the
+	// unique indexes.
+	SoftDelete bool
+
+	// Encryption encrypts fields of the documents as they are written, and
+	// decrypts them as they are read. The filters on the deterministic fields
+	// match their ciphertexts.
+	Encryption *encryption.Fields
 }
 
-func (c Collection) Set(ctx context.Context, filters []repository.Filter, Key string, value interface{}) error {
+// The keys of the timestamps of the documents
+const (
+	createdAtKey = "createdAt"
+	updatedAtKey = "updatedAt"
+	deletedAtKey = "deletedAt"
+)
+
+type withDeletedKey struct{}
+
+// WithDeleted returns a context in which the soft deleted documents are not
+// excluded.
+func WithDeleted(ctx context.Context) context.Context {
+	return context.WithValue(ctx, withDeletedKey{}, true)
+}
+
+// streamBatchSize is the number of documents a stream fetches at a time.
+const streamBatchSize = 100
+
+// operators maps the operators of repository.Filter to MongoDB query
+// operators.
+var operators = map[repository.Operator]string{
+	repository.Ne:     "$ne",
+	repository.Gt:     "$gt",
+	repository.Gte:    "$gte",
+	repository.Lt:     "$lt",
+	repository.Lte:    "$lte",
+	repository.In:     "$in",
+	repository.Nin:    "$nin",
+	repository.Exists: "$exists",
+}
+
+// matchFilters translates the filters to a query document. As a key can only
+// appear once in a document, filters repeating a key are combined with $and.
+func matchFilters(filters []repository.Filter) bson.D {
 	match := bson.D{}
+	keys := map[string]bool{}
+	repeated := false
 	for _, f := range filters {
-		match = append(match, bson.E{Key: f.Key, Value: f.Value})
+		e := matchFilter(f)
+		repeated = repeated || keys[e.Key]
+		keys[e.Key] = true
+		match = append(match, e)
+	}
+
+	if !repeated {
+		return match
 	}
+
+	conditions := bson.A{}
+	for _, e := range match {
+		conditions = append(conditions, bson.D{e})
+	}
+	return bson.D{{Key: "$and", Value: conditions}}
+}
+
+// match translates the filters to a query document, which excludes the
+// deleted documents of a collection with SoftDelete.
+func (c Collection) match(ctx context.Context, filters []repository.Filter) bson.D {
+	if c.SoftDelete && ctx.Value(withDeletedKey{}) == nil {
+		notDeleted := repository.Filter{Key: deletedAtKey, Operator: repository.Exists, Value: false}
+		filters = append(append([]repository.Filter{}, c.Encryption.Filters(filters)...), notDeleted)
+		return matchFilters(filters)
+	}
+	return matchFilters(c.Encryption.Filters(filters))
+}
+
+func byId(id primitive.Id) []repository.Filter {
+	return []repository.Filter{{Key: "_id", Value: id}}
+}
+
+// stamp adds the time of the update to the update document of a collection
+// with Timestamps, unless the update sets it already.
+func (c Collection) stamp(update bson.D) bson.D {
+	if !c.Timestamps {
+		return update
+	}
+
+	stamped := append(bson.D{}, update...)
+	for i, e := range stamped {
+		if e.Key != "$set" {
+			continue
+		}
+		setters := e.Value.(bson.D)
+		for _, setter := range setters {
+			if setter.Key == updatedAtKey {
+				return update
+			}
+		}
+		stamped[i].Value = append(append(bson.D{}, setters...), bson.E{Key: updatedAtKey, Value: time.Now()})
+		return stamped
+	}
+	return append(stamped, bson.E{Key: "$set", Value: bson.D{{Key: updatedAtKey, Value: time.Now()}}})
+}
+
+// updateOne updates the first document matching the query document, with the
+// encrypted fields of the update encrypted and its time stamped.
+func (c Collection) updateOne(ctx context.Context, match bson.D, update bson.D) (*mongo.UpdateResult, error) {
+	update, err := c.updateDocument(update)
+	if err != nil {
+		return nil, err
+	}
+	return c.UpdateOne(ctx, match, update)
+}
+
+// updateDocument encrypts the fields of the update document and stamps it.
+func (c Collection) updateDocument(update bson.D) (bson.D, error) {
+	update, err := c.Encryption.Update(update)
+	if err != nil {
+		return nil, err
+	}
+	return c.stamp(update), nil
+}
+
+// document marshals the model to a document with its fields encrypted.
+func (c Collection) document(model interface{}) (bson.D, error) {
+	doc, err := toDocument(model)
+	if err != nil {
+		return nil, err
+	}
+	return c.Encryption.EncryptDocument(doc)
+}
+
+// toDocument marshals the model to a document.
+func toDocument(model interface{}) (bson.D, error) {
+	data, err := bson.Marshal(model)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc bson.D
+	err = bson.Unmarshal(data, &doc)
+	return doc, err
+}
+
+// setKey sets the top level key of the document, appending it when missing.
+func setKey(doc bson.D, key string, value interface{}) bson.D {
+	for i, e := range doc {
+		if e.Key == key {
+			doc[i].Value = value
+			return doc
+		}
+	}
+	return append(doc, bson.E{Key: key, Value: value})
+}
+
+func matchFilter(f repository.Filter) bson.E {
+	if len(f.Or) > 0 || len(f.And) > 0 {
+		operator, filters := "$or", f.Or
+		if len(f.Or) == 0 {
+			operator, filters = "$and", f.And
+		}
+
+		conditions := bson.A{}
+		for _, filter := range filters {
+			conditions = append(conditions, matchFilters([]repository.Filter{filter}))
+		}
+		return bson.E{Key: operator, Value: conditions}
+	}
+
+	switch f.Operator {
+	case "", repository.Eq:
+		return bson.E{Key: f.Key, Value: f.Value}
+	case repository.Prefix:
+		prefix, _ := f.Value.(string)
+		return bson.E{Key: f.Key, Value: bson.D{{Key: "$regex", Value: "^" + regexp.QuoteMeta(prefix)}}}
+	}
+	return bson.E{Key: f.Key, Value: bson.D{{Key: operators[f.Operator], Value: f.Value}}}
+}
+
+// conflict converts duplicate key errors to exception.ErrConflict.
+func conflict(err error) error {
+	var writeException mongo.WriteException
+	if errors.As(err, &writeException) {
+		for _, we := range writeException.WriteErrors {
+			if we.Code == 11000 {
+				return exception.ErrConflict
+			}
+		}
+	}
+
+	var commandError mongo.CommandError
+	if errors.As(err, &commandError) && commandError.Code == 11000 {
+		return exception.ErrConflict
+	}
+	return err
+}
+
+func (c Collection) Set(ctx context.Context, filters []repository.Filter, Key string, value interface{}) error {
+	match := c.match(ctx, filters)
 	update := bson.D{{"$set", bson.D{{Key, value}}}}
 
-	res, err := c.UpdateOne(ctx, match, update)
+	res, err := c.updateOne(ctx, match, update)
 	if err != nil {
 		return err
 	}
@@ -35,10 +239,7 @@ func (c Collection) Set(ctx context.Context, filters []repository.Filter, Key st
 }
 
 func (c Collection) SetAll(ctx context.Context, filters []repository.Filter, keyValues []repository.KeyValue) error {
-	match := bson.D{}
-	for _, f := range filters {
-		match = append(match, bson.E{Key: f.Key, Value: f.Value})
-	}
+	match := c.match(ctx, filters)
 
 	setters := bson.D{}
 	for _, kv := range keyValues {
@@ -46,7 +247,7 @@ func (c Collection) SetAll(ctx context.Context, filters []repository.Filter, key
 	}
 	update := bson.D{{"$set", setters}}
 
-	res, err := c.UpdateOne(ctx, match, update)
+	res, err := c.updateOne(ctx, match, update)
 	if err != nil {
 		return err
 	}
@@ -61,12 +262,12 @@ func (c Collection) SetById(ctx context.Context, id primitive.Id, Key string, Va
 		return exception.ErrIdInvalid
 	}
 
-	match := bson.D{{"_id", id}}
+	match := c.match(ctx, byId(id))
 
 	setter := bson.D{{Key, Value}}
 	update := bson.D{{"$set", setter}}
 
-	res, err := c.UpdateOne(ctx, match, update)
+	res, err := c.updateOne(ctx, match, update)
 	if err != nil {
 		return err
 	}
@@ -81,7 +282,7 @@ func (c Collection) SetAllById(ctx context.Context, id primitive.Id, keyValues [
 		return exception.ErrIdInvalid
 	}
 
-	match := bson.D{{"_id", id}}
+	match := c.match(ctx, byId(id))
 
 	setters := bson.D{}
 	for _, kv := range keyValues {
@@ -89,7 +290,7 @@ func (c Collection) SetAllById(ctx context.Context, id primitive.Id, keyValues [
 	}
 	update := bson.D{{"$set", setters}}
 
-	res, err := c.UpdateOne(ctx, match, update)
+	res, err := c.updateOne(ctx, match, update)
 	if err != nil {
 		return err
 	}
@@ -100,13 +301,10 @@ func (c Collection) SetAllById(ctx context.Context, id primitive.Id, keyValues [
 }
 
 func (c Collection) UnSet(ctx context.Context, filters []repository.Filter, Key string) error {
-	match := bson.D{}
-	for _, f := range filters {
-		match = append(match, bson.E{Key: f.Key, Value: f.Value})
-	}
+	match := c.match(ctx, filters)
 	update := bson.D{{"$unset", bson.D{{Key, ""}}}}
 
-	res, err := c.UpdateOne(ctx, match, update)
+	res, err := c.updateOne(ctx, match, update)
 	if err != nil {
 		return err
 	}
@@ -121,21 +319,78 @@ func (c Collection) Patch(ctx context.Context, id primitive.Id, patches []reposi
 		return exception.ErrIdInvalid
 	}
 
-	match := bson.D{{"_id", id}}
+	match := c.match(ctx, byId(id))
 
+	res, err := c.updateOne(ctx, match, patchDocument(patches))
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return exception.ErrNotFound
+	}
+	return err
+}
+
+// patchDocument translates the patches to an update document. Patches with
+// the same action are grouped as an update document cannot contain the same
+// operator twice.
+func patchDocument(patches []repository.Patch) bson.D {
 	patch := bson.D{}
 	for _, p := range patches {
-		patch = append(patch, bson.E{Key: p.Action, Value: bson.D{{p.Key, p.Value}}})
+		grouped := false
+		for i, e := range patch {
+			if e.Key == p.Action {
+				patch[i].Value = append(e.Value.(bson.D), bson.E{Key: p.Key, Value: p.Value})
+				grouped = true
+				break
+			}
+		}
+		if !grouped {
+			patch = append(patch, bson.E{Key: p.Action, Value: bson.D{{p.Key, p.Value}}})
+		}
 	}
+	return patch
+}
+
+func byVersion(id primitive.Id, version int) []repository.Filter {
+	return []repository.Filter{{Key: "_id", Value: id}, {Key: repository.VersionKey, Value: version}}
+}
 
-	res, err := c.UpdateOne(ctx, match, patch)
+// versionConflict returns the error of a write which did not match the
+// document at the expected version, depending on whether the document exists.
+func (c Collection) versionConflict(ctx context.Context, id primitive.Id) error {
+	count, err := c.CountDocuments(ctx, c.match(ctx, byId(id)))
 	if err != nil {
 		return err
 	}
-	if res.MatchedCount == 0 {
+	if count == 0 {
 		return exception.ErrNotFound
 	}
-	return err
+	return exception.ErrVersionConflict
+}
+
+func (c Collection) PatchIfVersion(ctx context.Context, id primitive.Id, version int, patches []repository.Patch) error {
+	if id.IsValid() == false {
+		return exception.ErrIdInvalid
+	}
+
+	patches = append(append([]repository.Patch{}, patches...), repository.Patch{Action: "$inc", Key: repository.VersionKey, Value: 1})
+	res, err := c.updateOne(ctx, c.match(ctx, byVersion(id, version)), patchDocument(patches))
+	if err != nil {
+		return conflict(err)
+	}
+	if res.MatchedCount == 0 {
+		return c.versionConflict(ctx, id)
+	}
+	return nil
+}
+
+func (c Collection) SetAllByIdIfVersion(ctx context.Context, id primitive.Id, version int, keyValues []repository.KeyValue) error {
+	patches := make([]repository.Patch, len(keyValues))
+	for i, kv := range keyValues {
+		patches[i] = repository.Patch{Action: "$set", Key: kv.Key, Value: kv.Value}
+	}
+	return c.PatchIfVersion(ctx, id, version, patches)
 }
 
 func (c Collection) IncrementById(ctx context.Context, id primitive.Id, Key string, incrementBy int) error {
@@ -143,10 +398,10 @@ func (c Collection) IncrementById(ctx context.Context, id primitive.Id, Key stri
 		return exception.ErrIdInvalid
 	}
 
-	match := bson.D{{"_id", id}}
+	match := c.match(ctx, byId(id))
 	update := bson.D{{"$inc", bson.D{{Key, incrementBy}}}}
 
-	res, err := c.UpdateOne(ctx, match, update)
+	res, err := c.updateOne(ctx, match, update)
 	if err != nil {
 		return err
 	}
@@ -157,34 +412,27 @@ func (c Collection) IncrementById(ctx context.Context, id primitive.Id, Key stri
 }
 
 func (c Collection) Count(ctx context.Context, filters []repository.Filter) (int64, error) {
-	match := bson.D{}
-	for _, f := range filters {
-		match = append(match, bson.E{Key: f.Key, Value: f.Value})
-	}
+	match := c.match(ctx, filters)
 	return c.CountDocuments(ctx, match)
 }
 
 func (c Collection) Create(ctx context.Context, model interface{}) (repository.Copier, error) {
-	doc, err := bson.Marshal(model)
+	doc, err := c.document(model)
 	if err != nil {
 		return nil, err
 	}
+	if c.Timestamps {
+		now := time.Now()
+		doc = setKey(setKey(doc, createdAtKey, now), updatedAtKey, now)
+	}
 
 	result, err := c.InsertOne(ctx, doc)
 	if err != nil {
-		var e mongo.WriteException
-		if errors.As(err, &e) {
-			for _, we := range e.WriteErrors {
-				if we.Code == 11000 {
-					return nil, exception.ErrConflict
-				}
-			}
-		}
-		return nil, err
+		return nil, conflict(err)
 	}
 
 	singleResult := c.FindOne(ctx, bson.M{"_id": result.InsertedID})
-	return Copier{singleResult}, singleResult.Err()
+	return Copier{singleResult, c.Encryption}, singleResult.Err()
 }
 
 func (c Collection) FindById(ctx context.Context, id primitive.Id) (repository.Copier, error) {
@@ -192,11 +440,11 @@ func (c Collection) FindById(ctx context.Context, id primitive.Id) (repository.C
 		return nil, exception.ErrIdInvalid
 	}
 
-	singleResult := c.FindOne(ctx, bson.M{"_id": id})
+	singleResult := c.FindOne(ctx, c.match(ctx, byId(id)))
 	if singleResult.Err() != nil && errors.Is(singleResult.Err(), mongo.ErrNoDocuments) {
 		return nil, exception.ErrNotFound
 	}
-	return Copier{singleResult}, singleResult.Err()
+	return Copier{singleResult, c.Encryption}, singleResult.Err()
 }
 
 func (c Collection) Delete(ctx context.Context, id primitive.Id) (int64, error) {
@@ -204,6 +452,15 @@ func (c Collection) Delete(ctx context.Context, id primitive.Id) (int64, error)
 		return 0, exception.ErrIdInvalid
 	}
 
+	if c.SoftDelete {
+		deleted := bson.D{{Key: "$set", Value: bson.D{{Key: deletedAtKey, Value: time.Now()}}}}
+		res, err := c.updateOne(ctx, c.match(ctx, byId(id)), deleted)
+		if err != nil {
+			return 0, err
+		}
+		return res.ModifiedCount, nil
+	}
+
 	deleteResult, err := c.DeleteOne(ctx, bson.M{"_id": id})
 	if err != nil {
 		return 0, err
@@ -212,11 +469,7 @@ func (c Collection) Delete(ctx context.Context, id primitive.Id) (int64, error)
 }
 
 func (c Collection) Add(ctx context.Context, filters []repository.Filter, key string) (float64, error) {
-	matchConditions := bson.D{}
-	for _, f := range filters {
-		matchConditions = append(matchConditions, bson.E{Key: f.Key, Value: f.Value})
-	}
-	match := bson.D{{"$match", matchConditions}}
+	match := bson.D{{"$match", c.match(ctx, filters)}}
 	group := bson.D{{"$group", bson.D{{"total", bson.D{{"$sum", "$" + key}}}}}}
 
 	cursor, err := c.Aggregate(ctx, mongo.Pipeline{match, group})
@@ -235,16 +488,148 @@ func (c Collection) Add(ctx context.Context, filters []repository.Filter, key st
 }
 
 func (c Collection) FindSingle(ctx context.Context, filters []repository.Filter) (repository.Copier, error) {
-	match := bson.D{}
-	for _, f := range filters {
-		match = append(match, bson.E{Key: f.Key, Value: f.Value})
-	}
+	match := c.match(ctx, filters)
 
 	singleResult := c.FindOne(ctx, match)
 	if singleResult.Err() != nil && errors.Is(singleResult.Err(), mongo.ErrNoDocuments) {
 		return nil, exception.ErrNotFound
 	}
-	return Copier{singleResult}, singleResult.Err()
+	return Copier{singleResult, c.Encryption}, singleResult.Err()
+}
+
+func (c Collection) FindAll(ctx context.Context, filters []repository.Filter) (repository.ListCopier, error) {
+	match := c.match(ctx, filters)
+
+	cursor, err := c.Find(ctx, match)
+	if err != nil {
+		return nil, err
+	}
+	return ListCopier{cursor, c.Encryption}, nil
+}
+
+// findOptions translates the order, projection and paging of a query.
+func findOptions(query repository.Query) *options.FindOptions {
+	sort := bson.D{}
+	for _, s := range query.Sorts() {
+		direction := 1
+		if s.Descending {
+			direction = -1
+		}
+		sort = append(sort, bson.E{Key: s.Key, Value: direction})
+	}
+
+	findOptions := options.Find().SetSort(sort).SetSkip(query.Skip).SetLimit(query.Limit)
+	if len(query.Projection) > 0 {
+		projection := bson.D{}
+		for _, key := range query.Projection {
+			projection = append(projection, bson.E{Key: key, Value: 1})
+		}
+		findOptions.SetProjection(projection)
+	}
+	return findOptions
+}
+
+func (c Collection) Query(ctx context.Context, query repository.Query) (repository.ListCopier, error) {
+	cursor, err := c.Find(ctx, c.match(ctx, query.Filters), findOptions(query))
+	if err != nil {
+		return nil, err
+	}
+	return ListCopier{cursor, c.Encryption}, nil
+}
+
+func (c Collection) Paginate(ctx context.Context, query repository.Query, cursor string) (repository.ListCopier, string, error) {
+	query, err := query.After(cursor, fromCursorValue)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// One more document tells whether there is a next page, and the sort keys
+	// of the last document are needed even when they are not projected
+	extended := query
+	if query.Limit > 0 {
+		extended.Limit = query.Limit + 1
+	}
+	if len(query.Projection) > 0 {
+		extended.Projection = append([]string{}, query.Projection...)
+		for _, s := range query.Sort {
+			projected := false
+			for _, key := range query.Projection {
+				projected = projected || key == s.Key
+			}
+			if !projected {
+				extended.Projection = append(extended.Projection, s.Key)
+			}
+		}
+	}
+
+	result, err := c.Find(ctx, c.match(ctx, extended.Filters), findOptions(extended))
+	if err != nil {
+		return nil, "", err
+	}
+
+	var docs []bson.Raw
+	err = result.All(ctx, &docs)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if query.Limit <= 0 || int64(len(docs)) <= query.Limit {
+		return RawListCopier{docs, c.Encryption}, "", nil
+	}
+
+	docs = docs[:query.Limit]
+	next, err := cursorOf(docs[len(docs)-1], query)
+	if err != nil {
+		return nil, "", err
+	}
+	return RawListCopier{docs, c.Encryption}, next, nil
+}
+
+// cursorOf returns the cursor of the documents which follow the document in
+// the order of the query.
+func cursorOf(doc bson.Raw, query repository.Query) (string, error) {
+	var values []interface{}
+	for _, s := range query.Sorts() {
+		var value interface{}
+		if raw, err := doc.LookupErr(strings.Split(s.Key, ".")...); err == nil {
+			value = raw
+		}
+		values = append(values, value)
+	}
+	return newCursor(values...)
+}
+
+// newCursor encodes the values of a cursor as canonical Extended JSON, which
+// keeps their BSON types.
+func newCursor(values ...interface{}) (string, error) {
+	encoded := make([]json.RawMessage, len(values))
+	for i, value := range values {
+		var err error
+		encoded[i], err = bson.MarshalExtJSON(bson.D{{Key: "v", Value: value}}, true, false)
+		if err != nil {
+			return "", err
+		}
+	}
+	return repository.NewCursor(encoded)
+}
+
+// fromCursorValue decodes a value of a cursor created by newCursor.
+func fromCursorValue(data json.RawMessage) (interface{}, error) {
+	var value struct {
+		V interface{} `bson:"v"`
+	}
+	err := bson.UnmarshalExtJSON(data, true, &value)
+	return value.V, err
+}
+
+// Stream iterates over a cursor which fetches batches of streamBatchSize
+// documents as they are consumed.
+func (c Collection) Stream(ctx context.Context, query repository.Query) (repository.Iterator, error) {
+	cursor, err := c.Find(ctx, c.match(ctx, query.Filters), findOptions(query).SetBatchSize(streamBatchSize))
+	if err != nil {
+		return nil, err
+	}
+	return Iterator{cursor, c.Encryption}, nil
 }
 
 func (c Collection) Replace(ctx context.Context, id primitive.Id, value interface{}) (repository.Copier, error) {
@@ -252,13 +637,69 @@ func (c Collection) Replace(ctx context.Context, id primitive.Id, value interfac
 		return nil, exception.ErrIdInvalid
 	}
 
-	doc, err := bson.Marshal(value)
+	doc, err := c.replacement(ctx, id, value)
+	if err != nil {
+		return nil, err
+	}
+
+	after := options.After
+	replaceOptions := options.FindOneAndReplaceOptions{ReturnDocument: &after}
+	singleResult := c.FindOneAndReplace(ctx, c.match(ctx, byId(id)), doc, &replaceOptions)
+	return Copier{singleResult, c.Encryption}, singleResult.Err()
+}
+
+func (c Collection) ReplaceIfVersion(ctx context.Context, id primitive.Id, version int, value interface{}) (repository.Copier, error) {
+	if id.IsValid() == false {
+		return nil, exception.ErrIdInvalid
+	}
+
+	doc, err := c.replacement(ctx, id, value)
 	if err != nil {
 		return nil, err
 	}
+	doc = setKey(doc, repository.VersionKey, version+1)
 
 	after := options.After
 	replaceOptions := options.FindOneAndReplaceOptions{ReturnDocument: &after}
-	singleResult := c.FindOneAndReplace(ctx, bson.M{"_id": id}, doc, &replaceOptions)
-	return Copier{singleResult}, singleResult.Err()
+	singleResult := c.FindOneAndReplace(ctx, c.match(ctx, byVersion(id, version)), doc, &replaceOptions)
+	if errors.Is(singleResult.Err(), mongo.ErrNoDocuments) {
+		return nil, c.versionConflict(ctx, id)
+	}
+	if singleResult.Err() != nil {
+		return nil, conflict(singleResult.Err())
+	}
+	return Copier{singleResult, c.Encryption}, nil
+}
+
+// replacement marshals the value replacing the document with the ID. The
+// replacement of a collection with Timestamps keeps the creation time of the
+// document.
+func (c Collection) replacement(ctx context.Context, id primitive.Id, value interface{}) (bson.D, error) {
+	return c.replacementOf(ctx, byId(id), value)
+}
+
+// replacementOf marshals the value replacing the first document matching the
+// filters. The replacement of a collection with Timestamps keeps the creation
+// time of the document, or is created now when no document matches.
+func (c Collection) replacementOf(ctx context.Context, filters []repository.Filter, value interface{}) (bson.D, error) {
+	doc, err := c.document(value)
+	if err != nil || !c.Timestamps {
+		return doc, err
+	}
+
+	var existing struct {
+		CreatedAt *time.Time `bson:"createdAt"`
+	}
+	projection := options.FindOne().SetProjection(bson.D{{Key: createdAtKey, Value: 1}})
+	err = c.FindOne(ctx, c.match(ctx, filters), projection).Decode(&existing)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	now := time.Now()
+	if existing.CreatedAt != nil {
+		doc = setKey(doc, createdAtKey, *existing.CreatedAt)
+	} else if errors.Is(err, mongo.ErrNoDocuments) {
+		doc = setKey(doc, createdAtKey, now)
+	}
+	return setKey(doc, updatedAtKey, now), nil
 }
@@ -0,0 +1,81 @@
+package mongo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dannypaul/go-skeleton/internal/repository"
+
+	"go.mongodb.org/mongo-driver/bson"
+	bsonprimitive "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMatch(t *testing.T) {
+	ctx := context.Background()
+	filters := []repository.Filter{{Key: "name", Value: "Ada"}}
+
+	match := Collection{}.match(ctx, filters)
+	if len(match) != 1 || match[0].Key != "name" {
+		t.Errorf("Match of a collection without soft delete was incorrect, got: %v", match)
+	}
+
+	match = Collection{SoftDelete: true}.match(ctx, filters)
+	if len(match) != 2 || match[1].Key != deletedAtKey {
+		t.Errorf("Match did not exclude the deleted documents, got: %v", match)
+	}
+
+	match = Collection{SoftDelete: true}.match(WithDeleted(ctx), filters)
+	if len(match) != 1 {
+		t.Errorf("Match excluded the deleted documents, got: %v", match)
+	}
+}
+
+func TestStamp(t *testing.T) {
+	update := patchDocument([]repository.Patch{{Action: "$inc", Key: "version", Value: 1}})
+	if stamped := (Collection{}).stamp(update); len(stamped) != 1 {
+		t.Errorf("Update of a collection without timestamps was stamped, got: %v", stamped)
+	}
+
+	stamped := Collection{Timestamps: true}.stamp(update)
+	if len(stamped) != 2 || stamped[1].Key != "$set" || stamped[1].Value.(bson.D)[0].Key != updatedAtKey {
+		t.Errorf("Update was not stamped, got: %v", stamped)
+	}
+
+	update = patchDocument([]repository.Patch{{Action: "$set", Key: "name", Value: "Ada"}})
+	stamped = Collection{Timestamps: true}.stamp(update)
+	if setters := stamped[0].Value.(bson.D); len(stamped) != 1 || len(setters) != 2 || setters[1].Key != updatedAtKey {
+		t.Errorf("Update time was not added to the setters, got: %v", stamped)
+	}
+	if len(update[0].Value.(bson.D)) != 1 {
+		t.Errorf("Stamping changed the update, got: %v", update)
+	}
+}
+
+func TestCursorOf(t *testing.T) {
+	id := bsonprimitive.NewObjectID()
+	doc, err := bson.Marshal(bson.D{{Key: "_id", Value: id}, {Key: "age", Value: int32(36)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := repository.Query{Sort: []repository.Sort{{Key: "age"}}}
+
+	cursor, err := cursorOf(doc, query)
+	if err != nil {
+		t.Fatalf("Could not create the cursor, got: %v", err)
+	}
+	after, err := query.After(cursor, fromCursorValue)
+	if err != nil {
+		t.Fatalf("Could not decode the cursor, got: %v", err)
+	}
+
+	// The values keep their BSON types, so that they compare equal to the
+	// values of the documents
+	first := after.Filters[0].Or[0].And[0]
+	if first.Value != int32(36) {
+		t.Errorf("Cursor value was incorrect, got: %#v", first.Value)
+	}
+	second := after.Filters[0].Or[1].And[1]
+	if second.Value != id {
+		t.Errorf("Cursor value was incorrect, got: %#v", second.Value)
+	}
+}
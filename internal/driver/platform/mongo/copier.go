@@ -2,28 +2,119 @@ package mongo
 
 import (
 	"context"
+	"errors"
+	"reflect"
 
+	"github.com/dannypaul/go-skeleton/internal/driver/encryption"
+
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// The copiers decrypt the encrypted fields of the documents before copying
+// them, when the collection has Encryption.
+
 type Copier struct {
 	result *mongo.SingleResult
+	fields *encryption.Fields
 }
 
 func (c Copier) Copy(destination interface{}) error {
 	if c.result.Err() != nil {
 		return c.result.Err()
 	}
-	return c.result.Decode(destination)
+	if c.fields == nil {
+		return c.result.Decode(destination)
+	}
+
+	doc, err := c.result.DecodeBytes()
+	if err != nil {
+		return err
+	}
+	return RawCopier{doc, c.fields}.Copy(destination)
+}
+
+// RawCopier copies a document which has already been read.
+type RawCopier struct {
+	doc    bson.Raw
+	fields *encryption.Fields
+}
+
+func (c RawCopier) Copy(destination interface{}) error {
+	doc, err := c.fields.DecryptDocument(c.doc)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(doc, destination)
 }
 
 type ListCopier struct {
 	cursor *mongo.Cursor
+	fields *encryption.Fields
 }
 
 func (l ListCopier) CopyAll(ctx context.Context, destination interface{}) error {
 	if l.cursor.Err() != nil {
 		return l.cursor.Err()
 	}
-	return l.cursor.All(ctx, destination)
+	if l.fields == nil {
+		return l.cursor.All(ctx, destination)
+	}
+
+	var docs []bson.Raw
+	err := l.cursor.All(ctx, &docs)
+	if err != nil {
+		return err
+	}
+	return RawListCopier{docs, l.fields}.CopyAll(ctx, destination)
+}
+
+// RawListCopier copies documents which have already been read from a cursor.
+type RawListCopier struct {
+	docs   []bson.Raw
+	fields *encryption.Fields
+}
+
+func (l RawListCopier) CopyAll(ctx context.Context, destination interface{}) error {
+	slice := reflect.ValueOf(destination)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		return errors.New("destination must be a pointer to a slice")
+	}
+
+	elements := slice.Elem().Slice(0, 0)
+	for _, doc := range l.docs {
+		element := reflect.New(elements.Type().Elem())
+		err := RawCopier{doc, l.fields}.Copy(element.Interface())
+		if err != nil {
+			return err
+		}
+		elements = reflect.Append(elements, element.Elem())
+	}
+
+	slice.Elem().Set(elements)
+	return nil
+}
+
+type Iterator struct {
+	cursor *mongo.Cursor
+	fields *encryption.Fields
+}
+
+func (i Iterator) Next(ctx context.Context) bool {
+	return i.cursor.Next(ctx)
+}
+
+func (i Iterator) Decode(destination interface{}) error {
+	if i.fields == nil {
+		return i.cursor.Decode(destination)
+	}
+	return RawCopier{i.cursor.Current, i.fields}.Copy(destination)
+}
+
+func (i Iterator) Err() error {
+	return i.cursor.Err()
+}
+
+func (i Iterator) Close(ctx context.Context) error {
+	return i.cursor.Close(ctx)
 }
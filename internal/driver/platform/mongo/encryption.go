@@ -0,0 +1,112 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dannypaul/go-skeleton/internal/config"
+	"github.com/dannypaul/go-skeleton/internal/driver/encryption"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var _ encryption.KeyStore = DataKeys{}
+
+const DataKeyCollectionName = "encryption_keys"
+
+// DataKeys keeps the wrapped data keys of the encrypted fields in the
+// encryption_keys collection.
+type DataKeys struct {
+	collection *mongo.Collection
+}
+
+func NewDataKeys(client *Client) (DataKeys, error) {
+	conf, err := config.Get()
+	if err != nil {
+		return DataKeys{}, err
+	}
+	return DataKeys{client.Database(conf.MongoDatabasebName).Collection(DataKeyCollectionName)}, nil
+}
+
+func (d DataKeys) DataKeys(ctx context.Context) ([]encryption.DataKey, error) {
+	cursor, err := d.collection.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: createdAtKey, Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []encryption.DataKey
+	err = cursor.All(ctx, &keys)
+	return keys, err
+}
+
+func (d DataKeys) SaveDataKey(ctx context.Context, key encryption.DataKey) error {
+	_, err := d.collection.ReplaceOne(ctx, bson.D{{Key: "_id", Value: key.Id}}, key, options.Replace().SetUpsert(true))
+	return err
+}
+
+// NewCipher loads the master keys of the key file, and the data keys they
+// wrap from the encryption_keys collection.
+func NewCipher(ctx context.Context, client *Client, keyFile string) (*encryption.Cipher, error) {
+	keyring, err := encryption.LoadKeyring(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKeys, err := NewDataKeys(client)
+	if err != nil {
+		return nil, err
+	}
+	return encryption.NewCipher(ctx, keyring, dataKeys)
+}
+
+// Reencrypt encrypts the encrypted fields of every document, including the
+// soft deleted ones, with the active data key, and encrypts the fields still
+// in plaintext. A document is only updated when the fields are unchanged since
+// it was read, so that concurrent writes are not overwritten. It returns the
+// number of documents re-encrypted.
+func (c Collection) Reencrypt(ctx context.Context) (int, error) {
+	if c.Encryption == nil {
+		return 0, nil
+	}
+
+	cursor, err := c.Find(ctx, bson.D{}, options.Find().SetBatchSize(streamBatchSize))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	reencrypted := 0
+	for cursor.Next(ctx) {
+		var doc bson.D
+		err = bson.Unmarshal(cursor.Current, &doc)
+		if err != nil {
+			return reencrypted, err
+		}
+
+		changed, err := c.Encryption.Reencrypt(doc)
+		if err != nil {
+			return reencrypted, fmt.Errorf("could not re-encrypt document %v %w", cursor.Current.Lookup("_id"), err)
+		}
+		if len(changed) == 0 {
+			continue
+		}
+
+		match := bson.D{{Key: "_id", Value: cursor.Current.Lookup("_id")}}
+		for _, e := range doc {
+			for _, element := range changed {
+				if element.Key == e.Key {
+					match = append(match, e)
+				}
+			}
+		}
+
+		res, err := c.UpdateOne(ctx, match, bson.D{{Key: "$set", Value: changed}})
+		if err != nil {
+			return reencrypted, conflict(err)
+		}
+		reencrypted += int(res.ModifiedCount)
+	}
+	return reencrypted, cursor.Err()
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/dannypaul/go-skeleton/internal/config"
+	"github.com/dannypaul/go-skeleton/internal/repository"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -17,6 +18,8 @@ type Client struct {
 	*mongo.Client
 }
 
+var _ repository.Transactor = &Client{}
+
 // Connect ...
 func Connect(ctx context.Context) *Client {
 	conf, _ := config.Get()
@@ -46,6 +49,26 @@ func Connect(ctx context.Context) *Client {
 	return &Client{client}
 }
 
+// WithTransaction runs fn in a transaction of a new session. The driver retries
+// the transaction on TransientTransactionError and retries the commit on
+// UnknownTransactionCommitResult. Transactions require a replica set.
+func (c *Client) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+
+	session, err := c.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessionCtx)
+	})
+	return err
+}
+
 // Disconnect ...
 func Disconnect(client *Client) {
 	err := client.Disconnect(context.Background())
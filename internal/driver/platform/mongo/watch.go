@@ -0,0 +1,308 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dannypaul/go-skeleton/internal/config"
+	"github.com/dannypaul/go-skeleton/internal/driver/encryption"
+	"github.com/dannypaul/go-skeleton/internal/primitive"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+
+	"go.mongodb.org/mongo-driver/bson"
+	bsonprimitive "go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	_ repository.Watcher      = Collection{}
+	_ repository.Watcher      = Poller{}
+	_ repository.ResumeTokens = ResumeTokens{}
+)
+
+// Watch streams the changes of the collection with a change stream, which
+// requires a replica set. The soft deletes of a collection with SoftDelete are
+// reported as deletes.
+func (c Collection) Watch(ctx context.Context, resumeToken string) (repository.EventStream, error) {
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.D{{Key: "operationType", Value: bson.D{
+		{Key: "$in", Value: bson.A{"insert", "update", "replace", "delete"}},
+	}}}}}}
+
+	streamOptions := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if resumeToken != "" {
+		streamOptions.SetResumeAfter(bson.D{{Key: "_data", Value: resumeToken}})
+	}
+
+	stream, err := c.Collection.Watch(ctx, pipeline, streamOptions)
+	if err != nil {
+		return nil, err
+	}
+	return &ChangeStream{stream: stream, fields: c.Encryption}, nil
+}
+
+// changeEvent holds the fields of a change stream event which are reported.
+type changeEvent struct {
+	OperationType string `bson:"operationType"`
+	DocumentKey   struct {
+		Id primitive.Id `bson:"_id"`
+	} `bson:"documentKey"`
+	FullDocument      bson.Raw `bson:"fullDocument"`
+	UpdateDescription struct {
+		UpdatedFields bson.Raw `bson:"updatedFields"`
+	} `bson:"updateDescription"`
+}
+
+// event translates the change stream event. The update of a document which
+// was deleted meanwhile has no full document.
+func (e changeEvent) event(resumeToken string, fields *encryption.Fields) repository.Event {
+	event := repository.Event{Id: e.DocumentKey.Id, ResumeToken: resumeToken}
+	switch e.OperationType {
+	case "insert":
+		event.Type = repository.InsertEvent
+	case "delete":
+		event.Type = repository.DeleteEvent
+	default:
+		event.Type = repository.UpdateEvent
+		if _, err := e.UpdateDescription.UpdatedFields.LookupErr(deletedAtKey); err == nil {
+			event.Type = repository.DeleteEvent
+		}
+	}
+
+	if event.Type != repository.DeleteEvent && e.FullDocument != nil {
+		event.Document = RawCopier{e.FullDocument, fields}
+	}
+	return event
+}
+
+// ChangeStream implements repository.EventStream with a change stream.
+type ChangeStream struct {
+	stream *mongo.ChangeStream
+	fields *encryption.Fields
+	event  repository.Event
+	err    error
+}
+
+func (s *ChangeStream) Next(ctx context.Context) bool {
+	if s.err != nil || !s.stream.Next(ctx) {
+		return false
+	}
+
+	var change changeEvent
+	s.err = s.stream.Decode(&change)
+	if s.err != nil {
+		return false
+	}
+
+	resumeToken, ok := s.stream.ResumeToken().Lookup("_data").StringValueOK()
+	if !ok {
+		s.err = errors.New("change stream event has no resume token")
+		return false
+	}
+
+	s.event = change.event(resumeToken, s.fields)
+	return true
+}
+
+func (s *ChangeStream) Event() repository.Event {
+	return s.event
+}
+
+func (s *ChangeStream) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.stream.Err()
+}
+
+func (s *ChangeStream) Close(ctx context.Context) error {
+	return s.stream.Close(ctx)
+}
+
+// pollBatchSize is the number of documents a poll fetches at most.
+const pollBatchSize = 100
+
+// pollQuery orders the documents by the time they were last written.
+var pollQuery = repository.Query{Sort: []repository.Sort{{Key: updatedAtKey}}, Limit: pollBatchSize}
+
+// Poller implements repository.Watcher for deployments without a replica set
+// by polling a collection with Timestamps for the documents written since the
+// last poll. It sees the documents as they are at each poll, so the writes to
+// a document between two polls are reported as a single event. Only the soft
+// deletes of a collection with SoftDelete are seen. The resume tokens are the
+// cursors of the documents in the order of their updatedAt.
+//
+// The updatedAt of a write is the time of the application before the write
+// commits, so a poll only fetches the documents written more than lag ago, to
+// not move past the writes still in flight. A write is skipped when it commits
+// more than lag after it was stamped, such as within a long transaction or on
+// a replica whose clock is behind by more than lag.
+type Poller struct {
+	collection Collection
+	interval   time.Duration
+	lag        time.Duration
+}
+
+func NewPoller(collection Collection, interval time.Duration, lag time.Duration) (Poller, error) {
+	if !collection.Timestamps {
+		return Poller{}, errors.New("polling requires a collection with timestamps")
+	}
+	return Poller{collection: collection, interval: interval, lag: lag}, nil
+}
+
+func (p Poller) Watch(ctx context.Context, resumeToken string) (repository.EventStream, error) {
+	if resumeToken == "" {
+		var err error
+		resumeToken, err = newCursor(time.Now().Add(-p.lag), bsonprimitive.NilObjectID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err := p.query(resumeToken, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &pollStream{poller: p, resumeToken: resumeToken}, nil
+}
+
+// query returns the query of the documents written after the resume token,
+// and more than lag before now.
+func (p Poller) query(resumeToken string, now time.Time) (repository.Query, error) {
+	query, err := pollQuery.After(resumeToken, fromCursorValue)
+	if err != nil {
+		return repository.Query{}, err
+	}
+	query.Filters = append(query.Filters, repository.Filter{Key: updatedAtKey, Operator: repository.Lte, Value: now.Add(-p.lag)})
+	return query, nil
+}
+
+// polledEvent translates a polled document. The timestamps of a created
+// document are equal until it is written again.
+func polledEvent(doc bson.Raw, resumeToken string, fields *encryption.Fields) (repository.Event, error) {
+	var stamps struct {
+		Id        primitive.Id `bson:"_id"`
+		CreatedAt *time.Time   `bson:"createdAt"`
+		UpdatedAt *time.Time   `bson:"updatedAt"`
+		DeletedAt *time.Time   `bson:"deletedAt"`
+	}
+	err := bson.Unmarshal(doc, &stamps)
+	if err != nil {
+		return repository.Event{}, err
+	}
+
+	event := repository.Event{Type: repository.UpdateEvent, Id: stamps.Id, ResumeToken: resumeToken}
+	switch {
+	case stamps.DeletedAt != nil:
+		event.Type = repository.DeleteEvent
+		return event, nil
+	case stamps.CreatedAt != nil && stamps.UpdatedAt != nil && stamps.CreatedAt.Equal(*stamps.UpdatedAt):
+		event.Type = repository.InsertEvent
+	}
+	event.Document = RawCopier{doc, fields}
+	return event, nil
+}
+
+type pollStream struct {
+	poller      Poller
+	resumeToken string
+	batch       []bson.Raw
+	event       repository.Event
+	err         error
+}
+
+func (s *pollStream) Next(ctx context.Context) bool {
+	for len(s.batch) == 0 {
+		if s.err != nil {
+			return false
+		}
+
+		s.err = s.poll(ctx)
+		if s.err != nil {
+			return false
+		}
+		if len(s.batch) > 0 {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			s.err = ctx.Err()
+			return false
+		case <-time.After(s.poller.interval):
+		}
+	}
+
+	doc := s.batch[0]
+	s.batch = s.batch[1:]
+
+	s.resumeToken, s.err = cursorOf(doc, pollQuery)
+	if s.err != nil {
+		return false
+	}
+	s.event, s.err = polledEvent(doc, s.resumeToken, s.poller.collection.Encryption)
+	return s.err == nil
+}
+
+// poll fetches the documents written after the resume token and more than
+// lag ago, including the soft deleted ones.
+func (s *pollStream) poll(ctx context.Context) error {
+	query, err := s.poller.query(s.resumeToken, time.Now())
+	if err != nil {
+		return err
+	}
+
+	c := s.poller.collection
+	ctx = WithDeleted(ctx)
+	cursor, err := c.Find(ctx, c.match(ctx, query.Filters), findOptions(query))
+	if err != nil {
+		return err
+	}
+	return cursor.All(ctx, &s.batch)
+}
+
+func (s *pollStream) Event() repository.Event {
+	return s.event
+}
+
+func (s *pollStream) Err() error {
+	return s.err
+}
+
+func (s *pollStream) Close(ctx context.Context) error {
+	return nil
+}
+
+const ResumeTokenCollectionName = "resume_tokens"
+
+// ResumeTokens keeps the resume tokens in the resume_tokens collection, with
+// the name of the consumer as the ID of its document.
+type ResumeTokens struct {
+	collection *mongo.Collection
+}
+
+func NewResumeTokens(client *Client) (ResumeTokens, error) {
+	conf, err := config.Get()
+	if err != nil {
+		return ResumeTokens{}, err
+	}
+	return ResumeTokens{client.Database(conf.MongoDatabasebName).Collection(ResumeTokenCollectionName)}, nil
+}
+
+func (r ResumeTokens) ResumeToken(ctx context.Context, consumer string) (string, error) {
+	var saved struct {
+		Token string `bson:"token"`
+	}
+	err := r.collection.FindOne(ctx, bson.D{{Key: "_id", Value: consumer}}).Decode(&saved)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", nil
+	}
+	return saved.Token, err
+}
+
+func (r ResumeTokens) SaveResumeToken(ctx context.Context, consumer string, token string) error {
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "token", Value: token}, {Key: updatedAtKey, Value: time.Now()}}}}
+	_, err := r.collection.UpdateOne(ctx, bson.D{{Key: "_id", Value: consumer}}, update, options.Update().SetUpsert(true))
+	return err
+}
@@ -0,0 +1,83 @@
+package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dannypaul/go-skeleton/internal/repository"
+
+	"go.mongodb.org/mongo-driver/bson"
+	bsonprimitive "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPolledEvent(t *testing.T) {
+	created := time.Now()
+	updated := created.Add(time.Second)
+
+	tests := []struct {
+		name string
+		doc  bson.D
+		want repository.EventType
+	}{
+		{name: "insert", doc: bson.D{{Key: createdAtKey, Value: created}, {Key: updatedAtKey, Value: created}}, want: repository.InsertEvent},
+		{name: "update", doc: bson.D{{Key: createdAtKey, Value: created}, {Key: updatedAtKey, Value: updated}}, want: repository.UpdateEvent},
+		{name: "delete", doc: bson.D{{Key: createdAtKey, Value: created}, {Key: updatedAtKey, Value: updated}, {Key: deletedAtKey, Value: updated}}, want: repository.DeleteEvent},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			id := bsonprimitive.NewObjectID()
+			doc, err := bson.Marshal(append(bson.D{{Key: "_id", Value: id}}, test.doc...))
+			if err != nil {
+				t.Fatalf("Could not marshal the document, got: %v", err)
+			}
+
+			event, err := polledEvent(doc, "token", nil)
+			if err != nil {
+				t.Fatalf("Could not translate the document, got: %v", err)
+			}
+			if event.Type != test.want || event.Id.String() != id.Hex() || event.ResumeToken != "token" {
+				t.Errorf("Event was incorrect, got: %+v", event)
+			}
+			if (event.Document == nil) != (test.want == repository.DeleteEvent) {
+				t.Errorf("Event document was incorrect, got: %+v", event.Document)
+			}
+		})
+	}
+}
+
+func TestPollerResumeToken(t *testing.T) {
+	poller, err := NewPoller(Collection{Timestamps: true}, time.Second, 10*time.Second)
+	if err != nil {
+		t.Fatalf("Could not create the poller, got: %v", err)
+	}
+
+	if _, err = poller.Watch(context.Background(), "not-a-cursor"); err == nil {
+		t.Errorf("Invalid resume token was accepted")
+	}
+
+	if _, err = NewPoller(Collection{}, time.Second, 10*time.Second); err == nil {
+		t.Errorf("Poller was created for a collection without timestamps")
+	}
+}
+
+func TestPollerLag(t *testing.T) {
+	poller, err := NewPoller(Collection{Timestamps: true}, time.Second, 10*time.Second)
+	if err != nil {
+		t.Fatalf("Could not create the poller, got: %v", err)
+	}
+	resumeToken, err := newCursor(time.Unix(0, 0), bsonprimitive.NilObjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	query, err := poller.query(resumeToken, now)
+	if err != nil {
+		t.Fatalf("Could not build the poll query, got: %v", err)
+	}
+	last := query.Filters[len(query.Filters)-1]
+	if last.Key != updatedAtKey || last.Operator != repository.Lte || !last.Value.(time.Time).Equal(now.Add(-10*time.Second)) {
+		t.Errorf("Poll query did not lag behind now, got: %+v", last)
+	}
+}
@@ -0,0 +1,145 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/dannypaul/go-skeleton/internal/driver/document"
+	"github.com/dannypaul/go-skeleton/internal/exception"
+	"github.com/dannypaul/go-skeleton/internal/primitive"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+)
+
+var (
+	_ repository.BulkWriter  = Collection{}
+	_ repository.ManyUpdater = Collection{}
+	_ repository.Upserter    = Collection{}
+)
+
+func (c Collection) CreateMany(ctx context.Context, models []interface{}, ordered bool) ([]repository.WriteResult, error) {
+	return c.BulkWrite(ctx, repository.Inserts(models), ordered)
+}
+
+// BulkWrite makes the writes in a single transaction, in which each write has
+// a savepoint of its own so that a failed write is undone alone.
+func (c Collection) BulkWrite(ctx context.Context, writes []repository.Write, ordered bool) ([]repository.WriteResult, error) {
+	var results []repository.WriteResult
+	err := c.write(ctx, func(tx *sql.Tx) error {
+		ctx := context.WithValue(ctx, txKey{}, tx)
+
+		var err error
+		results, err = document.BulkWrite(writes, ordered, func(w repository.Write) (primitive.Id, error) {
+			return c.writeOne(ctx, w)
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// writeOne makes a write of a bulk write, and returns the ID of the document
+// it created.
+func (c Collection) writeOne(ctx context.Context, w repository.Write) (primitive.Id, error) {
+	switch w.Op {
+	case repository.InsertOp:
+		copier, err := c.Create(ctx, w.Document)
+		if err != nil {
+			return "", err
+		}
+		return document.Id(copier.(Copier).doc), nil
+	case repository.UpdateOp:
+		err := c.update(ctx, w.Filters, applyAll(w.Patches))
+		if errors.Is(err, exception.ErrNotFound) {
+			return "", nil
+		}
+		return "", err
+	case repository.UpsertOp:
+		doc, created, err := c.upsert(ctx, w.Filters, w.Document)
+		if err != nil || !created {
+			return "", err
+		}
+		return document.Id(doc), nil
+	default:
+		return "", c.write(ctx, func(tx *sql.Tx) error {
+			rows, err := c.scan(ctx, tx, w.Filters, insertionOrder, 1)
+			if err != nil || len(rows) == 0 {
+				return err
+			}
+			_, err = c.deleteRow(ctx, tx, rows[0].id)
+			return err
+		})
+	}
+}
+
+func (c Collection) UpdateMany(ctx context.Context, filters []repository.Filter, patches []repository.Patch) (int64, error) {
+	var matched int64
+	err := c.write(ctx, func(tx *sql.Tx) error {
+		rows, err := c.scan(ctx, tx, filters, insertionOrder, 0)
+		if err != nil {
+			return err
+		}
+
+		for _, r := range rows {
+			err = applyAll(patches)(r.doc)
+			if err != nil {
+				return err
+			}
+			err = c.store(ctx, tx, r)
+			if err != nil {
+				return err
+			}
+		}
+		matched = int64(len(rows))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return matched, nil
+}
+
+func (c Collection) Upsert(ctx context.Context, filters []repository.Filter, model interface{}) (repository.Copier, error) {
+	doc, _, err := c.upsert(ctx, filters, model)
+	if err != nil {
+		return nil, err
+	}
+	return Copier{doc}, nil
+}
+
+// upsert replaces the first document matching the filters with the model, or
+// creates it, and returns the document written and whether it was created.
+func (c Collection) upsert(ctx context.Context, filters []repository.Filter, model interface{}) (document.Document, bool, error) {
+	doc, err := document.FromModel(model)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var written document.Document
+	var created bool
+	err = c.write(ctx, func(tx *sql.Tx) error {
+		ctx := context.WithValue(ctx, txKey{}, tx)
+
+		err := c.update(ctx, filters, replaceWith(document.Clone(doc).(document.Document), &written))
+		if !errors.Is(err, exception.ErrNotFound) {
+			return err
+		}
+
+		upserted, err := document.Upserted(filters, doc)
+		if err != nil {
+			return err
+		}
+		copier, err := c.Create(ctx, upserted)
+		if err != nil {
+			return err
+		}
+		written, created = copier.(Copier).doc, true
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return written, created, nil
+}
@@ -0,0 +1,714 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/dannypaul/go-skeleton/internal/driver/document"
+	"github.com/dannypaul/go-skeleton/internal/exception"
+	"github.com/dannypaul/go-skeleton/internal/primitive"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+
+	"github.com/mattn/go-sqlite3"
+	"go.mongodb.org/mongo-driver/bson"
+	bsonprimitive "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Index is kept in the keys table of a collection, which holds an entry for
+// every value of the dotted paths in Keys. Filters on the single key of an
+// index are looked up in the keys table instead of scanning the collection,
+// and the entries of unique indexes are unique in the keys table. Like the
+// sparse indexes of the migrations, documents without any of the keys are not
+// indexed. When Partial is set, only the documents matching its filters are
+// indexed.
+type Index struct {
+	Name    string
+	Keys    []string
+	Unique  bool
+	Partial []repository.Filter
+}
+
+// Collection implements the repository interfaces with the semantics of the
+// mongo driver on a SQLite table holding the documents as JSON. The table and
+// its keys table are created by the SQLite migrations.
+type Collection struct {
+	db      *sql.DB
+	name    string
+	indexes []Index
+}
+
+var (
+	_ repository.Adder           = Collection{}
+	_ repository.Counter         = Collection{}
+	_ repository.Creator         = Collection{}
+	_ repository.Deleter         = Collection{}
+	_ repository.Finder          = Collection{}
+	_ repository.Incrementer     = Collection{}
+	_ repository.Lister          = Collection{}
+	_ repository.Paginator       = Collection{}
+	_ repository.Patcher         = Collection{}
+	_ repository.Querier         = Collection{}
+	_ repository.Streamer        = Collection{}
+	_ repository.Replacer        = Collection{}
+	_ repository.Setter          = Collection{}
+	_ repository.VersionedWriter = Collection{}
+)
+
+func NewCollection(client *Client, name string, indexes ...Index) Collection {
+	return Collection{db: client.DB, name: name, indexes: indexes}
+}
+
+// The orders in which documents are scanned
+const (
+	insertionOrder = "rowid"
+	idOrder        = "id"
+)
+
+// streamBatchSize is the number of documents a stream reads at a time.
+const streamBatchSize = 100
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+type row struct {
+	id  string
+	doc document.Document
+}
+
+func (c Collection) table() string {
+	return `"` + c.name + `"`
+}
+
+func (c Collection) keysTable() string {
+	return `"` + c.name + `_keys"`
+}
+
+// key returns the form in which a value is stored in the id and key columns.
+// Numbers are stored as doubles and the fields of documents are sorted so
+// that the values which are equal in MongoDB have the same key.
+func key(value interface{}) (string, error) {
+	data, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: canonical(value)}}, true, false)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(string(data), `{"v":`), "}"), nil
+}
+
+func canonical(value interface{}) interface{} {
+	if n, ok := document.Number(value); ok {
+		return n
+	}
+
+	switch v := value.(type) {
+	case document.Document:
+		fields := make([]string, 0, len(v))
+		for field := range v {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+
+		doc := bson.D{}
+		for _, field := range fields {
+			doc = append(doc, bson.E{Key: field, Value: canonical(v[field])})
+		}
+		return doc
+	case []interface{}:
+		array := bson.A{}
+		for _, e := range v {
+			array = append(array, canonical(e))
+		}
+		return array
+	}
+	return value
+}
+
+// where narrows a query on the filters down to the documents which can match
+// them, using the id column and the keys of the indexes. The documents found
+// still have to be matched against the filters.
+func (c Collection) where(filters []repository.Filter) (string, []interface{}, error) {
+	conditions, args, err := c.conditions(filters)
+	if err != nil || len(conditions) == 0 {
+		return "", nil, err
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args, nil
+}
+
+func (c Collection) conditions(filters []repository.Filter) ([]string, []interface{}, error) {
+	var conditions []string
+	var args []interface{}
+	for _, f := range filters {
+		// The filters of And and of a single alternative all have to match
+		nested := f.And
+		if len(f.Or) == 1 {
+			nested = f.Or
+		}
+		if len(nested) > 0 {
+			nestedConditions, nestedArgs, err := c.conditions(nested)
+			if err != nil {
+				return nil, nil, err
+			}
+			conditions = append(conditions, nestedConditions...)
+			args = append(args, nestedArgs...)
+			continue
+		}
+		if len(f.Or) > 0 {
+			continue
+		}
+
+		value, err := document.Value(f.Value)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// ObjectIDs are ordered like their keys, which are the same but for
+		// the hexadecimal digits
+		if _, ok := value.(bsonprimitive.ObjectID); ok && f.Key == "_id" && (f.Operator == repository.Gt || f.Operator == repository.Lt) {
+			k, err := key(value)
+			if err != nil {
+				return nil, nil, err
+			}
+			operator := ">"
+			if f.Operator == repository.Lt {
+				operator = "<"
+			}
+			conditions = append(conditions, "id "+operator+" ?")
+			args = append(args, k)
+			continue
+		}
+
+		if f.Operator != "" && f.Operator != repository.Eq {
+			continue
+		}
+
+		// nil matches missing fields and arrays and documents match as a
+		// whole, neither of which have entries in the keys table
+		switch value.(type) {
+		case nil, document.Document, []interface{}:
+			continue
+		}
+
+		k, err := key(value)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if f.Key == "_id" {
+			conditions = append(conditions, "id = ?")
+			args = append(args, k)
+			continue
+		}
+
+		for _, index := range c.indexes {
+			if len(index.Keys) == 1 && index.Keys[0] == f.Key && len(index.Partial) == 0 {
+				conditions = append(conditions, "id IN (SELECT id FROM "+c.keysTable()+" WHERE name = ? AND key = ?)")
+				args = append(args, index.Name, k)
+				break
+			}
+		}
+	}
+	return conditions, args, nil
+}
+
+// scan returns the documents matching the filters ordered by the column,
+// at most limit of them unless limit is 0.
+func (c Collection) scan(ctx context.Context, q querier, filters []repository.Filter, order string, limit int) ([]row, error) {
+	where, args, err := c.where(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.QueryContext(ctx, "SELECT id, doc FROM "+c.table()+where+" ORDER BY "+order, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var matched []row
+	for rows.Next() {
+		var r row
+		var data []byte
+		err = rows.Scan(&r.id, &data)
+		if err != nil {
+			return nil, err
+		}
+
+		r.doc, err = document.FromJSON(data)
+		if err != nil {
+			return nil, err
+		}
+
+		ok, err := document.Matches(r.doc, filters)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matched = append(matched, r)
+			if len(matched) == limit {
+				break
+			}
+		}
+	}
+	return matched, rows.Err()
+}
+
+func byId(id primitive.Id) []repository.Filter {
+	return []repository.Filter{{Key: "_id", Value: id}}
+}
+
+// conflict converts the violations of the primary key and of the unique
+// indexes to exception.ErrConflict.
+func conflict(err error) error {
+	var e sqlite3.Error
+	if errors.As(err, &e) && (e.ExtendedCode == sqlite3.ErrConstraintUnique || e.ExtendedCode == sqlite3.ErrConstraintPrimaryKey) {
+		return exception.ErrConflict
+	}
+	return err
+}
+
+// writeKeys replaces the entries of the document in the keys table.
+func (c Collection) writeKeys(ctx context.Context, tx *sql.Tx, id string, doc document.Document) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM "+c.keysTable()+" WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	for _, index := range c.indexes {
+		ok, err := document.Matches(doc, index.Partial)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		// A document can repeat a value of a unique index, as in MongoDB
+		written := map[string]bool{}
+		for _, entry := range document.IndexEntries(doc, index.Keys) {
+			// Single key entries are stored as the value itself so that
+			// filters can look them up
+			var value interface{} = entry
+			if len(entry) == 1 {
+				value = entry[0]
+			}
+			k, err := key(value)
+			if err != nil {
+				return err
+			}
+			if written[k] {
+				continue
+			}
+			written[k] = true
+
+			_, err = tx.ExecContext(ctx, "INSERT INTO "+c.keysTable()+" (id, name, key, is_unique) VALUES (?, ?, ?, ?)", id, index.Name, k, index.Unique)
+			if err != nil {
+				return conflict(err)
+			}
+		}
+	}
+	return nil
+}
+
+// querier returns the transaction of ctx, or the database outside of one.
+func (c Collection) querier(ctx context.Context) querier {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return tx
+	}
+	return c.db
+}
+
+// write runs fn in a transaction of its own, or in a savepoint of the
+// transaction of ctx so that a failed write leaves no partial changes in it.
+func (c Collection) write(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		_, err := tx.ExecContext(ctx, "SAVEPOINT collection_write")
+		if err != nil {
+			return err
+		}
+
+		err = fn(tx)
+		if err != nil {
+			tx.ExecContext(ctx, "ROLLBACK TO collection_write")
+		}
+		tx.ExecContext(ctx, "RELEASE collection_write")
+		return err
+	}
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// update applies the update to the first document matching the filters and
+// stores it when it does not violate the unique indexes.
+func (c Collection) update(ctx context.Context, filters []repository.Filter, update func(doc document.Document) error) error {
+	return c.write(ctx, func(tx *sql.Tx) error {
+		rows, err := c.scan(ctx, tx, filters, insertionOrder, 1)
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return exception.ErrNotFound
+		}
+
+		r := rows[0]
+		err = update(r.doc)
+		if err != nil {
+			return err
+		}
+		return c.store(ctx, tx, r)
+	})
+}
+
+// store writes the updated document of the row and its index entries.
+func (c Collection) store(ctx context.Context, tx *sql.Tx, r row) error {
+	data, err := document.ToJSON(r.doc)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE "+c.table()+" SET doc = ? WHERE id = ?", string(data), r.id)
+	if err != nil {
+		return err
+	}
+
+	return c.writeKeys(ctx, tx, r.id, r.doc)
+}
+
+func setAll(keyValues []repository.KeyValue) func(doc document.Document) error {
+	return func(doc document.Document) error {
+		return document.SetAll(doc, keyValues)
+	}
+}
+
+func (c Collection) Set(ctx context.Context, filters []repository.Filter, Key string, value interface{}) error {
+	return c.update(ctx, filters, setAll([]repository.KeyValue{{Key: Key, Value: value}}))
+}
+
+func (c Collection) SetAll(ctx context.Context, filters []repository.Filter, keyValues []repository.KeyValue) error {
+	return c.update(ctx, filters, setAll(keyValues))
+}
+
+func (c Collection) SetById(ctx context.Context, id primitive.Id, Key string, Value interface{}) error {
+	if id.IsValid() == false {
+		return exception.ErrIdInvalid
+	}
+	return c.update(ctx, byId(id), setAll([]repository.KeyValue{{Key: Key, Value: Value}}))
+}
+
+func (c Collection) SetAllById(ctx context.Context, id primitive.Id, keyValues []repository.KeyValue) error {
+	if id.IsValid() == false {
+		return exception.ErrIdInvalid
+	}
+	return c.update(ctx, byId(id), setAll(keyValues))
+}
+
+func (c Collection) UnSet(ctx context.Context, filters []repository.Filter, Key string) error {
+	return c.update(ctx, filters, func(doc document.Document) error {
+		document.Unset(doc, document.Split(Key))
+		return nil
+	})
+}
+
+func (c Collection) Patch(ctx context.Context, id primitive.Id, patches []repository.Patch) error {
+	if id.IsValid() == false {
+		return exception.ErrIdInvalid
+	}
+
+	return c.update(ctx, byId(id), applyAll(patches))
+}
+
+func applyAll(patches []repository.Patch) func(doc document.Document) error {
+	return func(doc document.Document) error {
+		for _, p := range patches {
+			err := document.Apply(doc, p)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func (c Collection) IncrementById(ctx context.Context, id primitive.Id, Key string, incrementBy int) error {
+	return c.Patch(ctx, id, []repository.Patch{{Action: "$inc", Key: Key, Value: incrementBy}})
+}
+
+func (c Collection) Count(ctx context.Context, filters []repository.Filter) (int64, error) {
+	rows, err := c.scan(ctx, c.querier(ctx), filters, insertionOrder, 0)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(rows)), nil
+}
+
+func (c Collection) Create(ctx context.Context, model interface{}) (repository.Copier, error) {
+	doc, err := document.FromModel(model)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = bsonprimitive.NewObjectID()
+	}
+
+	id, err := key(doc["_id"])
+	if err != nil {
+		return nil, err
+	}
+	data, err := document.ToJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.write(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "INSERT INTO "+c.table()+" (id, doc) VALUES (?, ?)", id, string(data))
+		if err != nil {
+			return conflict(err)
+		}
+		return c.writeKeys(ctx, tx, id, doc)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return Copier{doc}, nil
+}
+
+func (c Collection) FindById(ctx context.Context, id primitive.Id) (repository.Copier, error) {
+	if id.IsValid() == false {
+		return nil, exception.ErrIdInvalid
+	}
+	return c.FindSingle(ctx, byId(id))
+}
+
+func (c Collection) Delete(ctx context.Context, id primitive.Id) (int64, error) {
+	if id.IsValid() == false {
+		return 0, exception.ErrIdInvalid
+	}
+
+	value, err := document.Value(id)
+	if err != nil {
+		return 0, err
+	}
+	k, err := key(value)
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	err = c.write(ctx, func(tx *sql.Tx) error {
+		deleted, err = c.deleteRow(ctx, tx, k)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// deleteRow deletes the document with the key and its index entries, and
+// returns the number of documents deleted.
+func (c Collection) deleteRow(ctx context.Context, tx *sql.Tx, k string) (int64, error) {
+	_, err := tx.ExecContext(ctx, "DELETE FROM "+c.keysTable()+" WHERE id = ?", k)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM "+c.table()+" WHERE id = ?", k)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (c Collection) Add(ctx context.Context, filters []repository.Filter, key string) (float64, error) {
+	rows, err := c.scan(ctx, c.querier(ctx), filters, insertionOrder, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	var total float64
+	for _, r := range rows {
+		// $sum ignores the values which are not numbers
+		if value, found := document.Get(r.doc, document.Split(key)); found {
+			if n, isNumber := document.Number(value); isNumber {
+				total += n
+			}
+		}
+	}
+	return total, nil
+}
+
+func (c Collection) FindSingle(ctx context.Context, filters []repository.Filter) (repository.Copier, error) {
+	rows, err := c.scan(ctx, c.querier(ctx), filters, insertionOrder, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, exception.ErrNotFound
+	}
+	return Copier{rows[0].doc}, nil
+}
+
+func (c Collection) FindAll(ctx context.Context, filters []repository.Filter) (repository.ListCopier, error) {
+	rows, err := c.scan(ctx, c.querier(ctx), filters, insertionOrder, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make([]document.Document, len(rows))
+	for i, r := range rows {
+		docs[i] = r.doc
+	}
+	return ListCopier{docs}, nil
+}
+
+func (c Collection) Query(ctx context.Context, query repository.Query) (repository.ListCopier, error) {
+	rows, err := c.scan(ctx, c.querier(ctx), query.Filters, insertionOrder, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make([]document.Document, len(rows))
+	for i, r := range rows {
+		docs[i] = r.doc
+	}
+	return ListCopier{document.Arrange(docs, query)}, nil
+}
+
+func (c Collection) page(ctx context.Context, query repository.Query, cursor string) ([]document.Document, string, error) {
+	query, err := query.After(cursor, document.FromCursorValue)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Without sort keys the pages are in the order of the id column, so only
+	// the documents up to the first one of the next page have to be read
+	order, limit := insertionOrder, 0
+	if len(query.Sort) == 0 && query.Limit > 0 {
+		order, limit = idOrder, int(query.Skip+query.Limit)+1
+	}
+
+	rows, err := c.scan(ctx, c.querier(ctx), query.Filters, order, limit)
+	if err != nil {
+		return nil, "", err
+	}
+
+	docs := make([]document.Document, len(rows))
+	for i, r := range rows {
+		docs[i] = r.doc
+	}
+	return document.Page(docs, query)
+}
+
+func (c Collection) Paginate(ctx context.Context, query repository.Query, cursor string) (repository.ListCopier, string, error) {
+	docs, next, err := c.page(ctx, query, cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	return ListCopier{docs}, next, nil
+}
+
+// Stream reads the documents a batch at a time so that no read transaction is
+// held open while they are consumed.
+func (c Collection) Stream(ctx context.Context, query repository.Query) (repository.Iterator, error) {
+	return document.NewIterator(query, streamBatchSize, c.page), nil
+}
+
+func (c Collection) Replace(ctx context.Context, id primitive.Id, value interface{}) (repository.Copier, error) {
+	if id.IsValid() == false {
+		return nil, exception.ErrIdInvalid
+	}
+
+	doc, err := document.FromModel(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var replaced document.Document
+	err = c.update(ctx, byId(id), replaceWith(doc, &replaced))
+	if err != nil {
+		return nil, err
+	}
+	return Copier{replaced}, nil
+}
+
+// replaceWith replaces the contents of a document with doc, keeping its ID,
+// and copies the result to replaced.
+func replaceWith(doc document.Document, replaced *document.Document) func(current document.Document) error {
+	return func(current document.Document) error {
+		doc["_id"] = current["_id"]
+		for key := range current {
+			delete(current, key)
+		}
+		for key, v := range doc {
+			current[key] = v
+		}
+		*replaced = current
+		return nil
+	}
+}
+
+func byVersion(id primitive.Id, version int) []repository.Filter {
+	return []repository.Filter{{Key: "_id", Value: id}, {Key: repository.VersionKey, Value: version}}
+}
+
+// updateIfVersion applies the update to the document with the ID when it is
+// at the version, and increments the version.
+func (c Collection) updateIfVersion(ctx context.Context, id primitive.Id, version int, update func(doc document.Document) error) error {
+	if id.IsValid() == false {
+		return exception.ErrIdInvalid
+	}
+
+	err := c.update(ctx, byVersion(id, version), func(doc document.Document) error {
+		err := update(doc)
+		if err != nil {
+			return err
+		}
+		return document.SetAll(doc, []repository.KeyValue{{Key: repository.VersionKey, Value: version + 1}})
+	})
+	if errors.Is(err, exception.ErrNotFound) {
+		if _, findErr := c.FindById(ctx, id); findErr == nil {
+			return exception.ErrVersionConflict
+		}
+	}
+	return err
+}
+
+func (c Collection) PatchIfVersion(ctx context.Context, id primitive.Id, version int, patches []repository.Patch) error {
+	return c.updateIfVersion(ctx, id, version, applyAll(patches))
+}
+
+func (c Collection) SetAllByIdIfVersion(ctx context.Context, id primitive.Id, version int, keyValues []repository.KeyValue) error {
+	return c.updateIfVersion(ctx, id, version, setAll(keyValues))
+}
+
+func (c Collection) ReplaceIfVersion(ctx context.Context, id primitive.Id, version int, value interface{}) (repository.Copier, error) {
+	doc, err := document.FromModel(value)
+	if err != nil {
+		return nil, err
+	}
+
+	// The version is set before the replacement, which copies the document
+	err = document.SetAll(doc, []repository.KeyValue{{Key: repository.VersionKey, Value: version + 1}})
+	if err != nil {
+		return nil, err
+	}
+
+	var replaced document.Document
+	err = c.updateIfVersion(ctx, id, version, replaceWith(doc, &replaced))
+	if err != nil {
+		return nil, err
+	}
+	return Copier{replaced}, nil
+}
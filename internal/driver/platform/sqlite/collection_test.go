@@ -0,0 +1,275 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/dannypaul/go-skeleton/internal/exception"
+	"github.com/dannypaul/go-skeleton/internal/primitive"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+)
+
+type identity struct {
+	EmailId string `bson:"emailId,omitempty"`
+}
+
+type user struct {
+	Id         primitive.Id `bson:"_id,omitempty"`
+	Name       string       `bson:"name"`
+	Version    int          `bson:"version"`
+	Identities []identity   `bson:"identities"`
+	Tags       []string     `bson:"tags,omitempty"`
+}
+
+// openTestClient opens a new database with the tables of the migrations.
+func openTestClient(t *testing.T) *Client {
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db")+"?_txlock=immediate")
+	if err != nil {
+		t.Fatalf("Could not open the database, got: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migration, err := ioutil.ReadFile("../../../migration/sqlite/1_collections.up.sql")
+	if err != nil {
+		t.Fatalf("Could not read the migration, got: %v", err)
+	}
+	if _, err = db.Exec(string(migration)); err != nil {
+		t.Fatalf("Could not run the migration, got: %v", err)
+	}
+	return &Client{db}
+}
+
+func TestCollection(t *testing.T) {
+	ctx := context.Background()
+	users := NewCollection(openTestClient(t), "users",
+		Index{Name: "identities_emailId_asc", Keys: []string{"identities.emailId"}, Unique: true},
+		Index{Name: "tags_asc", Keys: []string{"tags"}},
+	)
+
+	copier, err := users.Create(ctx, user{Name: "Ada", Identities: []identity{{EmailId: "ada@example.com"}}})
+	if err != nil {
+		t.Fatalf("Could not create the user, got: %v", err)
+	}
+	var ada user
+	if err = copier.Copy(&ada); err != nil || !ada.Id.IsValid() {
+		t.Fatalf("Created user was not copied with an ID, got: %+v, %v", ada, err)
+	}
+
+	_, err = users.Create(ctx, user{Name: "Eve", Identities: []identity{{EmailId: "ada@example.com"}}})
+	if !errors.Is(err, exception.ErrConflict) {
+		t.Errorf("Duplicate email ID was not a conflict, got: %v", err)
+	}
+
+	_, err = users.Create(ctx, ada)
+	if !errors.Is(err, exception.ErrConflict) {
+		t.Errorf("Duplicate ID was not a conflict, got: %v", err)
+	}
+
+	// Documents without the indexed field are not indexed
+	for _, name := range []string{"Bob", "Eve"} {
+		if _, err = users.Create(ctx, user{Name: name}); err != nil {
+			t.Errorf("Could not create a user without identities, got: %v", err)
+		}
+	}
+
+	_, err = users.FindSingle(ctx, []repository.Filter{{Key: "identities.emailId", Value: "ada@example.com"}})
+	if err != nil {
+		t.Errorf("Could not find the user by an indexed path, got: %v", err)
+	}
+
+	_, err = users.FindSingle(ctx, []repository.Filter{{Key: "name", Value: "Mallory"}})
+	if !errors.Is(err, exception.ErrNotFound) {
+		t.Errorf("Missing user was found, got: %v", err)
+	}
+
+	err = users.Patch(ctx, ada.Id, []repository.Patch{
+		{Action: "$set", Key: "identities.0.emailId", Value: "lovelace@example.com"},
+		{Action: "$inc", Key: "version", Value: 1},
+		{Action: "$addToSet", Key: "tags", Value: "admin"},
+		{Action: "$addToSet", Key: "tags", Value: "admin"},
+	})
+	if err != nil {
+		t.Fatalf("Could not patch the user, got: %v", err)
+	}
+
+	copier, err = users.FindById(ctx, ada.Id)
+	if err != nil {
+		t.Fatalf("Could not find the user by ID, got: %v", err)
+	}
+	var patched user
+	_ = copier.Copy(&patched)
+	if patched.Identities[0].EmailId != "lovelace@example.com" || patched.Version != 1 || len(patched.Tags) != 1 {
+		t.Errorf("User was not patched, got: %+v", patched)
+	}
+
+	// The keys of the previous email ID are removed by the patch
+	if _, err = users.Create(ctx, user{Name: "Eve", Identities: []identity{{EmailId: "ada@example.com"}}}); err != nil {
+		t.Errorf("Could not reuse the previous email ID, got: %v", err)
+	}
+
+	count, _ := users.Count(ctx, []repository.Filter{{Key: "tags", Value: "admin"}})
+	if count != 1 {
+		t.Errorf("Array element filter count was incorrect, got: %d, want: 1.", count)
+	}
+
+	total, _ := users.Add(ctx, nil, "version")
+	if total != 1 {
+		t.Errorf("Sum of the versions was incorrect, got: %v, want: 1.", total)
+	}
+
+	err = users.UnSet(ctx, []repository.Filter{{Key: "_id", Value: ada.Id}}, "tags")
+	if err != nil {
+		t.Errorf("Could not unset the tags, got: %v", err)
+	}
+	count, _ = users.Count(ctx, []repository.Filter{{Key: "tags", Value: nil}})
+	if count != 4 {
+		t.Errorf("Users without tags count was incorrect, got: %d, want: 4.", count)
+	}
+
+	listCopier, _ := users.FindAll(ctx, nil)
+	var all []user
+	if err = listCopier.CopyAll(ctx, &all); err != nil || len(all) != 4 || all[0].Name != "Ada" {
+		t.Errorf("Could not list the users in insertion order, got: %+v, %v", all, err)
+	}
+
+	err = users.SetById(ctx, primitive.NewObjectId(), "name", "Nobody")
+	if !errors.Is(err, exception.ErrNotFound) {
+		t.Errorf("Setting a missing user was not a not found error, got: %v", err)
+	}
+
+	deleted, _ := users.Delete(ctx, ada.Id)
+	if deleted != 1 {
+		t.Errorf("Deleted count was incorrect, got: %d, want: 1.", deleted)
+	}
+
+	// The keys of a deleted document are removed with it
+	if _, err = users.Create(ctx, user{Name: "Ada", Identities: []identity{{EmailId: "lovelace@example.com"}}}); err != nil {
+		t.Errorf("Could not reuse the email ID of a deleted user, got: %v", err)
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	ctx := context.Background()
+	users := NewCollection(openTestClient(t), "users")
+
+	for _, name := range []string{"Ada", "Bob", "Eve"} {
+		if _, err := users.Create(ctx, user{Name: name}); err != nil {
+			t.Fatalf("Could not create the user, got: %v", err)
+		}
+	}
+
+	listCopier, next, err := users.Paginate(ctx, repository.Query{Limit: 2}, "")
+	var page []user
+	if err == nil {
+		err = listCopier.CopyAll(ctx, &page)
+	}
+	if err != nil || len(page) != 2 || next == "" {
+		t.Fatalf("First page was incorrect, got: %+v, %q, %v", page, next, err)
+	}
+
+	listCopier, next, err = users.Paginate(ctx, repository.Query{Limit: 2}, next)
+	page = nil
+	if err == nil {
+		err = listCopier.CopyAll(ctx, &page)
+	}
+	if err != nil || len(page) != 1 || page[0].Name != "Eve" || next != "" {
+		t.Errorf("Last page was incorrect, got: %+v, %q, %v", page, next, err)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	ctx := context.Background()
+	client := openTestClient(t)
+	users := NewCollection(client, "users",
+		Index{Name: "identities_emailId_asc", Keys: []string{"identities.emailId"}, Unique: true},
+	)
+
+	failure := errors.New("failure")
+	err := client.WithTransaction(ctx, func(ctx context.Context) error {
+		if _, err := users.Create(ctx, user{Name: "Ada", Identities: []identity{{EmailId: "ada@example.com"}}}); err != nil {
+			return err
+		}
+		return failure
+	})
+	if err != failure {
+		t.Fatalf("Transaction did not return the error of the function, got: %v", err)
+	}
+	if count, err := users.Count(ctx, nil); err != nil || count != 0 {
+		t.Errorf("Failed transaction was not rolled back, got: %d, %v", count, err)
+	}
+
+	// A failed write leaves no partial changes in the transaction
+	err = client.WithTransaction(ctx, func(ctx context.Context) error {
+		if _, err := users.Create(ctx, user{Name: "Ada", Identities: []identity{{EmailId: "ada@example.com"}}}); err != nil {
+			return err
+		}
+		_, err := users.Create(ctx, user{Name: "Eve", Identities: []identity{{EmailId: "ada@example.com"}}})
+		if !errors.Is(err, exception.ErrConflict) {
+			t.Errorf("Duplicate email ID was not a conflict, got: %v", err)
+		}
+
+		count, err := users.Count(ctx, nil)
+		if err != nil || count != 1 {
+			t.Errorf("Transaction did not see its own writes, got: %d, %v", count, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Could not commit the transaction, got: %v", err)
+	}
+	if count, err := users.Count(ctx, nil); err != nil || count != 1 {
+		t.Errorf("Committed transaction was incorrect, got: %d, %v", count, err)
+	}
+}
+
+func TestBulkWrite(t *testing.T) {
+	ctx := context.Background()
+	users := NewCollection(openTestClient(t), "users",
+		Index{Name: "identities_emailId_asc", Keys: []string{"identities.emailId"}, Unique: true},
+	)
+
+	ada := user{Name: "Ada", Identities: []identity{{EmailId: "ada@example.com"}}}
+	results, err := users.CreateMany(ctx, []interface{}{ada, ada, user{Name: "Bob"}}, true)
+	if err != nil {
+		t.Fatalf("Could not create the users, got: %v", err)
+	}
+	if !results[0].Id.IsValid() || !errors.Is(results[1].Err, exception.ErrConflict) || !errors.Is(results[2].Err, exception.ErrWriteSkipped) {
+		t.Errorf("Ordered results were incorrect, got: %+v", results)
+	}
+
+	results, err = users.BulkWrite(ctx, []repository.Write{
+		{Op: repository.InsertOp, Document: ada},
+		{Op: repository.InsertOp, Document: user{Name: "Bob"}},
+		{Op: repository.UpdateOp, Filters: []repository.Filter{{Key: "name", Value: "Ada"}}, Patches: []repository.Patch{{Action: "$inc", Key: "version", Value: 1}}},
+		{Op: repository.UpsertOp, Filters: []repository.Filter{{Key: "name", Value: "Eve"}}, Document: user{Name: "Eve"}},
+	}, false)
+	if err != nil {
+		t.Fatalf("Could not write the users, got: %v", err)
+	}
+	if !errors.Is(results[0].Err, exception.ErrConflict) || results[1].Err != nil || results[2].Err != nil || !results[3].Id.IsValid() {
+		t.Errorf("Unordered results were incorrect, got: %+v", results)
+	}
+
+	count, err := users.Count(ctx, nil)
+	if err != nil || count != 3 {
+		t.Errorf("Failed writes were not undone alone, got: %d, %v", count, err)
+	}
+
+	matched, err := users.UpdateMany(ctx, []repository.Filter{{Key: "version", Value: 0}}, []repository.Patch{{Action: "$set", Key: "tags", Value: []string{"new"}}})
+	if err != nil || matched != 2 {
+		t.Errorf("Users were not updated, got: %d, %v", matched, err)
+	}
+
+	copier, err := users.Upsert(ctx, []repository.Filter{{Key: "name", Value: "Eve"}}, user{Name: "Eve", Version: 5})
+	if err != nil {
+		t.Fatalf("Could not upsert the user, got: %v", err)
+	}
+	var eve user
+	if err = copier.Copy(&eve); err != nil || eve.Version != 5 || eve.Id != results[3].Id {
+		t.Errorf("Upserted user did not replace the existing one, got: %+v, %v", eve, err)
+	}
+}
@@ -0,0 +1,43 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"reflect"
+
+	"github.com/dannypaul/go-skeleton/internal/driver/document"
+)
+
+type Copier struct {
+	doc document.Document
+}
+
+func (c Copier) Copy(destination interface{}) error {
+	return document.Decode(c.doc, destination)
+}
+
+type ListCopier struct {
+	docs []document.Document
+}
+
+// CopyAll decodes the documents into the slice destination points to,
+// replacing its elements like mongo.Cursor.All does.
+func (l ListCopier) CopyAll(ctx context.Context, destination interface{}) error {
+	slice := reflect.ValueOf(destination)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		return errors.New("destination must be a pointer to a slice")
+	}
+
+	elements := slice.Elem().Slice(0, 0)
+	for _, doc := range l.docs {
+		element := reflect.New(elements.Type().Elem())
+		err := Copier{doc}.Copy(element.Interface())
+		if err != nil {
+			return err
+		}
+		elements = reflect.Append(elements, element.Elem())
+	}
+
+	slice.Elem().Set(elements)
+	return nil
+}
@@ -0,0 +1,77 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/dannypaul/go-skeleton/internal/config"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/rs/zerolog/log"
+)
+
+type Client struct {
+	*sql.DB
+}
+
+var _ repository.Transactor = &Client{}
+
+// txKey is the context key of the transaction the collections take part in.
+type txKey struct{}
+
+// Open opens the SQLite database at SQLITE_PATH, creating it when it does not
+// exist. Writes take the database lock when their transaction begins so that
+// concurrent writers wait for each other instead of failing.
+func Open(ctx context.Context) *Client {
+	conf, _ := config.Get()
+	db, err := sql.Open("sqlite3", "file:"+conf.SqlitePath+"?_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate")
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error occurred while opening the SQLite database")
+	}
+
+	ctx, cancelPing := context.WithTimeout(ctx, 10*time.Second)
+	defer cancelPing()
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error occurred while trying to open the SQLite database")
+	}
+
+	log.Info().Msg("Successfully opened the SQLite database")
+
+	return &Client{db}
+}
+
+// WithTransaction runs fn in a transaction which the collections of the client
+// take part in. Since a transaction takes the database lock when it begins,
+// concurrent transactions wait for each other instead of conflicting, so they
+// are not retried.
+func (c *Client) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := c.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(context.WithValue(ctx, txKey{}, tx))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Close ...
+func Close(client *Client) {
+	err := client.DB.Close()
+	if err != nil {
+		log.Info().Err(err).Msg("Error occurred when trying to close the SQLite database")
+	}
+	log.Info().Msg("Closed the SQLite database successfully")
+}
@@ -3,6 +3,7 @@ package exception
 import (
 	"errors"
 	"net/http"
+	"time"
 )
 
 const (
@@ -18,7 +19,19 @@ const (
 	VerificationFailed              = "verificationFailed"
 	TooManyChallengeRequests        = "tooManyChallengeRequests"
 	EmailIdInvalid                  = "emailIdInvalid"
+	EmailDomainDisposable           = "emailDomainDisposable"
 	PhoneNumberInvalid              = "phoneNumberInvalid"
+	SignupDisabled                  = "signupDisabled"
+	EmailDomainNotAllowed           = "emailDomainNotAllowed"
+	UserApprovalPending             = "userApprovalPending"
+	UserRejected                    = "userRejected"
+	UserNotPendingApproval          = "userNotPendingApproval"
+	RecoveryCodeInvalid             = "recoveryCodeInvalid"
+	UserLocked                      = "userLocked"
+	UserDeactivated                 = "userDeactivated"
+	ErasureAlreadyRequested         = "erasureAlreadyRequested"
+	ErasureNotRequested             = "erasureNotRequested"
+	StepUpRequired                  = "stepUpRequired"
 
 	// Cron
 	MinuteIsInvalid    = "minuteIsInvalid"
@@ -28,6 +41,46 @@ const (
 	// Identity
 	IdentityTypeNotFound = "identityTypeNotFound"
 
+	// Attribute
+	AttributeNotFound        = "attributeNotFound"
+	AttributeNameInvalid     = "attributeNameInvalid"
+	AttributeTypeInvalid     = "attributeTypeInvalid"
+	AttributeEditorInvalid   = "attributeEditorInvalid"
+	AttributeValueInvalid    = "attributeValueInvalid"
+	AttributeValueNotAllowed = "attributeValueNotAllowed"
+	AttributeValueNotUnique  = "attributeValueNotUnique"
+	AttributeRequired        = "attributeRequired"
+	AttributeNotEditable     = "attributeNotEditable"
+
+	// Group
+	GroupNotFound      = "groupNotFound"
+	GroupNameInvalid   = "groupNameInvalid"
+	GroupAlreadyExists = "groupAlreadyExists"
+	RoleInvalid        = "roleInvalid"
+
+	// Policy
+	PolicyNotFound    = "policyNotFound"
+	PolicyTypeInvalid = "policyTypeInvalid"
+	PolicyUrlInvalid  = "policyUrlInvalid"
+
+	// SCIM
+	ScimFilterInvalid = "scimFilterInvalid"
+	ScimPathInvalid   = "scimPathInvalid"
+	ScimValueInvalid  = "scimValueInvalid"
+	ScimUserManaged   = "scimUserManaged"
+	ScimGroupManaged  = "scimGroupManaged"
+
+	// Pagination
+	CursorInvalid = "cursorInvalid"
+	LimitInvalid  = "limitInvalid"
+
+	// Concurrency
+	VersionConflict = "versionConflict"
+	IfMatchInvalid  = "ifMatchInvalid"
+
+	// Bulk write
+	WriteSkipped = "writeSkipped"
+
 	// Generic
 	Unauthorised        = "unauthorised"
 	Forbidden           = "forbidden"
@@ -35,11 +88,35 @@ const (
 	InternalServerError = "internalServerError"
 	Conflict            = "conflict"
 	IdInvalid           = "idInvalid"
+	TooManyRequests     = "tooManyRequests"
 )
 
 var ErrNotFound = errors.New(NotFound)
 var ErrConflict = errors.New(Conflict)
 var ErrIdInvalid = errors.New(IdInvalid)
+var ErrCursorInvalid = errors.New(CursorInvalid)
+var ErrVersionConflict = errors.New(VersionConflict)
+var ErrWriteSkipped = errors.New(WriteSkipped)
+
+// TooManyRequestsError is returned when a client is throttled. RetryAfter is
+// the time the client has to wait before the next attempt is allowed.
+type TooManyRequestsError struct {
+	RetryAfter time.Duration
+}
+
+func (e TooManyRequestsError) Error() string {
+	return TooManyRequests
+}
+
+// StepUpRequiredError is returned when the user of a session has to
+// authenticate again. MaxAge is how recent the authentication has to be.
+type StepUpRequiredError struct {
+	MaxAge time.Duration
+}
+
+func (e StepUpRequiredError) Error() string {
+	return StepUpRequired
+}
 
 var messages = map[string]string{
 	// User
@@ -54,7 +131,19 @@ var messages = map[string]string{
 	VerificationFailed:              "Verification failed",
 	TooManyChallengeRequests:        "Too many challenge requests",
 	EmailIdInvalid:                  "Invalid email ID",
+	EmailDomainDisposable:           "Disposable email IDs are not allowed",
 	PhoneNumberInvalid:              "Invalid phone number",
+	SignupDisabled:                  "Sign-up is disabled",
+	EmailDomainNotAllowed:           "Sign-up is not allowed for this email domain",
+	UserApprovalPending:             "User is waiting for administrator approval",
+	UserRejected:                    "User sign-up was rejected",
+	UserNotPendingApproval:          "User is not waiting for approval",
+	RecoveryCodeInvalid:             "You have entered an invalid recovery code",
+	UserLocked:                      "User is locked, contact an administrator",
+	UserDeactivated:                 "User is deactivated",
+	ErasureAlreadyRequested:         "Erasure of the user is already requested",
+	ErasureNotRequested:             "Erasure of the user is not requested",
+	StepUpRequired:                  "Authenticate again to continue",
 
 	// Cron
 	MinuteIsInvalid:    "Invalid minute",
@@ -64,6 +153,46 @@ var messages = map[string]string{
 	// Identity
 	IdentityTypeNotFound: "Identity type not found",
 
+	// Attribute
+	AttributeNotFound:        "Attribute not found",
+	AttributeNameInvalid:     "Invalid attribute name",
+	AttributeTypeInvalid:     "Invalid attribute type",
+	AttributeEditorInvalid:   "Invalid attribute editor",
+	AttributeValueInvalid:    "Attribute value does not match the attribute type",
+	AttributeValueNotAllowed: "Attribute value is not one of the allowed values",
+	AttributeValueNotUnique:  "Attribute value is already used by another user",
+	AttributeRequired:        "Required attribute is missing",
+	AttributeNotEditable:     "Attribute can only be edited by an administrator",
+
+	// Group
+	GroupNotFound:      "Group not found",
+	GroupNameInvalid:   "Invalid group name",
+	GroupAlreadyExists: "Group with the same name already exists",
+	RoleInvalid:        "Invalid role",
+
+	// Policy
+	PolicyNotFound:    "Policy not found or not the current version",
+	PolicyTypeInvalid: "Invalid policy type",
+	PolicyUrlInvalid:  "Invalid policy URL",
+
+	// SCIM
+	ScimFilterInvalid: "Filter is not supported, use attribute eq \"value\"",
+	ScimPathInvalid:   "Patch path is not supported",
+	ScimValueInvalid:  "Invalid value",
+	ScimUserManaged:   "Platform admins cannot be changed by provisioning",
+	ScimGroupManaged:  "Members of groups with roles cannot be changed by provisioning",
+
+	// Pagination
+	CursorInvalid: "Invalid cursor",
+	LimitInvalid:  "Invalid limit",
+
+	// Concurrency
+	VersionConflict: "The resource was changed by another request, fetch it and try again",
+	IfMatchInvalid:  "Invalid If-Match header",
+
+	// Bulk write
+	WriteSkipped: "Not written as an earlier write failed",
+
 	// Generic
 	Unauthorised:        "Unauthorized",
 	Forbidden:           "Forbidden",
@@ -71,6 +200,7 @@ var messages = map[string]string{
 	InternalServerError: "Internal Server Error",
 	Conflict:            "Conflict",
 	IdInvalid:           "Id Invalid",
+	TooManyRequests:     "Too many requests, try again later",
 }
 
 var httpStatus = map[string]int{
@@ -86,11 +216,63 @@ var httpStatus = map[string]int{
 	VerificationFailed:              http.StatusForbidden,
 	TooManyChallengeRequests:        http.StatusTooManyRequests,
 	EmailIdInvalid:                  http.StatusBadRequest,
+	EmailDomainDisposable:           http.StatusBadRequest,
 	PhoneNumberInvalid:              http.StatusBadRequest,
+	SignupDisabled:                  http.StatusForbidden,
+	EmailDomainNotAllowed:           http.StatusForbidden,
+	UserApprovalPending:             http.StatusForbidden,
+	UserRejected:                    http.StatusForbidden,
+	UserNotPendingApproval:          http.StatusConflict,
+	RecoveryCodeInvalid:             http.StatusUnauthorized,
+	UserLocked:                      http.StatusForbidden,
+	UserDeactivated:                 http.StatusForbidden,
+	ErasureAlreadyRequested:         http.StatusConflict,
+	ErasureNotRequested:             http.StatusConflict,
+	StepUpRequired:                  http.StatusUnauthorized,
 
 	// Identity
 	IdentityTypeNotFound: http.StatusNotFound,
 
+	// Attribute
+	AttributeNotFound:        http.StatusNotFound,
+	AttributeNameInvalid:     http.StatusBadRequest,
+	AttributeTypeInvalid:     http.StatusBadRequest,
+	AttributeEditorInvalid:   http.StatusBadRequest,
+	AttributeValueInvalid:    http.StatusBadRequest,
+	AttributeValueNotAllowed: http.StatusBadRequest,
+	AttributeValueNotUnique:  http.StatusConflict,
+	AttributeRequired:        http.StatusBadRequest,
+	AttributeNotEditable:     http.StatusForbidden,
+
+	// Group
+	GroupNotFound:      http.StatusNotFound,
+	GroupNameInvalid:   http.StatusBadRequest,
+	GroupAlreadyExists: http.StatusConflict,
+	RoleInvalid:        http.StatusBadRequest,
+
+	// Policy
+	PolicyNotFound:    http.StatusNotFound,
+	PolicyTypeInvalid: http.StatusBadRequest,
+	PolicyUrlInvalid:  http.StatusBadRequest,
+
+	// SCIM
+	ScimFilterInvalid: http.StatusBadRequest,
+	ScimPathInvalid:   http.StatusBadRequest,
+	ScimValueInvalid:  http.StatusBadRequest,
+	ScimUserManaged:   http.StatusForbidden,
+	ScimGroupManaged:  http.StatusForbidden,
+
+	// Pagination
+	CursorInvalid: http.StatusBadRequest,
+	LimitInvalid:  http.StatusBadRequest,
+
+	// Concurrency
+	VersionConflict: http.StatusConflict,
+	IfMatchInvalid:  http.StatusBadRequest,
+
+	// Bulk write
+	WriteSkipped: http.StatusFailedDependency,
+
 	// Cron
 	MinuteIsInvalid:    http.StatusBadRequest,
 	HourIsInvalid:      http.StatusBadRequest,
@@ -103,6 +285,7 @@ var httpStatus = map[string]int{
 	InternalServerError: http.StatusInternalServerError,
 	Conflict:            http.StatusConflict,
 	IdInvalid:           http.StatusUnprocessableEntity,
+	TooManyRequests:     http.StatusTooManyRequests,
 }
 
 func Message(code string) string {
@@ -0,0 +1,349 @@
+package iam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/dannypaul/go-skeleton/internal/exception"
+	"github.com/dannypaul/go-skeleton/internal/primitive"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+)
+
+type AttributeType string
+
+const (
+	StringAttribute  AttributeType = "STRING"
+	NumberAttribute  AttributeType = "NUMBER"
+	BooleanAttribute AttributeType = "BOOLEAN"
+)
+
+// AttributeEditor is who may edit an attribute. Administrators can edit every
+// attribute, users can only edit their own USER attributes.
+type AttributeEditor string
+
+const (
+	UserEditor  AttributeEditor = "USER"
+	AdminEditor AttributeEditor = "ADMIN"
+)
+
+// Attribute defines a user profile attribute. The attribute values of a user
+// are stored in User.Attributes keyed by the attribute name.
+type Attribute struct {
+	Id            primitive.Id    `bson:"_id,omitempty" json:"id"`
+	Name          string          `bson:"name" json:"name"`
+	Type          AttributeType   `bson:"type" json:"type"`
+	Required      bool            `bson:"required" json:"required"`
+	Unique        bool            `bson:"unique" json:"unique"`
+	AllowedValues []interface{}   `bson:"allowedValues,omitempty" json:"allowedValues,omitempty"`
+	EditableBy    AttributeEditor `bson:"editableBy" json:"editableBy"`
+}
+
+// Attribute names become part of the attributes.<name> document path, so
+// dots and dollar signs are not allowed
+var attributeNameRegexp = regexp.MustCompile("^[a-zA-Z][a-zA-Z0-9_]{0,63}$")
+
+func (a Attribute) Validate() error {
+	if !attributeNameRegexp.MatchString(a.Name) {
+		return errors.New(exception.AttributeNameInvalid)
+	}
+
+	if a.Type != StringAttribute && a.Type != NumberAttribute && a.Type != BooleanAttribute {
+		return errors.New(exception.AttributeTypeInvalid)
+	}
+
+	if a.EditableBy != UserEditor && a.EditableBy != AdminEditor {
+		return errors.New(exception.AttributeEditorInvalid)
+	}
+
+	for _, allowed := range a.AllowedValues {
+		if !a.hasType(allowed) {
+			return errors.New(exception.AttributeValueInvalid)
+		}
+	}
+
+	return nil
+}
+
+func (a Attribute) hasType(value interface{}) bool {
+	switch value.(type) {
+	case string:
+		return a.Type == StringAttribute
+	case float64, float32, int, int32, int64:
+		return a.Type == NumberAttribute
+	case bool:
+		return a.Type == BooleanAttribute
+	}
+	return false
+}
+
+func (a Attribute) validateValue(value interface{}) error {
+	if !a.hasType(value) {
+		return errors.New(exception.AttributeValueInvalid)
+	}
+
+	if len(a.AllowedValues) == 0 {
+		return nil
+	}
+
+	for _, allowed := range a.AllowedValues {
+		if normaliseNumber(allowed) == normaliseNumber(value) {
+			return nil
+		}
+	}
+	return errors.New(exception.AttributeValueNotAllowed)
+}
+
+// normaliseNumber converts the numbers decoded from JSON and BSON to float64
+// so that they can be compared with each other.
+func normaliseNumber(value interface{}) interface{} {
+	switch v := value.(type) {
+	case float32:
+		return float64(v)
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	}
+	return value
+}
+
+type AttributeSchema []Attribute
+
+func (schema AttributeSchema) get(name string) (Attribute, bool) {
+	for _, attribute := range schema {
+		if attribute.Name == name {
+			return attribute, true
+		}
+	}
+	return Attribute{}, false
+}
+
+// filter drops the attribute values which are no longer defined in the schema.
+func (schema AttributeSchema) filter(attributes map[string]interface{}) map[string]interface{} {
+	if attributes == nil {
+		return nil
+	}
+
+	filtered := make(map[string]interface{}, len(attributes))
+	for name, value := range attributes {
+		if attribute, ok := schema.get(name); ok && attribute.hasType(value) {
+			filtered[name] = value
+		}
+	}
+	return filtered
+}
+
+func (s svc) attributeSchema(ctx context.Context) (AttributeSchema, error) {
+	attributes, err := s.attributes.FindAll(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not find the attribute schema %w", err)
+	}
+	return attributes, nil
+}
+
+func (s svc) findAttribute(ctx context.Context, name string) (Attribute, error) {
+	attribute, err := s.attributes.FindSingle(ctx, []repository.Filter{{Key: "name", Value: name}})
+	if err != nil {
+		if errors.Is(err, exception.ErrNotFound) {
+			return Attribute{}, errors.New(exception.AttributeNotFound)
+		}
+		return Attribute{}, err
+	}
+	return attribute, nil
+}
+
+func (s svc) ListAttributes(ctx context.Context) (AttributeSchema, error) {
+	_, err := VerifySession(ctx, []Role{PlatformAdmin, MerchantAdmin, Member})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.attributeSchema(ctx)
+}
+
+// SaveAttribute creates the attribute with the given name or replaces its
+// definition. Values already stored for the attribute are not re-validated.
+func (s svc) SaveAttribute(ctx context.Context, name string, req Attribute) (Attribute, error) {
+	_, err := VerifySession(ctx, []Role{PlatformAdmin})
+	if err != nil {
+		return Attribute{}, err
+	}
+
+	req.Name = name
+	if err := req.Validate(); err != nil {
+		return Attribute{}, err
+	}
+
+	existing, err := s.findAttribute(ctx, name)
+	if err != nil && err.Error() != exception.AttributeNotFound {
+		return Attribute{}, err
+	}
+
+	var attribute Attribute
+	if err == nil {
+		req.Id = existing.Id
+		attribute, err = s.attributes.Replace(ctx, existing.Id, req)
+	} else {
+		req.Id = ""
+		attribute, err = s.attributes.Create(ctx, req)
+	}
+	if err != nil {
+		return Attribute{}, fmt.Errorf("could not save the attribute to persistence %w", err)
+	}
+
+	return attribute, nil
+}
+
+func (s svc) DeleteAttribute(ctx context.Context, name string) (bool, error) {
+	_, err := VerifySession(ctx, []Role{PlatformAdmin})
+	if err != nil {
+		return false, err
+	}
+
+	attribute, err := s.findAttribute(ctx, name)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = s.attributeRepo.Delete(ctx, attribute.Id)
+	if err != nil {
+		return false, fmt.Errorf("could not delete the attribute %w", err)
+	}
+
+	return true, nil
+}
+
+func (s svc) UpdateMyAttributes(ctx context.Context, attributes map[string]interface{}) (User, error) {
+	claims, err := VerifySession(ctx, []Role{PlatformAdmin, MerchantAdmin, Member})
+	if err != nil {
+		return User{}, err
+	}
+
+	user, err := s.findUserById(ctx, claims.UserId)
+	if err != nil {
+		return User{}, err
+	}
+
+	return s.updateAttributes(ctx, user, attributes, false)
+}
+
+func (s svc) UpdateUserAttributes(ctx context.Context, userId primitive.Id, attributes map[string]interface{}) (User, error) {
+	_, err := VerifySession(ctx, []Role{PlatformAdmin, MerchantAdmin})
+	if err != nil {
+		return User{}, err
+	}
+
+	user, err := s.findUserById(ctx, userId)
+	if err != nil {
+		return User{}, err
+	}
+
+	return s.updateAttributes(ctx, user, attributes, true)
+}
+
+// uniqueValue is a value written to an attribute whose values are unique.
+type uniqueValue struct {
+	attribute Attribute
+	value     interface{}
+}
+
+// updateAttributes validates the attributes against the schema and stores
+// them unless the user changed since it was read. A nil value removes the
+// attribute, unless it is required. Only the attributes written are
+// validated, so that a missing required attribute does not block the edits of
+// the others.
+func (s svc) updateAttributes(ctx context.Context, user User, attributes map[string]interface{}, isAdmin bool) (User, error) {
+	if err := checkVersion(ctx, user.Version); err != nil {
+		return User{}, err
+	}
+
+	schema, err := s.attributeSchema(ctx)
+	if err != nil {
+		return User{}, err
+	}
+
+	merged := schema.filter(user.Attributes)
+	if merged == nil {
+		merged = make(map[string]interface{})
+	}
+
+	var patchers []repository.Patch
+	var unique []uniqueValue
+	for name, value := range attributes {
+		attribute, ok := schema.get(name)
+		if !ok {
+			return User{}, errors.New(exception.AttributeNotFound)
+		}
+
+		if !isAdmin && attribute.EditableBy != UserEditor {
+			return User{}, errors.New(exception.AttributeNotEditable)
+		}
+
+		if value == nil {
+			if attribute.Required {
+				return User{}, errors.New(exception.AttributeRequired)
+			}
+			delete(merged, name)
+			patchers = append(patchers, repository.Patch{Action: "$unset", Key: "attributes." + name, Value: ""})
+			continue
+		}
+
+		if err := attribute.validateValue(value); err != nil {
+			return User{}, err
+		}
+
+		if attribute.Unique {
+			unique = append(unique, uniqueValue{attribute: attribute, value: value})
+		}
+
+		merged[name] = value
+		patchers = append(patchers, repository.Patch{Action: "$set", Key: "attributes." + name, Value: value})
+	}
+
+	if len(patchers) == 0 {
+		user.Attributes = merged
+		return user, nil
+	}
+
+	// Every write of a unique value increments the write count of its
+	// attribute, so that concurrent writes of the same attribute conflict and
+	// all but one of them are retried, finding the value written by it
+	err = s.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		for _, u := range unique {
+			err := s.attributeRepo.IncrementById(ctx, u.attribute.Id, "uniqueWrites", 1)
+			if err != nil {
+				return fmt.Errorf("could not lock the unique attribute %w", err)
+			}
+
+			other, err := s.users.FindSingle(ctx, []repository.Filter{{Key: "attributes." + u.attribute.Name, Value: u.value}})
+			if err == nil {
+				if other.Id != user.Id {
+					return errors.New(exception.AttributeValueNotUnique)
+				}
+			} else if !errors.Is(err, exception.ErrNotFound) {
+				return fmt.Errorf("could not check if the attribute value is unique %w", err)
+			}
+		}
+
+		err := s.userRepo.PatchIfVersion(ctx, user.Id, user.Version, patchers)
+		if err != nil {
+			if errors.Is(err, exception.ErrVersionConflict) {
+				return err
+			}
+			return fmt.Errorf("could not update the user attributes %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	user.Attributes = merged
+	user.Version += 1
+
+	return user, nil
+}
@@ -0,0 +1,54 @@
+package iam
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dannypaul/go-skeleton/internal/primitive"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+)
+
+type AuditAction string
+
+const (
+	DataExported     AuditAction = "DATA_EXPORTED"
+	ErasureRequested AuditAction = "ERASURE_REQUESTED"
+	ErasureWithdrawn AuditAction = "ERASURE_WITHDRAWN"
+	UserErased       AuditAction = "USER_ERASED"
+)
+
+// AuditEntry records an action performed on the data of a user. ActorId is
+// empty when the action was performed by the application itself.
+type AuditEntry struct {
+	Id        primitive.Id `bson:"_id,omitempty" json:"id"`
+	Action    AuditAction  `bson:"action" json:"action"`
+	SubjectId primitive.Id `bson:"subjectId" json:"subjectId"`
+	ActorId   primitive.Id `bson:"actorId,omitempty" json:"actorId,omitempty"`
+	IP        string       `bson:"ip,omitempty" json:"ip,omitempty"`
+	At        time.Time    `bson:"at" json:"at"`
+}
+
+func (s svc) recordAudit(ctx context.Context, action AuditAction, subjectId primitive.Id) error {
+	entry := AuditEntry{Action: action, SubjectId: subjectId, At: time.Now().UTC()}
+	if claims, ok := ctx.Value(CtxClaimsKey).(Claims); ok {
+		entry.ActorId = claims.UserId
+	}
+	if client, ok := ctx.Value(CtxClientKey).(Client); ok {
+		entry.IP = client.IP
+	}
+
+	_, err := s.audit.Create(ctx, entry)
+	if err != nil {
+		return fmt.Errorf("could not save the audit entry %w", err)
+	}
+	return nil
+}
+
+func (s svc) auditEntries(ctx context.Context, subjectId primitive.Id) ([]AuditEntry, error) {
+	entries, err := s.audit.FindAll(ctx, []repository.Filter{{Key: "subjectId", Value: subjectId}})
+	if err != nil {
+		return nil, fmt.Errorf("could not find the audit entries %w", err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,106 @@
+package iam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/dannypaul/go-skeleton/internal/exception"
+	"github.com/dannypaul/go-skeleton/internal/primitive"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+	"github.com/dannypaul/go-skeleton/internal/validation"
+)
+
+// EmailIdConflicts are the canonical email IDs shared by more than one user,
+// along with the IDs of those users.
+type EmailIdConflicts map[string][]primitive.Id
+
+type canonicalUpdate struct {
+	userId   primitive.Id
+	version  int
+	patchers []repository.Patch
+}
+
+// CanonicaliseEmailIds recomputes the canonical email IDs of the identities of
+// every user the context sees, with the current EMAIL_PROVIDER_RULES. Nothing
+// is written when two users get the same canonical email ID, as the unique
+// index on it would reject one of them, and the conflicts are returned to be
+// resolved first. With dryRun only the conflicts are checked. It returns the
+// number of users whose canonical email IDs changed, or would change.
+func CanonicaliseEmailIds(ctx context.Context, userRepo UserRepo, dryRun bool) (int, EmailIdConflicts, error) {
+	streamer, ok := userRepo.(repository.Streamer)
+	if !ok {
+		return 0, nil, errors.New("the users repository cannot be streamed")
+	}
+
+	iterator, err := streamer.Stream(ctx, repository.Query{})
+	if err != nil {
+		return 0, nil, fmt.Errorf("could not stream the users %w", err)
+	}
+	defer iterator.Close(ctx)
+
+	owners := make(map[string][]primitive.Id)
+	var updates []canonicalUpdate
+	for iterator.Next(ctx) {
+		var user User
+		err = iterator.Decode(&user)
+		if err != nil {
+			return 0, nil, fmt.Errorf("could not decode the user %w", err)
+		}
+
+		update := canonicalUpdate{userId: user.Id, version: user.Version}
+		for i, identity := range user.Identities {
+			if identity.Type != EMAIL || identity.EmailId == "" {
+				continue
+			}
+
+			canonicalEmailId, err := validation.CanonicaliseEmailId(identity.EmailId)
+			if err != nil {
+				return 0, nil, fmt.Errorf("could not canonicalise the email ID of the user %s %w", user.Id, err)
+			}
+
+			userIds := owners[canonicalEmailId]
+			if len(userIds) == 0 || userIds[len(userIds)-1] != user.Id {
+				owners[canonicalEmailId] = append(userIds, user.Id)
+			}
+
+			if canonicalEmailId != identity.CanonicalEmailId {
+				update.patchers = append(update.patchers, repository.Patch{
+					Action: "$set",
+					Key:    "identities." + strconv.Itoa(i) + ".canonicalEmailId",
+					Value:  canonicalEmailId,
+				})
+			}
+		}
+		if len(update.patchers) > 0 {
+			updates = append(updates, update)
+		}
+	}
+	if err = iterator.Err(); err != nil {
+		return 0, nil, fmt.Errorf("could not stream the users %w", err)
+	}
+
+	conflicts := EmailIdConflicts{}
+	for canonicalEmailId, userIds := range owners {
+		if len(userIds) > 1 {
+			conflicts[canonicalEmailId] = userIds
+		}
+	}
+	if len(conflicts) > 0 || dryRun {
+		return len(updates), conflicts, nil
+	}
+
+	// A user changed since it was read is left to a later run, so that the
+	// canonical email ID of a changed email ID is not overwritten
+	for i, update := range updates {
+		err = userRepo.PatchIfVersion(ctx, update.userId, update.version, update.patchers)
+		if err != nil {
+			if errors.Is(err, exception.ErrVersionConflict) {
+				return i, nil, err
+			}
+			return i, nil, fmt.Errorf("could not save the canonical email IDs of the user %s %w", update.userId, err)
+		}
+	}
+	return len(updates), nil, nil
+}
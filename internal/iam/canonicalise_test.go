@@ -0,0 +1,59 @@
+package iam
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dannypaul/go-skeleton/internal/repository"
+)
+
+func TestCanonicaliseEmailIds(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	// The migration lowercases the email IDs, which keeps the domain in Unicode
+	ada, err := s.users.Create(ctx, User{Name: "Ada", Identities: IdentityList{
+		{Type: EMAIL, EmailId: "Ada@Bücher.example", CanonicalEmailId: "ada@bücher.example"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.users.Create(ctx, User{Name: "Bob", Identities: IdentityList{
+		{Type: EMAIL, EmailId: "bob@example.com", CanonicalEmailId: "bob@example.com"},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	updated, conflicts, err := CanonicaliseEmailIds(ctx, s.userRepo, false)
+	if err != nil || len(conflicts) != 0 || updated != 1 {
+		t.Fatalf("Could not canonicalise the email IDs, got: %d, %v, %v", updated, conflicts, err)
+	}
+
+	found, err := s.users.FindSingle(ctx, []repository.Filter{{Key: "identities.canonicalEmailId", Value: "ada@xn--bcher-kva.example"}})
+	if err != nil || found.Id != ada.Id {
+		t.Errorf("User was not found by the canonical email ID, got: %+v, %v", found, err)
+	}
+}
+
+func TestCanonicaliseEmailIdsConflict(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	for _, emailId := range []string{"Ada@Bücher.example", "ada@bücher.example"} {
+		if _, err := s.users.Create(ctx, User{Name: "Ada", Identities: IdentityList{
+			{Type: EMAIL, EmailId: emailId, CanonicalEmailId: emailId},
+		}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	updated, conflicts, err := CanonicaliseEmailIds(ctx, s.userRepo, false)
+	if err != nil || len(conflicts["ada@xn--bcher-kva.example"]) != 2 {
+		t.Fatalf("Conflict was not reported, got: %d, %v, %v", updated, conflicts, err)
+	}
+
+	count, err := s.users.Count(ctx, []repository.Filter{{Key: "identities.canonicalEmailId", Value: "ada@xn--bcher-kva.example"}})
+	if err != nil || count != 0 {
+		t.Errorf("Email IDs were canonicalised despite the conflict, got: %d, %v", count, err)
+	}
+}
@@ -27,21 +27,26 @@ type Challenge struct {
 	Phone Phone `bson:"phone,omitempty" json:"phone"`
 
 	// Email
-	EmailId string `bson:"emailId,omitempty" json:"emailId"`
+	EmailId          string `bson:"emailId,omitempty" json:"emailId"`
+	CanonicalEmailId string `bson:"canonicalEmailId,omitempty" json:"-"`
 }
 
-func (c Challenge) Validate() error {
+func (c *Challenge) Validate() error {
 	if c.IdentityType == PHONE {
-		if err := validation.ValidatePhone(c.Phone.Number); err != nil {
+		phone, err := c.Phone.normalise()
+		if err != nil {
 			return err
 		}
+		c.Phone = phone
 		c.EmailId = ""
 	}
 
 	if c.IdentityType == EMAIL {
-		if err := validation.ValidateEmailId(c.EmailId); err != nil {
+		identity, err := newEmailIdentity(c.EmailId)
+		if err != nil {
 			return err
 		}
+		c.CanonicalEmailId = identity.CanonicalEmailId
 		c.Phone = Phone{}
 	}
 
@@ -53,24 +58,33 @@ func (s svc) FindChallengeByIdentity(ctx context.Context, identity Identity) (Ch
 		return Challenge{}, errors.New(exception.IdentityTypeNotFound)
 	}
 
-	var copier repository.Copier
+	var challenge Challenge
 	var err error
 	if identity.Type == PHONE {
-		copier, err = s.challengeRepo.FindSingle(ctx, []repository.Filter{{Key: "phone.number", Value: identity.Phone.Number}})
+		if identity.Phone == nil {
+			return Challenge{}, errors.New(exception.PhoneNumberInvalid)
+		}
+		var phone Phone
+		phone, err = identity.Phone.normalise()
+		if err != nil {
+			return Challenge{}, err
+		}
+		challenge, err = s.challenges.FindSingle(ctx, []repository.Filter{{Key: "phone.number", Value: phone.Number}})
 	}
 
 	if identity.Type == EMAIL {
-		copier, err = s.challengeRepo.FindSingle(ctx, []repository.Filter{{Key: "emailId", Value: identity.EmailId}})
+		var canonicalEmailId string
+		canonicalEmailId, err = validation.CanonicaliseEmailId(identity.EmailId)
+		if err != nil {
+			return Challenge{}, err
+		}
+		challenge, err = s.challenges.FindSingle(ctx, []repository.Filter{{Key: "canonicalEmailId", Value: canonicalEmailId}})
 	}
 
 	if err != nil {
 		return Challenge{}, err
 	}
-
-	var challenge Challenge
-	err = copier.Copy(&challenge)
-
-	return challenge, err
+	return challenge, nil
 }
 
 func (s svc) Challenge(ctx context.Context, req Challenge) (Challenge, error) {
@@ -90,36 +104,33 @@ func (s svc) Challenge(ctx context.Context, req Challenge) (Challenge, error) {
 		EmailId: req.EmailId,
 		Phone:   &req.Phone,
 	}
-	challenge, err := s.FindChallengeByIdentity(ctx, identity)
-	if errors.Is(err, exception.ErrNotFound) {
-		req.CreatedAt = now
-		req.UpdatedAt = now
-		req.OTP = otp
-
-		copier, err := s.challengeRepo.Create(ctx, req)
-		if err != nil {
-			return Challenge{}, fmt.Errorf("could not save the challenge request to persistence %w", err)
+	// The notification is sent after the transaction, which may be retried
+	var challenge Challenge
+	err = s.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		challenge, err = s.FindChallengeByIdentity(ctx, identity)
+		if errors.Is(err, exception.ErrNotFound) {
+			req.CreatedAt = now
+			req.UpdatedAt = now
+			req.OTP = otp
+
+			challenge, err = s.challenges.Create(ctx, req)
+			if err != nil {
+				return fmt.Errorf("could not save the challenge request to persistence %w", err)
+			}
 		}
 
-		var createdChallenge Challenge
-		err = copier.Copy(&createdChallenge)
-		if err != nil {
-			return Challenge{}, fmt.Errorf("could not copy the challenge to local variable %w", err)
+		if !challenge.UpdatedAt.Equal(challenge.CreatedAt) {
+			duration := now.Sub(challenge.UpdatedAt)
+			if duration.Seconds() < conf.ChallengeTTL.Seconds() {
+				return errors.New(exception.TooManyChallengeRequests)
+			}
 		}
 
-		challenge = createdChallenge
-	}
-
-	if !challenge.UpdatedAt.Equal(challenge.CreatedAt) {
-		duration := now.Sub(challenge.UpdatedAt)
-		if duration.Seconds() < conf.ChallengeTTL.Seconds() {
-			return Challenge{}, errors.New(exception.TooManyChallengeRequests)
-		}
-	}
-
-	err = s.challengeRepo.SetAllById(ctx, challenge.Id, []repository.KeyValue{
-		{"updatedAt", time.Now()},
-		{"otp", otp},
+		return s.challengeRepo.SetAllById(ctx, challenge.Id, []repository.KeyValue{
+			{"updatedAt", time.Now()},
+			{"otp", otp},
+		})
 	})
 	if err != nil {
 		return Challenge{}, err
@@ -177,28 +188,69 @@ func (s svc) Verify(ctx context.Context, req VerifyReq) (Session, error) {
 		return Session{}, fmt.Errorf("could not find the user by identity, %w", err)
 	}
 
+	if user.Status == PendingApproval {
+		return Session{}, errors.New(exception.UserApprovalPending)
+	}
+
+	if user.Status == Rejected {
+		return Session{}, errors.New(exception.UserRejected)
+	}
+
+	if user.Status == Locked {
+		return Session{}, errors.New(exception.UserLocked)
+	}
+
+	if user.Status == Deactivated {
+		return Session{}, errors.New(exception.UserDeactivated)
+	}
+
 	user.Version += 1
 
-	_, identityIndex, err := user.Identities.getIdentity(req.IdentityType)
+	verifiedIdentity, identityIndex, err := user.Identities.getIdentity(req.IdentityType)
 	patchers := []repository.Patch{
 		{"$set", "identities." + strconv.Itoa(identityIndex) + ".verified", true},
 		{"$set", "failedAuthAttempts", 0},
 		{"$inc", "version", 1},
 	}
-	err = s.userRepo.Patch(ctx, user.Id, patchers)
-	if err != nil {
-		return Session{}, fmt.Errorf("could not update the user %w", err)
+
+	// A challenge completed within a session of the same user steps up that
+	// session instead of replacing its authentication methods
+	auth := Authentication{Time: time.Now(), Methods: []AuthMethod{OtpAuth}}
+	if claims, ok := ctx.Value(CtxClaimsKey).(Claims); ok && claims.UserId == user.Id {
+		auth.Methods = claims.authentication().withMethod(OtpAuth).Methods
 	}
 
-	token, err := user.createToken(true)
+	// The challenge is consumed together with the verification of the
+	// identity, so it cannot be verified twice concurrently
+	var session Session
+	err = s.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		deleted, err := s.challengeRepo.Delete(ctx, challenge.Id)
+		if err != nil {
+			return fmt.Errorf("could not delete the challenge request %w", err)
+		}
+		if deleted == 0 {
+			return errors.New(exception.ChallengeNotFound)
+		}
+
+		err = s.userRepo.Patch(ctx, user.Id, patchers)
+		if err != nil {
+			return fmt.Errorf("could not update the user %w", err)
+		}
+
+		session, err = s.createSession(ctx, user, auth)
+		return err
+	})
 	if err != nil {
-		return Session{}, fmt.Errorf("could not create token for the user %w", err)
+		return Session{}, err
 	}
 
-	_, err = s.challengeRepo.Delete(ctx, challenge.Id)
+	// The device of the first verification, such as after signing up, is
+	// remembered without telling the user about it
+	user, err = s.rememberClient(ctx, user, verifiedIdentity.Verified)
 	if err != nil {
-		return Session{}, fmt.Errorf("could not delete the challenge request %w", err)
+		return Session{}, err
 	}
+	session.User.KnownDevices = user.KnownDevices
 
-	return Session{User: user, Token: token}, err
+	return session, nil
 }
@@ -0,0 +1,304 @@
+package iam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/dannypaul/go-skeleton/internal/exception"
+	"github.com/dannypaul/go-skeleton/internal/primitive"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+)
+
+type PolicyType string
+
+const (
+	TermsOfService PolicyType = "TERMS_OF_SERVICE"
+	PrivacyPolicy  PolicyType = "PRIVACY_POLICY"
+)
+
+// Policy is a published version of the terms of service or the privacy
+// policy. Versions are numbered per type and never change once published.
+type Policy struct {
+	Id          primitive.Id `bson:"_id,omitempty" json:"id"`
+	Type        PolicyType   `bson:"type" json:"type"`
+	Version     int          `bson:"version" json:"version"`
+	Url         string       `bson:"url" json:"url"`
+	Mandatory   bool         `bson:"mandatory" json:"mandatory"`
+	PublishedAt time.Time    `bson:"publishedAt" json:"publishedAt"`
+}
+
+func (p Policy) Validate() error {
+	if p.Type != TermsOfService && p.Type != PrivacyPolicy {
+		return errors.New(exception.PolicyTypeInvalid)
+	}
+
+	u, err := url.Parse(p.Url)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return errors.New(exception.PolicyUrlInvalid)
+	}
+
+	return nil
+}
+
+// Consent is the proof that a user accepted a policy version.
+type Consent struct {
+	Id         primitive.Id `bson:"_id,omitempty" json:"id"`
+	UserId     primitive.Id `bson:"userId" json:"userId"`
+	PolicyId   primitive.Id `bson:"policyId" json:"policyId"`
+	PolicyType PolicyType   `bson:"policyType" json:"policyType"`
+	Version    int          `bson:"version" json:"version"`
+	AcceptedAt time.Time    `bson:"acceptedAt" json:"acceptedAt"`
+	IP         string       `bson:"ip" json:"ip"`
+	UserAgent  string       `bson:"userAgent" json:"userAgent"`
+}
+
+type PolicyCoverage struct {
+	Policy   Policy  `json:"policy"`
+	Accepted int64   `json:"accepted"`
+	Users    int64   `json:"users"`
+	Coverage float64 `json:"coverage"`
+}
+
+func (s svc) allPolicies(ctx context.Context) ([]Policy, error) {
+	policies, err := s.policies.FindAll(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not find the policies %w", err)
+	}
+	return policies, nil
+}
+
+// currentPolicies returns the latest version of every policy type.
+func (s svc) currentPolicies(ctx context.Context) ([]Policy, error) {
+	policies, err := s.allPolicies(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	latest := map[PolicyType]Policy{}
+	for _, policy := range policies {
+		if current, ok := latest[policy.Type]; !ok || policy.Version > current.Version {
+			latest[policy.Type] = policy
+		}
+	}
+
+	current := make([]Policy, 0, len(latest))
+	for _, policyType := range []PolicyType{TermsOfService, PrivacyPolicy} {
+		if policy, ok := latest[policyType]; ok {
+			current = append(current, policy)
+		}
+	}
+	return current, nil
+}
+
+// pendingPolicies returns the current mandatory policies the user has not
+// accepted yet.
+func (s svc) pendingPolicies(ctx context.Context, userId primitive.Id) ([]Policy, error) {
+	current, err := s.currentPolicies(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := []Policy{}
+	for _, policy := range current {
+		if !policy.Mandatory {
+			continue
+		}
+
+		count, err := s.consentRepo.Count(ctx, []repository.Filter{
+			{Key: "userId", Value: userId},
+			{Key: "policyId", Value: policy.Id},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not find the consents of the user %w", err)
+		}
+		if count == 0 {
+			pending = append(pending, policy)
+		}
+	}
+	return pending, nil
+}
+
+// createSession creates the session of an authenticated user. Until the user
+// accepts the pending mandatory policies, the session is restricted to
+// ConsentScope.
+func (s svc) createSession(ctx context.Context, user User, auth Authentication) (Session, error) {
+	user, err := s.withEffectiveRoles(ctx, user)
+	if err != nil {
+		return Session{}, err
+	}
+
+	pending, err := s.pendingPolicies(ctx, user.Id)
+	if err != nil {
+		return Session{}, err
+	}
+
+	var scope Scope
+	if len(pending) > 0 {
+		scope = ConsentScope
+	}
+
+	token, err := user.createScopedToken(auth, scope)
+	if err != nil {
+		return Session{}, fmt.Errorf("could not create token for the user %w", err)
+	}
+
+	session := Session{User: user, Token: token}
+	if len(pending) > 0 {
+		session.ConsentRequired = true
+		session.Policies = pending
+	}
+	return session, nil
+}
+
+func (s svc) ListPolicies(ctx context.Context) ([]Policy, error) {
+	return s.currentPolicies(ctx)
+}
+
+// PublishPolicy publishes the next version of a policy. Users have to accept
+// it on their next sign in when it is mandatory.
+func (s svc) PublishPolicy(ctx context.Context, req Policy) (Policy, error) {
+	_, err := VerifySession(ctx, []Role{PlatformAdmin})
+	if err != nil {
+		return Policy{}, err
+	}
+
+	if err := req.Validate(); err != nil {
+		return Policy{}, err
+	}
+
+	policies, err := s.allPolicies(ctx)
+	if err != nil {
+		return Policy{}, err
+	}
+
+	version := 1
+	for _, policy := range policies {
+		if policy.Type == req.Type && policy.Version >= version {
+			version = policy.Version + 1
+		}
+	}
+
+	policy, err := s.policies.Create(ctx, Policy{
+		Type:        req.Type,
+		Version:     version,
+		Url:         req.Url,
+		Mandatory:   req.Mandatory,
+		PublishedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		if errors.Is(err, exception.ErrConflict) {
+			return Policy{}, errors.New(exception.Conflict)
+		}
+		return Policy{}, fmt.Errorf("could not save the policy to persistence %w", err)
+	}
+
+	return policy, nil
+}
+
+// AcceptPolicies records the consent of the signed in user to the policies
+// and returns a session which is no longer restricted to ConsentScope once
+// every mandatory policy is accepted.
+func (s svc) AcceptPolicies(ctx context.Context, req AcceptPoliciesReq) (Session, error) {
+	claims, ok := ctx.Value(CtxClaimsKey).(Claims)
+	if !ok || claims.UserId == "" {
+		return Session{}, errors.New(exception.Unauthorised)
+	}
+	if claims.Scope != "" && claims.Scope != ConsentScope {
+		return Session{}, errors.New(exception.Forbidden)
+	}
+
+	user, err := s.findUserById(ctx, claims.UserId)
+	if err != nil {
+		return Session{}, err
+	}
+
+	current, err := s.currentPolicies(ctx)
+	if err != nil {
+		return Session{}, err
+	}
+
+	client, _ := ctx.Value(CtxClientKey).(Client)
+	err = s.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		for _, policyId := range req.PolicyIds {
+			var policy Policy
+			for _, p := range current {
+				if p.Id == policyId {
+					policy = p
+				}
+			}
+
+			// Only the current versions can be accepted
+			if policy.Id == "" {
+				return errors.New(exception.PolicyNotFound)
+			}
+
+			// A failed write aborts the transaction, so the policies which are
+			// already accepted are skipped instead of relying on the conflict
+			count, err := s.consentRepo.Count(ctx, []repository.Filter{
+				{Key: "userId", Value: user.Id},
+				{Key: "policyId", Value: policy.Id},
+			})
+			if err != nil {
+				return fmt.Errorf("could not find the consent of the user %w", err)
+			}
+			if count > 0 {
+				continue
+			}
+
+			_, err = s.consents.Create(ctx, Consent{
+				UserId:     user.Id,
+				PolicyId:   policy.Id,
+				PolicyType: policy.Type,
+				Version:    policy.Version,
+				AcceptedAt: time.Now().UTC(),
+				IP:         client.IP,
+				UserAgent:  client.UserAgent,
+			})
+			if err != nil {
+				return fmt.Errorf("could not save the consent to persistence %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Session{}, err
+	}
+
+	return s.createSession(ctx, user, claims.authentication())
+}
+
+// PolicyCoverage reports how many of the users accepted each policy version.
+func (s svc) PolicyCoverage(ctx context.Context) ([]PolicyCoverage, error) {
+	_, err := VerifySession(ctx, []Role{PlatformAdmin})
+	if err != nil {
+		return nil, err
+	}
+
+	policies, err := s.allPolicies(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := s.userRepo.Count(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not count the users %w", err)
+	}
+
+	report := make([]PolicyCoverage, 0, len(policies))
+	for _, policy := range policies {
+		accepted, err := s.consentRepo.Count(ctx, []repository.Filter{{Key: "policyId", Value: policy.Id}})
+		if err != nil {
+			return nil, fmt.Errorf("could not count the consents %w", err)
+		}
+
+		coverage := PolicyCoverage{Policy: policy, Accepted: accepted, Users: users}
+		if users > 0 {
+			coverage.Coverage = float64(accepted) / float64(users)
+		}
+		report = append(report, coverage)
+	}
+	return report, nil
+}
@@ -0,0 +1,289 @@
+package iam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dannypaul/go-skeleton/internal/exception"
+	"github.com/dannypaul/go-skeleton/internal/primitive"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+)
+
+// Group grants its roles to every member. Memberships are stored on the user
+// in User.GroupIds, so a deleted group is ignored wherever it is still listed.
+type Group struct {
+	Id          primitive.Id `bson:"_id,omitempty" json:"id"`
+	Name        string       `bson:"name" json:"name"`
+	Description string       `bson:"description,omitempty" json:"description,omitempty"`
+	ExternalId  string       `bson:"externalId,omitempty" json:"externalId,omitempty"`
+	Provisioned bool         `bson:"provisioned,omitempty" json:"provisioned,omitempty"`
+	Roles       []Role       `bson:"roles" json:"roles"`
+	Version     int          `bson:"version" json:"version"`
+}
+
+func (g Group) Validate() error {
+	if strings.TrimSpace(g.Name) == "" {
+		return errors.New(exception.GroupNameInvalid)
+	}
+
+	for _, role := range g.Roles {
+		if !role.isValid() {
+			return errors.New(exception.RoleInvalid)
+		}
+	}
+
+	return nil
+}
+
+func (s svc) findGroupById(ctx context.Context, id primitive.Id) (Group, error) {
+	group, err := s.groups.FindById(ctx, id)
+	if err != nil {
+		if errors.Is(err, exception.ErrNotFound) || errors.Is(err, exception.ErrIdInvalid) {
+			return Group{}, errors.New(exception.GroupNotFound)
+		}
+		return Group{}, err
+	}
+
+	return group, nil
+}
+
+// userGroups returns the groups the user is a member of.
+func (s svc) userGroups(ctx context.Context, user User) ([]Group, error) {
+	groups := make([]Group, 0, len(user.GroupIds))
+	for _, id := range user.GroupIds {
+		group, err := s.findGroupById(ctx, id)
+		if err != nil {
+			if err.Error() == exception.GroupNotFound {
+				continue
+			}
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// withEffectiveRoles sets the roles of the user to the union of their direct
+// role and the roles of their groups. It is called before creating a token, so
+// changes to groups apply to the sessions created after them.
+func (s svc) withEffectiveRoles(ctx context.Context, user User) (User, error) {
+	groups, err := s.userGroups(ctx, user)
+	if err != nil {
+		return User{}, fmt.Errorf("could not find the groups of the user %w", err)
+	}
+
+	roles := []Role{user.Role}
+	for _, group := range groups {
+		for _, role := range group.Roles {
+			if !hasRole(roles, role) {
+				roles = append(roles, role)
+			}
+		}
+	}
+
+	user.Roles = roles
+	return user, nil
+}
+
+// ListGroups returns a page of the groups ordered by name, and the cursor of
+// the next page.
+func (s svc) ListGroups(ctx context.Context, cursor string, limit int64) ([]Group, string, error) {
+	_, err := VerifySession(ctx, []Role{PlatformAdmin, MerchantAdmin})
+	if err != nil {
+		return nil, "", err
+	}
+
+	limit, err = pageLimit(limit)
+	if err != nil {
+		return nil, "", err
+	}
+
+	query := repository.Query{Sort: []repository.Sort{{Key: "name"}}, Limit: limit}
+	groups, next, err := s.groups.Paginate(ctx, query, cursor)
+	if err != nil {
+		if errors.Is(err, exception.ErrCursorInvalid) {
+			return nil, "", err
+		}
+		return nil, "", fmt.Errorf("could not find the groups %w", err)
+	}
+	return groups, next, nil
+}
+
+func (s svc) FindGroup(ctx context.Context, id primitive.Id) (Group, error) {
+	_, err := VerifySession(ctx, []Role{PlatformAdmin, MerchantAdmin})
+	if err != nil {
+		return Group{}, err
+	}
+
+	return s.findGroupById(ctx, id)
+}
+
+func (s svc) CreateGroup(ctx context.Context, req Group) (Group, error) {
+	_, err := VerifySession(ctx, []Role{PlatformAdmin})
+	if err != nil {
+		return Group{}, err
+	}
+
+	if err := req.Validate(); err != nil {
+		return Group{}, err
+	}
+
+	req.Id = ""
+	req.Version = 0
+	group, err := s.groups.Create(ctx, req)
+	if err != nil {
+		if errors.Is(err, exception.ErrConflict) {
+			return Group{}, errors.New(exception.GroupAlreadyExists)
+		}
+		return Group{}, fmt.Errorf("could not save the group to persistence %w", err)
+	}
+
+	return group, nil
+}
+
+func (s svc) UpdateGroup(ctx context.Context, id primitive.Id, req Group) (Group, error) {
+	_, err := VerifySession(ctx, []Role{PlatformAdmin})
+	if err != nil {
+		return Group{}, err
+	}
+
+	if err := req.Validate(); err != nil {
+		return Group{}, err
+	}
+
+	existing, err := s.findGroupById(ctx, id)
+	if err != nil {
+		return Group{}, err
+	}
+
+	if err := checkVersion(ctx, existing.Version); err != nil {
+		return Group{}, err
+	}
+
+	// Whether the group is managed by provisioning is not up to the request
+	req.Id = existing.Id
+	req.Provisioned = existing.Provisioned
+	group, err := repository.Copy[Group](s.groupRepo.ReplaceIfVersion(ctx, existing.Id, existing.Version, req))
+	if err != nil {
+		if errors.Is(err, exception.ErrVersionConflict) {
+			return Group{}, err
+		}
+		if errors.Is(err, exception.ErrConflict) {
+			return Group{}, errors.New(exception.GroupAlreadyExists)
+		}
+		return Group{}, fmt.Errorf("could not save the group to persistence %w", err)
+	}
+
+	return group, nil
+}
+
+func (s svc) DeleteGroup(ctx context.Context, id primitive.Id) (bool, error) {
+	_, err := VerifySession(ctx, []Role{PlatformAdmin})
+	if err != nil {
+		return false, err
+	}
+
+	group, err := s.findGroupById(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = s.groupRepo.Delete(ctx, group.Id)
+	if err != nil {
+		return false, fmt.Errorf("could not delete the group %w", err)
+	}
+
+	return true, nil
+}
+
+// ListGroupMembers returns a page of the members of the group, and the cursor
+// of the next page.
+func (s svc) ListGroupMembers(ctx context.Context, id primitive.Id, cursor string, limit int64) ([]User, string, error) {
+	_, err := VerifySession(ctx, []Role{PlatformAdmin, MerchantAdmin})
+	if err != nil {
+		return nil, "", err
+	}
+
+	group, err := s.findGroupById(ctx, id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	limit, err = pageLimit(limit)
+	if err != nil {
+		return nil, "", err
+	}
+
+	query := repository.Query{Filters: []repository.Filter{{Key: "groupIds", Value: group.Id}}, Limit: limit}
+	users, next, err := s.users.Paginate(ctx, query, cursor)
+	if err != nil {
+		if errors.Is(err, exception.ErrCursorInvalid) {
+			return nil, "", err
+		}
+		return nil, "", fmt.Errorf("could not find the members of the group %w", err)
+	}
+	return users, next, nil
+}
+
+func (s svc) ListUserGroups(ctx context.Context, userId primitive.Id) ([]Group, error) {
+	_, err := VerifySession(ctx, []Role{PlatformAdmin, MerchantAdmin})
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.findUserById(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.userGroups(ctx, user)
+}
+
+func (s svc) AddGroupMember(ctx context.Context, groupId primitive.Id, userId primitive.Id) (bool, error) {
+	return s.updateMembership(ctx, groupId, userId, "$addToSet")
+}
+
+func (s svc) RemoveGroupMember(ctx context.Context, groupId primitive.Id, userId primitive.Id) (bool, error) {
+	return s.updateMembership(ctx, groupId, userId, "$pull")
+}
+
+func (s svc) updateMembership(ctx context.Context, groupId primitive.Id, userId primitive.Id, action string) (bool, error) {
+	_, err := VerifySession(ctx, []Role{PlatformAdmin})
+	if err != nil {
+		return false, err
+	}
+
+	group, err := s.findGroupById(ctx, groupId)
+	if err != nil {
+		return false, err
+	}
+
+	err = s.patchMembership(ctx, group, userId, action)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// patchMembership adds the user to the group with the $addToSet action and
+// removes them with the $pull action.
+func (s svc) patchMembership(ctx context.Context, group Group, userId primitive.Id, action string) error {
+	user, err := s.findUserById(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	patchers := []repository.Patch{
+		{Action: action, Key: "groupIds", Value: group.Id},
+		{Action: "$inc", Key: "version", Value: 1},
+	}
+	err = s.userRepo.Patch(ctx, user.Id, patchers)
+	if err != nil {
+		return fmt.Errorf("could not update the group membership %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package iam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/dannypaul/go-skeleton/internal/config"
+	"github.com/dannypaul/go-skeleton/internal/exception"
+	"github.com/dannypaul/go-skeleton/internal/notification"
+	"github.com/dannypaul/go-skeleton/internal/primitive"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+)
+
+// UpdateIdentity replaces the identity of the given type, or adds it when the
+// user does not have one yet. The new identity has to be verified through a
+// challenge. A new token is returned as the update invalidates the old one.
+func (s svc) UpdateIdentity(ctx context.Context, userId primitive.Id, req UpdateIdentityReq) (Session, error) {
+	claims, err := VerifyActionToken(ctx)
+	if err != nil {
+		return Session{}, err
+	}
+
+	user, err := s.findUserById(ctx, userId)
+	if err != nil {
+		return Session{}, err
+	}
+
+	if claims.UserVersion != user.Version {
+		return Session{}, errors.New(exception.Unauthorised)
+	}
+
+	if user.Id != claims.UserId {
+		return Session{}, errors.New(exception.Forbidden)
+	}
+
+	conf, _ := config.Get()
+	if err := requireRecentAuth(claims, conf.IdentityStepUpMaxAge, OtpAuth, PasswordAuth, RecoveryCodeAuth); err != nil {
+		return Session{}, err
+	}
+
+	identity := Identity{Type: req.IdentityType}
+	var exists bool
+	switch req.IdentityType {
+	case EMAIL:
+		identity, err = newEmailIdentity(req.EmailId)
+		if err != nil {
+			return Session{}, err
+		}
+		exists, err = s.DoesEmailIdExist(ctx, req.EmailId)
+	case PHONE:
+		var phone Phone
+		phone, err = req.Phone.normalise()
+		if err != nil {
+			return Session{}, err
+		}
+		identity.Phone = &phone
+		exists, err = s.DoesPhoneNumberExist(ctx, phone.Number)
+	default:
+		return Session{}, errors.New(exception.IdentityTypeNotFound)
+	}
+	if err != nil {
+		return Session{}, fmt.Errorf("could not check if the identity exists %w", err)
+	}
+	if exists {
+		return Session{}, errors.New(exception.UserAlreadyExists)
+	}
+
+	var patchers []repository.Patch
+
+	// The identities before the update, so that a replaced identity is told
+	// about its removal
+	recipients := append(IdentityList{}, user.Identities...)
+
+	removed, identityIndex, err := user.Identities.getIdentity(req.IdentityType)
+	replaced := err == nil
+	if replaced {
+		patchers = append(patchers, repository.Patch{Action: "$set", Key: "identities." + strconv.Itoa(identityIndex), Value: identity})
+		user.Identities[identityIndex] = identity
+	} else {
+		patchers = append(patchers, repository.Patch{Action: "$push", Key: "identities", Value: identity})
+		user.Identities = append(user.Identities, identity)
+	}
+
+	err = s.userRepo.PatchIfVersion(ctx, user.Id, user.Version, patchers)
+	if err != nil {
+		if errors.Is(err, exception.ErrVersionConflict) {
+			return Session{}, err
+		}
+		return Session{}, fmt.Errorf("could not update the user %w", err)
+	}
+
+	user.Version += 1
+
+	s.notifySecurityEvent(ctx, user, recipients, notification.SecurityNotification{
+		Event:    notification.IdentityAdded,
+		Identity: identity.String(),
+	})
+	if replaced {
+		s.notifySecurityEvent(ctx, user, recipients, notification.SecurityNotification{
+			Event:    notification.IdentityRemoved,
+			Identity: removed.String(),
+		})
+	}
+
+	user, err = s.withEffectiveRoles(ctx, user)
+	if err != nil {
+		return Session{}, err
+	}
+
+	token, err := user.createScopedToken(claims.authentication(), claims.Scope)
+	if err != nil {
+		return Session{}, fmt.Errorf("could not create token for the user %w", err)
+	}
+
+	return Session{User: user, Token: token}, nil
+}
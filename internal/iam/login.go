@@ -4,8 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/dannypaul/go-skeleton/internal/exception"
+	"github.com/dannypaul/go-skeleton/internal/notification"
 )
 
 func (s svc) Login(ctx context.Context, req LoginReq) (Session, error) {
@@ -22,6 +24,22 @@ func (s svc) Login(ctx context.Context, req LoginReq) (Session, error) {
 		return Session{}, err
 	}
 
+	if user.Status == PendingApproval {
+		return Session{}, errors.New(exception.UserApprovalPending)
+	}
+
+	if user.Status == Rejected {
+		return Session{}, errors.New(exception.UserRejected)
+	}
+
+	if user.Status == Locked {
+		return Session{}, errors.New(exception.UserLocked)
+	}
+
+	if user.Status == Deactivated {
+		return Session{}, errors.New(exception.UserDeactivated)
+	}
+
 	if user.FailedAuthAttempts >= 3 {
 		return Session{}, errors.New(exception.FailedLoginLimitExceeded)
 	}
@@ -32,6 +50,9 @@ func (s svc) Login(ctx context.Context, req LoginReq) (Session, error) {
 
 	if !user.equalsPassword(req.Password) {
 		err = s.userRepo.IncrementById(ctx, user.Id, "failedAuthAttempts", 1)
+		if err == nil && user.FailedAuthAttempts+1 >= 3 {
+			s.notifySecurityEvent(ctx, user, user.Identities, notification.SecurityNotification{Event: notification.AccountLocked})
+		}
 		return Session{}, errors.New(exception.CredentialsInvalid)
 	}
 
@@ -42,10 +63,36 @@ func (s svc) Login(ctx context.Context, req LoginReq) (Session, error) {
 		}
 	}
 
-	token, err := user.createToken(false)
+	user, err = s.rememberClient(ctx, user, true)
 	if err != nil {
-		return Session{}, fmt.Errorf("could not create token for the user %w", err)
+		return Session{}, err
+	}
+
+	return s.createSession(ctx, user, Authentication{Time: time.Now(), Methods: []AuthMethod{PasswordAuth}})
+}
+
+// rememberClient adds the client of the request to the known devices of the
+// user and, with notify, notifies the user when it was not known before.
+func (s svc) rememberClient(ctx context.Context, user User, notify bool) (User, error) {
+	client, ok := ctx.Value(CtxClientKey).(Client)
+	if !ok || client.IP == "" {
+		return user, nil
+	}
+
+	devices, isNewDevice := user.rememberDevice(client, time.Now().UTC())
+	err := s.userRepo.SetById(ctx, user.Id, "knownDevices", devices)
+	if err != nil {
+		return User{}, fmt.Errorf("could not save the known devices of the user %w", err)
+	}
+	user.KnownDevices = devices
+
+	if isNewDevice && notify {
+		s.notifySecurityEvent(ctx, user, user.Identities, notification.SecurityNotification{
+			Event:     notification.NewDeviceLogin,
+			IP:        client.IP,
+			UserAgent: client.UserAgent,
+		})
 	}
 
-	return Session{User: user, Token: token}, nil
+	return user, nil
 }
@@ -1,12 +1,15 @@
 package iam
 
 import (
+	"context"
+	"errors"
 	"time"
 
 	"github.com/dannypaul/go-skeleton/internal/config"
 	"github.com/dannypaul/go-skeleton/internal/exception"
 	"github.com/dannypaul/go-skeleton/internal/primitive"
 	"github.com/dannypaul/go-skeleton/internal/repository"
+	"github.com/dannypaul/go-skeleton/internal/validation"
 
 	"github.com/dgrijalva/jwt-go"
 
@@ -15,6 +18,10 @@ import (
 
 const CtxClaimsKey = "claims"
 
+// CtxVersionKey is the context key of the version of the resource a
+// conditional update expects, as sent in the If-Match header.
+const CtxVersionKey = "version"
+
 type IdentityType string
 
 const (
@@ -22,8 +29,29 @@ const (
 	PHONE IdentityType = "PHONE"
 )
 
+// Phone numbers are stored in E.164 format. Region is the ISO 3166-1 alpha-2
+// code of the region the number belongs to. When a request contains a national
+// number, Region is the region it is dialed from and defaults to the
+// DEFAULT_PHONE_REGION.
 type Phone struct {
-	Number string `bson:"number,omitempty" json:"number"`
+	Number      string `bson:"number,omitempty" json:"number"`
+	CountryCode string `bson:"countryCode,omitempty" json:"countryCode,omitempty"`
+	Region      string `bson:"region,omitempty" json:"region,omitempty"`
+}
+
+func (p Phone) normalise() (Phone, error) {
+	region := p.Region
+	if region == "" {
+		conf, _ := config.Get()
+		region = conf.DefaultPhoneRegion
+	}
+
+	parsed, err := validation.ParsePhone(p.Number, region)
+	if err != nil {
+		return Phone{}, err
+	}
+
+	return Phone{Number: parsed.E164, CountryCode: parsed.CountryCode, Region: parsed.Region}, nil
 }
 
 type Identity struct {
@@ -32,6 +60,23 @@ type Identity struct {
 	Verified bool         `bson:"verified" json:"verified"`
 	EmailId  string       `bson:"emailId,omitempty" json:"emailId"`
 	Phone    *Phone       `bson:"phone,omitempty" json:"phone"`
+
+	// CanonicalEmailId is used for lookups and uniqueness, EmailId keeps the
+	// form the user entered for display
+	CanonicalEmailId string `bson:"canonicalEmailId,omitempty" json:"-"`
+}
+
+func newEmailIdentity(emailId string) (Identity, error) {
+	if err := validation.ValidateEmailId(emailId); err != nil {
+		return Identity{}, err
+	}
+
+	canonicalEmailId, err := validation.CanonicaliseEmailId(emailId)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	return Identity{Type: EMAIL, EmailId: emailId, CanonicalEmailId: canonicalEmailId}, nil
 }
 
 type IdentityList []Identity
@@ -50,16 +95,76 @@ type Role string
 const (
 	PlatformAdmin Role = "PLATFORM_ADMIN"
 	MerchantAdmin Role = "MERCHANT_ADMIN"
+	Member        Role = "MEMBER"
+)
+
+func (r Role) isValid() bool {
+	return r == PlatformAdmin || r == MerchantAdmin || r == Member
+}
+
+func hasRole(roles []Role, role Role) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Scope restricts what a session can be used for.
+type Scope string
+
+// RecoveryScope sessions are issued on recovery code login and can only
+// update the identities and the password of the user. ReportScope tokens are
+// sent in security notifications and can only be used to lock the account.
+// ConsentScope sessions are issued to users who have to accept a new version
+// of a mandatory policy and can only be used to accept it.
+const (
+	RecoveryScope Scope = "RECOVERY"
+	ReportScope   Scope = "REPORT"
+	ConsentScope  Scope = "CONSENT"
+)
+
+type UserStatus string
+
+// Users created before sign-up was introduced have no status and are treated
+// as ACTIVE.
+const (
+	Active          UserStatus = "ACTIVE"
+	PendingApproval UserStatus = "PENDING_APPROVAL"
+	Rejected        UserStatus = "REJECTED"
+	Locked          UserStatus = "LOCKED"
+	Deactivated     UserStatus = "DEACTIVATED"
+	Erased          UserStatus = "ERASED"
 )
 
 type User struct {
-	Id                 primitive.Id `bson:"_id,omitempty" json:"id"`
-	Role               Role         `bson:"role" json:"role"`
-	Name               string       `bson:"name" json:"name"`
-	Version            int          `bson:"version" json:"version"`
-	FailedAuthAttempts int          `bson:"failedAuthAttempts" json:"-"`
-	Identities         IdentityList `bson:"identities" json:"identities"`
-	Password           string       `bson:"password" json:"-"`
+	Id                 primitive.Id   `bson:"_id,omitempty" json:"id"`
+	Role               Role           `bson:"role" json:"role"`
+	Name               string         `bson:"name" json:"name"`
+	Status             UserStatus     `bson:"status,omitempty" json:"status,omitempty"`
+	ExternalId         string         `bson:"externalId,omitempty" json:"externalId,omitempty"`
+	Provisioned        bool           `bson:"provisioned,omitempty" json:"provisioned,omitempty"`
+	Version            int            `bson:"version" json:"version"`
+	FailedAuthAttempts int            `bson:"failedAuthAttempts" json:"-"`
+	Identities         IdentityList   `bson:"identities" json:"identities"`
+	Password           string         `bson:"password" json:"-"`
+	RecoveryCodes      []string       `bson:"recoveryCodes,omitempty" json:"-"`
+	KnownDevices       []Device       `bson:"knownDevices,omitempty" json:"-"`
+	SessionsRevokedAt  time.Time      `bson:"sessionsRevokedAt,omitempty" json:"-"`
+	GroupIds           []primitive.Id `bson:"groupIds,omitempty" json:"groupIds,omitempty"`
+	ErasedAt           *time.Time     `bson:"erasedAt,omitempty" json:"erasedAt,omitempty"`
+
+	// CreatedAt and UpdatedAt are stamped by the collection, on drivers which
+	// support timestamps
+	CreatedAt *time.Time `bson:"createdAt,omitempty" json:"createdAt,omitempty"`
+	UpdatedAt *time.Time `bson:"updatedAt,omitempty" json:"updatedAt,omitempty"`
+
+	// Roles are the effective roles of the user, the union of Role and the
+	// roles of their groups. They are not stored.
+	Roles []Role `bson:"-" json:"roles,omitempty"`
+
+	Attributes map[string]interface{} `bson:"attributes,omitempty" json:"attributes,omitempty"`
 }
 
 func (u User) equalsPassword(password string) bool {
@@ -77,20 +182,89 @@ type UserList struct {
 	Page  repository.Page `json:"page"`
 }
 
-func (u User) createToken(verified bool) (string, error) {
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 500
+)
+
+// pageLimit returns the size of the pages of a list requested with limit. It
+// is the default size when limit is 0 and at most maxPageLimit.
+func pageLimit(limit int64) (int64, error) {
+	switch {
+	case limit < 0:
+		return 0, errors.New(exception.LimitInvalid)
+	case limit == 0:
+		return defaultPageLimit, nil
+	case limit > maxPageLimit:
+		return maxPageLimit, nil
+	}
+	return limit, nil
+}
+
+// checkVersion fails with exception.ErrVersionConflict when the update expects
+// a version of the resource other than the current one.
+func checkVersion(ctx context.Context, current int) error {
+	if expected, ok := ctx.Value(CtxVersionKey).(int); ok && expected != current {
+		return exception.ErrVersionConflict
+	}
+	return nil
+}
+
+func (u User) createToken(auth Authentication) (string, error) {
+	return u.createScopedToken(auth, "")
+}
+
+// createScopedToken creates a token which can only be used for the actions
+// allowed by the scope. An empty scope allows every action.
+func (u User) createScopedToken(auth Authentication, scope Scope) (string, error) {
 	conf, _ := config.Get()
+	ttl := conf.JwtTTL
+	if scope == ReportScope {
+		ttl = conf.ReportTokenTTL
+	}
+
+	now := time.Now().UTC()
 	claims := &Claims{
-		UserId:         u.Id,
-		UserVersion:    u.Version,
-		Role:           u.Role,
-		Verified:       verified,
-		StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().UTC().Add(conf.JwtTTL).Unix()},
+		UserId:      u.Id,
+		UserVersion: u.Version,
+		Role:        u.Role,
+		Roles:       u.Roles,
+		Verified:    auth.hasAnyMethod(OtpAuth, RecoveryCodeAuth),
+		Scope:       scope,
+		Amr:         auth.Methods,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: now.Add(ttl).Unix(),
+			IssuedAt:  now.Unix(),
+		},
+	}
+	if !auth.Time.IsZero() {
+		claims.AuthTime = auth.Time.Unix()
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString([]byte(conf.JwtSecret))
 }
 
+// ParseToken verifies the signature and the expiry of a token created by
+// createScopedToken and returns its claims.
+func ParseToken(tokenString string) (Claims, error) {
+	conf, _ := config.Get()
+
+	var claims Claims
+	token, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(conf.JwtSecret), nil
+	})
+	if err != nil || !token.Valid || claims.UserId == "" {
+		return Claims{}, errors.New(exception.Unauthorised)
+	}
+
+	return claims, nil
+}
+
 type Session struct {
 	User  User   `json:"user"`
 	Token string `json:"token"`
+
+	// ConsentRequired is set when the user has to accept the Policies
+	ConsentRequired bool     `json:"consentRequired,omitempty"`
+	Policies        []Policy `json:"policies,omitempty"`
 }
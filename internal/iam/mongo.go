@@ -2,22 +2,46 @@ package iam
 
 import (
 	"github.com/dannypaul/go-skeleton/internal/config"
+	"github.com/dannypaul/go-skeleton/internal/driver/encryption"
 	"github.com/dannypaul/go-skeleton/internal/driver/platform/mongo"
 )
 
+// encryptedFields returns the encrypted fields of a collection, or nil when
+// the cipher is nil. The email IDs and phone numbers are encrypted
+// deterministically, as they are looked up and in unique indexes.
+func encryptedFields(cipher *encryption.Cipher, paths ...string) *encryption.Fields {
+	if cipher == nil {
+		return nil
+	}
+
+	fields := make([]encryption.Field, len(paths))
+	for i, path := range paths {
+		fields[i] = encryption.Field{Path: path, Mode: encryption.Deterministic}
+	}
+	return encryption.NewFields(cipher, fields...)
+}
+
 const UserCollectionName = "users"
 
 type mongoUserRepo struct {
 	mongo.Collection
 }
 
-func NewMongoUserRepo(client *mongo.Client) (UserRepo, error) {
+// NewMongoUserRepo returns the users repository, which encrypts the email IDs
+// and phone numbers of the identities when the cipher is not nil.
+func NewMongoUserRepo(client *mongo.Client, cipher *encryption.Cipher) (UserRepo, error) {
 	conf, err := config.Get()
 	if err != nil {
 		return nil, err
 	}
 
-	collection := mongo.Collection{Collection: client.Database(conf.MongoDatabasebName).Collection(UserCollectionName)}
+	// Deleted users are kept, so that the references to them stay valid
+	collection := mongo.Collection{
+		Collection: client.Database(conf.MongoDatabasebName).Collection(UserCollectionName),
+		Timestamps: true,
+		SoftDelete: true,
+		Encryption: encryptedFields(cipher, "identities.emailId", "identities.canonicalEmailId", "identities.phone.number"),
+	}
 
 	return mongoUserRepo{collection}, err
 }
@@ -28,13 +52,122 @@ type mongoChallengeRepo struct {
 	mongo.Collection
 }
 
-func NewMongoChallengeRepo(client *mongo.Client) (ChallengeRepo, error) {
+// NewMongoChallengeRepo returns the challenges repository, which encrypts the
+// email IDs and phone numbers when the cipher is not nil.
+func NewMongoChallengeRepo(client *mongo.Client, cipher *encryption.Cipher) (ChallengeRepo, error) {
 	conf, err := config.Get()
 	if err != nil {
 		return nil, err
 	}
 
-	collection := mongo.Collection{Collection: client.Database(conf.MongoDatabasebName).Collection(ChallengeCollectionName)}
+	// Challenges keep their own timestamps, as updatedAt is the time the OTP
+	// was last sent
+	collection := mongo.Collection{
+		Collection: client.Database(conf.MongoDatabasebName).Collection(ChallengeCollectionName),
+		Encryption: encryptedFields(cipher, "emailId", "canonicalEmailId", "phone.number"),
+	}
 
 	return mongoChallengeRepo{collection}, err
 }
+
+const AttributeCollectionName = "attributes"
+
+type mongoAttributeRepo struct {
+	mongo.Collection
+}
+
+func NewMongoAttributeRepo(client *mongo.Client) (AttributeRepo, error) {
+	conf, err := config.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	collection := mongo.Collection{Collection: client.Database(conf.MongoDatabasebName).Collection(AttributeCollectionName)}
+
+	return mongoAttributeRepo{collection}, err
+}
+
+const GroupCollectionName = "groups"
+
+type mongoGroupRepo struct {
+	mongo.Collection
+}
+
+func NewMongoGroupRepo(client *mongo.Client) (GroupRepo, error) {
+	conf, err := config.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	collection := mongo.Collection{Collection: client.Database(conf.MongoDatabasebName).Collection(GroupCollectionName)}
+
+	return mongoGroupRepo{collection}, err
+}
+
+const PolicyCollectionName = "policies"
+
+type mongoPolicyRepo struct {
+	mongo.Collection
+}
+
+func NewMongoPolicyRepo(client *mongo.Client) (PolicyRepo, error) {
+	conf, err := config.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	collection := mongo.Collection{Collection: client.Database(conf.MongoDatabasebName).Collection(PolicyCollectionName)}
+
+	return mongoPolicyRepo{collection}, err
+}
+
+const ConsentCollectionName = "consents"
+
+type mongoConsentRepo struct {
+	mongo.Collection
+}
+
+func NewMongoConsentRepo(client *mongo.Client) (ConsentRepo, error) {
+	conf, err := config.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	collection := mongo.Collection{Collection: client.Database(conf.MongoDatabasebName).Collection(ConsentCollectionName)}
+
+	return mongoConsentRepo{collection}, err
+}
+
+const AuditCollectionName = "audit"
+
+type mongoAuditRepo struct {
+	mongo.Collection
+}
+
+func NewMongoAuditRepo(client *mongo.Client) (AuditRepo, error) {
+	conf, err := config.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	collection := mongo.Collection{Collection: client.Database(conf.MongoDatabasebName).Collection(AuditCollectionName)}
+
+	return mongoAuditRepo{collection}, err
+}
+
+const ErasureCollectionName = "erasures"
+
+type mongoErasureRepo struct {
+	mongo.Collection
+}
+
+func NewMongoErasureRepo(client *mongo.Client) (ErasureRepo, error) {
+	conf, err := config.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	collection := mongo.Collection{Collection: client.Database(conf.MongoDatabasebName).Collection(ErasureCollectionName)}
+
+	return mongoErasureRepo{collection}, err
+}
@@ -0,0 +1,376 @@
+package iam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dannypaul/go-skeleton/internal/config"
+	"github.com/dannypaul/go-skeleton/internal/exception"
+	"github.com/dannypaul/go-skeleton/internal/primitive"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+
+	"github.com/rs/zerolog/log"
+)
+
+// UserExport is the personal data held about a user, for data subject access
+// requests. Sessions are not stored, the devices the user signed in from are
+// exported instead.
+type UserExport struct {
+	ExportedAt   time.Time      `json:"exportedAt"`
+	User         User           `json:"user"`
+	Identities   IdentityList   `json:"identities"`
+	Sessions     SessionsExport `json:"sessions"`
+	Groups       []Group        `json:"groups"`
+	Consents     []Consent      `json:"consents"`
+	Challenges   []Challenge    `json:"challenges"`
+	Erasures     []Erasure      `json:"erasures"`
+	AuditEntries []AuditEntry   `json:"auditEntries"`
+}
+
+type SessionsExport struct {
+	KnownDevices []Device   `json:"knownDevices"`
+	RevokedAt    *time.Time `json:"revokedAt,omitempty"`
+}
+
+// verifySubjectAccess allows platform administrators and the user themselves,
+// after a recent authentication, to act on the data of the user.
+func verifySubjectAccess(ctx context.Context, userId primitive.Id) (Claims, error) {
+	claims, err := VerifySession(ctx, []Role{PlatformAdmin, MerchantAdmin, Member})
+	if err != nil {
+		return Claims{}, err
+	}
+
+	if claims.Role == PlatformAdmin || hasRole(claims.Roles, PlatformAdmin) {
+		return claims, nil
+	}
+
+	if claims.UserId != userId {
+		return Claims{}, errors.New(exception.Forbidden)
+	}
+
+	conf, _ := config.Get()
+	return claims, requireRecentAuth(claims, conf.PrivacyStepUpMaxAge, OtpAuth, PasswordAuth)
+}
+
+func (s svc) ExportUser(ctx context.Context, userId primitive.Id) (UserExport, error) {
+	_, err := verifySubjectAccess(ctx, userId)
+	if err != nil {
+		return UserExport{}, err
+	}
+
+	user, err := s.findUserById(ctx, userId)
+	if err != nil {
+		return UserExport{}, err
+	}
+
+	groups, err := s.userGroups(ctx, user)
+	if err != nil {
+		return UserExport{}, err
+	}
+
+	consents, err := s.consents.FindAll(ctx, []repository.Filter{{Key: "userId", Value: user.Id}})
+	if err != nil {
+		return UserExport{}, fmt.Errorf("could not find the consents of the user %w", err)
+	}
+
+	challenges, err := s.userChallenges(ctx, user)
+	if err != nil {
+		return UserExport{}, err
+	}
+
+	erasures, err := s.erasures.FindAll(ctx, []repository.Filter{{Key: "userId", Value: user.Id}})
+	if err != nil {
+		return UserExport{}, fmt.Errorf("could not find the erasures of the user %w", err)
+	}
+
+	// The export is audited before the audit entries are read so that the
+	// archive contains its own export
+	err = s.recordAudit(ctx, DataExported, user.Id)
+	if err != nil {
+		return UserExport{}, err
+	}
+
+	entries, err := s.auditEntries(ctx, user.Id)
+	if err != nil {
+		return UserExport{}, err
+	}
+
+	export := UserExport{
+		ExportedAt:   time.Now().UTC(),
+		User:         user,
+		Identities:   user.Identities,
+		Sessions:     SessionsExport{KnownDevices: user.KnownDevices},
+		Groups:       groups,
+		Consents:     consents,
+		Challenges:   challenges,
+		Erasures:     erasures,
+		AuditEntries: entries,
+	}
+	if !user.SessionsRevokedAt.IsZero() {
+		export.Sessions.RevokedAt = &user.SessionsRevokedAt
+	}
+
+	return export, nil
+}
+
+// userChallenges returns the pending challenges of the identities of the user.
+func (s svc) userChallenges(ctx context.Context, user User) ([]Challenge, error) {
+	challenges := []Challenge{}
+	for _, identity := range user.Identities {
+		var filters []repository.Filter
+		if identity.Type == EMAIL && identity.CanonicalEmailId != "" {
+			filters = []repository.Filter{{Key: "canonicalEmailId", Value: identity.CanonicalEmailId}}
+		}
+		if identity.Type == PHONE && identity.Phone != nil {
+			filters = []repository.Filter{{Key: "phone.number", Value: identity.Phone.Number}}
+		}
+		if filters == nil {
+			continue
+		}
+
+		identityChallenges, err := s.challenges.FindAll(ctx, filters)
+		if err != nil {
+			return nil, fmt.Errorf("could not find the challenges of the user %w", err)
+		}
+		challenges = append(challenges, identityChallenges...)
+	}
+	return challenges, nil
+}
+
+type ErasureStatus string
+
+const (
+	ErasureScheduled ErasureStatus = "SCHEDULED"
+	ErasureCancelled ErasureStatus = "CANCELLED"
+	ErasureCompleted ErasureStatus = "COMPLETED"
+)
+
+// Erasure is a request to erase the personal data of a user. Completed
+// erasures are the tombstones of the erased users.
+type Erasure struct {
+	Id          primitive.Id  `bson:"_id,omitempty" json:"id"`
+	UserId      primitive.Id  `bson:"userId" json:"userId"`
+	Status      ErasureStatus `bson:"status" json:"status"`
+	RequestedBy primitive.Id  `bson:"requestedBy" json:"requestedBy"`
+	RequestedAt time.Time     `bson:"requestedAt" json:"requestedAt"`
+	ScheduledAt time.Time     `bson:"scheduledAt" json:"scheduledAt"`
+	CompletedAt *time.Time    `bson:"completedAt,omitempty" json:"completedAt,omitempty"`
+}
+
+func (s svc) findScheduledErasure(ctx context.Context, userId primitive.Id) (Erasure, error) {
+	erasure, err := s.erasures.FindSingle(ctx, []repository.Filter{
+		{Key: "userId", Value: userId},
+		{Key: "status", Value: ErasureScheduled},
+	})
+	if err != nil {
+		if errors.Is(err, exception.ErrNotFound) {
+			return Erasure{}, errors.New(exception.ErasureNotRequested)
+		}
+		return Erasure{}, err
+	}
+	return erasure, nil
+}
+
+// RequestErasure schedules the erasure of the user after ERASURE_GRACE_PERIOD.
+// The user is erased immediately when the grace period is zero.
+func (s svc) RequestErasure(ctx context.Context, userId primitive.Id) (Erasure, error) {
+	claims, err := verifySubjectAccess(ctx, userId)
+	if err != nil {
+		return Erasure{}, err
+	}
+
+	user, err := s.findUserById(ctx, userId)
+	if err != nil {
+		return Erasure{}, err
+	}
+
+	if user.Status == Erased {
+		return Erasure{}, errors.New(exception.ErasureAlreadyRequested)
+	}
+
+	_, err = s.findScheduledErasure(ctx, user.Id)
+	if err == nil {
+		return Erasure{}, errors.New(exception.ErasureAlreadyRequested)
+	}
+	if err.Error() != exception.ErasureNotRequested {
+		return Erasure{}, err
+	}
+
+	conf, _ := config.Get()
+	now := time.Now().UTC()
+	var erasure Erasure
+	err = s.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		erasure, err = s.erasures.Create(ctx, Erasure{
+			UserId:      user.Id,
+			Status:      ErasureScheduled,
+			RequestedBy: claims.UserId,
+			RequestedAt: now,
+			ScheduledAt: now.Add(conf.ErasureGracePeriod),
+		})
+		if err != nil {
+			if errors.Is(err, exception.ErrConflict) {
+				return errors.New(exception.ErasureAlreadyRequested)
+			}
+			return fmt.Errorf("could not schedule the erasure of the user %w", err)
+		}
+
+		err = s.recordAudit(ctx, ErasureRequested, user.Id)
+		if err != nil {
+			return err
+		}
+
+		if conf.ErasureGracePeriod <= 0 {
+			erasure, err = s.erase(ctx, erasure)
+		}
+		return err
+	})
+	if err != nil {
+		return Erasure{}, err
+	}
+	return erasure, nil
+}
+
+func (s svc) CancelErasure(ctx context.Context, userId primitive.Id) (Erasure, error) {
+	_, err := verifySubjectAccess(ctx, userId)
+	if err != nil {
+		return Erasure{}, err
+	}
+
+	erasure, err := s.findScheduledErasure(ctx, userId)
+	if err != nil {
+		return Erasure{}, err
+	}
+
+	err = s.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		err := s.claimErasure(ctx, erasure, []repository.Patch{{Action: "$set", Key: "status", Value: ErasureCancelled}})
+		if err != nil {
+			return err
+		}
+		return s.recordAudit(ctx, ErasureWithdrawn, userId)
+	})
+	if err != nil {
+		return Erasure{}, err
+	}
+	erasure.Status = ErasureCancelled
+
+	return erasure, nil
+}
+
+// EraseDueUsers erases the users whose grace period has ended. It is run
+// periodically and returns the number of users erased.
+func (s svc) EraseDueUsers(ctx context.Context) (int, error) {
+	iterator, err := s.erasureRepo.Stream(ctx, repository.Query{Filters: []repository.Filter{
+		{Key: "status", Value: ErasureScheduled},
+		{Key: "scheduledAt", Operator: repository.Lte, Value: time.Now()},
+	}})
+	if err != nil {
+		return 0, fmt.Errorf("could not find the scheduled erasures %w", err)
+	}
+	defer iterator.Close(ctx)
+
+	erased := 0
+	for iterator.Next(ctx) {
+		var erasure Erasure
+		err = iterator.Decode(&erasure)
+		if err != nil {
+			return erased, err
+		}
+
+		_, err = s.erase(ctx, erasure)
+		if err != nil {
+			// The erasure was cancelled, or erased by another replica
+			if err.Error() == exception.ErasureNotRequested {
+				continue
+			}
+			log.Error().Err(err).Str("userId", erasure.UserId.String()).Msg("Could not erase the user")
+			continue
+		}
+		erased++
+	}
+	if err = iterator.Err(); err != nil {
+		return erased, fmt.Errorf("could not find the scheduled erasures %w", err)
+	}
+	return erased, nil
+}
+
+// claimErasure applies the patches to the erasure if it is still scheduled,
+// and otherwise fails with exception.ErasureNotRequested. It changes the
+// status of the erasure from scheduled, so that the erasure is cancelled or
+// completed only once, even by concurrent replicas.
+func (s svc) claimErasure(ctx context.Context, erasure Erasure, patchers []repository.Patch) error {
+	claimed, err := s.erasureRepo.UpdateMany(ctx, []repository.Filter{
+		{Key: "_id", Value: erasure.Id},
+		{Key: "status", Value: ErasureScheduled},
+	}, patchers)
+	if err != nil {
+		return fmt.Errorf("could not update the erasure of the user %w", err)
+	}
+	if claimed == 0 {
+		return errors.New(exception.ErasureNotRequested)
+	}
+	return nil
+}
+
+// erase anonymises the personal data of the user and deletes their challenges.
+// The user document is kept without identities, so the email ID and the phone
+// number can be registered again. The erasure is completed before the user is
+// erased, so that a replica erasing it at the same time erases nothing.
+func (s svc) erase(ctx context.Context, erasure Erasure) (Erasure, error) {
+	err := s.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		now := time.Now().UTC()
+		err := s.claimErasure(ctx, erasure, []repository.Patch{
+			{Action: "$set", Key: "status", Value: ErasureCompleted},
+			{Action: "$set", Key: "completedAt", Value: now},
+		})
+		if err != nil {
+			return err
+		}
+		erasure.Status = ErasureCompleted
+		erasure.CompletedAt = &now
+
+		user, err := s.findUserById(ctx, erasure.UserId)
+		if err != nil {
+			return err
+		}
+
+		challenges, err := s.userChallenges(ctx, user)
+		if err != nil {
+			return err
+		}
+		for _, challenge := range challenges {
+			_, err = s.challengeRepo.Delete(ctx, challenge.Id)
+			if err != nil {
+				return fmt.Errorf("could not delete the challenge %w", err)
+			}
+		}
+
+		patchers := []repository.Patch{
+			{Action: "$set", Key: "status", Value: Erased},
+			{Action: "$set", Key: "erasedAt", Value: now},
+			{Action: "$set", Key: "sessionsRevokedAt", Value: now},
+			{Action: "$set", Key: "name", Value: ""},
+			{Action: "$set", Key: "identities", Value: IdentityList{}},
+			{Action: "$set", Key: "password", Value: ""},
+			{Action: "$unset", Key: "recoveryCodes", Value: ""},
+			{Action: "$unset", Key: "knownDevices", Value: ""},
+			{Action: "$unset", Key: "attributes", Value: ""},
+			{Action: "$unset", Key: "externalId", Value: ""},
+			{Action: "$unset", Key: "groupIds", Value: ""},
+			{Action: "$inc", Key: "version", Value: 1},
+		}
+		err = s.userRepo.Patch(ctx, user.Id, patchers)
+		if err != nil {
+			return fmt.Errorf("could not erase the user %w", err)
+		}
+
+		return s.recordAudit(ctx, UserErased, user.Id)
+	})
+	if err != nil {
+		return Erasure{}, err
+	}
+
+	return erasure, nil
+}
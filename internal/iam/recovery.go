@@ -0,0 +1,173 @@
+package iam
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dannypaul/go-skeleton/internal/config"
+	"github.com/dannypaul/go-skeleton/internal/exception"
+	"github.com/dannypaul/go-skeleton/internal/kit"
+	"github.com/dannypaul/go-skeleton/internal/notification"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+)
+
+const recoveryCodeCount = 10
+
+type RecoveryCodes struct {
+	Codes []string `json:"codes"`
+}
+
+// hashRecoveryCode hashes a normalised recovery code. The codes are random
+// and long enough that a fast hash is sufficient, unlike passwords.
+func hashRecoveryCode(code string) string {
+	normalised := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalised))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateRecoveryCodes replaces the recovery codes of the signed in user. The
+// codes are returned in plain text only once, only their hashes are stored.
+func (s svc) GenerateRecoveryCodes(ctx context.Context) (RecoveryCodes, error) {
+	claims, err := VerifyActionToken(ctx)
+	if err != nil {
+		return RecoveryCodes{}, err
+	}
+
+	if claims.Scope != "" {
+		return RecoveryCodes{}, errors.New(exception.Forbidden)
+	}
+
+	user, err := s.findUserById(ctx, claims.UserId)
+	if err != nil {
+		return RecoveryCodes{}, err
+	}
+
+	if claims.UserVersion != user.Version {
+		return RecoveryCodes{}, errors.New(exception.Unauthorised)
+	}
+
+	conf, _ := config.Get()
+	if err := requireRecentAuth(claims, conf.RecoveryCodesStepUpMaxAge, OtpAuth, PasswordAuth); err != nil {
+		return RecoveryCodes{}, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		codes[i], err = kit.GenerateRecoveryCode()
+		if err != nil {
+			return RecoveryCodes{}, fmt.Errorf("could not generate a recovery code %w", err)
+		}
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	patchers := []repository.Patch{
+		{Action: "$set", Key: "recoveryCodes", Value: hashes},
+		{Action: "$inc", Key: "version", Value: 1},
+	}
+	err = s.userRepo.Patch(ctx, user.Id, patchers)
+	if err != nil {
+		return RecoveryCodes{}, fmt.Errorf("could not save the recovery codes %w", err)
+	}
+
+	return RecoveryCodes{Codes: codes}, nil
+}
+
+// Recover consumes a recovery code and returns a session restricted to
+// RecoveryScope. Every identity of the user is notified about it.
+func (s svc) Recover(ctx context.Context, req RecoverReq) (Session, error) {
+	identity := Identity{
+		Type:    req.IdentityType,
+		EmailId: req.EmailId,
+		Phone:   &req.Phone,
+	}
+	user, err := s.FindUserByIdentity(ctx, identity)
+	if err != nil {
+		if errors.Is(err, exception.ErrNotFound) {
+			return Session{}, errors.New(exception.UserNotFound)
+		}
+		return Session{}, err
+	}
+
+	if user.Status == PendingApproval {
+		return Session{}, errors.New(exception.UserApprovalPending)
+	}
+
+	if user.Status == Rejected {
+		return Session{}, errors.New(exception.UserRejected)
+	}
+
+	if user.Status == Locked {
+		return Session{}, errors.New(exception.UserLocked)
+	}
+
+	if user.Status == Deactivated {
+		return Session{}, errors.New(exception.UserDeactivated)
+	}
+
+	if user.FailedAuthAttempts >= 3 {
+		return Session{}, errors.New(exception.FailedLoginLimitExceeded)
+	}
+
+	hash := hashRecoveryCode(req.Code)
+	remainingCodes := make([]string, 0, len(user.RecoveryCodes))
+	for _, recoveryCode := range user.RecoveryCodes {
+		if recoveryCode != hash {
+			remainingCodes = append(remainingCodes, recoveryCode)
+		}
+	}
+
+	if len(remainingCodes) == len(user.RecoveryCodes) {
+		err = s.userRepo.IncrementById(ctx, user.Id, "failedAuthAttempts", 1)
+		if err == nil && user.FailedAuthAttempts+1 >= 3 {
+			s.notifySecurityEvent(ctx, user, user.Identities, notification.SecurityNotification{Event: notification.AccountLocked})
+		}
+		return Session{}, errors.New(exception.RecoveryCodeInvalid)
+	}
+
+	// The code is consumed only when the user is unchanged since it was
+	// checked, so that concurrent requests cannot both use it
+	patchers := []repository.Patch{
+		{Action: "$pull", Key: "recoveryCodes", Value: hash},
+		{Action: "$set", Key: "failedAuthAttempts", Value: 0},
+	}
+	err = s.userRepo.PatchIfVersion(ctx, user.Id, user.Version, patchers)
+	if err != nil {
+		if errors.Is(err, exception.ErrVersionConflict) {
+			return Session{}, err
+		}
+		return Session{}, fmt.Errorf("could not consume the recovery code %w", err)
+	}
+
+	user.RecoveryCodes = remainingCodes
+	user.FailedAuthAttempts = 0
+	user.Version += 1
+
+	user, err = s.rememberClient(ctx, user, true)
+	if err != nil {
+		return Session{}, err
+	}
+
+	user, err = s.withEffectiveRoles(ctx, user)
+	if err != nil {
+		return Session{}, err
+	}
+
+	auth := Authentication{Time: time.Now(), Methods: []AuthMethod{RecoveryCodeAuth}}
+	token, err := user.createScopedToken(auth, RecoveryScope)
+	if err != nil {
+		return Session{}, fmt.Errorf("could not create token for the user %w", err)
+	}
+
+	s.notifySecurityEvent(ctx, user, user.Identities, notification.SecurityNotification{
+		Event:     notification.RecoveryCodeUsed,
+		Remaining: len(remainingCodes),
+	})
+
+	return Session{User: user, Token: token}, nil
+}
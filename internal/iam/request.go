@@ -1,5 +1,7 @@
 package iam
 
+import "github.com/dannypaul/go-skeleton/internal/primitive"
+
 type VerifyReq struct {
 	IdentityType IdentityType `json:"identityType"`
 
@@ -29,3 +31,39 @@ type LoginReq struct {
 
 	Password string `json:"password"`
 }
+
+type SignupReq struct {
+	Name         string       `json:"name"`
+	IdentityType IdentityType `json:"identityType"`
+
+	EmailId string `json:"emailId"`
+	Phone   Phone  `json:"phone"`
+}
+
+type RecoverReq struct {
+	IdentityType IdentityType `json:"identityType"`
+
+	EmailId string `json:"emailId"`
+	Phone   Phone  `json:"phone"`
+
+	Code string `json:"code"`
+}
+
+type UpdateIdentityReq struct {
+	IdentityType IdentityType `json:"identityType"`
+
+	EmailId string `json:"emailId"`
+	Phone   Phone  `json:"phone"`
+}
+
+type StepUpReq struct {
+	Password string `json:"password"`
+}
+
+type AcceptPoliciesReq struct {
+	PolicyIds []primitive.Id `json:"policyIds"`
+}
+
+type ReportReq struct {
+	Token string `json:"token"`
+}
@@ -1,16 +1,28 @@
 package iam
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
 	"net/http"
+	"strings"
 
 	"github.com/go-chi/chi"
 
+	"github.com/dannypaul/go-skeleton/internal/config"
+	"github.com/dannypaul/go-skeleton/internal/kit/http/header"
 	"github.com/dannypaul/go-skeleton/internal/primitive"
 	"github.com/dannypaul/go-skeleton/internal/rest"
+	"github.com/dannypaul/go-skeleton/internal/throttle"
+	"github.com/dannypaul/go-skeleton/internal/validation"
+
+	"github.com/rs/zerolog/log"
 )
 
-func Router(svc Svc) *chi.Mux {
-	resource := resource{svc}
+func Router(svc Svc, limiter throttle.Limiter) *chi.Mux {
+	resource := resource{svc, limiter}
 
 	router := chi.NewRouter()
 
@@ -18,17 +30,131 @@ func Router(svc Svc) *chi.Mux {
 	router.Post("/challenges/{challengeId}/resend", resource.challenge)
 	router.Post("/verify", resource.verify)
 
+	router.Post("/signup", resource.signup)
+
 	router.Post("/login", resource.login)
+	router.Post("/recover", resource.recover)
+	router.Get("/report", resource.reportLink)
+	router.Post("/report", resource.report)
+	router.Post("/step-up", resource.stepUp)
 
 	router.Get("/users/me", resource.findMe)
+	router.Post("/users/me/recovery-codes", resource.generateRecoveryCodes)
+	router.Patch("/users/me/attributes", resource.updateMyAttributes)
 	router.Get("/users/{userId}", resource.findUser)
 	router.Put("/users/{userId}/password", resource.updatePassword)
+	router.Put("/users/{userId}/identities", resource.updateIdentity)
+	router.Patch("/users/{userId}/attributes", resource.updateUserAttributes)
+
+	router.Get("/attributes", resource.listAttributes)
+	router.Put("/attributes/{name}", resource.saveAttribute)
+	router.Delete("/attributes/{name}", resource.deleteAttribute)
+	router.Post("/users/{userId}/approve", resource.approveUser)
+	router.Post("/users/{userId}/reject", resource.rejectUser)
+	router.Post("/users/{userId}/unlock", resource.unlockUser)
+	router.Get("/users/{userId}/groups", resource.listUserGroups)
+	router.Get("/users/{userId}/export", resource.exportUser)
+	router.Post("/users/{userId}/erasure", resource.requestErasure)
+	router.Delete("/users/{userId}/erasure", resource.cancelErasure)
+
+	router.Get("/policies", resource.listPolicies)
+	router.Post("/policies", resource.publishPolicy)
+	router.Get("/policies/coverage", resource.policyCoverage)
+	router.Post("/consents", resource.acceptPolicies)
+
+	router.Get("/groups", resource.listGroups)
+	router.Post("/groups", resource.createGroup)
+	router.Get("/groups/{groupId}", resource.findGroup)
+	router.Put("/groups/{groupId}", resource.updateGroup)
+	router.Delete("/groups/{groupId}", resource.deleteGroup)
+	router.Get("/groups/{groupId}/members", resource.listGroupMembers)
+	router.Put("/groups/{groupId}/members/{userId}", resource.addGroupMember)
+	router.Delete("/groups/{groupId}/members/{userId}", resource.removeGroupMember)
 
 	return router
 }
 
+// withClient adds the device the request was sent from to the context.
+func withClient(r *http.Request) context.Context {
+	client := Client{IP: rest.ClientIP(r), UserAgent: r.UserAgent()}
+	return context.WithValue(r.Context(), CtxClientKey, client)
+}
+
+// withExpectedVersion adds the version of the resource the If-Match header
+// expects to the context of a conditional update.
+func withExpectedVersion(r *http.Request) (context.Context, error) {
+	version, ok, err := rest.IfMatch(r)
+	if err != nil || !ok {
+		return r.Context(), err
+	}
+	return context.WithValue(r.Context(), CtxVersionKey, version), nil
+}
+
 type resource struct {
-	svc Svc
+	svc     Svc
+	limiter throttle.Limiter
+}
+
+// throttle limits the attempts of an action per client IP, per identity and
+// per user. The user is the one the identity belongs to, or the user of the
+// session when the request has no identity, so that the identities of a user
+// share a single limit.
+func (res resource) throttle(r *http.Request, action string, identityType IdentityType, emailId string, phone Phone) error {
+	conf, _ := config.Get()
+
+	rules := []throttle.Rule{{
+		Key:    action + ":ip:" + rest.ClientIP(r),
+		Limit:  conf.ThrottleIpLimit,
+		Window: conf.ThrottleWindow,
+	}}
+
+	// Identities are canonicalised so that variations of the same email ID or
+	// phone number share a single limit
+	identity := emailId
+	if canonicalEmailId, err := validation.CanonicaliseEmailId(emailId); err == nil {
+		identity = canonicalEmailId
+	}
+	if identityType == PHONE {
+		identity = phone.Number
+		if normalised, err := phone.normalise(); err == nil {
+			identity = normalised.Number
+		}
+	}
+	var userId primitive.Id
+	if identity != "" {
+		rules = append(rules, throttle.Rule{
+			Key:    action + ":identity:" + hashThrottleIdentity(identity, conf.JwtSecret),
+			Limit:  conf.ThrottleIdentityLimit,
+			Window: conf.ThrottleWindow,
+		})
+
+		// Identities which belong to no user, or which are not valid, are
+		// only limited by the rules above
+		user, err := res.svc.FindUserByIdentity(r.Context(), Identity{Type: identityType, EmailId: emailId, Phone: &phone})
+		if err == nil {
+			userId = user.Id
+		}
+	} else if claims, ok := r.Context().Value(CtxClaimsKey).(Claims); ok {
+		userId = claims.UserId
+	}
+
+	if userId != "" {
+		rules = append(rules, throttle.Rule{
+			Key:    action + ":user:" + userId.String(),
+			Limit:  conf.ThrottleUserLimit,
+			Window: conf.ThrottleWindow,
+		})
+	}
+
+	return res.limiter.Allow(r.Context(), rules...)
+}
+
+// hashThrottleIdentity keys the limits of an identity with an HMAC of it, so
+// that the throttle store holds no email IDs or phone numbers.
+func hashThrottleIdentity(identity string, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("throttle:" + identity))
+	return hex.EncodeToString(mac.Sum(nil))
 }
 
 func (res resource) challenge(w http.ResponseWriter, r *http.Request) {
@@ -37,6 +163,11 @@ func (res resource) challenge(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := res.throttle(r, "challenge", req.IdentityType, req.EmailId, req.Phone); err != nil {
+		rest.EncodeRes(w, r, nil, err)
+		return
+	}
+
 	startedVerification, err := res.svc.Challenge(r.Context(), req)
 	rest.EncodeRes(w, r, startedVerification, err)
 }
@@ -47,7 +178,12 @@ func (res resource) login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	session, err := res.svc.Login(r.Context(), req)
+	if err := res.throttle(r, "login", req.IdentityType, req.EmailId, req.Phone); err != nil {
+		rest.EncodeRes(w, r, nil, err)
+		return
+	}
+
+	session, err := res.svc.Login(withClient(r), req)
 	rest.EncodeRes(w, r, session, err)
 }
 
@@ -57,7 +193,12 @@ func (res resource) verify(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	session, err := res.svc.Verify(r.Context(), req)
+	if err := res.throttle(r, "verify", req.IdentityType, req.EmailId, req.Phone); err != nil {
+		rest.EncodeRes(w, r, nil, err)
+		return
+	}
+
+	session, err := res.svc.Verify(withClient(r), req)
 	rest.EncodeRes(w, r, session, err)
 }
 
@@ -73,10 +214,348 @@ func (res resource) updatePassword(w http.ResponseWriter, r *http.Request) {
 
 func (res resource) findMe(w http.ResponseWriter, r *http.Request) {
 	user, err := res.svc.FindMe(r.Context())
+	if err == nil {
+		rest.SetETag(w, user.Version)
+	}
 	rest.EncodeRes(w, r, user, err)
 }
 
 func (res resource) findUser(w http.ResponseWriter, r *http.Request) {
 	user, err := res.svc.FindUser(r.Context(), primitive.Id(chi.URLParam(r, "userId")))
+	if err == nil {
+		rest.SetETag(w, user.Version)
+	}
+	rest.EncodeRes(w, r, user, err)
+}
+
+func (res resource) signup(w http.ResponseWriter, r *http.Request) {
+	var req SignupReq
+	if rest.DecodeReq(w, r, &req) != nil {
+		return
+	}
+
+	user, err := res.svc.Signup(r.Context(), req)
+	rest.EncodeRes(w, r, user, err)
+}
+
+func (res resource) approveUser(w http.ResponseWriter, r *http.Request) {
+	user, err := res.svc.ApproveUser(r.Context(), primitive.Id(chi.URLParam(r, "userId")))
+	rest.EncodeRes(w, r, user, err)
+}
+
+func (res resource) rejectUser(w http.ResponseWriter, r *http.Request) {
+	user, err := res.svc.RejectUser(r.Context(), primitive.Id(chi.URLParam(r, "userId")))
+	rest.EncodeRes(w, r, user, err)
+}
+
+func (res resource) generateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	codes, err := res.svc.GenerateRecoveryCodes(r.Context())
+	rest.EncodeRes(w, r, codes, err)
+}
+
+func (res resource) recover(w http.ResponseWriter, r *http.Request) {
+	var req RecoverReq
+	if rest.DecodeReq(w, r, &req) != nil {
+		return
+	}
+
+	if err := res.throttle(r, "recover", req.IdentityType, req.EmailId, req.Phone); err != nil {
+		rest.EncodeRes(w, r, nil, err)
+		return
+	}
+
+	session, err := res.svc.Recover(withClient(r), req)
+	rest.EncodeRes(w, r, session, err)
+}
+
+func (res resource) updateIdentity(w http.ResponseWriter, r *http.Request) {
+	var req UpdateIdentityReq
+	if rest.DecodeReq(w, r, &req) != nil {
+		return
+	}
+
+	session, err := res.svc.UpdateIdentity(r.Context(), primitive.Id(chi.URLParam(r, "userId")), req)
+	rest.EncodeRes(w, r, session, err)
+}
+
+func (res resource) listAttributes(w http.ResponseWriter, r *http.Request) {
+	schema, err := res.svc.ListAttributes(r.Context())
+	rest.EncodeRes(w, r, schema, err)
+}
+
+func (res resource) saveAttribute(w http.ResponseWriter, r *http.Request) {
+	var req Attribute
+	if rest.DecodeReq(w, r, &req) != nil {
+		return
+	}
+
+	attribute, err := res.svc.SaveAttribute(r.Context(), chi.URLParam(r, "name"), req)
+	rest.EncodeRes(w, r, attribute, err)
+}
+
+func (res resource) deleteAttribute(w http.ResponseWriter, r *http.Request) {
+	deleted, err := res.svc.DeleteAttribute(r.Context(), chi.URLParam(r, "name"))
+	rest.EncodeRes(w, r, deleted, err)
+}
+
+func (res resource) updateMyAttributes(w http.ResponseWriter, r *http.Request) {
+	var req map[string]interface{}
+	if rest.DecodeReq(w, r, &req) != nil {
+		return
+	}
+
+	ctx, err := withExpectedVersion(r)
+	if err != nil {
+		rest.EncodeRes(w, r, nil, err)
+		return
+	}
+
+	user, err := res.svc.UpdateMyAttributes(ctx, req)
+	if err == nil {
+		rest.SetETag(w, user.Version)
+	}
+	rest.EncodeRes(w, r, user, err)
+}
+
+func (res resource) updateUserAttributes(w http.ResponseWriter, r *http.Request) {
+	var req map[string]interface{}
+	if rest.DecodeReq(w, r, &req) != nil {
+		return
+	}
+
+	ctx, err := withExpectedVersion(r)
+	if err != nil {
+		rest.EncodeRes(w, r, nil, err)
+		return
+	}
+
+	user, err := res.svc.UpdateUserAttributes(ctx, primitive.Id(chi.URLParam(r, "userId")), req)
+	if err == nil {
+		rest.SetETag(w, user.Version)
+	}
+	rest.EncodeRes(w, r, user, err)
+}
+
+// reportPage is served by the link of the security notifications. Mail link
+// scanners and prefetchers open the link, so it only asks the user to confirm
+// the report, which posts it as a form.
+var reportPage = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Report suspicious activity</title></head>
+<body>
+{{if .Reported}}
+<p>Your account has been locked and signed out everywhere. Contact support to unlock it.</p>
+{{else if .Failed}}
+<p>This link has expired or was already used.</p>
+{{else}}
+<form method="post">
+<p>Locking your account signs you out everywhere until support unlocks it.</p>
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">Lock my account</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+type reportPageData struct {
+	Token    string
+	Reported bool
+	Failed   bool
+}
+
+func (res resource) report(w http.ResponseWriter, r *http.Request) {
+	if strings.Split(r.Header.Get(header.ContentType), ";")[0] == "application/x-www-form-urlencoded" {
+		res.reportForm(w, r)
+		return
+	}
+
+	var req ReportReq
+	if rest.DecodeReq(w, r, &req) != nil {
+		return
+	}
+
+	reported, err := res.svc.ReportActivity(r.Context(), req)
+	rest.EncodeRes(w, r, reported, err)
+}
+
+// reportLink serves the confirmation page of the link of the security
+// notifications, which carries the report token in its query.
+func (res resource) reportLink(w http.ResponseWriter, r *http.Request) {
+	renderReportPage(w, reportPageData{Token: r.URL.Query().Get("token")})
+}
+
+// reportForm reports the activity confirmed on the page of reportLink.
+func (res resource) reportForm(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Request body is not a valid form", http.StatusBadRequest)
+		return
+	}
+
+	reported, err := res.svc.ReportActivity(r.Context(), ReportReq{Token: r.PostForm.Get("token")})
+	if err != nil {
+		log.Warn().Err(err).Msg("could not report the activity")
+	}
+	renderReportPage(w, reportPageData{Reported: reported, Failed: !reported})
+}
+
+func renderReportPage(w http.ResponseWriter, data reportPageData) {
+	w.Header().Set(header.ContentType, "text/html; charset=utf-8")
+	err := reportPage.Execute(w, data)
+	if err != nil {
+		log.Error().Err(err).Msg("could not render the report page")
+	}
+}
+
+func (res resource) unlockUser(w http.ResponseWriter, r *http.Request) {
+	user, err := res.svc.UnlockUser(r.Context(), primitive.Id(chi.URLParam(r, "userId")))
+	rest.EncodeRes(w, r, user, err)
+}
+
+func (res resource) stepUp(w http.ResponseWriter, r *http.Request) {
+	var req StepUpReq
+	if rest.DecodeReq(w, r, &req) != nil {
+		return
+	}
+
+	if err := res.throttle(r, "stepUp", "", "", Phone{}); err != nil {
+		rest.EncodeRes(w, r, nil, err)
+		return
+	}
+
+	session, err := res.svc.StepUp(r.Context(), req)
+	rest.EncodeRes(w, r, session, err)
+}
+
+func (res resource) listGroups(w http.ResponseWriter, r *http.Request) {
+	cursor, limit, err := rest.PageParams(r)
+	if err != nil {
+		rest.EncodeRes(w, r, nil, err)
+		return
+	}
+
+	groups, next, err := res.svc.ListGroups(r.Context(), cursor, limit)
+	rest.SetNextLink(w, r, next)
+	rest.EncodeRes(w, r, groups, err)
+}
+
+func (res resource) createGroup(w http.ResponseWriter, r *http.Request) {
+	var req Group
+	if rest.DecodeReq(w, r, &req) != nil {
+		return
+	}
+
+	group, err := res.svc.CreateGroup(r.Context(), req)
+	rest.EncodeRes(w, r, group, err)
+}
+
+func (res resource) findGroup(w http.ResponseWriter, r *http.Request) {
+	group, err := res.svc.FindGroup(r.Context(), primitive.Id(chi.URLParam(r, "groupId")))
+	if err == nil {
+		rest.SetETag(w, group.Version)
+	}
+	rest.EncodeRes(w, r, group, err)
+}
+
+func (res resource) updateGroup(w http.ResponseWriter, r *http.Request) {
+	var req Group
+	if rest.DecodeReq(w, r, &req) != nil {
+		return
+	}
+
+	ctx, err := withExpectedVersion(r)
+	if err != nil {
+		rest.EncodeRes(w, r, nil, err)
+		return
+	}
+
+	group, err := res.svc.UpdateGroup(ctx, primitive.Id(chi.URLParam(r, "groupId")), req)
+	if err == nil {
+		rest.SetETag(w, group.Version)
+	}
+	rest.EncodeRes(w, r, group, err)
+}
+
+func (res resource) deleteGroup(w http.ResponseWriter, r *http.Request) {
+	deleted, err := res.svc.DeleteGroup(r.Context(), primitive.Id(chi.URLParam(r, "groupId")))
+	rest.EncodeRes(w, r, deleted, err)
+}
+
+func (res resource) listGroupMembers(w http.ResponseWriter, r *http.Request) {
+	cursor, limit, err := rest.PageParams(r)
+	if err != nil {
+		rest.EncodeRes(w, r, nil, err)
+		return
+	}
+
+	users, next, err := res.svc.ListGroupMembers(r.Context(), primitive.Id(chi.URLParam(r, "groupId")), cursor, limit)
+	rest.SetNextLink(w, r, next)
+	rest.EncodeRes(w, r, users, err)
+}
+
+func (res resource) addGroupMember(w http.ResponseWriter, r *http.Request) {
+	groupId := primitive.Id(chi.URLParam(r, "groupId"))
+	added, err := res.svc.AddGroupMember(r.Context(), groupId, primitive.Id(chi.URLParam(r, "userId")))
+	rest.EncodeRes(w, r, added, err)
+}
+
+func (res resource) removeGroupMember(w http.ResponseWriter, r *http.Request) {
+	groupId := primitive.Id(chi.URLParam(r, "groupId"))
+	removed, err := res.svc.RemoveGroupMember(r.Context(), groupId, primitive.Id(chi.URLParam(r, "userId")))
+	rest.EncodeRes(w, r, removed, err)
+}
+
+func (res resource) listUserGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := res.svc.ListUserGroups(r.Context(), primitive.Id(chi.URLParam(r, "userId")))
+	rest.EncodeRes(w, r, groups, err)
+}
+
+func (res resource) listPolicies(w http.ResponseWriter, r *http.Request) {
+	policies, err := res.svc.ListPolicies(r.Context())
+	rest.EncodeRes(w, r, policies, err)
+}
+
+func (res resource) publishPolicy(w http.ResponseWriter, r *http.Request) {
+	var req Policy
+	if rest.DecodeReq(w, r, &req) != nil {
+		return
+	}
+
+	policy, err := res.svc.PublishPolicy(r.Context(), req)
+	rest.EncodeRes(w, r, policy, err)
+}
+
+func (res resource) acceptPolicies(w http.ResponseWriter, r *http.Request) {
+	var req AcceptPoliciesReq
+	if rest.DecodeReq(w, r, &req) != nil {
+		return
+	}
+
+	session, err := res.svc.AcceptPolicies(withClient(r), req)
+	rest.EncodeRes(w, r, session, err)
+}
+
+func (res resource) policyCoverage(w http.ResponseWriter, r *http.Request) {
+	report, err := res.svc.PolicyCoverage(r.Context())
+	rest.EncodeRes(w, r, report, err)
+}
+
+func (res resource) exportUser(w http.ResponseWriter, r *http.Request) {
+	userId := primitive.Id(chi.URLParam(r, "userId"))
+	export, err := res.svc.ExportUser(withClient(r), userId)
+	if err == nil {
+		w.Header().Set(header.ContentDisposition, `attachment; filename="user-`+userId.String()+`.json"`)
+	}
+	rest.EncodeRes(w, r, export, err)
+}
+
+func (res resource) requestErasure(w http.ResponseWriter, r *http.Request) {
+	user, err := res.svc.RequestErasure(withClient(r), primitive.Id(chi.URLParam(r, "userId")))
+	rest.EncodeRes(w, r, user, err)
+}
+
+func (res resource) cancelErasure(w http.ResponseWriter, r *http.Request) {
+	user, err := res.svc.CancelErasure(withClient(r), primitive.Id(chi.URLParam(r, "userId")))
 	rest.EncodeRes(w, r, user, err)
 }
@@ -0,0 +1,40 @@
+package iam
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dannypaul/go-skeleton/internal/throttle"
+)
+
+func TestThrottleUser(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	t.Setenv("THROTTLE_USER_LIMIT", "2")
+	res := resource{s, throttle.NewLimiter(throttle.NewMemoryStore())}
+
+	if _, err := s.users.Create(ctx, User{Name: "Ada", Status: Active, Identities: IdentityList{
+		{Type: EMAIL, EmailId: "ada@example.com", CanonicalEmailId: "ada@example.com"},
+		{Type: PHONE, Phone: &Phone{Number: "+919999999999"}},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The identities of the user share the limit of the user
+	attempts := []struct {
+		identityType IdentityType
+		emailId      string
+		phone        Phone
+	}{
+		{EMAIL, "ada@example.com", Phone{}},
+		{PHONE, "", Phone{Number: "+919999999999"}},
+		{EMAIL, "Ada@Example.com", Phone{}},
+	}
+	for i, attempt := range attempts {
+		err := res.throttle(httptest.NewRequest("POST", "/identity/login", nil), "login", attempt.identityType, attempt.emailId, attempt.phone)
+		if limited := err != nil; limited != (i == 2) {
+			t.Errorf("Attempt %d was incorrectly throttled, got: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,1162 @@
+package iam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dannypaul/go-skeleton/internal/config"
+	"github.com/dannypaul/go-skeleton/internal/exception"
+	"github.com/dannypaul/go-skeleton/internal/notification"
+	"github.com/dannypaul/go-skeleton/internal/primitive"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+)
+
+// SCIM 2.0 (RFC 7643 and RFC 7644) resources of the users and groups. Users
+// are identified by their email ID, which is the SCIM userName.
+const (
+	ScimUserSchema         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	ScimGroupSchema        = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	ScimListResponseSchema = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	ScimPatchOpSchema      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ScimErrorSchema        = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+const CtxProvisioningClientKey = "provisioningClient"
+
+type ScimSvc interface {
+	ListScimUsers(ctx context.Context, query ScimQuery) (ScimListResponse, error)
+	FindScimUser(ctx context.Context, id primitive.Id) (ScimUser, error)
+	CreateScimUser(ctx context.Context, req ScimUser) (ScimUser, error)
+	ReplaceScimUser(ctx context.Context, id primitive.Id, req ScimUser) (ScimUser, error)
+	PatchScimUser(ctx context.Context, id primitive.Id, req ScimPatch) (ScimUser, error)
+	DeactivateScimUser(ctx context.Context, id primitive.Id) error
+	ListScimGroups(ctx context.Context, query ScimQuery) (ScimListResponse, error)
+	FindScimGroup(ctx context.Context, id primitive.Id) (ScimGroup, error)
+	CreateScimGroup(ctx context.Context, req ScimGroup) (ScimGroup, error)
+	ReplaceScimGroup(ctx context.Context, id primitive.Id, req ScimGroup) (ScimGroup, error)
+	PatchScimGroup(ctx context.Context, id primitive.Id, req ScimPatch) (ScimGroup, error)
+	DeleteScimGroup(ctx context.Context, id primitive.Id) error
+}
+
+// NewScimService returns the SCIM API of the iam service, so that provisioned
+// users are handled with the same repositories and notifications as the rest
+// of the users.
+func NewScimService(iamService Svc) ScimSvc {
+	return iamService.(svc)
+}
+
+const scimMaxCount = 100
+
+type ScimMeta struct {
+	ResourceType string `json:"resourceType"`
+	Location     string `json:"location"`
+	Version      string `json:"version"`
+}
+
+type ScimName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type ScimMultiValue struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type ScimMember struct {
+	Value   primitive.Id `json:"value"`
+	Display string       `json:"display,omitempty"`
+	Ref     string       `json:"$ref,omitempty"`
+}
+
+type ScimUser struct {
+	Schemas      []string         `json:"schemas"`
+	Id           primitive.Id     `json:"id,omitempty"`
+	ExternalId   string           `json:"externalId,omitempty"`
+	UserName     string           `json:"userName"`
+	Name         *ScimName        `json:"name,omitempty"`
+	DisplayName  string           `json:"displayName,omitempty"`
+	Active       *bool            `json:"active,omitempty"`
+	Emails       []ScimMultiValue `json:"emails,omitempty"`
+	PhoneNumbers []ScimMultiValue `json:"phoneNumbers,omitempty"`
+	Groups       []ScimMember     `json:"groups,omitempty"`
+	Meta         *ScimMeta        `json:"meta,omitempty"`
+}
+
+type ScimGroup struct {
+	Schemas     []string     `json:"schemas"`
+	Id          primitive.Id `json:"id,omitempty"`
+	ExternalId  string       `json:"externalId,omitempty"`
+	DisplayName string       `json:"displayName"`
+	Members     []ScimMember `json:"members,omitempty"`
+	Meta        *ScimMeta    `json:"meta,omitempty"`
+}
+
+type ScimListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int         `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+type ScimQuery struct {
+	Filter     string
+	StartIndex int
+	Count      int
+}
+
+// page returns the bounds of the requested page within total results.
+func (q ScimQuery) page(total int) (int, int) {
+	start := q.StartIndex - 1
+	if start < 0 {
+		start = 0
+	}
+	if start > total {
+		start = total
+	}
+
+	count := q.Count
+	if count <= 0 || count > scimMaxCount {
+		count = scimMaxCount
+	}
+
+	end := start + count
+	if end > total {
+		end = total
+	}
+	return start, end
+}
+
+type ScimPatchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+type ScimPatch struct {
+	Schemas    []string      `json:"schemas"`
+	Operations []ScimPatchOp `json:"Operations"`
+}
+
+// Only the eq operator is supported, which is what provisioning clients use to
+// look up existing resources before creating them
+var scimFilterRegexp = regexp.MustCompile(`^\s*([A-Za-z][A-Za-z0-9.]*)\s+(?i:eq)\s+"((?:[^"\\]|\\.)*)"\s*$`)
+
+func parseScimFilter(filter string) (string, string, error) {
+	matches := scimFilterRegexp.FindStringSubmatch(filter)
+	if matches == nil {
+		return "", "", errors.New(exception.ScimFilterInvalid)
+	}
+
+	value, err := strconv.Unquote(`"` + matches[2] + `"`)
+	if err != nil {
+		return "", "", errors.New(exception.ScimFilterInvalid)
+	}
+	return strings.ToLower(matches[1]), value, nil
+}
+
+func scimLocation(resourceType string, id primitive.Id) string {
+	conf, _ := config.Get()
+	return strings.TrimSuffix(conf.PublicUrl, "/") + "/scim/v2/" + resourceType + "s/" + id.String()
+}
+
+func scimVersion(version int) string {
+	return `W/"` + strconv.Itoa(version) + `"`
+}
+
+func verifyProvisioningClient(ctx context.Context) error {
+	if provisioningClient, _ := ctx.Value(CtxProvisioningClientKey).(bool); !provisioningClient {
+		return errors.New(exception.Unauthorised)
+	}
+	return nil
+}
+
+// Provisioning clients only see the users and groups they provisioned. Users
+// and groups created in the application are left to its administrators.
+var scimProvisioned = repository.Filter{Key: "provisioned", Value: true}
+
+func (s svc) findScimUser(ctx context.Context, id primitive.Id) (User, error) {
+	user, err := s.findUserById(ctx, id)
+	if err != nil {
+		return User{}, err
+	}
+	if !user.Provisioned {
+		return User{}, errors.New(exception.UserNotFound)
+	}
+	return user, nil
+}
+
+func (s svc) findScimGroup(ctx context.Context, id primitive.Id) (Group, error) {
+	group, err := s.findGroupById(ctx, id)
+	if err != nil {
+		return Group{}, err
+	}
+	if !group.Provisioned {
+		return Group{}, errors.New(exception.GroupNotFound)
+	}
+	return group, nil
+}
+
+func (s svc) toScimUser(ctx context.Context, user User) (ScimUser, error) {
+	active := user.Status != Deactivated
+	scimUser := ScimUser{
+		Schemas:     []string{ScimUserSchema},
+		Id:          user.Id,
+		ExternalId:  user.ExternalId,
+		DisplayName: user.Name,
+		Name:        &ScimName{Formatted: user.Name},
+		Active:      &active,
+		Meta: &ScimMeta{
+			ResourceType: "User",
+			Location:     scimLocation("User", user.Id),
+			Version:      scimVersion(user.Version),
+		},
+	}
+
+	for _, identity := range user.Identities {
+		if identity.Type == EMAIL {
+			scimUser.UserName = identity.EmailId
+			scimUser.Emails = append(scimUser.Emails, ScimMultiValue{Value: identity.EmailId, Type: "work", Primary: true})
+		}
+		if identity.Type == PHONE && identity.Phone != nil {
+			scimUser.PhoneNumbers = append(scimUser.PhoneNumbers, ScimMultiValue{Value: identity.Phone.Number, Type: "work"})
+		}
+	}
+
+	// Users who signed up with a phone number have no email ID
+	if scimUser.UserName == "" && len(scimUser.PhoneNumbers) > 0 {
+		scimUser.UserName = scimUser.PhoneNumbers[0].Value
+	}
+
+	groups, err := s.userGroups(ctx, user)
+	if err != nil {
+		return ScimUser{}, err
+	}
+	for _, group := range groups {
+		if !group.Provisioned {
+			continue
+		}
+		scimUser.Groups = append(scimUser.Groups, ScimMember{
+			Value:   group.Id,
+			Display: group.Name,
+			Ref:     scimLocation("Group", group.Id),
+		})
+	}
+
+	return scimUser, nil
+}
+
+func (s svc) toScimGroup(ctx context.Context, group Group) (ScimGroup, error) {
+	members, err := s.groupMembers(ctx, group.Id)
+	if err != nil {
+		return ScimGroup{}, err
+	}
+
+	scimGroup := ScimGroup{
+		Schemas:     []string{ScimGroupSchema},
+		Id:          group.Id,
+		ExternalId:  group.ExternalId,
+		DisplayName: group.Name,
+		Meta: &ScimMeta{
+			ResourceType: "Group",
+			Location:     scimLocation("Group", group.Id),
+			Version:      scimVersion(group.Version),
+		},
+	}
+	for _, member := range members {
+		scimGroup.Members = append(scimGroup.Members, ScimMember{
+			Value:   member.Id,
+			Display: member.Name,
+			Ref:     scimLocation("User", member.Id),
+		})
+	}
+
+	return scimGroup, nil
+}
+
+func (s svc) groupMembers(ctx context.Context, groupId primitive.Id) ([]User, error) {
+	users, err := s.users.FindAll(ctx, []repository.Filter{{Key: "groupIds", Value: groupId}, scimProvisioned})
+	if err != nil {
+		return nil, fmt.Errorf("could not find the members of the group %w", err)
+	}
+	return users, nil
+}
+
+// displayName returns the name of the user, preferring displayName over the
+// components of name.
+func (u ScimUser) displayName() string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+	if u.Name == nil {
+		return ""
+	}
+	if u.Name.Formatted != "" {
+		return u.Name.Formatted
+	}
+	return strings.TrimSpace(u.Name.GivenName + " " + u.Name.FamilyName)
+}
+
+// identities returns the identities of the SCIM user. Identities of the
+// existing user which are unchanged keep their verification status.
+func (u ScimUser) identities(existing IdentityList) (IdentityList, error) {
+	identities := IdentityList{}
+
+	// The userName of a user without an email ID is their phone number
+	current, _, err := existing.getIdentity(EMAIL)
+	currentPhone, _, phoneErr := existing.getIdentity(PHONE)
+	withoutEmail := err != nil && phoneErr == nil && currentPhone.Phone != nil && currentPhone.Phone.Number == u.UserName
+	if !withoutEmail {
+		email, err := newEmailIdentity(u.UserName)
+		if err != nil {
+			return nil, err
+		}
+		if current.Type == EMAIL && current.CanonicalEmailId == email.CanonicalEmailId {
+			email.Id = current.Id
+			email.Verified = current.Verified
+		}
+		identities = append(identities, email)
+	}
+
+	if len(u.PhoneNumbers) == 0 {
+		if withoutEmail {
+			identities = append(identities, currentPhone)
+		}
+		return identities, nil
+	}
+
+	number := u.PhoneNumbers[0].Value
+	for _, phoneNumber := range u.PhoneNumbers {
+		if phoneNumber.Primary {
+			number = phoneNumber.Value
+		}
+	}
+
+	phone, err := Phone{Number: number}.normalise()
+	if err != nil {
+		return nil, err
+	}
+	identity := Identity{Type: PHONE, Phone: &phone}
+	if phoneErr == nil && currentPhone.Phone != nil && currentPhone.Phone.Number == phone.Number {
+		identity.Id = currentPhone.Id
+		identity.Verified = currentPhone.Verified
+	}
+
+	return append(identities, identity), nil
+}
+
+func (s svc) findScimUserFilter(filter string) ([]repository.Filter, error) {
+	if filter == "" {
+		return nil, nil
+	}
+
+	attribute, value, err := parseScimFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	switch attribute {
+	case "username", "emails.value", "emails":
+		identity, err := newEmailIdentity(value)
+		if err != nil {
+			// An email ID which is not valid does not belong to any user
+			return []repository.Filter{{Key: "identities.canonicalEmailId", Value: value}}, nil
+		}
+		return []repository.Filter{{Key: "identities.canonicalEmailId", Value: identity.CanonicalEmailId}}, nil
+	case "externalid":
+		return []repository.Filter{{Key: "externalId", Value: value}}, nil
+	}
+	return nil, errors.New(exception.ScimFilterInvalid)
+}
+
+func (s svc) ListScimUsers(ctx context.Context, query ScimQuery) (ScimListResponse, error) {
+	if err := verifyProvisioningClient(ctx); err != nil {
+		return ScimListResponse{}, err
+	}
+
+	filters, err := s.findScimUserFilter(query.Filter)
+	if err != nil {
+		return ScimListResponse{}, err
+	}
+	filters = append(filters, scimProvisioned)
+
+	total, err := s.userRepo.Count(ctx, filters)
+	if err != nil {
+		return ScimListResponse{}, fmt.Errorf("could not count the users %w", err)
+	}
+
+	start, end := query.page(int(total))
+	users := []User{}
+	if end > start {
+		users, err = s.users.Query(ctx, repository.Query{
+			Filters: filters,
+			Skip:    int64(start),
+			Limit:   int64(end - start),
+		})
+		if err != nil {
+			return ScimListResponse{}, fmt.Errorf("could not find the users %w", err)
+		}
+	}
+
+	resources := make([]ScimUser, 0, len(users))
+	for _, user := range users {
+		scimUser, err := s.toScimUser(ctx, user)
+		if err != nil {
+			return ScimListResponse{}, err
+		}
+		resources = append(resources, scimUser)
+	}
+
+	return ScimListResponse{
+		Schemas:      []string{ScimListResponseSchema},
+		TotalResults: int(total),
+		StartIndex:   start + 1,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}, nil
+}
+
+func (s svc) FindScimUser(ctx context.Context, id primitive.Id) (ScimUser, error) {
+	if err := verifyProvisioningClient(ctx); err != nil {
+		return ScimUser{}, err
+	}
+
+	user, err := s.findScimUser(ctx, id)
+	if err != nil {
+		return ScimUser{}, err
+	}
+
+	return s.toScimUser(ctx, user)
+}
+
+// CreateScimUser provisions a MEMBER user. The identities of provisioned users
+// are trusted as they are managed by the identity provider, but they still
+// have to be verified before they can be used to sign in.
+func (s svc) CreateScimUser(ctx context.Context, req ScimUser) (ScimUser, error) {
+	if err := verifyProvisioningClient(ctx); err != nil {
+		return ScimUser{}, err
+	}
+
+	identities, err := req.identities(nil)
+	if err != nil {
+		return ScimUser{}, err
+	}
+
+	for _, identity := range identities {
+		if err := s.ensureIdentityAvailable(ctx, "", identity); err != nil {
+			return ScimUser{}, err
+		}
+	}
+
+	status := Active
+	if req.Active != nil && !*req.Active {
+		status = Deactivated
+	}
+
+	user, err := s.users.Create(ctx, User{
+		Role:        Member,
+		Name:        req.displayName(),
+		Status:      status,
+		ExternalId:  req.ExternalId,
+		Provisioned: true,
+		Identities:  identities,
+	})
+	if err != nil {
+		if errors.Is(err, exception.ErrConflict) {
+			return ScimUser{}, errors.New(exception.UserAlreadyExists)
+		}
+		return ScimUser{}, fmt.Errorf("could not save the user to persistence %w", err)
+	}
+
+	return s.toScimUser(ctx, user)
+}
+
+// ensureIdentityAvailable fails when the identity belongs to a user other than
+// userId.
+func (s svc) ensureIdentityAvailable(ctx context.Context, userId primitive.Id, identity Identity) error {
+	other, err := s.FindUserByIdentity(ctx, identity)
+	if err != nil {
+		if errors.Is(err, exception.ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("could not check if the user exists %w", err)
+	}
+
+	if other.Id != userId {
+		return errors.New(exception.UserAlreadyExists)
+	}
+	return nil
+}
+
+func (s svc) ReplaceScimUser(ctx context.Context, id primitive.Id, req ScimUser) (ScimUser, error) {
+	if err := verifyProvisioningClient(ctx); err != nil {
+		return ScimUser{}, err
+	}
+
+	user, err := s.findScimUser(ctx, id)
+	if err != nil {
+		return ScimUser{}, err
+	}
+
+	return s.replaceScimUser(ctx, user, req)
+}
+
+// replaceScimUser replaces the user with the SCIM user. Platform admins are
+// never changed by provisioning, even if they were provisioned.
+func (s svc) replaceScimUser(ctx context.Context, user User, req ScimUser) (ScimUser, error) {
+	if user.Role == PlatformAdmin {
+		return ScimUser{}, errors.New(exception.ScimUserManaged)
+	}
+
+	identities, err := req.identities(user.Identities)
+	if err != nil {
+		return ScimUser{}, err
+	}
+
+	for _, identity := range identities {
+		if err := s.ensureIdentityAvailable(ctx, user.Id, identity); err != nil {
+			return ScimUser{}, err
+		}
+	}
+
+	patchers := []repository.Patch{
+		{Action: "$set", Key: "name", Value: req.displayName()},
+		{Action: "$set", Key: "externalId", Value: req.ExternalId},
+		{Action: "$set", Key: "identities", Value: identities},
+	}
+
+	// Deprovisioned users are deactivated instead of deleted and their
+	// sessions are revoked
+	active := req.Active == nil || *req.Active
+	if !active && user.Status != Deactivated {
+		user.Status = Deactivated
+		user.SessionsRevokedAt = time.Now().UTC()
+		patchers = append(patchers,
+			repository.Patch{Action: "$set", Key: "status", Value: user.Status},
+			repository.Patch{Action: "$set", Key: "sessionsRevokedAt", Value: user.SessionsRevokedAt},
+		)
+	}
+	if active && user.Status == Deactivated {
+		user.Status = Active
+		patchers = append(patchers, repository.Patch{Action: "$set", Key: "status", Value: user.Status})
+	}
+
+	err = s.userRepo.PatchIfVersion(ctx, user.Id, user.Version, patchers)
+	if err != nil {
+		if errors.Is(err, exception.ErrVersionConflict) {
+			return ScimUser{}, err
+		}
+		return ScimUser{}, fmt.Errorf("could not update the user %w", err)
+	}
+
+	// The identities before the update, so that a replaced identity is told
+	// about its removal
+	recipients := user.Identities
+
+	user.Name = req.displayName()
+	user.ExternalId = req.ExternalId
+	user.Identities = identities
+	user.Version += 1
+
+	for _, identity := range identities {
+		if !hasIdentity(recipients, identity) {
+			s.notifySecurityEvent(ctx, user, recipients, notification.SecurityNotification{
+				Event:    notification.IdentityAdded,
+				Identity: identity.String(),
+			})
+		}
+	}
+	for _, identity := range recipients {
+		if !hasIdentity(identities, identity) {
+			s.notifySecurityEvent(ctx, user, recipients, notification.SecurityNotification{
+				Event:    notification.IdentityRemoved,
+				Identity: identity.String(),
+			})
+		}
+	}
+
+	return s.toScimUser(ctx, user)
+}
+
+// hasIdentity reports whether the identities contain the identity, ignoring
+// the case of email IDs.
+func hasIdentity(identities IdentityList, identity Identity) bool {
+	for _, i := range identities {
+		if i.Type != identity.Type {
+			continue
+		}
+		if i.Type == EMAIL && i.CanonicalEmailId == identity.CanonicalEmailId {
+			return true
+		}
+		if i.Type == PHONE && i.Phone != nil && identity.Phone != nil && i.Phone.Number == identity.Phone.Number {
+			return true
+		}
+	}
+	return false
+}
+
+func (s svc) PatchScimUser(ctx context.Context, id primitive.Id, req ScimPatch) (ScimUser, error) {
+	if err := verifyProvisioningClient(ctx); err != nil {
+		return ScimUser{}, err
+	}
+
+	user, err := s.findScimUser(ctx, id)
+	if err != nil {
+		return ScimUser{}, err
+	}
+
+	scimUser, err := s.toScimUser(ctx, user)
+	if err != nil {
+		return ScimUser{}, err
+	}
+
+	for _, op := range req.Operations {
+		if err := scimUser.apply(op); err != nil {
+			return ScimUser{}, err
+		}
+	}
+
+	return s.replaceScimUser(ctx, user, scimUser)
+}
+
+// DeactivateScimUser deprovisions the user. The user is kept so that their
+// history is retained, but they can no longer sign in.
+func (s svc) DeactivateScimUser(ctx context.Context, id primitive.Id) error {
+	if err := verifyProvisioningClient(ctx); err != nil {
+		return err
+	}
+
+	user, err := s.findScimUser(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	scimUser, err := s.toScimUser(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	active := false
+	scimUser.Active = &active
+	_, err = s.replaceScimUser(ctx, user, scimUser)
+	return err
+}
+
+// apply applies a patch operation to the user. The paths used by the common
+// identity providers are supported, filters in value paths are ignored.
+func (u *ScimUser) apply(op ScimPatchOp) error {
+	action := strings.ToLower(op.Op)
+	if action != "add" && action != "replace" && action != "remove" {
+		return errors.New(exception.ScimPathInvalid)
+	}
+
+	if op.Path == "" {
+		values, ok := op.Value.(map[string]interface{})
+		if !ok || action == "remove" {
+			return errors.New(exception.ScimValueInvalid)
+		}
+		for path, value := range values {
+			if err := u.apply(ScimPatchOp{Op: op.Op, Path: path, Value: value}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	path := strings.ToLower(op.Path)
+	if i := strings.Index(path, "["); i >= 0 {
+		path = path[:i]
+	}
+
+	if action == "remove" {
+		switch path {
+		case "externalid":
+			u.ExternalId = ""
+		case "displayname":
+			u.DisplayName = ""
+		case "phonenumbers":
+			u.PhoneNumbers = nil
+		default:
+			return errors.New(exception.ScimPathInvalid)
+		}
+		return nil
+	}
+
+	switch path {
+	case "active":
+		active, err := scimBool(op.Value)
+		if err != nil {
+			return err
+		}
+		u.Active = &active
+	case "username", "emails":
+		value, err := scimString(op.Value)
+		if err != nil {
+			return err
+		}
+		u.UserName = value
+	case "phonenumbers":
+		value, err := scimString(op.Value)
+		if err != nil {
+			return err
+		}
+		u.PhoneNumbers = []ScimMultiValue{{Value: value, Type: "work", Primary: true}}
+	case "externalid":
+		value, err := scimString(op.Value)
+		if err != nil {
+			return err
+		}
+		u.ExternalId = value
+	case "displayname":
+		value, err := scimString(op.Value)
+		if err != nil {
+			return err
+		}
+		u.DisplayName = value
+	case "name.formatted", "name.givenname", "name.familyname":
+		value, err := scimString(op.Value)
+		if err != nil {
+			return err
+		}
+		u.setName(path, value)
+	case "name":
+		values, ok := op.Value.(map[string]interface{})
+		if !ok {
+			return errors.New(exception.ScimValueInvalid)
+		}
+		for key, value := range values {
+			if err := u.apply(ScimPatchOp{Op: op.Op, Path: "name." + key, Value: value}); err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.New(exception.ScimPathInvalid)
+	}
+	return nil
+}
+
+// setName sets a component of the name. The display name is cleared so that
+// it is derived from the updated name.
+func (u *ScimUser) setName(path string, value string) {
+	name := ScimName{}
+	if u.Name != nil {
+		name = *u.Name
+	}
+
+	switch path {
+	case "name.formatted":
+		name.Formatted = value
+	case "name.givenname":
+		name.GivenName = value
+		name.Formatted = ""
+	case "name.familyname":
+		name.FamilyName = value
+		name.Formatted = ""
+	}
+
+	u.Name = &name
+	u.DisplayName = ""
+}
+
+// scimString reads a string value, either given directly or as the value of a
+// multi-valued attribute.
+func scimString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case map[string]interface{}:
+		return scimString(v["value"])
+	case []interface{}:
+		if len(v) > 0 {
+			return scimString(v[0])
+		}
+	}
+	return "", errors.New(exception.ScimValueInvalid)
+}
+
+// scimBool reads a boolean value. Some identity providers send booleans as
+// strings.
+func scimBool(value interface{}) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		b, err := strconv.ParseBool(v)
+		if err == nil {
+			return b, nil
+		}
+	}
+	return false, errors.New(exception.ScimValueInvalid)
+}
+
+func (s svc) findScimGroupFilter(filter string) ([]repository.Filter, error) {
+	if filter == "" {
+		return nil, nil
+	}
+
+	attribute, value, err := parseScimFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	switch attribute {
+	case "displayname":
+		return []repository.Filter{{Key: "name", Value: value}}, nil
+	case "externalid":
+		return []repository.Filter{{Key: "externalId", Value: value}}, nil
+	}
+	return nil, errors.New(exception.ScimFilterInvalid)
+}
+
+func (s svc) ListScimGroups(ctx context.Context, query ScimQuery) (ScimListResponse, error) {
+	if err := verifyProvisioningClient(ctx); err != nil {
+		return ScimListResponse{}, err
+	}
+
+	filters, err := s.findScimGroupFilter(query.Filter)
+	if err != nil {
+		return ScimListResponse{}, err
+	}
+	filters = append(filters, scimProvisioned)
+
+	total, err := s.groupRepo.Count(ctx, filters)
+	if err != nil {
+		return ScimListResponse{}, fmt.Errorf("could not count the groups %w", err)
+	}
+
+	start, end := query.page(int(total))
+	groups := []Group{}
+	if end > start {
+		groups, err = s.groups.Query(ctx, repository.Query{
+			Filters: filters,
+			Skip:    int64(start),
+			Limit:   int64(end - start),
+		})
+		if err != nil {
+			return ScimListResponse{}, fmt.Errorf("could not find the groups %w", err)
+		}
+	}
+
+	resources := make([]ScimGroup, 0, len(groups))
+	for _, group := range groups {
+		scimGroup, err := s.toScimGroup(ctx, group)
+		if err != nil {
+			return ScimListResponse{}, err
+		}
+		resources = append(resources, scimGroup)
+	}
+
+	return ScimListResponse{
+		Schemas:      []string{ScimListResponseSchema},
+		TotalResults: int(total),
+		StartIndex:   start + 1,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}, nil
+}
+
+func (s svc) FindScimGroup(ctx context.Context, id primitive.Id) (ScimGroup, error) {
+	if err := verifyProvisioningClient(ctx); err != nil {
+		return ScimGroup{}, err
+	}
+
+	group, err := s.findScimGroup(ctx, id)
+	if err != nil {
+		return ScimGroup{}, err
+	}
+
+	return s.toScimGroup(ctx, group)
+}
+
+// CreateScimGroup creates a group without roles. Roles are assigned to the
+// group by an administrator.
+func (s svc) CreateScimGroup(ctx context.Context, req ScimGroup) (ScimGroup, error) {
+	if err := verifyProvisioningClient(ctx); err != nil {
+		return ScimGroup{}, err
+	}
+
+	group := Group{Name: req.DisplayName, ExternalId: req.ExternalId, Provisioned: true, Roles: []Role{}}
+	if err := group.Validate(); err != nil {
+		return ScimGroup{}, err
+	}
+
+	err := s.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		group, err = s.groups.Create(ctx, group)
+		if err != nil {
+			if errors.Is(err, exception.ErrConflict) {
+				return errors.New(exception.GroupAlreadyExists)
+			}
+			return fmt.Errorf("could not save the group to persistence %w", err)
+		}
+
+		return s.setGroupMembers(ctx, group, nil, req.Members)
+	})
+	if err != nil {
+		return ScimGroup{}, err
+	}
+
+	return s.toScimGroup(ctx, group)
+}
+
+func (s svc) ReplaceScimGroup(ctx context.Context, id primitive.Id, req ScimGroup) (ScimGroup, error) {
+	if err := verifyProvisioningClient(ctx); err != nil {
+		return ScimGroup{}, err
+	}
+
+	group, err := s.findScimGroup(ctx, id)
+	if err != nil {
+		return ScimGroup{}, err
+	}
+
+	current, err := s.toScimGroup(ctx, group)
+	if err != nil {
+		return ScimGroup{}, err
+	}
+
+	return s.replaceScimGroup(ctx, group, current.Members, req)
+}
+
+func (s svc) replaceScimGroup(ctx context.Context, group Group, members []ScimMember, req ScimGroup) (ScimGroup, error) {
+	replace := group.Name != req.DisplayName || group.ExternalId != req.ExternalId
+	if replace {
+		group.Name = req.DisplayName
+		group.ExternalId = req.ExternalId
+		if err := group.Validate(); err != nil {
+			return ScimGroup{}, err
+		}
+	}
+
+	version := group.Version
+	err := s.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		if replace {
+			replaced, err := repository.Copy[Group](s.groupRepo.ReplaceIfVersion(ctx, group.Id, version, group))
+			if err != nil {
+				if errors.Is(err, exception.ErrVersionConflict) {
+					return err
+				}
+				if errors.Is(err, exception.ErrConflict) {
+					return errors.New(exception.GroupAlreadyExists)
+				}
+				return fmt.Errorf("could not save the group to persistence %w", err)
+			}
+			group = replaced
+		}
+
+		return s.setGroupMembers(ctx, group, members, req.Members)
+	})
+	if err != nil {
+		return ScimGroup{}, err
+	}
+
+	return s.toScimGroup(ctx, group)
+}
+
+// setGroupMembers adds and removes members so that the members of the group
+// change from current to desired. Only provisioned users can be members, and
+// the members of a group with roles are managed by an administrator as they
+// would gain or lose its roles.
+func (s svc) setGroupMembers(ctx context.Context, group Group, current []ScimMember, desired []ScimMember) error {
+	for _, member := range desired {
+		if !hasScimMember(current, member.Value) {
+			if len(group.Roles) > 0 {
+				return errors.New(exception.ScimGroupManaged)
+			}
+			if _, err := s.findScimUser(ctx, member.Value); err != nil {
+				return err
+			}
+			if err := s.patchMembership(ctx, group, member.Value, "$addToSet"); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, member := range current {
+		if !hasScimMember(desired, member.Value) {
+			if len(group.Roles) > 0 {
+				return errors.New(exception.ScimGroupManaged)
+			}
+			if err := s.patchMembership(ctx, group, member.Value, "$pull"); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func hasScimMember(members []ScimMember, id primitive.Id) bool {
+	for _, member := range members {
+		if member.Value == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (s svc) PatchScimGroup(ctx context.Context, id primitive.Id, req ScimPatch) (ScimGroup, error) {
+	if err := verifyProvisioningClient(ctx); err != nil {
+		return ScimGroup{}, err
+	}
+
+	group, err := s.findScimGroup(ctx, id)
+	if err != nil {
+		return ScimGroup{}, err
+	}
+
+	current, err := s.toScimGroup(ctx, group)
+	if err != nil {
+		return ScimGroup{}, err
+	}
+
+	scimGroup := current
+	scimGroup.Members = append([]ScimMember{}, current.Members...)
+	for _, op := range req.Operations {
+		if err := scimGroup.apply(op); err != nil {
+			return ScimGroup{}, err
+		}
+	}
+
+	return s.replaceScimGroup(ctx, group, current.Members, scimGroup)
+}
+
+var scimMemberPathRegexp = regexp.MustCompile(`^(?i:members)\[\s*(?i:value)\s+(?i:eq)\s+"([^"]*)"\s*\]$`)
+
+// apply applies a patch operation to the group.
+func (g *ScimGroup) apply(op ScimPatchOp) error {
+	action := strings.ToLower(op.Op)
+	if action != "add" && action != "replace" && action != "remove" {
+		return errors.New(exception.ScimPathInvalid)
+	}
+
+	if op.Path == "" {
+		values, ok := op.Value.(map[string]interface{})
+		if !ok || action == "remove" {
+			return errors.New(exception.ScimValueInvalid)
+		}
+		for path, value := range values {
+			if err := g.apply(ScimPatchOp{Op: op.Op, Path: path, Value: value}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if matches := scimMemberPathRegexp.FindStringSubmatch(op.Path); matches != nil {
+		if action != "remove" {
+			return errors.New(exception.ScimPathInvalid)
+		}
+		g.removeMembers([]primitive.Id{primitive.Id(matches[1])})
+		return nil
+	}
+
+	switch strings.ToLower(op.Path) {
+	case "members":
+		ids, err := scimMemberIds(op.Value)
+		if err != nil && action != "remove" {
+			return err
+		}
+		switch action {
+		case "add":
+			for _, id := range ids {
+				if !hasScimMember(g.Members, id) {
+					g.Members = append(g.Members, ScimMember{Value: id})
+				}
+			}
+		case "replace":
+			g.Members = nil
+			for _, id := range ids {
+				g.Members = append(g.Members, ScimMember{Value: id})
+			}
+		case "remove":
+			// Removing the members attribute without a value removes every member
+			if op.Value == nil {
+				g.Members = nil
+			} else {
+				g.removeMembers(ids)
+			}
+		}
+	case "displayname":
+		value, err := scimString(op.Value)
+		if err != nil || action == "remove" {
+			return errors.New(exception.ScimValueInvalid)
+		}
+		g.DisplayName = value
+	case "externalid":
+		if action == "remove" {
+			g.ExternalId = ""
+			return nil
+		}
+		value, err := scimString(op.Value)
+		if err != nil {
+			return err
+		}
+		g.ExternalId = value
+	default:
+		return errors.New(exception.ScimPathInvalid)
+	}
+	return nil
+}
+
+func (g *ScimGroup) removeMembers(ids []primitive.Id) {
+	members := make([]ScimMember, 0, len(g.Members))
+	for _, member := range g.Members {
+		removed := false
+		for _, id := range ids {
+			if member.Value == id {
+				removed = true
+			}
+		}
+		if !removed {
+			members = append(members, member)
+		}
+	}
+	g.Members = members
+}
+
+func scimMemberIds(value interface{}) ([]primitive.Id, error) {
+	values, ok := value.([]interface{})
+	if !ok {
+		values = []interface{}{value}
+	}
+
+	ids := make([]primitive.Id, 0, len(values))
+	for _, v := range values {
+		id, err := scimString(v)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, primitive.Id(id))
+	}
+	return ids, nil
+}
+
+func (s svc) DeleteScimGroup(ctx context.Context, id primitive.Id) error {
+	if err := verifyProvisioningClient(ctx); err != nil {
+		return err
+	}
+
+	group, err := s.findScimGroup(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	// Deleting a group with roles would take the roles from its members
+	if len(group.Roles) > 0 {
+		return errors.New(exception.ScimGroupManaged)
+	}
+
+	_, err = s.groupRepo.Delete(ctx, group.Id)
+	if err != nil {
+		return fmt.Errorf("could not delete the group %w", err)
+	}
+	return nil
+}
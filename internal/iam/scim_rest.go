@@ -0,0 +1,279 @@
+package iam
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+
+	"github.com/dannypaul/go-skeleton/internal/config"
+	"github.com/dannypaul/go-skeleton/internal/exception"
+	"github.com/dannypaul/go-skeleton/internal/kit/http/header"
+	"github.com/dannypaul/go-skeleton/internal/primitive"
+)
+
+const scimContentType = "application/scim+json"
+
+// ScimRouter serves the SCIM 2.0 API. It is authenticated with the bearer
+// tokens in SCIM_TOKENS instead of user sessions, so it must not be mounted
+// behind the session middleware.
+func ScimRouter(svc ScimSvc) *chi.Mux {
+	resource := scimResource{svc}
+
+	router := chi.NewRouter()
+	router.Use(scimAuth)
+
+	router.Get("/ServiceProviderConfig", resource.serviceProviderConfig)
+	router.Get("/ResourceTypes", resource.resourceTypes)
+	router.Get("/Schemas", resource.schemas)
+	router.Get("/Schemas/{schemaId}", resource.schema)
+
+	router.Get("/Users", resource.listUsers)
+	router.Post("/Users", resource.createUser)
+	router.Get("/Users/{userId}", resource.findUser)
+	router.Put("/Users/{userId}", resource.replaceUser)
+	router.Patch("/Users/{userId}", resource.patchUser)
+	router.Delete("/Users/{userId}", resource.deactivateUser)
+
+	router.Get("/Groups", resource.listGroups)
+	router.Post("/Groups", resource.createGroup)
+	router.Get("/Groups/{groupId}", resource.findGroup)
+	router.Put("/Groups/{groupId}", resource.replaceGroup)
+	router.Patch("/Groups/{groupId}", resource.patchGroup)
+	router.Delete("/Groups/{groupId}", resource.deleteGroup)
+
+	return router
+}
+
+// scimAuth marks requests with one of the SCIM_TOKENS as sent by a
+// provisioning client and rejects the others.
+func scimAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conf, _ := config.Get()
+
+		token := strings.TrimPrefix(r.Header.Get(header.Authorization), "Bearer ")
+		for _, scimToken := range conf.ScimTokens {
+			if token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(scimToken)) == 1 {
+				ctx := context.WithValue(r.Context(), CtxProvisioningClientKey, true)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+		}
+
+		encodeScimRes(w, r, http.StatusUnauthorized, nil, errors.New(exception.Unauthorised))
+	})
+}
+
+type ScimError struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+}
+
+func scimType(code string) string {
+	switch code {
+	case exception.UserAlreadyExists, exception.GroupAlreadyExists:
+		return "uniqueness"
+	case exception.ScimFilterInvalid:
+		return "invalidFilter"
+	case exception.ScimPathInvalid:
+		return "invalidPath"
+	}
+	if exception.HttpStatus(code) == http.StatusBadRequest {
+		return "invalidValue"
+	}
+	return ""
+}
+
+// decodeScimReq decodes a SCIM request. Unlike rest.DecodeReq unknown fields
+// are ignored, as identity providers send extension attributes which are not
+// supported.
+func decodeScimReq(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	contentType := strings.Split(r.Header.Get(header.ContentType), ";")[0]
+	if contentType != scimContentType && contentType != "application/json" {
+		err := errors.New(exception.ScimValueInvalid)
+		encodeScimRes(w, r, http.StatusUnsupportedMediaType, nil, err)
+		return err
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
+	err := json.NewDecoder(r.Body).Decode(dst)
+	if err != nil {
+		err = errors.New(exception.ScimValueInvalid)
+		encodeScimRes(w, r, http.StatusBadRequest, nil, err)
+		return err
+	}
+
+	return nil
+}
+
+// encodeScimRes encodes the response in the SCIM format. Errors are written
+// with the status of their exception code unless status is an error status.
+func encodeScimRes(w http.ResponseWriter, r *http.Request, status int, res interface{}, err error) {
+	w.Header().Set(header.ContentType, scimContentType)
+	if err != nil {
+		if status < http.StatusBadRequest {
+			status = exception.HttpStatus(err.Error())
+		}
+
+		scimError := ScimError{
+			Schemas:  []string{ScimErrorSchema},
+			Status:   strconv.Itoa(status),
+			ScimType: scimType(err.Error()),
+			Detail:   exception.Message(err.Error()),
+		}
+		log.Info().Str("code", err.Error()).Int("status", status).Msg("SCIM request failed")
+
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(scimError)
+		return
+	}
+
+	w.WriteHeader(status)
+	if res != nil {
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
+func scimQuery(r *http.Request) ScimQuery {
+	query := r.URL.Query()
+	startIndex, _ := strconv.Atoi(query.Get("startIndex"))
+	count, _ := strconv.Atoi(query.Get("count"))
+	return ScimQuery{Filter: query.Get("filter"), StartIndex: startIndex, Count: count}
+}
+
+type scimResource struct {
+	svc ScimSvc
+}
+
+func (res scimResource) serviceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	encodeScimRes(w, r, http.StatusOK, scimServiceProviderConfig, nil)
+}
+
+func (res scimResource) resourceTypes(w http.ResponseWriter, r *http.Request) {
+	encodeScimRes(w, r, http.StatusOK, ScimListResponse{
+		Schemas:      []string{ScimListResponseSchema},
+		TotalResults: len(scimResourceTypes),
+		StartIndex:   1,
+		ItemsPerPage: len(scimResourceTypes),
+		Resources:    scimResourceTypes,
+	}, nil)
+}
+
+func (res scimResource) schemas(w http.ResponseWriter, r *http.Request) {
+	encodeScimRes(w, r, http.StatusOK, ScimListResponse{
+		Schemas:      []string{ScimListResponseSchema},
+		TotalResults: len(scimSchemas),
+		StartIndex:   1,
+		ItemsPerPage: len(scimSchemas),
+		Resources:    scimSchemas,
+	}, nil)
+}
+
+func (res scimResource) schema(w http.ResponseWriter, r *http.Request) {
+	schemaId := chi.URLParam(r, "schemaId")
+	for _, schema := range scimSchemas {
+		if schema.Id == schemaId {
+			encodeScimRes(w, r, http.StatusOK, schema, nil)
+			return
+		}
+	}
+	encodeScimRes(w, r, http.StatusOK, nil, errors.New(exception.NotFound))
+}
+
+func (res scimResource) listUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := res.svc.ListScimUsers(r.Context(), scimQuery(r))
+	encodeScimRes(w, r, http.StatusOK, users, err)
+}
+
+func (res scimResource) createUser(w http.ResponseWriter, r *http.Request) {
+	var req ScimUser
+	if decodeScimReq(w, r, &req) != nil {
+		return
+	}
+
+	user, err := res.svc.CreateScimUser(r.Context(), req)
+	encodeScimRes(w, r, http.StatusCreated, user, err)
+}
+
+func (res scimResource) findUser(w http.ResponseWriter, r *http.Request) {
+	user, err := res.svc.FindScimUser(r.Context(), primitive.Id(chi.URLParam(r, "userId")))
+	encodeScimRes(w, r, http.StatusOK, user, err)
+}
+
+func (res scimResource) replaceUser(w http.ResponseWriter, r *http.Request) {
+	var req ScimUser
+	if decodeScimReq(w, r, &req) != nil {
+		return
+	}
+
+	user, err := res.svc.ReplaceScimUser(r.Context(), primitive.Id(chi.URLParam(r, "userId")), req)
+	encodeScimRes(w, r, http.StatusOK, user, err)
+}
+
+func (res scimResource) patchUser(w http.ResponseWriter, r *http.Request) {
+	var req ScimPatch
+	if decodeScimReq(w, r, &req) != nil {
+		return
+	}
+
+	user, err := res.svc.PatchScimUser(r.Context(), primitive.Id(chi.URLParam(r, "userId")), req)
+	encodeScimRes(w, r, http.StatusOK, user, err)
+}
+
+func (res scimResource) deactivateUser(w http.ResponseWriter, r *http.Request) {
+	err := res.svc.DeactivateScimUser(r.Context(), primitive.Id(chi.URLParam(r, "userId")))
+	encodeScimRes(w, r, http.StatusNoContent, nil, err)
+}
+
+func (res scimResource) listGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := res.svc.ListScimGroups(r.Context(), scimQuery(r))
+	encodeScimRes(w, r, http.StatusOK, groups, err)
+}
+
+func (res scimResource) createGroup(w http.ResponseWriter, r *http.Request) {
+	var req ScimGroup
+	if decodeScimReq(w, r, &req) != nil {
+		return
+	}
+
+	group, err := res.svc.CreateScimGroup(r.Context(), req)
+	encodeScimRes(w, r, http.StatusCreated, group, err)
+}
+
+func (res scimResource) findGroup(w http.ResponseWriter, r *http.Request) {
+	group, err := res.svc.FindScimGroup(r.Context(), primitive.Id(chi.URLParam(r, "groupId")))
+	encodeScimRes(w, r, http.StatusOK, group, err)
+}
+
+func (res scimResource) replaceGroup(w http.ResponseWriter, r *http.Request) {
+	var req ScimGroup
+	if decodeScimReq(w, r, &req) != nil {
+		return
+	}
+
+	group, err := res.svc.ReplaceScimGroup(r.Context(), primitive.Id(chi.URLParam(r, "groupId")), req)
+	encodeScimRes(w, r, http.StatusOK, group, err)
+}
+
+func (res scimResource) patchGroup(w http.ResponseWriter, r *http.Request) {
+	var req ScimPatch
+	if decodeScimReq(w, r, &req) != nil {
+		return
+	}
+
+	group, err := res.svc.PatchScimGroup(r.Context(), primitive.Id(chi.URLParam(r, "groupId")), req)
+	encodeScimRes(w, r, http.StatusOK, group, err)
+}
+
+func (res scimResource) deleteGroup(w http.ResponseWriter, r *http.Request) {
+	err := res.svc.DeleteScimGroup(r.Context(), primitive.Id(chi.URLParam(r, "groupId")))
+	encodeScimRes(w, r, http.StatusNoContent, nil, err)
+}
@@ -0,0 +1,178 @@
+package iam
+
+// Discovery documents of the SCIM API as defined in RFC 7643 section 5 to 7.
+// They describe the subset of the core schemas which is supported.
+
+type scimAuthenticationScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Primary     bool   `json:"primary"`
+}
+
+type scimSupported struct {
+	Supported bool `json:"supported"`
+}
+
+type scimFilterConfig struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+type scimBulkConfig struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+type scimServiceProvider struct {
+	Schemas               []string                   `json:"schemas"`
+	Patch                 scimSupported              `json:"patch"`
+	Bulk                  scimBulkConfig             `json:"bulk"`
+	Filter                scimFilterConfig           `json:"filter"`
+	ChangePassword        scimSupported              `json:"changePassword"`
+	Sort                  scimSupported              `json:"sort"`
+	Etag                  scimSupported              `json:"etag"`
+	AuthenticationSchemes []scimAuthenticationScheme `json:"authenticationSchemes"`
+}
+
+var scimServiceProviderConfig = scimServiceProvider{
+	Schemas: []string{"urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"},
+	Patch:   scimSupported{Supported: true},
+	Filter:  scimFilterConfig{Supported: true, MaxResults: scimMaxCount},
+	AuthenticationSchemes: []scimAuthenticationScheme{{
+		Type:        "oauthbearertoken",
+		Name:        "Bearer token",
+		Description: "Authentication with one of the tokens in SCIM_TOKENS",
+		Primary:     true,
+	}},
+}
+
+type scimResourceType struct {
+	Schemas  []string `json:"schemas"`
+	Id       string   `json:"id"`
+	Name     string   `json:"name"`
+	Endpoint string   `json:"endpoint"`
+	Schema   string   `json:"schema"`
+}
+
+var scimResourceTypes = []scimResourceType{
+	{
+		Schemas:  []string{"urn:ietf:params:scim:schemas:core:2.0:ResourceType"},
+		Id:       "User",
+		Name:     "User",
+		Endpoint: "/Users",
+		Schema:   ScimUserSchema,
+	},
+	{
+		Schemas:  []string{"urn:ietf:params:scim:schemas:core:2.0:ResourceType"},
+		Id:       "Group",
+		Name:     "Group",
+		Endpoint: "/Groups",
+		Schema:   ScimGroupSchema,
+	},
+}
+
+type scimAttribute struct {
+	Name          string          `json:"name"`
+	Type          string          `json:"type"`
+	MultiValued   bool            `json:"multiValued"`
+	Required      bool            `json:"required"`
+	CaseExact     bool            `json:"caseExact"`
+	Mutability    string          `json:"mutability"`
+	Returned      string          `json:"returned"`
+	Uniqueness    string          `json:"uniqueness"`
+	SubAttributes []scimAttribute `json:"subAttributes,omitempty"`
+}
+
+func scimAttr(name string, attributeType string, mutability string) scimAttribute {
+	return scimAttribute{Name: name, Type: attributeType, Mutability: mutability, Returned: "default", Uniqueness: "none"}
+}
+
+func scimMultiValuedAttr(name string, mutability string, subAttributes ...scimAttribute) scimAttribute {
+	attribute := scimAttr(name, "complex", mutability)
+	attribute.MultiValued = true
+	attribute.SubAttributes = subAttributes
+	return attribute
+}
+
+type scimSchema struct {
+	Schemas     []string        `json:"schemas"`
+	Id          string          `json:"id"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Attributes  []scimAttribute `json:"attributes"`
+}
+
+var scimUserName = scimAttribute{
+	Name:       "userName",
+	Type:       "string",
+	Required:   true,
+	Mutability: "readWrite",
+	Returned:   "default",
+	Uniqueness: "server",
+}
+
+var scimSchemas = []scimSchema{
+	{
+		Schemas:     []string{"urn:ietf:params:scim:schemas:core:2.0:Schema"},
+		Id:          ScimUserSchema,
+		Name:        "User",
+		Description: "User account, identified by their email ID",
+		Attributes: []scimAttribute{
+			scimUserName,
+			scimAttr("externalId", "string", "readWrite"),
+			scimAttr("displayName", "string", "readWrite"),
+			{
+				Name:       "name",
+				Type:       "complex",
+				Mutability: "readWrite",
+				Returned:   "default",
+				Uniqueness: "none",
+				SubAttributes: []scimAttribute{
+					scimAttr("formatted", "string", "readWrite"),
+					scimAttr("givenName", "string", "readWrite"),
+					scimAttr("familyName", "string", "readWrite"),
+				},
+			},
+			scimAttr("active", "boolean", "readWrite"),
+			scimMultiValuedAttr("emails", "readWrite",
+				scimAttr("value", "string", "readWrite"),
+				scimAttr("type", "string", "readWrite"),
+				scimAttr("primary", "boolean", "readWrite"),
+			),
+			scimMultiValuedAttr("phoneNumbers", "readWrite",
+				scimAttr("value", "string", "readWrite"),
+				scimAttr("type", "string", "readWrite"),
+				scimAttr("primary", "boolean", "readWrite"),
+			),
+			scimMultiValuedAttr("groups", "readOnly",
+				scimAttr("value", "string", "readOnly"),
+				scimAttr("display", "string", "readOnly"),
+				scimAttr("$ref", "reference", "readOnly"),
+			),
+		},
+	},
+	{
+		Schemas:     []string{"urn:ietf:params:scim:schemas:core:2.0:Schema"},
+		Id:          ScimGroupSchema,
+		Name:        "Group",
+		Description: "Group of users, its roles are managed by an administrator",
+		Attributes: []scimAttribute{
+			{
+				Name:       "displayName",
+				Type:       "string",
+				Required:   true,
+				Mutability: "readWrite",
+				Returned:   "default",
+				Uniqueness: "server",
+			},
+			scimAttr("externalId", "string", "readWrite"),
+			scimMultiValuedAttr("members", "readWrite",
+				scimAttr("value", "string", "immutable"),
+				scimAttr("display", "string", "readOnly"),
+				scimAttr("$ref", "reference", "immutable"),
+			),
+		},
+	},
+}
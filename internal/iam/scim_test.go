@@ -0,0 +1,98 @@
+package iam
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dannypaul/go-skeleton/internal/exception"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+)
+
+func TestScimUsers(t *testing.T) {
+	s := newTestService(t)
+	scim := NewScimService(s)
+	ctx := context.WithValue(context.Background(), CtxProvisioningClientKey, true)
+
+	created, err := scim.CreateScimUser(ctx, ScimUser{UserName: "ada@example.com", DisplayName: "Ada"})
+	if err != nil {
+		t.Fatalf("Could not create the user, got: %v", err)
+	}
+
+	found, err := scim.FindScimUser(ctx, created.Id)
+	if err != nil || found.UserName != "ada@example.com" || found.DisplayName != "Ada" {
+		t.Errorf("Found user was incorrect, got: %+v, %v", found, err)
+	}
+
+	list, err := scim.ListScimUsers(ctx, ScimQuery{Filter: `userName eq "ada@example.com"`})
+	if err != nil || list.TotalResults != 1 {
+		t.Errorf("Listed users were incorrect, got: %+v, %v", list, err)
+	}
+}
+
+func TestScimGroups(t *testing.T) {
+	s := newTestService(t)
+	scim := NewScimService(s)
+	ctx := context.WithValue(context.Background(), CtxProvisioningClientKey, true)
+
+	user, err := scim.CreateScimUser(ctx, ScimUser{UserName: "ada@example.com", DisplayName: "Ada"})
+	if err != nil {
+		t.Fatalf("Could not create the user, got: %v", err)
+	}
+
+	group, err := scim.CreateScimGroup(ctx, ScimGroup{DisplayName: "Engineering", Members: []ScimMember{{Value: user.Id}}})
+	if err != nil {
+		t.Fatalf("Could not create the group, got: %v", err)
+	}
+	if len(group.Members) != 1 || group.Members[0].Value != user.Id {
+		t.Errorf("Group members were incorrect, got: %+v", group.Members)
+	}
+
+	replaced, err := scim.ReplaceScimGroup(ctx, group.Id, ScimGroup{DisplayName: "Platform"})
+	if err != nil || replaced.DisplayName != "Platform" || len(replaced.Members) != 0 {
+		t.Errorf("Replaced group was incorrect, got: %+v, %v", replaced, err)
+	}
+}
+
+func TestScimScope(t *testing.T) {
+	s := newTestService(t)
+	scim := NewScimService(s)
+	ctx := context.WithValue(context.Background(), CtxProvisioningClientKey, true)
+
+	admin, err := s.users.Create(ctx, User{Role: PlatformAdmin, Name: "Root", Status: Active, Identities: IdentityList{
+		{Type: EMAIL, EmailId: "root@example.com", CanonicalEmailId: "root@example.com"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = scim.FindScimUser(ctx, admin.Id); err == nil || err.Error() != exception.UserNotFound {
+		t.Errorf("User created in the application was found, got: %v", err)
+	}
+	list, err := scim.ListScimUsers(ctx, ScimQuery{})
+	if err != nil || list.TotalResults != 0 {
+		t.Errorf("Users created in the application were listed, got: %+v, %v", list, err)
+	}
+
+	user, err := scim.CreateScimUser(ctx, ScimUser{UserName: "ada@example.com", DisplayName: "Ada"})
+	if err != nil {
+		t.Fatalf("Could not create the user, got: %v", err)
+	}
+	if err = s.userRepo.Patch(ctx, user.Id, []repository.Patch{{Action: "$set", Key: "role", Value: PlatformAdmin}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = scim.DeactivateScimUser(ctx, user.Id); err == nil || err.Error() != exception.ScimUserManaged {
+		t.Errorf("Platform admin was deactivated, got: %v", err)
+	}
+
+	group, err := scim.CreateScimGroup(ctx, ScimGroup{DisplayName: "Engineering"})
+	if err != nil {
+		t.Fatalf("Could not create the group, got: %v", err)
+	}
+	if _, err = s.groups.Replace(ctx, group.Id, Group{Name: "Engineering", Provisioned: true, Roles: []Role{MerchantAdmin}}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = scim.ReplaceScimGroup(ctx, group.Id, ScimGroup{DisplayName: "Engineering", Members: []ScimMember{{Value: user.Id}}})
+	if err == nil || err.Error() != exception.ScimGroupManaged {
+		t.Errorf("Member was added to a group with roles, got: %v", err)
+	}
+}
@@ -0,0 +1,214 @@
+package iam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dannypaul/go-skeleton/internal/config"
+	"github.com/dannypaul/go-skeleton/internal/exception"
+	"github.com/dannypaul/go-skeleton/internal/notification"
+	"github.com/dannypaul/go-skeleton/internal/primitive"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+
+	"github.com/rs/zerolog/log"
+)
+
+const CtxClientKey = "client"
+
+// Client is the device a request was sent from.
+type Client struct {
+	IP        string
+	UserAgent string
+}
+
+type Device struct {
+	IP         string    `bson:"ip" json:"ip"`
+	UserAgent  string    `bson:"userAgent" json:"userAgent"`
+	LastSeenAt time.Time `bson:"lastSeenAt" json:"lastSeenAt"`
+}
+
+const maxKnownDevices = 20
+
+// rememberDevice returns the known devices of the user including the client,
+// most recently seen first. The client is new when either its IP or its user
+// agent has not been seen before. The first device of a user is never new.
+func (u User) rememberDevice(client Client, now time.Time) ([]Device, bool) {
+	knownIP, knownUserAgent, known := false, false, false
+	devices := make([]Device, 0, len(u.KnownDevices)+1)
+	for _, device := range u.KnownDevices {
+		knownIP = knownIP || device.IP == client.IP
+		knownUserAgent = knownUserAgent || device.UserAgent == client.UserAgent
+		if device.IP == client.IP && device.UserAgent == client.UserAgent {
+			device.LastSeenAt = now
+			known = true
+		}
+		devices = append(devices, device)
+	}
+
+	if !known {
+		devices = append(devices, Device{IP: client.IP, UserAgent: client.UserAgent, LastSeenAt: now})
+	}
+
+	sort.SliceStable(devices, func(i, j int) bool {
+		return devices[i].LastSeenAt.After(devices[j].LastSeenAt)
+	})
+	if len(devices) > maxKnownDevices {
+		devices = devices[:maxKnownDevices]
+	}
+
+	return devices, len(u.KnownDevices) > 0 && (!knownIP || !knownUserAgent)
+}
+
+func (i Identity) String() string {
+	if i.Type == PHONE && i.Phone != nil {
+		return i.Phone.Number
+	}
+	return i.EmailId
+}
+
+// notifySecurityEvent sends the notification to every recipient. Every event
+// other than a lockout carries a link to lock the account. The event has
+// already happened, so a failed notification is logged instead of returned.
+func (s svc) notifySecurityEvent(ctx context.Context, user User, recipients IdentityList, n notification.SecurityNotification) {
+	n.Name = user.Name
+
+	if n.Event != notification.AccountLocked {
+		reportUrl, err := user.reportUrl()
+		if err != nil {
+			log.Error().Err(err).Str("userId", user.Id.String()).Msg("could not create the report link")
+		}
+		n.ReportUrl = reportUrl
+	}
+
+	for _, identity := range recipients {
+		var err error
+		if identity.Type == EMAIL && identity.EmailId != "" {
+			err = s.notificationService.SecurityEventEmailId(ctx, identity.EmailId, n)
+		}
+		if identity.Type == PHONE && identity.Phone != nil {
+			err = s.notificationService.SecurityEventPhone(ctx, identity.Phone.Number, n)
+		}
+		if err != nil {
+			log.Error().Err(err).Str("userId", user.Id.String()).Str("event", string(n.Event)).Msg("could not send the security notification")
+		}
+	}
+}
+
+func (u User) reportUrl() (string, error) {
+	conf, _ := config.Get()
+
+	token, err := u.createScopedToken(Authentication{}, ReportScope)
+	if err != nil {
+		return "", err
+	}
+
+	reportUrl, err := url.Parse(conf.PublicUrl)
+	if err != nil {
+		return "", err
+	}
+	reportUrl.Path = strings.TrimSuffix(reportUrl.Path, "/") + "/identity/report"
+	query := reportUrl.Query()
+	query.Set("token", token)
+	reportUrl.RawQuery = query.Encode()
+
+	return reportUrl.String(), nil
+}
+
+// ReportActivity locks the account of the user the report token was issued to
+// and revokes all of their sessions. Only active users are locked, and only
+// while they are at the version the token was issued for, so that a token is
+// used once and cannot lock the user again after they were unlocked.
+func (s svc) ReportActivity(ctx context.Context, req ReportReq) (bool, error) {
+	claims, err := ParseToken(req.Token)
+	if err != nil || claims.Scope != ReportScope {
+		return false, errors.New(exception.Unauthorised)
+	}
+
+	user, err := s.findUserById(ctx, claims.UserId)
+	if err != nil {
+		return false, err
+	}
+
+	if user.Version != claims.UserVersion || user.Status != Active {
+		return false, errors.New(exception.Unauthorised)
+	}
+
+	patchers := []repository.Patch{
+		{Action: "$set", Key: "status", Value: Locked},
+		{Action: "$set", Key: "sessionsRevokedAt", Value: time.Now().UTC()},
+	}
+	err = s.userRepo.PatchIfVersion(ctx, user.Id, user.Version, patchers)
+	if err != nil {
+		if errors.Is(err, exception.ErrVersionConflict) {
+			return false, errors.New(exception.Unauthorised)
+		}
+		return false, fmt.Errorf("could not lock the user %w", err)
+	}
+
+	return true, nil
+}
+
+// UnlockUser unlocks a user locked through a report or by failed sign-in
+// attempts.
+func (s svc) UnlockUser(ctx context.Context, id primitive.Id) (User, error) {
+	_, err := VerifySession(ctx, []Role{PlatformAdmin})
+	if err != nil {
+		return User{}, err
+	}
+
+	user, err := s.findUserById(ctx, id)
+	if err != nil {
+		return User{}, err
+	}
+
+	patchers := []repository.Patch{
+		{Action: "$set", Key: "failedAuthAttempts", Value: 0},
+		{Action: "$inc", Key: "version", Value: 1},
+	}
+	if user.Status == Locked {
+		patchers = append(patchers, repository.Patch{Action: "$set", Key: "status", Value: Active})
+		user.Status = Active
+	}
+
+	err = s.userRepo.Patch(ctx, user.Id, patchers)
+	if err != nil {
+		return User{}, fmt.Errorf("could not unlock the user %w", err)
+	}
+
+	user.FailedAuthAttempts = 0
+	user.Version += 1
+
+	return user, nil
+}
+
+// ValidateSession checks that the user of the claims can still use the
+// session. It fails when the user is locked, pending approval or rejected, or
+// when their sessions were revoked after the token was issued.
+func (s svc) ValidateSession(ctx context.Context, claims Claims) error {
+	if claims.Scope == ReportScope {
+		return errors.New(exception.Unauthorised)
+	}
+
+	user, err := s.findUserById(ctx, claims.UserId)
+	if err != nil {
+		if err.Error() == exception.UserNotFound {
+			return errors.New(exception.Unauthorised)
+		}
+		return err
+	}
+
+	if user.Status == Locked || user.Status == PendingApproval || user.Status == Rejected || user.Status == Deactivated || user.Status == Erased {
+		return errors.New(exception.Unauthorised)
+	}
+
+	if !user.SessionsRevokedAt.IsZero() && claims.IssuedAt <= user.SessionsRevokedAt.Unix() {
+		return errors.New(exception.Unauthorised)
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package iam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dannypaul/go-skeleton/internal/config"
+	"github.com/dannypaul/go-skeleton/internal/exception"
+	"github.com/dannypaul/go-skeleton/internal/primitive"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+)
+
+type SignupMode string
+
+const (
+	SignupDisabled  SignupMode = "DISABLED"
+	SignupOpen      SignupMode = "OPEN"
+	SignupAllowlist SignupMode = "ALLOWLIST"
+	SignupApproval  SignupMode = "APPROVAL"
+)
+
+func (s svc) Signup(ctx context.Context, req SignupReq) (User, error) {
+	conf, _ := config.Get()
+	mode := SignupMode(conf.SignupMode)
+
+	if mode != SignupOpen && mode != SignupAllowlist && mode != SignupApproval {
+		return User{}, errors.New(exception.SignupDisabled)
+	}
+
+	identity := Identity{Type: req.IdentityType}
+	var exists bool
+	var err error
+	switch req.IdentityType {
+	case EMAIL:
+		identity, err = newEmailIdentity(req.EmailId)
+		if err != nil {
+			return User{}, err
+		}
+		exists, err = s.DoesEmailIdExist(ctx, req.EmailId)
+	case PHONE:
+		var phone Phone
+		phone, err = req.Phone.normalise()
+		if err != nil {
+			return User{}, err
+		}
+		identity.Phone = &phone
+		exists, err = s.DoesPhoneNumberExist(ctx, phone.Number)
+	default:
+		return User{}, errors.New(exception.IdentityTypeNotFound)
+	}
+	if err != nil {
+		return User{}, fmt.Errorf("could not check if the user exists %w", err)
+	}
+	if exists {
+		return User{}, errors.New(exception.UserAlreadyExists)
+	}
+
+	if mode == SignupAllowlist && !isAllowedDomain(identity.CanonicalEmailId, conf.SignupAllowedDomains) {
+		return User{}, errors.New(exception.EmailDomainNotAllowed)
+	}
+
+	status := Active
+	if mode == SignupApproval {
+		status = PendingApproval
+	}
+
+	user, err := s.users.Create(ctx, User{
+		Role:       Member,
+		Name:       req.Name,
+		Status:     status,
+		Identities: []Identity{identity},
+	})
+	if err != nil {
+		if errors.Is(err, exception.ErrConflict) {
+			return User{}, errors.New(exception.UserAlreadyExists)
+		}
+		return User{}, fmt.Errorf("could not save the user to persistence %w", err)
+	}
+
+	return user, nil
+}
+
+// isAllowedDomain reports whether the domain of emailId is one of the allowed
+// domains. Phone sign-ups have no email domain and are never allowed.
+func isAllowedDomain(emailId string, allowedDomains []string) bool {
+	at := strings.LastIndex(emailId, "@")
+	if at < 0 {
+		return false
+	}
+
+	domain := emailId[at+1:]
+	for _, allowed := range allowedDomains {
+		if strings.EqualFold(domain, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s svc) ApproveUser(ctx context.Context, id primitive.Id) (User, error) {
+	return s.reviewSignup(ctx, id, Active)
+}
+
+func (s svc) RejectUser(ctx context.Context, id primitive.Id) (User, error) {
+	return s.reviewSignup(ctx, id, Rejected)
+}
+
+func (s svc) reviewSignup(ctx context.Context, id primitive.Id, status UserStatus) (User, error) {
+	_, err := VerifySession(ctx, []Role{PlatformAdmin})
+	if err != nil {
+		return User{}, err
+	}
+
+	user, err := s.findUserById(ctx, id)
+	if err != nil {
+		return User{}, err
+	}
+
+	if user.Status != PendingApproval {
+		return User{}, errors.New(exception.UserNotPendingApproval)
+	}
+
+	patchers := []repository.Patch{
+		{Action: "$set", Key: "status", Value: status},
+		{Action: "$inc", Key: "version", Value: 1},
+	}
+	err = s.userRepo.Patch(ctx, user.Id, patchers)
+	if err != nil {
+		return User{}, fmt.Errorf("could not update the user %w", err)
+	}
+
+	user.Status = status
+	user.Version += 1
+
+	return user, nil
+}
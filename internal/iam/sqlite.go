@@ -0,0 +1,118 @@
+package iam
+
+import (
+	"github.com/dannypaul/go-skeleton/internal/driver/platform/sqlite"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+)
+
+// The indexes of the SQLite collections mirror the indexes created by the
+// MongoDB migrations.
+
+type sqliteUserRepo struct {
+	sqlite.Collection
+}
+
+func NewSqliteUserRepo(client *sqlite.Client) (UserRepo, error) {
+	collection := sqlite.NewCollection(client, UserCollectionName,
+		sqlite.Index{Name: "identities_phone_number_asc", Keys: []string{"identities.phone.number"}, Unique: true},
+		sqlite.Index{Name: "identities_canonicalEmailId_asc", Keys: []string{"identities.canonicalEmailId"}, Unique: true},
+		sqlite.Index{Name: "identities_emailId_asc", Keys: []string{"identities.emailId"}},
+		sqlite.Index{Name: "groupIds_asc", Keys: []string{"groupIds"}},
+		sqlite.Index{Name: "externalId_asc", Keys: []string{"externalId"}},
+	)
+
+	return sqliteUserRepo{collection}, nil
+}
+
+type sqliteChallengeRepo struct {
+	sqlite.Collection
+}
+
+func NewSqliteChallengeRepo(client *sqlite.Client) (ChallengeRepo, error) {
+	collection := sqlite.NewCollection(client, ChallengeCollectionName,
+		sqlite.Index{Name: "phone_number_asc", Keys: []string{"phone.number"}, Unique: true},
+		sqlite.Index{Name: "canonicalEmailId_asc", Keys: []string{"canonicalEmailId"}, Unique: true},
+	)
+
+	return sqliteChallengeRepo{collection}, nil
+}
+
+type sqliteAttributeRepo struct {
+	sqlite.Collection
+}
+
+func NewSqliteAttributeRepo(client *sqlite.Client) (AttributeRepo, error) {
+	collection := sqlite.NewCollection(client, AttributeCollectionName,
+		sqlite.Index{Name: "name_asc", Keys: []string{"name"}, Unique: true},
+	)
+
+	return sqliteAttributeRepo{collection}, nil
+}
+
+type sqliteGroupRepo struct {
+	sqlite.Collection
+}
+
+func NewSqliteGroupRepo(client *sqlite.Client) (GroupRepo, error) {
+	collection := sqlite.NewCollection(client, GroupCollectionName,
+		sqlite.Index{Name: "name_asc", Keys: []string{"name"}, Unique: true},
+		sqlite.Index{Name: "externalId_asc", Keys: []string{"externalId"}},
+	)
+
+	return sqliteGroupRepo{collection}, nil
+}
+
+type sqlitePolicyRepo struct {
+	sqlite.Collection
+}
+
+func NewSqlitePolicyRepo(client *sqlite.Client) (PolicyRepo, error) {
+	collection := sqlite.NewCollection(client, PolicyCollectionName,
+		sqlite.Index{Name: "type_asc_version_desc", Keys: []string{"type", "version"}, Unique: true},
+	)
+
+	return sqlitePolicyRepo{collection}, nil
+}
+
+type sqliteConsentRepo struct {
+	sqlite.Collection
+}
+
+func NewSqliteConsentRepo(client *sqlite.Client) (ConsentRepo, error) {
+	collection := sqlite.NewCollection(client, ConsentCollectionName,
+		sqlite.Index{Name: "userId_asc_policyId_asc", Keys: []string{"userId", "policyId"}, Unique: true},
+		sqlite.Index{Name: "policyId_asc", Keys: []string{"policyId"}},
+	)
+
+	return sqliteConsentRepo{collection}, nil
+}
+
+type sqliteAuditRepo struct {
+	sqlite.Collection
+}
+
+func NewSqliteAuditRepo(client *sqlite.Client) (AuditRepo, error) {
+	collection := sqlite.NewCollection(client, AuditCollectionName,
+		sqlite.Index{Name: "subjectId_asc", Keys: []string{"subjectId"}},
+	)
+
+	return sqliteAuditRepo{collection}, nil
+}
+
+type sqliteErasureRepo struct {
+	sqlite.Collection
+}
+
+func NewSqliteErasureRepo(client *sqlite.Client) (ErasureRepo, error) {
+	collection := sqlite.NewCollection(client, ErasureCollectionName,
+		sqlite.Index{
+			Name:    "userId_asc_scheduled",
+			Keys:    []string{"userId"},
+			Unique:  true,
+			Partial: []repository.Filter{{Key: "status", Value: ErasureScheduled}},
+		},
+		sqlite.Index{Name: "status_asc", Keys: []string{"status"}},
+	)
+
+	return sqliteErasureRepo{collection}, nil
+}
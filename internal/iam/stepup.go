@@ -0,0 +1,99 @@
+package iam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dannypaul/go-skeleton/internal/exception"
+	"github.com/dannypaul/go-skeleton/internal/notification"
+)
+
+// AuthMethod values follow the OpenID Connect amr claim where one exists.
+type AuthMethod string
+
+const (
+	PasswordAuth     AuthMethod = "pwd"
+	OtpAuth          AuthMethod = "otp"
+	RecoveryCodeAuth AuthMethod = "rc"
+)
+
+// Authentication is when and how the user of a session authenticated.
+type Authentication struct {
+	Time    time.Time
+	Methods []AuthMethod
+}
+
+func (a Authentication) hasAnyMethod(methods ...AuthMethod) bool {
+	for _, method := range methods {
+		for _, m := range a.Methods {
+			if m == method {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withMethod returns the authentication of a successful step-up with method.
+func (a Authentication) withMethod(method AuthMethod) Authentication {
+	methods := append([]AuthMethod{}, a.Methods...)
+	if !a.hasAnyMethod(method) {
+		methods = append(methods, method)
+	}
+	return Authentication{Time: time.Now(), Methods: methods}
+}
+
+func (c Claims) authentication() Authentication {
+	auth := Authentication{Methods: c.Amr}
+	if c.AuthTime != 0 {
+		auth.Time = time.Unix(c.AuthTime, 0)
+	}
+	return auth
+}
+
+// requireRecentAuth fails with exception.StepUpRequiredError unless the user
+// of the session authenticated with one of the methods within maxAge.
+func requireRecentAuth(claims Claims, maxAge time.Duration, methods ...AuthMethod) error {
+	auth := claims.authentication()
+	if auth.Time.IsZero() || time.Since(auth.Time) > maxAge || !auth.hasAnyMethod(methods...) {
+		return exception.StepUpRequiredError{MaxAge: maxAge}
+	}
+	return nil
+}
+
+// StepUp re-authenticates the user of the session with their password and
+// returns a token with a fresh authentication time.
+func (s svc) StepUp(ctx context.Context, req StepUpReq) (Session, error) {
+	claims, err := VerifySession(ctx, []Role{PlatformAdmin, MerchantAdmin, Member})
+	if err != nil {
+		return Session{}, err
+	}
+
+	user, err := s.findUserById(ctx, claims.UserId)
+	if err != nil {
+		return Session{}, err
+	}
+
+	if user.FailedAuthAttempts >= 3 {
+		return Session{}, errors.New(exception.FailedLoginLimitExceeded)
+	}
+
+	if user.Password == "" || !user.equalsPassword(req.Password) {
+		err = s.userRepo.IncrementById(ctx, user.Id, "failedAuthAttempts", 1)
+		if err == nil && user.FailedAuthAttempts+1 >= 3 {
+			s.notifySecurityEvent(ctx, user, user.Identities, notification.SecurityNotification{Event: notification.AccountLocked})
+		}
+		return Session{}, errors.New(exception.CredentialsInvalid)
+	}
+
+	if user.FailedAuthAttempts > 0 {
+		err = s.userRepo.SetById(ctx, user.Id, "failedAuthAttempts", 0)
+		if err != nil {
+			return Session{}, fmt.Errorf("could not reset the failed authentication attempt count %w", err)
+		}
+	}
+
+	return s.createSession(ctx, user, claims.authentication().withMethod(PasswordAuth))
+}
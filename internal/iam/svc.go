@@ -12,25 +12,55 @@ import (
 	"github.com/dannypaul/go-skeleton/internal/notification"
 	"github.com/dannypaul/go-skeleton/internal/primitive"
 	"github.com/dannypaul/go-skeleton/internal/repository"
+	"github.com/dannypaul/go-skeleton/internal/validation"
 )
 
+// The repositories embed repository.Collection, which the typed repositories
+// of the service are built on, along with the operations they need besides.
+
 type UserRepo interface {
-	repository.Counter
-	repository.Creator
-	repository.Finder
+	repository.Collection
 	repository.Incrementer
 	repository.Patcher
 	repository.Setter
+	repository.VersionedWriter
+}
+
+type AttributeRepo interface {
+	repository.Collection
+	repository.Incrementer
+}
+
+type GroupRepo interface {
+	repository.Collection
+	repository.VersionedWriter
+}
+
+type PolicyRepo interface {
+	repository.Collection
+}
+
+type ConsentRepo interface {
+	repository.Collection
 }
 
 type ChallengeRepo interface {
-	repository.Creator
-	repository.Deleter
-	repository.Finder
+	repository.Collection
 	repository.Incrementer
 	repository.Setter
 }
 
+type ErasureRepo interface {
+	repository.Collection
+	repository.Patcher
+	repository.Streamer
+	repository.ManyUpdater
+}
+
+type AuditRepo interface {
+	repository.Collection
+}
+
 type Svc interface {
 	VerifySeedUser(ctx context.Context) error
 	Invite(ctx context.Context, req InviteReq) (User, error)
@@ -40,19 +70,84 @@ type Svc interface {
 	Login(ctx context.Context, req LoginReq) (Session, error)
 	FindMe(ctx context.Context) (User, error)
 	FindUser(ctx context.Context, id primitive.Id) (User, error)
+	FindUserByIdentity(ctx context.Context, identity Identity) (User, error)
+	Signup(ctx context.Context, req SignupReq) (User, error)
+	ApproveUser(ctx context.Context, id primitive.Id) (User, error)
+	RejectUser(ctx context.Context, id primitive.Id) (User, error)
+	GenerateRecoveryCodes(ctx context.Context) (RecoveryCodes, error)
+	Recover(ctx context.Context, req RecoverReq) (Session, error)
+	UpdateIdentity(ctx context.Context, userId primitive.Id, req UpdateIdentityReq) (Session, error)
+	ListAttributes(ctx context.Context) (AttributeSchema, error)
+	SaveAttribute(ctx context.Context, name string, req Attribute) (Attribute, error)
+	DeleteAttribute(ctx context.Context, name string) (bool, error)
+	UpdateMyAttributes(ctx context.Context, attributes map[string]interface{}) (User, error)
+	UpdateUserAttributes(ctx context.Context, userId primitive.Id, attributes map[string]interface{}) (User, error)
+	ReportActivity(ctx context.Context, req ReportReq) (bool, error)
+	UnlockUser(ctx context.Context, id primitive.Id) (User, error)
+	StepUp(ctx context.Context, req StepUpReq) (Session, error)
+	ListGroups(ctx context.Context, cursor string, limit int64) ([]Group, string, error)
+	FindGroup(ctx context.Context, id primitive.Id) (Group, error)
+	CreateGroup(ctx context.Context, req Group) (Group, error)
+	UpdateGroup(ctx context.Context, id primitive.Id, req Group) (Group, error)
+	DeleteGroup(ctx context.Context, id primitive.Id) (bool, error)
+	ListGroupMembers(ctx context.Context, id primitive.Id, cursor string, limit int64) ([]User, string, error)
+	ListUserGroups(ctx context.Context, userId primitive.Id) ([]Group, error)
+	AddGroupMember(ctx context.Context, groupId primitive.Id, userId primitive.Id) (bool, error)
+	RemoveGroupMember(ctx context.Context, groupId primitive.Id, userId primitive.Id) (bool, error)
+	ListPolicies(ctx context.Context) ([]Policy, error)
+	PublishPolicy(ctx context.Context, req Policy) (Policy, error)
+	AcceptPolicies(ctx context.Context, req AcceptPoliciesReq) (Session, error)
+	PolicyCoverage(ctx context.Context) ([]PolicyCoverage, error)
+	ExportUser(ctx context.Context, userId primitive.Id) (UserExport, error)
+	RequestErasure(ctx context.Context, userId primitive.Id) (Erasure, error)
+	CancelErasure(ctx context.Context, userId primitive.Id) (Erasure, error)
+	EraseDueUsers(ctx context.Context) (int, error)
+	ValidateSession(ctx context.Context, claims Claims) error
 }
 
 type svc struct {
 	userRepo            UserRepo
 	challengeRepo       ChallengeRepo
+	attributeRepo       AttributeRepo
+	groupRepo           GroupRepo
+	policyRepo          PolicyRepo
+	consentRepo         ConsentRepo
+	erasureRepo         ErasureRepo
+	auditRepo           AuditRepo
+	transactor          repository.Transactor
 	notificationService notification.Svc
+
+	users      repository.Repository[User]
+	challenges repository.Repository[Challenge]
+	attributes repository.Repository[Attribute]
+	groups     repository.Repository[Group]
+	policies   repository.Repository[Policy]
+	consents   repository.Repository[Consent]
+	erasures   repository.Repository[Erasure]
+	audit      repository.Repository[AuditEntry]
 }
 
-func NewService(userRepo UserRepo, challengeRepo ChallengeRepo, notificationService notification.Svc) Svc {
+func NewService(userRepo UserRepo, challengeRepo ChallengeRepo, attributeRepo AttributeRepo, groupRepo GroupRepo, policyRepo PolicyRepo, consentRepo ConsentRepo, erasureRepo ErasureRepo, auditRepo AuditRepo, transactor repository.Transactor, notificationService notification.Svc) Svc {
 	return svc{
 		userRepo:            userRepo,
 		challengeRepo:       challengeRepo,
+		attributeRepo:       attributeRepo,
+		groupRepo:           groupRepo,
+		policyRepo:          policyRepo,
+		consentRepo:         consentRepo,
+		erasureRepo:         erasureRepo,
+		auditRepo:           auditRepo,
+		transactor:          transactor,
 		notificationService: notificationService,
+
+		users:      repository.New[User](userRepo),
+		challenges: repository.New[Challenge](challengeRepo),
+		attributes: repository.New[Attribute](attributeRepo),
+		groups:     repository.New[Group](groupRepo),
+		policies:   repository.New[Policy](policyRepo),
+		consents:   repository.New[Consent](consentRepo),
+		erasures:   repository.New[Erasure](erasureRepo),
+		audit:      repository.New[AuditEntry](auditRepo),
 	}
 }
 
@@ -61,6 +156,13 @@ type Claims struct {
 	UserVersion int          `json:"userVersion"`
 	Verified    bool         `json:"verified,omitempty"`
 	Role        Role         `json:"role"`
+	Roles       []Role       `json:"roles,omitempty"`
+	Scope       Scope        `json:"scope,omitempty"`
+
+	// AuthTime is when the user last authenticated and Amr the methods they
+	// authenticated with, as defined by OpenID Connect
+	AuthTime int64        `json:"auth_time,omitempty"`
+	Amr      []AuthMethod `json:"amr,omitempty"`
 	jwt.StandardClaims
 }
 
@@ -70,16 +172,12 @@ func (s svc) FindUser(ctx context.Context, id primitive.Id) (User, error) {
 		return User{}, err
 	}
 
-	copier, err := s.userRepo.FindById(ctx, id)
+	user, err := s.findUserById(ctx, id)
 	if err != nil {
-		if errors.Is(err, exception.ErrNotFound) {
-			return User{}, errors.New(exception.UserNotFound)
-		}
 		return User{}, err
 	}
 
-	var user User
-	return user, copier.Copy(&user)
+	return s.withSchemaAttributes(ctx, user)
 }
 
 func (s svc) VerifySeedUser(ctx context.Context) error {
@@ -88,22 +186,35 @@ func (s svc) VerifySeedUser(ctx context.Context) error {
 	if err != nil || exist {
 		return err
 	}
-	_, err = s.userRepo.Create(ctx, User{
+
+	seedPhone, err := Phone{Number: conf.SeedPhoneNumber}.normalise()
+	if err != nil {
+		return fmt.Errorf("could not normalise the seed phone number %w", err)
+	}
+
+	seedEmail, err := newEmailIdentity(conf.SeedEmailId)
+	if err != nil {
+		return fmt.Errorf("could not canonicalise the seed email ID %w", err)
+	}
+
+	_, err = s.users.Create(ctx, User{
 		Role: PlatformAdmin,
 		Name: "Root administrator",
-		Identities: []Identity{{
-			Type:    EMAIL,
-			EmailId: conf.SeedEmailId,
-		}, {
+		Identities: []Identity{seedEmail, {
 			Type:  PHONE,
-			Phone: &Phone{Number: conf.SeedPhoneNumber},
+			Phone: &seedPhone,
 		}},
 	})
 	return err
 }
 
 func (s svc) DoesPhoneNumberExist(ctx context.Context, phoneNumber string) (bool, error) {
-	count, err := s.userRepo.Count(ctx, []repository.Filter{{Key: "identities.phone.number", Value: phoneNumber}})
+	phone, err := Phone{Number: phoneNumber}.normalise()
+	if err != nil {
+		return false, err
+	}
+
+	count, err := s.userRepo.Count(ctx, []repository.Filter{{Key: "identities.phone.number", Value: phone.Number}})
 	if err != nil {
 		return false, fmt.Errorf("could count the number of users with the given phone number %w", err)
 	}
@@ -112,7 +223,12 @@ func (s svc) DoesPhoneNumberExist(ctx context.Context, phoneNumber string) (bool
 }
 
 func (s svc) DoesEmailIdExist(ctx context.Context, emailId string) (bool, error) {
-	count, err := s.userRepo.Count(ctx, []repository.Filter{{Key: "identities.emailId", Value: emailId}})
+	canonicalEmailId, err := validation.CanonicaliseEmailId(emailId)
+	if err != nil {
+		return false, err
+	}
+
+	count, err := s.userRepo.Count(ctx, []repository.Filter{{Key: "identities.canonicalEmailId", Value: canonicalEmailId}})
 	if err != nil {
 		return false, fmt.Errorf("could count the number of users with the given emailId %w", err)
 	}
@@ -126,7 +242,28 @@ func (s svc) FindMe(ctx context.Context) (User, error) {
 		return User{}, errors.New(exception.Unauthorised)
 	}
 
-	copier, err := s.userRepo.FindById(ctx, claims.UserId)
+	user, err := s.findUserById(ctx, claims.UserId)
+	if err != nil {
+		return User{}, err
+	}
+
+	return s.withSchemaAttributes(ctx, user)
+}
+
+// withSchemaAttributes drops the attributes of the user which are not defined
+// in the attribute schema.
+func (s svc) withSchemaAttributes(ctx context.Context, user User) (User, error) {
+	schema, err := s.attributeSchema(ctx)
+	if err != nil {
+		return User{}, err
+	}
+
+	user.Attributes = schema.filter(user.Attributes)
+	return user, nil
+}
+
+func (s svc) findUserById(ctx context.Context, id primitive.Id) (User, error) {
+	user, err := s.users.FindById(ctx, id)
 	if err != nil {
 		if errors.Is(err, exception.ErrNotFound) {
 			return User{}, errors.New(exception.UserNotFound)
@@ -134,8 +271,7 @@ func (s svc) FindMe(ctx context.Context) (User, error) {
 		return User{}, err
 	}
 
-	var user User
-	return user, copier.Copy(&user)
+	return user, nil
 }
 
 func (s svc) FindUserByIdentity(ctx context.Context, identity Identity) (User, error) {
@@ -143,26 +279,35 @@ func (s svc) FindUserByIdentity(ctx context.Context, identity Identity) (User, e
 		return User{}, errors.New(exception.IdentityTypeNotFound)
 	}
 
-	var copier repository.Copier
+	var user User
 	var err error
 	if identity.Type == PHONE {
-		filters := []repository.Filter{{Key: "identities.phone.number", Value: identity.Phone.Number}}
-		copier, err = s.userRepo.FindSingle(ctx, filters)
+		if identity.Phone == nil {
+			return User{}, errors.New(exception.PhoneNumberInvalid)
+		}
+		var phone Phone
+		phone, err = identity.Phone.normalise()
+		if err != nil {
+			return User{}, err
+		}
+		filters := []repository.Filter{{Key: "identities.phone.number", Value: phone.Number}}
+		user, err = s.users.FindSingle(ctx, filters)
 	}
 
 	if identity.Type == EMAIL {
-		filters := []repository.Filter{{Key: "identities.emailId", Value: identity.EmailId}}
-		copier, err = s.userRepo.FindSingle(ctx, filters)
+		var canonicalEmailId string
+		canonicalEmailId, err = validation.CanonicaliseEmailId(identity.EmailId)
+		if err != nil {
+			return User{}, err
+		}
+		filters := []repository.Filter{{Key: "identities.canonicalEmailId", Value: canonicalEmailId}}
+		user, err = s.users.FindSingle(ctx, filters)
 	}
 
 	if err != nil {
 		return User{}, err
 	}
-
-	var user User
-	err = copier.Copy(&user)
-
-	return user, err
+	return user, nil
 }
 
 func (s svc) Invite(ctx context.Context, inviteReq InviteReq) (User, error) {
@@ -171,6 +316,16 @@ func (s svc) Invite(ctx context.Context, inviteReq InviteReq) (User, error) {
 		return User{}, err
 	}
 
+	emailIdentity, err := newEmailIdentity(inviteReq.EmailId)
+	if err != nil {
+		return User{}, err
+	}
+
+	inviteReq.Phone, err = inviteReq.Phone.normalise()
+	if err != nil {
+		return User{}, err
+	}
+
 	phoneNumberExists, err := s.DoesPhoneNumberExist(ctx, inviteReq.Phone.Number)
 	if err != nil {
 		return User{}, fmt.Errorf("could not check if the user exists %w", err)
@@ -180,11 +335,11 @@ func (s svc) Invite(ctx context.Context, inviteReq InviteReq) (User, error) {
 		return User{}, errors.New(exception.UserAlreadyExists)
 	}
 
-	copier, err := s.userRepo.Create(ctx, User{
+	user, err := s.users.Create(ctx, User{
 		Role: inviteReq.Role,
 		Name: inviteReq.Name,
 		Identities: []Identity{
-			{Type: EMAIL, EmailId: inviteReq.EmailId},
+			emailIdentity,
 			{Type: PHONE, Phone: &inviteReq.Phone},
 		},
 	})
@@ -192,12 +347,6 @@ func (s svc) Invite(ctx context.Context, inviteReq InviteReq) (User, error) {
 		return User{}, fmt.Errorf("could not save the user to persistence %w", err)
 	}
 
-	var user User
-	err = copier.Copy(&user)
-	if err != nil {
-		return User{}, fmt.Errorf("could not copy the persistence response to variable %w", err)
-	}
-
 	return user, nil
 }
 
@@ -207,7 +356,7 @@ func (s svc) UpdatePassword(ctx context.Context, userId primitive.Id, req Update
 		return false, err
 	}
 
-	copier, err := s.userRepo.FindById(ctx, userId)
+	user, err := s.users.FindById(ctx, userId)
 	if err != nil {
 		if errors.Is(err, exception.ErrNotFound) {
 			return false, errors.New(exception.UserNotFound)
@@ -215,13 +364,7 @@ func (s svc) UpdatePassword(ctx context.Context, userId primitive.Id, req Update
 		return false, err
 	}
 
-	var user User
-	err = copier.Copy(&user)
-	if err != nil {
-		return false, fmt.Errorf("could not copy the persistence response to variable %w", err)
-	}
-
-	if claims.UserVersion != user.Version || claims.Verified == false {
+	if claims.UserVersion != user.Version {
 		return false, fmt.Errorf(exception.Unauthorised)
 	}
 
@@ -229,6 +372,11 @@ func (s svc) UpdatePassword(ctx context.Context, userId primitive.Id, req Update
 		return false, errors.New(exception.Forbidden)
 	}
 
+	conf, _ := config.Get()
+	if err := requireRecentAuth(claims, conf.PasswordStepUpMaxAge, OtpAuth, RecoveryCodeAuth); err != nil {
+		return false, err
+	}
+
 	user.updatePassword(req.Password)
 
 	patchers := []repository.Patch{
@@ -236,8 +384,14 @@ func (s svc) UpdatePassword(ctx context.Context, userId primitive.Id, req Update
 		{"$inc", "version", 1},
 	}
 	err = s.userRepo.Patch(ctx, user.Id, patchers)
+	if err != nil {
+		return false, err
+	}
+	user.Version += 1
+
+	s.notifySecurityEvent(ctx, user, user.Identities, notification.SecurityNotification{Event: notification.PasswordChanged})
 
-	return true, err
+	return true, nil
 }
 
 func VerifyActionToken(ctx context.Context) (Claims, error) {
@@ -245,6 +399,9 @@ func VerifyActionToken(ctx context.Context) (Claims, error) {
 	if !ok || claims.UserId == "" || claims.Role == "" {
 		return Claims{}, errors.New(exception.Unauthorised)
 	}
+	if claims.Scope != "" && claims.Scope != RecoveryScope {
+		return Claims{}, errors.New(exception.Forbidden)
+	}
 	return claims, nil
 }
 
@@ -254,8 +411,13 @@ func VerifySession(ctx context.Context, hasAnyRole []Role) (Claims, error) {
 		return Claims{}, errors.New(exception.Unauthorised)
 	}
 
+	if claims.Scope != "" {
+		return Claims{}, errors.New(exception.Forbidden)
+	}
+
+	// Tokens created before groups were introduced only have the direct role
 	for _, role := range hasAnyRole {
-		if role == claims.Role {
+		if role == claims.Role || hasRole(claims.Roles, role) {
 			return claims, nil
 		}
 	}
@@ -0,0 +1,284 @@
+package iam
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dannypaul/go-skeleton/internal/driver/platform/memory"
+	"github.com/dannypaul/go-skeleton/internal/exception"
+	"github.com/dannypaul/go-skeleton/internal/notification"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+)
+
+type noopNotifications struct{}
+
+func (noopNotifications) VerifyPhone(ctx context.Context, phoneNumber string, otp string) error {
+	return nil
+}
+
+func (noopNotifications) VerifyEmailId(ctx context.Context, emailId string, otp string) error {
+	return nil
+}
+
+func (noopNotifications) SecurityEventPhone(ctx context.Context, phoneNumber string, n notification.SecurityNotification) error {
+	return nil
+}
+
+func (noopNotifications) SecurityEventEmailId(ctx context.Context, emailId string, n notification.SecurityNotification) error {
+	return nil
+}
+
+// newTestService returns a service backed by in-memory repositories with the
+// unique indexes of the migrations.
+func newTestService(t *testing.T) svc {
+	env := map[string]string{
+		"PORT":                  "8080",
+		"MIGRATION_SOURCE_PATH": "file://migration",
+		"SEED_EMAIL_ID":         "root@example.com",
+		"SEED_PHONE_NUMBER":     "+919999999999",
+		"MONGO_URI":             "mongodb://localhost",
+		"MONGO_DATABASE_NAME":   "test",
+		"LOG_LEVEL":             "error",
+		"JWT_SECRET":            "secret",
+		"JWT_TTL":               "1h",
+		"CHALLENGE_TTL":         "5m",
+		"SIGNUP_MODE":           string(SignupOpen),
+	}
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+
+	users := memory.NewCollection(
+		memory.UniqueIndex{Keys: []string{"identities.phone.number"}},
+		memory.UniqueIndex{Keys: []string{"identities.canonicalEmailId"}},
+	)
+	challenges := memory.NewCollection()
+	attributes := memory.NewCollection(memory.UniqueIndex{Keys: []string{"name"}})
+	groups := memory.NewCollection(memory.UniqueIndex{Keys: []string{"name"}})
+	policies := memory.NewCollection(memory.UniqueIndex{Keys: []string{"type", "version"}})
+	consents := memory.NewCollection(memory.UniqueIndex{Keys: []string{"userId", "policyId"}})
+	erasures := memory.NewCollection(memory.UniqueIndex{
+		Keys:    []string{"userId"},
+		Partial: []repository.Filter{{Key: "status", Value: ErasureScheduled}},
+	})
+	audit := memory.NewCollection()
+	return NewService(
+		users,
+		challenges,
+		attributes,
+		groups,
+		policies,
+		consents,
+		erasures,
+		audit,
+		memory.NewTransactor(users, challenges, attributes, groups, policies, consents, erasures, audit),
+		noopNotifications{},
+	).(svc)
+}
+
+func TestSignup(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	user, err := s.Signup(ctx, SignupReq{Name: "Ada", IdentityType: EMAIL, EmailId: "Ada@Example.com"})
+	if err != nil {
+		t.Fatalf("Could not sign up, got: %v", err)
+	}
+	if user.Status != Active || user.Role != Member {
+		t.Errorf("Signed up user was incorrect, got: %+v", user)
+	}
+
+	_, err = s.Signup(ctx, SignupReq{Name: "Ada", IdentityType: EMAIL, EmailId: "ada@example.com"})
+	if err == nil || err.Error() != exception.UserAlreadyExists {
+		t.Errorf("Sign-up with the same email ID was allowed, got: %v", err)
+	}
+
+	found, err := s.FindUserByIdentity(ctx, Identity{Type: EMAIL, EmailId: "ADA@example.com"})
+	if err != nil || found.Id != user.Id {
+		t.Errorf("Could not find the user by email ID, got: %+v, %v", found, err)
+	}
+
+	_, err = s.Login(ctx, LoginReq{IdentityType: EMAIL, EmailId: "ada@example.com", Password: "password"})
+	if err == nil || err.Error() != exception.UserVerificationIncomplete {
+		t.Errorf("Login before verifying the email ID was allowed, got: %v", err)
+	}
+}
+
+func TestVerifySeedUser(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	for i := 0; i < 2; i++ {
+		if err := s.VerifySeedUser(ctx); err != nil {
+			t.Fatalf("Could not verify the seed user, got: %v", err)
+		}
+	}
+
+	count, _ := s.userRepo.Count(ctx, []repository.Filter{{Key: "role", Value: PlatformAdmin}})
+	if count != 1 {
+		t.Errorf("Seed user count was incorrect, got: %d, want: 1.", count)
+	}
+}
+
+func TestPendingApproval(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	t.Setenv("SIGNUP_MODE", string(SignupApproval))
+
+	user, err := s.Signup(ctx, SignupReq{Name: "Ada", IdentityType: EMAIL, EmailId: "ada@example.com"})
+	if err != nil || user.Status != PendingApproval {
+		t.Fatalf("Could not sign up, got: %+v, %v", user, err)
+	}
+
+	challenge := Challenge{IdentityType: EMAIL, EmailId: "ada@example.com", OTP: "123456"}
+	if err = challenge.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.challenges.Create(ctx, challenge); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Verify(ctx, VerifyReq{IdentityType: EMAIL, EmailId: "ada@example.com", OTP: "123456"})
+	if err == nil || err.Error() != exception.UserApprovalPending {
+		t.Errorf("Verification before approval was allowed, got: %v", err)
+	}
+
+	if err = s.ValidateSession(ctx, Claims{UserId: user.Id}); err == nil || err.Error() != exception.Unauthorised {
+		t.Errorf("Session of a user pending approval was accepted, got: %v", err)
+	}
+}
+
+func TestUniqueAttribute(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	if _, err := s.attributes.Create(ctx, Attribute{Name: "employeeId", Type: StringAttribute, Unique: true, EditableBy: AdminEditor}); err != nil {
+		t.Fatal(err)
+	}
+	var users []User
+	for _, name := range []string{"Ada", "Grace"} {
+		user, err := s.users.Create(ctx, User{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+
+	if _, err := s.updateAttributes(ctx, users[0], map[string]interface{}{"employeeId": "E1"}, true); err != nil {
+		t.Fatalf("Could not set the attribute, got: %v", err)
+	}
+	_, err := s.updateAttributes(ctx, users[1], map[string]interface{}{"employeeId": "E1"}, true)
+	if err == nil || err.Error() != exception.AttributeValueNotUnique {
+		t.Errorf("Duplicate value of a unique attribute was allowed, got: %v", err)
+	}
+}
+
+func TestRequiredAttribute(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	for _, attribute := range []Attribute{
+		{Name: "employeeId", Type: StringAttribute, Required: true, EditableBy: AdminEditor},
+		{Name: "department", Type: StringAttribute, EditableBy: AdminEditor},
+	} {
+		if _, err := s.attributes.Create(ctx, attribute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	user, err := s.users.Create(ctx, User{Name: "Ada"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A missing required attribute does not block the edits of the others
+	user, err = s.updateAttributes(ctx, user, map[string]interface{}{"department": "Research"}, true)
+	if err != nil {
+		t.Fatalf("Could not set the attribute, got: %v", err)
+	}
+	user, err = s.updateAttributes(ctx, user, map[string]interface{}{"employeeId": "E1"}, true)
+	if err != nil {
+		t.Fatalf("Could not set the required attribute, got: %v", err)
+	}
+
+	_, err = s.updateAttributes(ctx, user, map[string]interface{}{"employeeId": nil}, true)
+	if err == nil || err.Error() != exception.AttributeRequired {
+		t.Errorf("Required attribute was removed, got: %v", err)
+	}
+}
+
+func TestReportActivity(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	user, err := s.users.Create(ctx, User{Name: "Ada", Status: Active})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := user.createScopedToken(Authentication{}, ReportScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reported, err := s.ReportActivity(ctx, ReportReq{Token: token})
+	if err != nil || !reported {
+		t.Fatalf("Could not report the activity, got: %v, %v", reported, err)
+	}
+	if locked, _ := s.users.FindById(ctx, user.Id); locked.Status != Locked {
+		t.Errorf("User was not locked, got: %+v", locked)
+	}
+
+	if _, err = s.ReportActivity(ctx, ReportReq{Token: token}); err == nil || err.Error() != exception.Unauthorised {
+		t.Errorf("Report token was used twice, got: %v", err)
+	}
+}
+
+func TestReportActivityInactive(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	user, err := s.users.Create(ctx, User{Name: "Ada", Status: Deactivated})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := user.createScopedToken(Authentication{}, ReportScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = s.ReportActivity(ctx, ReportReq{Token: token}); err == nil {
+		t.Errorf("Deactivated user was locked")
+	}
+	if found, _ := s.users.FindById(ctx, user.Id); found.Status != Deactivated {
+		t.Errorf("Status of the deactivated user was changed, got: %+v", found)
+	}
+}
+
+func TestEraseDueUsers(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	user, err := s.users.Create(ctx, User{Name: "Ada", Status: Active, Identities: IdentityList{
+		{Type: EMAIL, EmailId: "ada@example.com", CanonicalEmailId: "ada@example.com"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	erasure, err := s.erasures.Create(ctx, Erasure{UserId: user.Id, Status: ErasureScheduled, ScheduledAt: time.Now().Add(-time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	erased, err := s.EraseDueUsers(ctx)
+	if err != nil || erased != 1 {
+		t.Fatalf("Due user was not erased, got: %d, %v", erased, err)
+	}
+	if found, _ := s.users.FindById(ctx, user.Id); found.Status != Erased || len(found.Identities) != 0 {
+		t.Errorf("User was not erased, got: %+v", found)
+	}
+
+	// A replica which found the erasure before it completed erases nothing
+	if _, err = s.erase(ctx, erasure); err == nil || err.Error() != exception.ErasureNotRequested {
+		t.Errorf("Completed erasure was erased again, got: %v", err)
+	}
+}
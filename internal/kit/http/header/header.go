@@ -1,7 +1,14 @@
 package header
 
 const (
-	Authorization = "Authorization"
-	ContentType   = "Content-Type"
-	CorrelationId = "X-Correlation-ID"
+	Authorization      = "Authorization"
+	ContentType        = "Content-Type"
+	ContentDisposition = "Content-Disposition"
+	CorrelationId      = "X-Correlation-ID"
+	ETag               = "ETag"
+	ForwardedFor       = "X-Forwarded-For"
+	IfMatch            = "If-Match"
+	Link               = "Link"
+	RetryAfter         = "Retry-After"
+	WWWAuthenticate    = "WWW-Authenticate"
 )
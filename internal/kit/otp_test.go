@@ -9,3 +9,10 @@ func TestOtpLength(t *testing.T) {
 		t.Errorf("OTP length was incorrect, got: %d, want: %d.", len(otp), otpLength)
 	}
 }
+
+func TestRecoveryCodeFormat(t *testing.T) {
+	code, _ := GenerateRecoveryCode()
+	if len(code) != 11 || code[5] != '-' {
+		t.Errorf("Recovery code format was incorrect, got: %s, want: xxxxx-xxxxx.", code)
+	}
+}
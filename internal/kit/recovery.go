@@ -0,0 +1,38 @@
+package kit
+
+import (
+	"crypto/rand"
+)
+
+// Visually ambiguous characters like 0, o, 1, i and l are left out
+const recoveryCodeChars = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// GenerateRecoveryCode returns a random code formatted as two groups of five
+// characters separated by a hyphen.
+func GenerateRecoveryCode() (string, error) {
+	const length = 10
+
+	code := make([]byte, 0, length+1)
+	buffer := make([]byte, 1)
+	for len(code) < length+1 {
+		if len(code) == length/2 {
+			code = append(code, '-')
+			continue
+		}
+
+		_, err := rand.Read(buffer)
+		if err != nil {
+			return "", err
+		}
+
+		// Discard bytes above the largest multiple of the alphabet length so
+		// that every character is equally likely
+		limit := 256 - 256%len(recoveryCodeChars)
+		if int(buffer[0]) >= limit {
+			continue
+		}
+		code = append(code, recoveryCodeChars[int(buffer[0])%len(recoveryCodeChars)])
+	}
+
+	return string(code), nil
+}
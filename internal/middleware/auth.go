@@ -5,51 +5,42 @@ import (
 	"net/http"
 	"strings"
 
-	"github.com/dannypaul/go-skeleton/internal/config"
 	"github.com/dannypaul/go-skeleton/internal/iam"
 	"github.com/dannypaul/go-skeleton/internal/kit/http/header"
-
-	"github.com/dgrijalva/jwt-go"
 )
 
-func Auth(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		conf, err := config.Get()
-		if err != nil {
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			return
-		}
+func Auth(iamService iam.Svc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var claims iam.Claims
+			authHeader := r.Header.Get(header.Authorization)
+			if authHeader == "" {
+				ctx := context.WithValue(r.Context(), iam.CtxClaimsKey, claims)
+				r = r.WithContext(ctx)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var authToken string
+			splitHeader := strings.Split(authHeader, "Bearer ")
+			if len(splitHeader) > 1 {
+				authToken = splitHeader[1]
+			}
+
+			claims, err := iam.ParseToken(authToken)
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+
+			err = iamService.ValidateSession(r.Context(), claims)
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
 
-		var claims iam.Claims
-		authHeader := r.Header.Get(header.Authorization)
-		if authHeader == "" {
 			ctx := context.WithValue(r.Context(), iam.CtxClaimsKey, claims)
-			r = r.WithContext(ctx)
-			next.ServeHTTP(w, r)
-			return
-		}
-
-		var authToken string
-		splitHeader := strings.Split(authHeader, "Bearer ")
-		if len(splitHeader) > 1 {
-			authToken = splitHeader[1]
-		}
-		token, err := jwt.ParseWithClaims(authToken, &claims, func(token *jwt.Token) (interface{}, error) {
-			return []byte(conf.JwtSecret), nil
+			next.ServeHTTP(w, r.WithContext(ctx))
 		})
-
-		if err != nil {
-			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
-			return
-		}
-
-		_, ok := token.Claims.(*iam.Claims)
-		if !ok || !token.Valid || claims.UserId == "" {
-			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
-			return
-		}
-
-		ctx := context.WithValue(r.Context(), iam.CtxClaimsKey, claims)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+	}
 }
@@ -20,7 +20,7 @@ func CorrelationId(next http.Handler) http.Handler {
 
 		w.Header().Set(header.CorrelationId, correlationID)
 
-		ctx = context.WithValue(ctx, "correlationId", uuid.New().String())
+		ctx = context.WithValue(ctx, "correlationId", correlationID)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
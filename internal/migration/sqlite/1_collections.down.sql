@@ -0,0 +1,23 @@
+DROP TABLE "audit_keys";
+DROP TABLE "audit";
+
+DROP TABLE "erasures_keys";
+DROP TABLE "erasures";
+
+DROP TABLE "consents_keys";
+DROP TABLE "consents";
+
+DROP TABLE "policies_keys";
+DROP TABLE "policies";
+
+DROP TABLE "groups_keys";
+DROP TABLE "groups";
+
+DROP TABLE "attributes_keys";
+DROP TABLE "attributes";
+
+DROP TABLE "challenges_keys";
+DROP TABLE "challenges";
+
+DROP TABLE "users_keys";
+DROP TABLE "users";
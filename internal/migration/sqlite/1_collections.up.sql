@@ -0,0 +1,111 @@
+CREATE TABLE "users" (
+  id TEXT PRIMARY KEY,
+  doc TEXT NOT NULL
+);
+CREATE TABLE "users_keys" (
+  id TEXT NOT NULL,
+  name TEXT NOT NULL,
+  key TEXT NOT NULL,
+  is_unique INTEGER NOT NULL
+);
+CREATE UNIQUE INDEX "users_keys_unique" ON "users_keys" (name, key) WHERE is_unique = 1;
+CREATE INDEX "users_keys_name_key" ON "users_keys" (name, key);
+CREATE INDEX "users_keys_id" ON "users_keys" (id);
+
+CREATE TABLE "challenges" (
+  id TEXT PRIMARY KEY,
+  doc TEXT NOT NULL
+);
+CREATE TABLE "challenges_keys" (
+  id TEXT NOT NULL,
+  name TEXT NOT NULL,
+  key TEXT NOT NULL,
+  is_unique INTEGER NOT NULL
+);
+CREATE UNIQUE INDEX "challenges_keys_unique" ON "challenges_keys" (name, key) WHERE is_unique = 1;
+CREATE INDEX "challenges_keys_name_key" ON "challenges_keys" (name, key);
+CREATE INDEX "challenges_keys_id" ON "challenges_keys" (id);
+
+CREATE TABLE "attributes" (
+  id TEXT PRIMARY KEY,
+  doc TEXT NOT NULL
+);
+CREATE TABLE "attributes_keys" (
+  id TEXT NOT NULL,
+  name TEXT NOT NULL,
+  key TEXT NOT NULL,
+  is_unique INTEGER NOT NULL
+);
+CREATE UNIQUE INDEX "attributes_keys_unique" ON "attributes_keys" (name, key) WHERE is_unique = 1;
+CREATE INDEX "attributes_keys_name_key" ON "attributes_keys" (name, key);
+CREATE INDEX "attributes_keys_id" ON "attributes_keys" (id);
+
+CREATE TABLE "groups" (
+  id TEXT PRIMARY KEY,
+  doc TEXT NOT NULL
+);
+CREATE TABLE "groups_keys" (
+  id TEXT NOT NULL,
+  name TEXT NOT NULL,
+  key TEXT NOT NULL,
+  is_unique INTEGER NOT NULL
+);
+CREATE UNIQUE INDEX "groups_keys_unique" ON "groups_keys" (name, key) WHERE is_unique = 1;
+CREATE INDEX "groups_keys_name_key" ON "groups_keys" (name, key);
+CREATE INDEX "groups_keys_id" ON "groups_keys" (id);
+
+CREATE TABLE "policies" (
+  id TEXT PRIMARY KEY,
+  doc TEXT NOT NULL
+);
+CREATE TABLE "policies_keys" (
+  id TEXT NOT NULL,
+  name TEXT NOT NULL,
+  key TEXT NOT NULL,
+  is_unique INTEGER NOT NULL
+);
+CREATE UNIQUE INDEX "policies_keys_unique" ON "policies_keys" (name, key) WHERE is_unique = 1;
+CREATE INDEX "policies_keys_name_key" ON "policies_keys" (name, key);
+CREATE INDEX "policies_keys_id" ON "policies_keys" (id);
+
+CREATE TABLE "consents" (
+  id TEXT PRIMARY KEY,
+  doc TEXT NOT NULL
+);
+CREATE TABLE "consents_keys" (
+  id TEXT NOT NULL,
+  name TEXT NOT NULL,
+  key TEXT NOT NULL,
+  is_unique INTEGER NOT NULL
+);
+CREATE UNIQUE INDEX "consents_keys_unique" ON "consents_keys" (name, key) WHERE is_unique = 1;
+CREATE INDEX "consents_keys_name_key" ON "consents_keys" (name, key);
+CREATE INDEX "consents_keys_id" ON "consents_keys" (id);
+
+CREATE TABLE "erasures" (
+  id TEXT PRIMARY KEY,
+  doc TEXT NOT NULL
+);
+CREATE TABLE "erasures_keys" (
+  id TEXT NOT NULL,
+  name TEXT NOT NULL,
+  key TEXT NOT NULL,
+  is_unique INTEGER NOT NULL
+);
+CREATE UNIQUE INDEX "erasures_keys_unique" ON "erasures_keys" (name, key) WHERE is_unique = 1;
+CREATE INDEX "erasures_keys_name_key" ON "erasures_keys" (name, key);
+CREATE INDEX "erasures_keys_id" ON "erasures_keys" (id);
+
+CREATE TABLE "audit" (
+  id TEXT PRIMARY KEY,
+  doc TEXT NOT NULL
+);
+CREATE TABLE "audit_keys" (
+  id TEXT NOT NULL,
+  name TEXT NOT NULL,
+  key TEXT NOT NULL,
+  is_unique INTEGER NOT NULL
+);
+CREATE UNIQUE INDEX "audit_keys_unique" ON "audit_keys" (name, key) WHERE is_unique = 1;
+CREATE INDEX "audit_keys_name_key" ON "audit_keys" (name, key);
+CREATE INDEX "audit_keys_id" ON "audit_keys" (id);
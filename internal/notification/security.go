@@ -0,0 +1,110 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+)
+
+type SecurityEvent string
+
+const (
+	NewDeviceLogin   SecurityEvent = "NEW_DEVICE_LOGIN"
+	PasswordChanged  SecurityEvent = "PASSWORD_CHANGED"
+	IdentityAdded    SecurityEvent = "IDENTITY_ADDED"
+	IdentityRemoved  SecurityEvent = "IDENTITY_REMOVED"
+	AccountLocked    SecurityEvent = "ACCOUNT_LOCKED"
+	RecoveryCodeUsed SecurityEvent = "RECOVERY_CODE_USED"
+)
+
+// SecurityNotification is rendered with the template of its event. ReportUrl
+// is the "this wasn't me" link, it is left out of the message when empty.
+type SecurityNotification struct {
+	Event     SecurityEvent
+	Name      string
+	IP        string
+	UserAgent string
+	Identity  string
+	Remaining int
+	ReportUrl string
+}
+
+type securityTemplate struct {
+	subject string
+	email   *htmltemplate.Template
+	sms     *texttemplate.Template
+}
+
+func newSecurityTemplate(subject string, email string, sms string) securityTemplate {
+	const reportEmail = `{{if .ReportUrl}}<p>If this wasn't you, <a href="{{.ReportUrl}}">lock your account</a> immediately.</p>{{end}}`
+	const reportSms = `{{if .ReportUrl}} If this wasn't you, lock your account: {{.ReportUrl}}{{end}}`
+
+	return securityTemplate{
+		subject: subject,
+		email:   htmltemplate.Must(htmltemplate.New(subject).Parse("<div><p>Hello {{.Name}},</p>" + email + reportEmail + "</div>")),
+		sms:     texttemplate.Must(texttemplate.New(subject).Parse(sms + reportSms)),
+	}
+}
+
+var securityTemplates = map[SecurityEvent]securityTemplate{
+	NewDeviceLogin: newSecurityTemplate(
+		"New sign-in to your app-name account",
+		"<p>Your app-name account was signed in to from a new device.</p><p>IP address: {{.IP}}<br>Device: {{.UserAgent}}</p>",
+		"New app-name sign-in from {{.IP}}.",
+	),
+	PasswordChanged: newSecurityTemplate(
+		"Your app-name password was changed",
+		"<p>The password of your app-name account was changed.</p>",
+		"Your app-name password was changed.",
+	),
+	IdentityAdded: newSecurityTemplate(
+		"A sign-in method was added to your app-name account",
+		"<p>{{.Identity}} was added to your app-name account.</p>",
+		"{{.Identity}} was added to your app-name account.",
+	),
+	IdentityRemoved: newSecurityTemplate(
+		"A sign-in method was removed from your app-name account",
+		"<p>{{.Identity}} was removed from your app-name account.</p>",
+		"{{.Identity}} was removed from your app-name account.",
+	),
+	AccountLocked: newSecurityTemplate(
+		"Your app-name account was locked",
+		"<p>Your app-name account was locked after too many failed sign-in attempts.</p>",
+		"Your app-name account was locked after too many failed sign-in attempts.",
+	),
+	RecoveryCodeUsed: newSecurityTemplate(
+		"A recovery code was used to sign in to your app-name account",
+		"<p>A recovery code was used to sign in to your app-name account. You have {{.Remaining}} recovery codes left.</p>",
+		"A recovery code was used to sign in to your app-name account. {{.Remaining}} codes left.",
+	),
+}
+
+func (s svc) SecurityEventEmailId(ctx context.Context, emailId string, notification SecurityNotification) error {
+	template, ok := securityTemplates[notification.Event]
+	if !ok {
+		return fmt.Errorf("no template for security event %s", notification.Event)
+	}
+
+	var html bytes.Buffer
+	if err := template.email.Execute(&html, notification); err != nil {
+		return err
+	}
+
+	return sendEmail(ctx, emailId, template.subject, html.String())
+}
+
+func (s svc) SecurityEventPhone(ctx context.Context, phoneNumber string, notification SecurityNotification) error {
+	template, ok := securityTemplates[notification.Event]
+	if !ok {
+		return fmt.Errorf("no template for security event %s", notification.Event)
+	}
+
+	var message bytes.Buffer
+	if err := template.sms.Execute(&message, notification); err != nil {
+		return err
+	}
+
+	return sendSms(ctx, phoneNumber, message.String())
+}
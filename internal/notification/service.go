@@ -13,6 +13,8 @@ import (
 type Svc interface {
 	VerifyPhone(ctx context.Context, phoneNumber string, otp string) error
 	VerifyEmailId(ctx context.Context, emailId string, otp string) error
+	SecurityEventPhone(ctx context.Context, phoneNumber string, notification SecurityNotification) error
+	SecurityEventEmailId(ctx context.Context, emailId string, notification SecurityNotification) error
 }
 
 type svc struct {
@@ -41,11 +43,19 @@ func (s svc) VerifyEmailId(ctx context.Context, emailId string, otp string) erro
 	query.Set("otp", otp)
 	verifyUserUri.RawQuery = query.Encode()
 
-	domain := "mail.app-name.com"
-	from := "no-reply@" + domain
 	subject := "Please verify your app-name account"
 	html := "<div>Hello from app-name. <a href=" + verifyUserUri.String() + ">Verify</a></div>"
-	to := emailId
+
+	return sendEmail(ctx, emailId, subject, html)
+}
+
+func (s svc) VerifyPhone(ctx context.Context, phoneNumber string, otp string) error {
+	return sendSms(ctx, phoneNumber, "Your app-name login OTP is "+otp)
+}
+
+func sendEmail(ctx context.Context, to string, subject string, html string) error {
+	domain := "mail.app-name.com"
+	from := "no-reply@" + domain
 
 	mailgunUri, err := url.Parse("https://api.mailgun.net")
 	if err != nil {
@@ -63,7 +73,7 @@ func (s svc) VerifyEmailId(ctx context.Context, emailId string, otp string) erro
 	}
 
 	body, err := json.Marshal(mailgunReq)
-	req, err := http.NewRequest("POST", mailgunUri.String(), bytes.NewBuffer(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", mailgunUri.String(), bytes.NewBuffer(body))
 	if err != nil {
 		return err
 	}
@@ -81,7 +91,7 @@ func (s svc) VerifyEmailId(ctx context.Context, emailId string, otp string) erro
 	return err
 }
 
-func (s svc) VerifyPhone(ctx context.Context, phoneNumber string, otp string) error {
+func sendSms(ctx context.Context, phoneNumber string, message string) error {
 	uri, err := url.Parse("https://api.textlocal.in")
 	if err != nil {
 		return err
@@ -92,13 +102,13 @@ func (s svc) VerifyPhone(ctx context.Context, phoneNumber string, otp string) er
 	query := uri.Query()
 	query.Set("apikey", "apikey")
 	query.Set("numbers", phoneNumber)
-	query.Set("message", "Your app-name login OTP is "+otp)
+	query.Set("message", message)
 	query.Set("sender", "TXTLCL")
 	uri.RawQuery = query.Encode()
 
 	emptyBody := bytes.NewBuffer([]byte("{}"))
 
-	req, err := http.NewRequest("POST", uri.String(), emptyBody)
+	req, err := http.NewRequestWithContext(ctx, "POST", uri.String(), emptyBody)
 	if err != nil {
 		return err
 	}
@@ -16,7 +16,7 @@ func (id Id) String() string {
 
 func (id Id) IsValid() bool {
 	_, err := primitive.ObjectIDFromHex(id.String())
-	return err != nil
+	return err == nil
 }
 
 func (id Id) Equals(anotherId Id) bool {
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/dannypaul/go-skeleton/internal/primitive"
+)
+
+// WriteOp is the operation of a write of a bulk write.
+type WriteOp string
+
+const (
+	// InsertOp creates the Document
+	InsertOp WriteOp = "insert"
+	// UpdateOp applies the Patches to the first document matching the Filters
+	UpdateOp WriteOp = "update"
+	// UpsertOp replaces the first document matching the Filters with the
+	// Document, or creates the Document when no document matches
+	UpsertOp WriteOp = "upsert"
+	// DeleteOp deletes the first document matching the Filters
+	DeleteOp WriteOp = "delete"
+)
+
+type Write struct {
+	Op       WriteOp
+	Filters  []Filter
+	Patches  []Patch
+	Document interface{}
+}
+
+// WriteResult is the result of a write of a bulk write. Id is the ID of the
+// document created by an insert or an upsert, and Err is the reason the write
+// failed. The writes violating a unique index fail with exception.ErrConflict.
+type WriteResult struct {
+	Id  primitive.Id
+	Err error
+}
+
+// BulkWriter writes many documents at once, returning a result for each
+// write. An ordered bulk write stops at the first write which fails, and the
+// writes after it fail with exception.ErrWriteSkipped. An unordered bulk write
+// attempts every write. The error is only returned when the bulk write as a
+// whole fails.
+type BulkWriter interface {
+	CreateMany(ctx context.Context, models []interface{}, ordered bool) ([]WriteResult, error)
+	BulkWrite(ctx context.Context, writes []Write, ordered bool) ([]WriteResult, error)
+}
+
+// ManyUpdater applies the patches to every document matching the filters, and
+// returns the number of documents matched.
+type ManyUpdater interface {
+	UpdateMany(ctx context.Context, filters []Filter, patches []Patch) (int64, error)
+}
+
+// Upserter replaces the first document matching the filters with the model,
+// or creates the model when no document matches.
+type Upserter interface {
+	Upsert(ctx context.Context, filters []Filter, model interface{}) (Copier, error)
+}
+
+// Inserts returns the writes creating the models.
+func Inserts(models []interface{}) []Write {
+	writes := make([]Write, len(models))
+	for i, model := range models {
+		writes[i] = Write{Op: InsertOp, Document: model}
+	}
+	return writes
+}
@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/dannypaul/go-skeleton/internal/exception"
+)
+
+// Paginator pages through the documents matching a query with keyset
+// pagination. The next cursor is empty on the last page, and is passed back
+// to get the page which follows. Query.Limit is the size of the pages, and the
+// keys of Query.Sort should be present in every document.
+type Paginator interface {
+	Paginate(ctx context.Context, query Query, cursor string) (ListCopier, string, error)
+}
+
+// Iterator decodes documents one at a time, fetching them as they are
+// consumed. It has to be closed once the documents are no longer needed.
+type Iterator interface {
+	Next(ctx context.Context) bool
+	Decode(destination interface{}) error
+	Err() error
+	Close(ctx context.Context) error
+}
+
+type Streamer interface {
+	Stream(ctx context.Context, query Query) (Iterator, error)
+}
+
+// Sorts returns the sort order of the query with _id breaking the ties, which
+// is the order in which the drivers return the documents.
+func (q Query) Sorts() []Sort {
+	for _, s := range q.Sort {
+		if s.Key == "_id" {
+			return q.Sort
+		}
+	}
+	return append(append([]Sort{}, q.Sort...), Sort{Key: "_id"})
+}
+
+type cursor struct {
+	Values []json.RawMessage `json:"v"`
+}
+
+// NewCursor encodes the values of the Sorts keys of the last document of a
+// page in an opaque cursor. The driver encodes each value as JSON which keeps
+// its type, so that it can decode it back when the cursor is passed to After.
+func NewCursor(values []json.RawMessage) (string, error) {
+	data, err := json.Marshal(cursor{Values: values})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// After returns the query for the documents which follow the cursor in the
+// order of the query, with the values of the cursor decoded by decode. It
+// returns exception.ErrCursorInvalid when the cursor was not created for the
+// order of the query.
+func (q Query) After(encoded string, decode func(value json.RawMessage) (interface{}, error)) (Query, error) {
+	if encoded == "" {
+		return q, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return Query{}, exception.ErrCursorInvalid
+	}
+	var c cursor
+	sorts := q.Sorts()
+	if json.Unmarshal(data, &c) != nil || len(c.Values) != len(sorts) {
+		return Query{}, exception.ErrCursorInvalid
+	}
+	values := make([]interface{}, len(c.Values))
+	for i, value := range c.Values {
+		values[i], err = decode(value)
+		if err != nil {
+			return Query{}, exception.ErrCursorInvalid
+		}
+	}
+
+	// The documents after (a, b) are those with a key greater than a, or
+	// with a key equal to a and b key greater than b
+	var alternatives []Filter
+	for i, s := range sorts {
+		var conditions []Filter
+		for j := 0; j < i; j++ {
+			conditions = append(conditions, Filter{Key: sorts[j].Key, Value: values[j]})
+		}
+
+		operator := Gt
+		if s.Descending {
+			operator = Lt
+		}
+		conditions = append(conditions, Filter{Key: s.Key, Operator: operator, Value: values[i]})
+		alternatives = append(alternatives, And(conditions...))
+	}
+
+	after := q
+	after.Filters = append(append([]Filter{}, q.Filters...), Or(alternatives...))
+	after.Skip = 0
+	return after, nil
+}
@@ -0,0 +1,48 @@
+package repository
+
+type Operator string
+
+const (
+	Eq  Operator = "eq"
+	Ne  Operator = "ne"
+	Gt  Operator = "gt"
+	Gte Operator = "gte"
+	Lt  Operator = "lt"
+	Lte Operator = "lte"
+	// In matches when the value at the key equals one of the elements of the
+	// slice in Value, and Nin when it equals none of them
+	In  Operator = "in"
+	Nin Operator = "nin"
+	// Exists matches when the presence of the key equals the bool in Value
+	Exists Operator = "exists"
+	// Prefix matches the strings starting with the string in Value
+	Prefix Operator = "prefix"
+)
+
+// Or matches the documents matching any of the filters.
+func Or(filters ...Filter) Filter {
+	return Filter{Or: filters}
+}
+
+// And matches the documents matching all of the filters. The filters of a
+// query are already combined with And, it is meant for use within Or.
+func And(filters ...Filter) Filter {
+	return Filter{And: filters}
+}
+
+type Sort struct {
+	Key        string
+	Descending bool
+}
+
+// Query finds the documents matching all of the Filters, ordered by Sort and
+// then by _id. When Projection is set, only the listed dotted keys and
+// _id are returned. Skip and Limit page through the results, a zero Limit
+// returns all of them.
+type Query struct {
+	Filters    []Filter
+	Sort       []Sort
+	Projection []string
+	Skip       int64
+	Limit      int64
+}
@@ -51,10 +51,42 @@ type Finder interface {
 	FindSingle(ctx context.Context, filters []Filter) (Copier, error)
 }
 
+type Lister interface {
+	FindAll(ctx context.Context, filters []Filter) (ListCopier, error)
+}
+
+type Querier interface {
+	Query(ctx context.Context, query Query) (ListCopier, error)
+}
+
 type Replacer interface {
 	Replace(ctx context.Context, id primitive.Id, Value interface{}) (Copier, error)
 }
 
+// VersionKey is the key of the version of a document, which is incremented by
+// every write of a VersionedWriter.
+const VersionKey = "version"
+
+// VersionedWriter writes a document only when it is at the expected version,
+// and increments the version along with the write, so the patches must not
+// change the version themselves. When the version of the document differs, it
+// was changed since it was read and exception.ErrVersionConflict is returned.
+type VersionedWriter interface {
+	PatchIfVersion(ctx context.Context, id primitive.Id, version int, patches []Patch) error
+	SetAllByIdIfVersion(ctx context.Context, id primitive.Id, version int, setters []KeyValue) error
+	ReplaceIfVersion(ctx context.Context, id primitive.Id, version int, Value interface{}) (Copier, error)
+}
+
+// Transactor runs fn in a transaction which the repository calls made with the
+// context passed to fn take part in. The transaction is committed when fn
+// returns nil and rolled back otherwise. fn may be run more than once when the
+// transaction is retried, so it must not have effects outside of the
+// repositories. Calling WithTransaction within a transaction runs fn in that
+// transaction.
+type Transactor interface {
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
 type Patch struct {
 	Action string
 	Key    string
@@ -66,9 +98,15 @@ type KeyValue struct {
 	Value interface{} `json:"value"`
 }
 
+// Filter matches the documents whose value at the dotted Key compares to Value
+// with the Operator, which is Eq when it is empty. A filter with Or or And set
+// instead matches the documents matching any or all of those filters.
 type Filter struct {
-	Key   string      `json:"key"`
-	Value interface{} `json:"value"`
+	Key      string      `json:"key"`
+	Operator Operator    `json:"operator,omitempty"`
+	Value    interface{} `json:"value"`
+	Or       []Filter    `json:"or,omitempty"`
+	And      []Filter    `json:"and,omitempty"`
 }
 
 type Page struct {
@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/dannypaul/go-skeleton/internal/primitive"
+)
+
+// Collection is the set of operations of a collection which Repository is
+// built on, and which the collections of every driver implement.
+type Collection interface {
+	Counter
+	Creator
+	Deleter
+	Finder
+	Lister
+	Paginator
+	Querier
+	Replacer
+}
+
+// Repository reads and writes the documents of a collection as values of the
+// model T, instead of through copiers.
+type Repository[T any] struct {
+	collection Collection
+}
+
+func New[T any](collection Collection) Repository[T] {
+	return Repository[T]{collection: collection}
+}
+
+// Copy copies the document of a copier returned along with err to a T. It is
+// meant for the operations Repository does not cover, as in
+// Copy[User](repo.ReplaceIfVersion(ctx, id, version, user)).
+func Copy[T any](copier Copier, err error) (T, error) {
+	var model T
+	if err != nil {
+		return model, err
+	}
+	err = copier.Copy(&model)
+	return model, err
+}
+
+// copyAll copies the documents of a list copier returned along with err to a
+// slice of T, which is empty rather than nil when there are none.
+func copyAll[T any](ctx context.Context, listCopier ListCopier, err error) ([]T, error) {
+	if err != nil {
+		return nil, err
+	}
+	models := []T{}
+	err = listCopier.CopyAll(ctx, &models)
+	if err != nil {
+		return nil, err
+	}
+	if models == nil {
+		models = []T{}
+	}
+	return models, nil
+}
+
+func (r Repository[T]) FindById(ctx context.Context, id primitive.Id) (T, error) {
+	return Copy[T](r.collection.FindById(ctx, id))
+}
+
+func (r Repository[T]) FindSingle(ctx context.Context, filters []Filter) (T, error) {
+	return Copy[T](r.collection.FindSingle(ctx, filters))
+}
+
+func (r Repository[T]) FindAll(ctx context.Context, filters []Filter) ([]T, error) {
+	listCopier, err := r.collection.FindAll(ctx, filters)
+	return copyAll[T](ctx, listCopier, err)
+}
+
+func (r Repository[T]) Query(ctx context.Context, query Query) ([]T, error) {
+	listCopier, err := r.collection.Query(ctx, query)
+	return copyAll[T](ctx, listCopier, err)
+}
+
+// List returns the page of the documents matching the query which its Skip
+// and Limit select, along with the total number of matching documents.
+func (r Repository[T]) List(ctx context.Context, query Query) ([]T, Page, error) {
+	models, err := r.Query(ctx, query)
+	if err != nil {
+		return nil, Page{}, err
+	}
+
+	total, err := r.collection.Count(ctx, query.Filters)
+	if err != nil {
+		return nil, Page{}, err
+	}
+	return models, Page{Skip: query.Skip, Limit: query.Limit, Total: total}, nil
+}
+
+func (r Repository[T]) Paginate(ctx context.Context, query Query, cursor string) ([]T, string, error) {
+	listCopier, next, err := r.collection.Paginate(ctx, query, cursor)
+	models, err := copyAll[T](ctx, listCopier, err)
+	if err != nil {
+		return nil, "", err
+	}
+	return models, next, nil
+}
+
+func (r Repository[T]) Count(ctx context.Context, filters []Filter) (int64, error) {
+	return r.collection.Count(ctx, filters)
+}
+
+// Create returns the model as it was stored, with its ID.
+func (r Repository[T]) Create(ctx context.Context, model T) (T, error) {
+	return Copy[T](r.collection.Create(ctx, model))
+}
+
+func (r Repository[T]) Replace(ctx context.Context, id primitive.Id, model T) (T, error) {
+	return Copy[T](r.collection.Replace(ctx, id, model))
+}
+
+func (r Repository[T]) Delete(ctx context.Context, id primitive.Id) (int64, error) {
+	return r.collection.Delete(ctx, id)
+}
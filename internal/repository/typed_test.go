@@ -0,0 +1,50 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dannypaul/go-skeleton/internal/driver/platform/memory"
+	"github.com/dannypaul/go-skeleton/internal/exception"
+	"github.com/dannypaul/go-skeleton/internal/primitive"
+	"github.com/dannypaul/go-skeleton/internal/repository"
+)
+
+type user struct {
+	Id   primitive.Id `bson:"_id,omitempty"`
+	Name string       `bson:"name"`
+}
+
+func TestRepository(t *testing.T) {
+	ctx := context.Background()
+	users := repository.New[user](memory.NewCollection())
+
+	none, err := users.FindAll(ctx, nil)
+	if err != nil || none == nil || len(none) != 0 {
+		t.Errorf("Empty collection did not return an empty list, got: %v, %v", none, err)
+	}
+
+	ada, err := users.Create(ctx, user{Name: "Ada"})
+	if err != nil || !ada.Id.IsValid() {
+		t.Fatalf("Could not create the user, got: %+v, %v", ada, err)
+	}
+	if _, err = users.Create(ctx, user{Name: "Bob"}); err != nil {
+		t.Fatalf("Could not create the user, got: %v", err)
+	}
+
+	found, err := users.FindById(ctx, ada.Id)
+	if err != nil || found != ada {
+		t.Errorf("Found user was incorrect, got: %+v, %v", found, err)
+	}
+
+	_, err = users.FindById(ctx, primitive.NewObjectId())
+	if !errors.Is(err, exception.ErrNotFound) {
+		t.Errorf("Missing user was not reported as not found, got: %v", err)
+	}
+
+	list, page, err := users.List(ctx, repository.Query{Sort: []repository.Sort{{Key: "name", Descending: true}}, Limit: 1})
+	if err != nil || len(list) != 1 || list[0].Name != "Bob" || page.Total != 2 {
+		t.Errorf("Listed users were incorrect, got: %+v, %+v, %v", list, page, err)
+	}
+}
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/dannypaul/go-skeleton/internal/primitive"
+)
+
+// EventType is the kind of change an Event reports.
+type EventType string
+
+const (
+	InsertEvent EventType = "insert"
+	UpdateEvent EventType = "update"
+	DeleteEvent EventType = "delete"
+)
+
+// Event is a change of a document. Document copies the document as it was
+// after the change, and is nil for deletes. ResumeToken is passed back to
+// Watch to receive the events which follow this one.
+type Event struct {
+	Type        EventType
+	Id          primitive.Id
+	Document    Copier
+	ResumeToken string
+}
+
+// EventStream receives the events of a Watcher one at a time, waiting for the
+// next change when there is none. It has to be closed once the events are no
+// longer needed.
+type EventStream interface {
+	Next(ctx context.Context) bool
+	Event() Event
+	Err() error
+	Close(ctx context.Context) error
+}
+
+// Watcher streams the changes made to the documents of a collection after the
+// resume token, or from now on when it is empty.
+type Watcher interface {
+	Watch(ctx context.Context, resumeToken string) (EventStream, error)
+}
+
+// ResumeTokens keeps the resume token of the last event each consumer handled.
+// The token of a consumer which handled no event yet is empty.
+type ResumeTokens interface {
+	ResumeToken(ctx context.Context, consumer string) (string, error)
+	SaveResumeToken(ctx context.Context, consumer string, token string) error
+}
+
+// Subscribe handles the events of the watcher on behalf of the consumer until
+// ctx is done or handle fails. The resume token is saved after each event, so
+// a restarted consumer continues after the last event it handled. An event is
+// handled again when the consumer stops before its token is saved, so handle
+// should be idempotent.
+func Subscribe(ctx context.Context, watcher Watcher, tokens ResumeTokens, consumer string, handle func(ctx context.Context, event Event) error) error {
+	token, err := tokens.ResumeToken(ctx, consumer)
+	if err != nil {
+		return err
+	}
+
+	stream, err := watcher.Watch(ctx, token)
+	if err != nil {
+		return err
+	}
+	defer stream.Close(context.Background())
+
+	for stream.Next(ctx) {
+		event := stream.Event()
+		err = handle(ctx, event)
+		if err != nil {
+			return err
+		}
+
+		err = tokens.SaveResumeToken(ctx, consumer, event.ResumeToken)
+		if err != nil {
+			return err
+		}
+	}
+	return stream.Err()
+}
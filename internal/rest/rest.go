@@ -5,9 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 
+	"github.com/dannypaul/go-skeleton/internal/config"
 	"github.com/dannypaul/go-skeleton/internal/exception"
 	"github.com/dannypaul/go-skeleton/internal/kit/http/header"
 
@@ -91,6 +96,19 @@ func EncodeRes(w http.ResponseWriter, r *http.Request, res interface{}, err erro
 		errListJson, _ := json.Marshal(errList)
 		log.Info().Msg(string(errListJson))
 
+		var tooManyRequests exception.TooManyRequestsError
+		if errors.As(err, &tooManyRequests) {
+			retryAfter := int(math.Ceil(tooManyRequests.RetryAfter.Seconds()))
+			w.Header().Set(header.RetryAfter, strconv.Itoa(retryAfter))
+		}
+
+		// The step-up challenge follows RFC 9470
+		var stepUpRequired exception.StepUpRequiredError
+		if errors.As(err, &stepUpRequired) {
+			maxAge := int(stepUpRequired.MaxAge.Seconds())
+			w.Header().Set(header.WWWAuthenticate, `Bearer error="insufficient_user_authentication", max_age=`+strconv.Itoa(maxAge))
+		}
+
 		status := exception.HttpStatus(err.Error())
 		w.WriteHeader(status)
 
@@ -111,3 +129,72 @@ func EncodeRes(w http.ResponseWriter, r *http.Request, res interface{}, err erro
 		return
 	}
 }
+
+// ClientIP returns the IP address of the client which sent the request. The
+// X-Forwarded-For header is only honoured when TRUST_FORWARDED_FOR is set,
+// as it can be spoofed by any client talking to the server directly.
+func ClientIP(r *http.Request) string {
+	conf, _ := config.Get()
+	if conf.TrustForwardedFor {
+		forwardedFor := strings.Split(r.Header.Get(header.ForwardedFor), ",")[0]
+		if ip := strings.TrimSpace(forwardedFor); ip != "" {
+			return ip
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// PageParams reads the cursor and limit query parameters of a list request.
+// A missing limit is 0.
+func PageParams(r *http.Request) (string, int64, error) {
+	query := r.URL.Query()
+	limit := int64(0)
+	if value := query.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "", 0, errors.New(exception.LimitInvalid)
+		}
+	}
+	return query.Get("cursor"), limit, nil
+}
+
+// SetNextLink links to the next page of a list in the Link header, as in
+// RFC 8288, when there is a next cursor.
+func SetNextLink(w http.ResponseWriter, r *http.Request, next string) {
+	if next == "" {
+		return
+	}
+
+	query := r.URL.Query()
+	query.Set("cursor", next)
+	link := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+	w.Header().Set(header.Link, "<"+link.String()+`>; rel="next"`)
+}
+
+// SetETag sets the ETag header to the version of the resource, which
+// conditional updates send back in the If-Match header.
+func SetETag(w http.ResponseWriter, version int) {
+	w.Header().Set(header.ETag, `"`+strconv.Itoa(version)+`"`)
+}
+
+// IfMatch reads the version of the resource a conditional update expects from
+// the If-Match header. ok is false when the update is not conditional, which
+// includes the * wildcard.
+func IfMatch(r *http.Request) (version int, ok bool, err error) {
+	value := strings.TrimSpace(r.Header.Get(header.IfMatch))
+	if value == "" || value == "*" {
+		return 0, false, nil
+	}
+
+	version, err = strconv.Atoi(strings.Trim(strings.TrimPrefix(value, "W/"), `"`))
+	if err != nil {
+		return 0, false, errors.New(exception.IfMatchInvalid)
+	}
+	return version, true, nil
+}
@@ -0,0 +1,63 @@
+package throttle
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryStore struct {
+	mutex     *sync.Mutex
+	hits      map[string][]time.Time
+	lastSweep *time.Time
+}
+
+// NewMemoryStore returns a Store which keeps the attempts in process memory.
+// Attempts are not shared between replicas and are lost on restart.
+func NewMemoryStore() Store {
+	return memoryStore{
+		mutex:     &sync.Mutex{},
+		hits:      make(map[string][]time.Time),
+		lastSweep: &time.Time{},
+	}
+}
+
+func (m memoryStore) Hits(ctx context.Context, key string, now time.Time, window time.Duration) ([]time.Time, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	hits := prune(m.hits[key], now.Add(-window))
+	return append([]time.Time{}, hits...), nil
+}
+
+func (m memoryStore) Hit(ctx context.Context, key string, now time.Time, window time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	start := now.Add(-window)
+	m.hits[key] = append(prune(m.hits[key], start), now)
+
+	// Keys which are no longer hit would otherwise stay in memory forever
+	if now.Sub(*m.lastSweep) > window {
+		for k, h := range m.hits {
+			if h = prune(h, start); len(h) == 0 {
+				delete(m.hits, k)
+			} else {
+				m.hits[k] = h
+			}
+		}
+		*m.lastSweep = now
+	}
+
+	return nil
+}
+
+// prune drops the hits which happened at or before start. hits is sorted
+// oldest first.
+func prune(hits []time.Time, start time.Time) []time.Time {
+	i := 0
+	for i < len(hits) && !hits[i].After(start) {
+		i++
+	}
+	return hits[i:]
+}
@@ -0,0 +1,66 @@
+package throttle
+
+import (
+	"context"
+	"time"
+
+	"github.com/dannypaul/go-skeleton/internal/config"
+	"github.com/dannypaul/go-skeleton/internal/driver/platform/mongo"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const CollectionName = "throttles"
+
+type hit struct {
+	Key       string    `bson:"key"`
+	At        time.Time `bson:"at"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+type mongoStore struct {
+	mongo.Collection
+}
+
+// NewMongoStore returns a Store which shares the attempts between replicas.
+// Expired attempts are removed by the TTL index on expiresAt.
+func NewMongoStore(client *mongo.Client) (Store, error) {
+	conf, err := config.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	collection := mongo.Collection{Collection: client.Database(conf.MongoDatabasebName).Collection(CollectionName)}
+
+	return mongoStore{collection}, err
+}
+
+func (m mongoStore) Hits(ctx context.Context, key string, now time.Time, window time.Duration) ([]time.Time, error) {
+	match := bson.D{
+		{Key: "key", Value: key},
+		{Key: "at", Value: bson.D{{Key: "$gt", Value: now.Add(-window)}}},
+	}
+
+	cursor, err := m.Find(ctx, match, options.Find().SetSort(bson.D{{Key: "at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	var hits []hit
+	err = cursor.All(ctx, &hits)
+	if err != nil {
+		return nil, err
+	}
+
+	times := make([]time.Time, len(hits))
+	for i, h := range hits {
+		times[i] = h.At
+	}
+	return times, nil
+}
+
+func (m mongoStore) Hit(ctx context.Context, key string, now time.Time, window time.Duration) error {
+	_, err := m.InsertOne(ctx, hit{Key: key, At: now, ExpiresAt: now.Add(window)})
+	return err
+}
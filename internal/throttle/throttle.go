@@ -0,0 +1,78 @@
+package throttle
+
+import (
+	"context"
+	"time"
+
+	"github.com/dannypaul/go-skeleton/internal/exception"
+)
+
+// Store records attempts in a sliding window.
+type Store interface {
+	// Hits returns the times of the attempts recorded for key within the
+	// window ending at now, oldest first.
+	Hits(ctx context.Context, key string, now time.Time, window time.Duration) ([]time.Time, error)
+	// Hit records an attempt for key at now.
+	Hit(ctx context.Context, key string, now time.Time, window time.Duration) error
+}
+
+// Rule allows at most Limit attempts for Key within any Window.
+type Rule struct {
+	Key    string
+	Limit  int
+	Window time.Duration
+}
+
+type Limiter struct {
+	store Store
+	now   func() time.Time
+}
+
+func NewLimiter(store Store) Limiter {
+	return Limiter{store: store, now: time.Now}
+}
+
+// Allow records an attempt against every rule when none of them is exceeded.
+// Otherwise an exception.TooManyRequestsError is returned, carrying the
+// longest time the client has to wait before all the rules allow an attempt
+// again, and the attempt is not recorded, so that retrying does not extend
+// the wait. Concurrent attempts may exceed a limit by the number of attempts
+// in flight.
+func (l Limiter) Allow(ctx context.Context, rules ...Rule) error {
+	now := l.now()
+
+	var retryAfter time.Duration
+	for _, rule := range rules {
+		if rule.Limit <= 0 {
+			continue
+		}
+
+		hits, err := l.store.Hits(ctx, rule.Key, now, rule.Window)
+		if err != nil {
+			return err
+		}
+
+		// An attempt is allowed again once all but Limit-1 of the hits left
+		// the window
+		if len(hits) >= rule.Limit {
+			wait := hits[len(hits)-rule.Limit].Add(rule.Window).Sub(now)
+			if wait > retryAfter {
+				retryAfter = wait
+			}
+		}
+	}
+
+	if retryAfter > 0 {
+		return exception.TooManyRequestsError{RetryAfter: retryAfter}
+	}
+
+	for _, rule := range rules {
+		if rule.Limit <= 0 {
+			continue
+		}
+		if err := l.store.Hit(ctx, rule.Key, now, rule.Window); err != nil {
+			return err
+		}
+	}
+	return nil
+}
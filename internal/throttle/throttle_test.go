@@ -0,0 +1,78 @@
+package throttle
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dannypaul/go-skeleton/internal/exception"
+)
+
+func TestLimiterAllow(t *testing.T) {
+	limiter := NewLimiter(NewMemoryStore())
+	rule := Rule{Key: "login:ip:127.0.0.1", Limit: 2, Window: time.Minute}
+
+	for i := 0; i < rule.Limit; i++ {
+		if err := limiter.Allow(context.Background(), rule); err != nil {
+			t.Fatalf("Attempt %d was throttled, got: %v", i+1, err)
+		}
+	}
+
+	err := limiter.Allow(context.Background(), rule)
+	var tooManyRequests exception.TooManyRequestsError
+	if !errors.As(err, &tooManyRequests) {
+		t.Fatalf("Attempt over the limit was not throttled, got: %v", err)
+	}
+	if tooManyRequests.RetryAfter <= 0 || tooManyRequests.RetryAfter > rule.Window {
+		t.Errorf("Retry after was incorrect, got: %s, want: (0, %s].", tooManyRequests.RetryAfter, rule.Window)
+	}
+}
+
+func TestLimiterRetryAfter(t *testing.T) {
+	now := time.Now()
+	limiter := NewLimiter(NewMemoryStore())
+	limiter.now = func() time.Time { return now }
+	rule := Rule{Key: "login:identity:ada@example.com", Limit: 2, Window: time.Minute}
+
+	for i := 0; i < rule.Limit; i++ {
+		if err := limiter.Allow(context.Background(), rule); err != nil {
+			t.Fatalf("Attempt %d was throttled, got: %v", i+1, err)
+		}
+		now = now.Add(10 * time.Second)
+	}
+
+	// Retrying while throttled does not extend the wait
+	var tooManyRequests exception.TooManyRequestsError
+	for i := 0; i < 5; i++ {
+		if err := limiter.Allow(context.Background(), rule); !errors.As(err, &tooManyRequests) {
+			t.Fatalf("Attempt over the limit was not throttled, got: %v", err)
+		}
+		now = now.Add(time.Second)
+	}
+
+	if want := 36 * time.Second; tooManyRequests.RetryAfter != want {
+		t.Errorf("Retry after was incorrect, got: %s, want: %s.", tooManyRequests.RetryAfter, want)
+	}
+
+	now = now.Add(tooManyRequests.RetryAfter)
+	if err := limiter.Allow(context.Background(), rule); err != nil {
+		t.Errorf("Attempt after waiting for the retry after was throttled, got: %v", err)
+	}
+	if err := limiter.Allow(context.Background(), rule); err == nil {
+		t.Errorf("Attempt over the limit was not throttled after the retry")
+	}
+}
+
+func TestMemoryStoreSlidingWindow(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Now()
+
+	store.Hit(context.Background(), "key", now, time.Minute)
+	store.Hit(context.Background(), "key", now.Add(45*time.Second), time.Minute)
+	hits, _ := store.Hits(context.Background(), "key", now.Add(90*time.Second), time.Minute)
+
+	if len(hits) != 1 || !hits[0].Equal(now.Add(45*time.Second)) {
+		t.Errorf("Hits were incorrect, got: %v, want: [%s].", hits, now.Add(45*time.Second))
+	}
+}
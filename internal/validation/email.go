@@ -0,0 +1,84 @@
+package validation
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/dannypaul/go-skeleton/internal/config"
+	"github.com/dannypaul/go-skeleton/internal/exception"
+
+	"golang.org/x/net/idna"
+)
+
+// providerAliases maps the domains which deliver to the same mailboxes
+var providerAliases = map[string]string{
+	"googlemail.com": "gmail.com",
+}
+
+// Providers which ignore dots in the local part
+var dotInsensitiveProviders = map[string]bool{
+	"gmail.com": true,
+}
+
+// Providers which deliver local+tag@domain to local@domain
+var plusAddressingProviders = map[string]bool{
+	"gmail.com":    true,
+	"outlook.com":  true,
+	"hotmail.com":  true,
+	"live.com":     true,
+	"icloud.com":   true,
+	"fastmail.com": true,
+}
+
+// CanonicaliseEmailId returns the form of an email ID used for lookups and
+// uniqueness. The domain is converted to its ASCII (punycode) form and the
+// whole email ID is lowercased. When EMAIL_PROVIDER_RULES is enabled the
+// addressing rules of well known providers are applied as well, so that
+// john.doe+news@gmail.com and johndoe@gmail.com are the same email ID.
+func CanonicaliseEmailId(emailId string) (string, error) {
+	conf, _ := config.Get()
+	return canonicaliseEmailId(emailId, conf.EmailProviderRules)
+}
+
+func canonicaliseEmailId(emailId string, providerRules bool) (string, error) {
+	at := strings.LastIndex(emailId, "@")
+	if at <= 0 || at == len(emailId)-1 {
+		return "", errors.New(exception.EmailIdInvalid)
+	}
+
+	local := strings.ToLower(strings.TrimSpace(emailId[:at]))
+	domain, err := idna.Lookup.ToASCII(strings.TrimSpace(emailId[at+1:]))
+	if err != nil {
+		return "", errors.New(exception.EmailIdInvalid)
+	}
+	domain = strings.ToLower(domain)
+
+	if providerRules {
+		if alias, ok := providerAliases[domain]; ok {
+			domain = alias
+		}
+		if plusAddressingProviders[domain] {
+			local = strings.SplitN(local, "+", 2)[0]
+		}
+		if dotInsensitiveProviders[domain] {
+			local = strings.ReplaceAll(local, ".", "")
+		}
+		if local == "" {
+			return "", errors.New(exception.EmailIdInvalid)
+		}
+	}
+
+	return local + "@" + domain, nil
+}
+
+// isDisposableDomain reports whether domain or any of its parent domains is
+// in the blocklist.
+func isDisposableDomain(domain string, blocklist []string) bool {
+	for _, blocked := range blocklist {
+		blocked = strings.ToLower(blocked)
+		if domain == blocked || strings.HasSuffix(domain, "."+blocked) {
+			return true
+		}
+	}
+	return false
+}
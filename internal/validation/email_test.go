@@ -0,0 +1,40 @@
+package validation
+
+import "testing"
+
+func TestCanonicaliseEmailId(t *testing.T) {
+	tests := []struct {
+		emailId       string
+		providerRules bool
+		want          string
+	}{
+		{"Alice@X.com", false, "alice@x.com"},
+		{"alice@bücher.de", false, "alice@xn--bcher-kva.de"},
+		{"John.Doe+news@gmail.com", false, "john.doe+news@gmail.com"},
+		{"John.Doe+news@gmail.com", true, "johndoe@gmail.com"},
+		{"john.doe@googlemail.com", true, "johndoe@gmail.com"},
+		{"jane.doe+work@outlook.com", true, "jane.doe@outlook.com"},
+		{"jane.doe+work@example.com", true, "jane.doe+work@example.com"},
+	}
+
+	for _, test := range tests {
+		got, err := canonicaliseEmailId(test.emailId, test.providerRules)
+		if err != nil {
+			t.Errorf("canonicaliseEmailId(%q, %t) returned error: %v", test.emailId, test.providerRules, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("canonicaliseEmailId(%q, %t) was incorrect, got: %s, want: %s.", test.emailId, test.providerRules, got, test.want)
+		}
+	}
+}
+
+func TestIsDisposableDomain(t *testing.T) {
+	blocklist := []string{"mailinator.com"}
+	if !isDisposableDomain("eu.mailinator.com", blocklist) {
+		t.Errorf("Subdomain of a blocked domain was not blocked")
+	}
+	if isDisposableDomain("notmailinator.com", blocklist) {
+		t.Errorf("Domain which only ends with a blocked domain was blocked")
+	}
+}
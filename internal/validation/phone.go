@@ -0,0 +1,124 @@
+package validation
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/dannypaul/go-skeleton/internal/exception"
+)
+
+// E.164 numbers have at most 15 digits, including the country code
+const maxE164Digits = 15
+
+type PhoneNumber struct {
+	E164        string
+	CountryCode string
+	Region      string
+}
+
+// ParsePhone normalises a phone number to E.164. Numbers starting with + or 00
+// are international, every other number is treated as a national number of
+// defaultRegion. Spaces, hyphens, dots, slashes and parentheses are ignored.
+func ParsePhone(number string, defaultRegion string) (PhoneNumber, error) {
+	var digits strings.Builder
+	international := false
+	// Offsets of the digits of the first parenthesised group
+	bracketStart, bracketEnd := -1, -1
+	for _, c := range strings.TrimSpace(number) {
+		switch {
+		case c >= '0' && c <= '9':
+			digits.WriteRune(c)
+		case c == '(' && bracketStart < 0:
+			bracketStart = digits.Len()
+		case c == ')' && bracketStart >= 0 && bracketEnd < 0:
+			bracketEnd = digits.Len()
+		case c == '+' && digits.Len() == 0 && !international:
+			international = true
+		case c == ' ' || c == '-' || c == '.' || c == '/' || c == '(' || c == ')':
+			continue
+		default:
+			return PhoneNumber{}, errors.New(exception.PhoneNumberInvalid)
+		}
+	}
+
+	national := digits.String()
+	offset := 0
+	if !international && strings.HasPrefix(national, "00") {
+		international = true
+		national = national[2:]
+		offset = 2
+	}
+
+	defaultPlan, hasDefault := findPlanByRegion(defaultRegion)
+
+	var plan phonePlan
+	if international {
+		var ok bool
+		plan, ok = findPlanByNumber(national, defaultPlan)
+		if !ok {
+			return PhoneNumber{}, errors.New(exception.PhoneNumberInvalid)
+		}
+		national = national[len(plan.countryCode):]
+		offset += len(plan.countryCode)
+	} else {
+		if !hasDefault {
+			return PhoneNumber{}, errors.New(exception.PhoneNumberInvalid)
+		}
+		plan = defaultPlan
+	}
+
+	// National numbers are often written with the trunk prefix. After the
+	// country code it is only a trunk prefix when written in parentheses as in
+	// +44 (0)20 7946 0958, since national numbers may start with the same
+	// digits as in +7 812 123 45 67.
+	bracketed := bracketStart == offset && bracketEnd == offset+len(plan.trunkPrefix)
+	if plan.trunkPrefix != "" && strings.HasPrefix(national, plan.trunkPrefix) && (!international || bracketed) {
+		national = national[len(plan.trunkPrefix):]
+	}
+
+	if !plan.isValidLength(len(national)) || len(plan.countryCode)+len(national) > maxE164Digits {
+		return PhoneNumber{}, errors.New(exception.PhoneNumberInvalid)
+	}
+
+	return PhoneNumber{
+		E164:        "+" + plan.countryCode + national,
+		CountryCode: plan.countryCode,
+		Region:      plan.region,
+	}, nil
+}
+
+func (p phonePlan) isValidLength(length int) bool {
+	for _, l := range p.lengths {
+		if l == length {
+			return true
+		}
+	}
+	return false
+}
+
+func findPlanByRegion(region string) (phonePlan, bool) {
+	for _, plan := range phonePlans {
+		if strings.EqualFold(plan.region, region) {
+			return plan, true
+		}
+	}
+	return phonePlan{}, false
+}
+
+// findPlanByNumber finds the plan of the country code the number starts with.
+// The preferred plan is used when other regions share its country code.
+func findPlanByNumber(number string, preferred phonePlan) (phonePlan, bool) {
+	// Country codes are prefix free, so at most one length can match
+	for length := 1; length <= 3 && length <= len(number); length++ {
+		countryCode := number[:length]
+		if preferred.countryCode == countryCode {
+			return preferred, true
+		}
+		for _, plan := range phonePlans {
+			if plan.countryCode == countryCode {
+				return plan, true
+			}
+		}
+	}
+	return phonePlan{}, false
+}
@@ -0,0 +1,48 @@
+package validation
+
+// phonePlan describes the numbering plan of a region. Lengths are the allowed
+// lengths of the national significant number, that is the number without the
+// country code and the trunk prefix.
+type phonePlan struct {
+	region      string
+	countryCode string
+	trunkPrefix string
+	lengths     []int
+}
+
+// phonePlans is ordered so that the main region of a shared country code comes
+// first. For instance +1 numbers resolve to US unless the default region is CA.
+var phonePlans = []phonePlan{
+	{region: "US", countryCode: "1", trunkPrefix: "1", lengths: []int{10}},
+	{region: "CA", countryCode: "1", trunkPrefix: "1", lengths: []int{10}},
+	{region: "RU", countryCode: "7", trunkPrefix: "8", lengths: []int{10}},
+	{region: "KZ", countryCode: "7", trunkPrefix: "8", lengths: []int{10}},
+	{region: "ZA", countryCode: "27", trunkPrefix: "0", lengths: []int{9}},
+	{region: "NL", countryCode: "31", trunkPrefix: "0", lengths: []int{9}},
+	{region: "FR", countryCode: "33", trunkPrefix: "0", lengths: []int{9}},
+	{region: "ES", countryCode: "34", lengths: []int{9}},
+	{region: "IT", countryCode: "39", lengths: []int{6, 7, 8, 9, 10, 11}},
+	{region: "CH", countryCode: "41", trunkPrefix: "0", lengths: []int{9}},
+	{region: "GB", countryCode: "44", trunkPrefix: "0", lengths: []int{9, 10}},
+	{region: "SE", countryCode: "46", trunkPrefix: "0", lengths: []int{7, 8, 9}},
+	{region: "DE", countryCode: "49", trunkPrefix: "0", lengths: []int{7, 8, 9, 10, 11}},
+	{region: "MX", countryCode: "52", lengths: []int{10}},
+	{region: "BR", countryCode: "55", trunkPrefix: "0", lengths: []int{10, 11}},
+	{region: "MY", countryCode: "60", trunkPrefix: "0", lengths: []int{9, 10}},
+	{region: "AU", countryCode: "61", trunkPrefix: "0", lengths: []int{9}},
+	{region: "ID", countryCode: "62", trunkPrefix: "0", lengths: []int{8, 9, 10, 11, 12}},
+	{region: "PH", countryCode: "63", trunkPrefix: "0", lengths: []int{10}},
+	{region: "NZ", countryCode: "64", trunkPrefix: "0", lengths: []int{8, 9, 10}},
+	{region: "SG", countryCode: "65", lengths: []int{8}},
+	{region: "JP", countryCode: "81", trunkPrefix: "0", lengths: []int{9, 10}},
+	{region: "CN", countryCode: "86", trunkPrefix: "0", lengths: []int{10, 11}},
+	{region: "IN", countryCode: "91", trunkPrefix: "0", lengths: []int{10}},
+	{region: "PK", countryCode: "92", trunkPrefix: "0", lengths: []int{10}},
+	{region: "LK", countryCode: "94", trunkPrefix: "0", lengths: []int{9}},
+	{region: "NG", countryCode: "234", trunkPrefix: "0", lengths: []int{8, 10}},
+	{region: "KE", countryCode: "254", trunkPrefix: "0", lengths: []int{9}},
+	{region: "IE", countryCode: "353", trunkPrefix: "0", lengths: []int{7, 8, 9}},
+	{region: "AE", countryCode: "971", trunkPrefix: "0", lengths: []int{8, 9}},
+	{region: "SA", countryCode: "966", trunkPrefix: "0", lengths: []int{9}},
+	{region: "BD", countryCode: "880", trunkPrefix: "0", lengths: []int{10}},
+}
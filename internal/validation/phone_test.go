@@ -0,0 +1,57 @@
+package validation
+
+import "testing"
+
+func TestParsePhone(t *testing.T) {
+	tests := []struct {
+		number        string
+		defaultRegion string
+		want          PhoneNumber
+	}{
+		{"9876543210", "IN", PhoneNumber{E164: "+919876543210", CountryCode: "91", Region: "IN"}},
+		{"098765 43210", "IN", PhoneNumber{E164: "+919876543210", CountryCode: "91", Region: "IN"}},
+		{"+91 98765-43210", "US", PhoneNumber{E164: "+919876543210", CountryCode: "91", Region: "IN"}},
+		{"(415) 555-2671", "US", PhoneNumber{E164: "+14155552671", CountryCode: "1", Region: "US"}},
+		{"1-415-555-2671", "US", PhoneNumber{E164: "+14155552671", CountryCode: "1", Region: "US"}},
+		{"+1 416 555 0123", "CA", PhoneNumber{E164: "+14165550123", CountryCode: "1", Region: "CA"}},
+		{"+44 (0)20 7946 0958", "IN", PhoneNumber{E164: "+442079460958", CountryCode: "44", Region: "GB"}},
+		{"0044 20 7946 0958", "IN", PhoneNumber{E164: "+442079460958", CountryCode: "44", Region: "GB"}},
+		{"+65 6123 4567", "IN", PhoneNumber{E164: "+6561234567", CountryCode: "65", Region: "SG"}},
+		{"+7 812 123 45 67", "IN", PhoneNumber{E164: "+78121234567", CountryCode: "7", Region: "RU"}},
+		{"+7 800 555 35 35", "IN", PhoneNumber{E164: "+78005553535", CountryCode: "7", Region: "RU"}},
+		{"8 812 123 45 67", "RU", PhoneNumber{E164: "+78121234567", CountryCode: "7", Region: "RU"}},
+		{"+7 701 123 4567", "KZ", PhoneNumber{E164: "+77011234567", CountryCode: "7", Region: "KZ"}},
+		{"8 701 123 4567", "KZ", PhoneNumber{E164: "+77011234567", CountryCode: "7", Region: "KZ"}},
+	}
+
+	for _, test := range tests {
+		got, err := ParsePhone(test.number, test.defaultRegion)
+		if err != nil {
+			t.Errorf("ParsePhone(%q, %q) returned error: %v", test.number, test.defaultRegion, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParsePhone(%q, %q) was incorrect, got: %+v, want: %+v.", test.number, test.defaultRegion, got, test.want)
+		}
+	}
+}
+
+func TestParsePhoneInvalid(t *testing.T) {
+	tests := []struct {
+		number        string
+		defaultRegion string
+	}{
+		{"98765", "IN"},
+		{"98765432101", "IN"},
+		{"+999 1234567", "IN"},
+		{"9876543210", "XX"},
+		{"98765abcde", "IN"},
+		{"++919876543210", "IN"},
+	}
+
+	for _, test := range tests {
+		if got, err := ParsePhone(test.number, test.defaultRegion); err == nil {
+			t.Errorf("ParsePhone(%q, %q) was expected to fail, got: %+v", test.number, test.defaultRegion, got)
+		}
+	}
+}
@@ -2,34 +2,40 @@ package validation
 
 import (
 	"errors"
-	"github.com/dannypaul/go-skeleton/internal/exception"
 	"regexp"
+	"strings"
+
+	"github.com/dannypaul/go-skeleton/internal/config"
+	"github.com/dannypaul/go-skeleton/internal/exception"
 )
 
+// ValidatePhone validates a phone number in any of the formats accepted by
+// ParsePhone. National numbers are validated against the default region.
 func ValidatePhone(phoneNumber string) error {
-	if len(phoneNumber) != 10 {
-		return errors.New(exception.PhoneNumberInvalid)
-	}
-
-	for i, c := range phoneNumber {
-		if i == 0 {
-			if c < '6' || c > '9' {
-				return errors.New(exception.PhoneNumberInvalid)
-			}
-		}
-		if c < '0' || c > '9' {
-			return errors.New(exception.PhoneNumberInvalid)
-		}
-	}
-
-	return nil
+	conf, _ := config.Get()
+	_, err := ParsePhone(phoneNumber, conf.DefaultPhoneRegion)
+	return err
 }
 
 var emailRegexp = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 
+// ValidateEmailId validates the canonical form of the email ID, which allows
+// internationalised domain names, and rejects disposable email domains.
 func ValidateEmailId(email string) error {
-	if !emailRegexp.MatchString(email) {
+	canonical, err := canonicaliseEmailId(email, false)
+	if err != nil {
+		return err
+	}
+
+	if !emailRegexp.MatchString(canonical) {
 		return errors.New(exception.EmailIdInvalid)
 	}
+
+	conf, _ := config.Get()
+	domain := canonical[strings.LastIndex(canonical, "@")+1:]
+	if isDisposableDomain(domain, conf.DisposableEmailDomains) {
+		return errors.New(exception.EmailDomainDisposable)
+	}
+
 	return nil
 }